@@ -0,0 +1,325 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudnative-pg/machinery/pkg/fileutils"
+	"github.com/cloudnative-pg/machinery/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/credentials"
+)
+
+const (
+	// outputTable prints the catalog data as a table
+	outputTable = "table"
+
+	// outputJSON prints the catalog data as JSON
+	outputJSON = "json"
+)
+
+// cli contains the flags shared by every subcommand
+// and the resources built from them
+type cli struct {
+	command subcommand
+	stdout  io.Writer
+	stderr  io.Writer
+
+	configPath   string
+	serverName   string
+	output       string
+	envFile      string
+	secretsDir   string
+	logLevel     string
+	flags        *flag.FlagSet
+	scratchDir   string
+	parsedConfig *api.BarmanObjectStoreConfiguration
+}
+
+// newFlagSet creates the flag set of the subcommand,
+// including the flags shared by every subcommand
+func (c *cli) newFlagSet() *flag.FlagSet {
+	c.flags = flag.NewFlagSet(c.command.name, flag.ContinueOnError)
+	c.flags.SetOutput(c.stderr)
+	c.flags.Usage = func() {
+		_, _ = fmt.Fprintf(c.stderr, "Usage: barman-cloud-go %s [flags] %s\n\n%s.\n\nFlags:\n",
+			c.command.name, c.command.arguments, c.command.description)
+		c.flags.PrintDefaults()
+	}
+
+	c.flags.StringVar(&c.configPath, "config", "",
+		"the file containing the BarmanObjectStoreConfiguration, in YAML or JSON format, "+
+			"or - to read it from the standard input")
+	c.flags.StringVar(&c.serverName, "server-name", "",
+		"the name of the server in the object store, defaults to the serverName in the configuration")
+	c.flags.StringVar(&c.output, "output", outputTable, "the output format, one of table and json")
+	c.flags.StringVar(&c.envFile, "env-file", "",
+		"a file containing KEY=VALUE lines to be added to the environment of barman-cloud")
+	c.flags.StringVar(&c.secretsDir, "secrets-dir", "",
+		"the directory containing the secrets referenced by the configuration, "+
+			"laid out as <secret-name>/<key> like mounted Kubernetes secrets")
+	c.flags.StringVar(&c.logLevel, "log-level", log.WarningLevelString,
+		"the desired log level, one of error, warning, info, debug and trace")
+
+	return c.flags
+}
+
+// parse parses the command line of the subcommand, checking
+// the number of positional arguments
+func (c *cli) parse(args []string, positionalArgs int) error {
+	if err := c.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return errUsage
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if c.flags.NArg() != positionalArgs {
+		c.flags.Usage()
+		return errUsage
+	}
+
+	if c.output != outputTable && c.output != outputJSON {
+		_, _ = fmt.Fprintf(c.stderr, "Unknown output format %q\n", c.output)
+		return errUsage
+	}
+
+	if c.configPath == "" {
+		_, _ = fmt.Fprintln(c.stderr, "The -config flag is required")
+		return errUsage
+	}
+
+	log.SetLogLevel(c.logLevel)
+	logFlags := log.NewFlags(zap.Options{DestWriter: c.stderr})
+	logFlags.ConfigureLogging()
+
+	return nil
+}
+
+// configuration loads the object store configuration
+func (c *cli) configuration() (*api.BarmanObjectStoreConfiguration, error) {
+	if c.parsedConfig != nil {
+		return c.parsedConfig, nil
+	}
+
+	var content []byte
+	var err error
+	if c.configPath == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(c.configPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading the configuration: %w", err)
+	}
+
+	configuration, err := parseConfiguration(content)
+	if err != nil {
+		return nil, err
+	}
+
+	c.parsedConfig = configuration
+	return configuration, nil
+}
+
+// parseConfiguration parses a BarmanObjectStoreConfiguration in YAML or JSON format
+func parseConfiguration(content []byte) (*api.BarmanObjectStoreConfiguration, error) {
+	configuration := &api.BarmanObjectStoreConfiguration{}
+	if err := yaml.UnmarshalStrict(content, configuration); err != nil {
+		return nil, fmt.Errorf("while parsing the configuration: %w", err)
+	}
+
+	if configuration.DestinationPath == "" {
+		return nil, errors.New("the configuration doesn't contain a destinationPath")
+	}
+
+	return configuration, nil
+}
+
+// getServerName gets the name of the server in the object store
+func (c *cli) getServerName() (string, error) {
+	if c.serverName != "" {
+		return c.serverName, nil
+	}
+
+	configuration, err := c.configuration()
+	if err != nil {
+		return "", err
+	}
+
+	if configuration.ServerName == "" {
+		return "", errors.New("the server name must be set in the configuration or with -server-name")
+	}
+
+	return configuration.ServerName, nil
+}
+
+// prepare loads the configuration and builds the
+// environment needed to invoke the barman-cloud tools
+func (c *cli) prepare(ctx context.Context) (
+	configuration *api.BarmanObjectStoreConfiguration,
+	serverName string,
+	env []string,
+	err error,
+) {
+	if configuration, err = c.configuration(); err != nil {
+		return nil, "", nil, err
+	}
+	if serverName, err = c.getServerName(); err != nil {
+		return nil, "", nil, err
+	}
+	if env, err = c.env(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	return configuration, serverName, env, nil
+}
+
+// env builds the environment of the barman-cloud tools, made of the current
+// environment, the content of the env file and the variables derived from
+// the configuration and the referenced secrets
+func (c *cli) env(ctx context.Context) ([]string, error) {
+	configuration, err := c.configuration()
+	if err != nil {
+		return nil, err
+	}
+
+	env := os.Environ()
+	if c.envFile != "" {
+		fileEnv, err := readEnvFile(c.envFile)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fileEnv...)
+	}
+
+	// The files needed by the cloud provider SDKs are written in the
+	// scratch directory, as the default one only exists in the operand pods
+	scratchDir, err := c.getScratchDir()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := &secretsDirClient{directory: c.secretsDir}
+	caLocation := ""
+	if configuration.EndpointCA != nil {
+		if caLocation, err = c.writeEndpointCA(ctx, secrets, configuration); err != nil {
+			return nil, err
+		}
+	} else if configuration.TLS != nil {
+		caLocation = filepath.Join(scratchDir, credentials.BarmanBackupEndpointCACertificateFileName)
+	}
+
+	return credentials.EnvSetCloudCredentialsInDirectory(
+		ctx, secrets, "", configuration, env, caLocation, scratchDir)
+}
+
+// writeEndpointCA stores the CA bundle referenced by EndpointCA in the
+// scratch directory, as expected by the credentials package
+func (c *cli) writeEndpointCA(
+	ctx context.Context,
+	secrets *secretsDirClient,
+	configuration *api.BarmanObjectStoreConfiguration,
+) (string, error) {
+	content, err := secrets.getSecretValue(ctx, configuration.EndpointCA.Name, configuration.EndpointCA.Key)
+	if err != nil {
+		return "", err
+	}
+
+	scratchDir, err := c.getScratchDir()
+	if err != nil {
+		return "", err
+	}
+
+	location := filepath.Join(scratchDir, credentials.BarmanBackupEndpointCACertificateFileName)
+	if _, err := fileutils.WriteFileAtomic(location, content, 0o600); err != nil {
+		return "", fmt.Errorf("while writing the endpoint CA: %w", err)
+	}
+
+	return location, nil
+}
+
+// getScratchDir gets the directory containing the temporary
+// files of the current execution, creating it if needed
+func (c *cli) getScratchDir() (string, error) {
+	if c.scratchDir != "" {
+		return c.scratchDir, nil
+	}
+
+	scratchDir, err := os.MkdirTemp("", "barman-cloud-go-")
+	if err != nil {
+		return "", err
+	}
+
+	c.scratchDir = scratchDir
+	return scratchDir, nil
+}
+
+// cleanup removes the temporary files of the current execution
+func (c *cli) cleanup() {
+	if c.scratchDir != "" {
+		_ = os.RemoveAll(c.scratchDir)
+	}
+}
+
+// readEnvFile reads a file containing KEY=VALUE lines, ignoring
+// empty lines and comments
+func readEnvFile(fileName string) ([]string, error) {
+	f, err := os.Open(filepath.Clean(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("while reading the env file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, _, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid line %d in the env file %s", lineNumber, fileName)
+		}
+		env = append(env, strings.TrimPrefix(line, "export "))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("while reading the env file: %w", err)
+	}
+
+	return env, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	machineryapi "github.com/cloudnative-pg/machinery/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("parseConfiguration", func() {
+	It("parses a YAML configuration", func() {
+		configuration, err := parseConfiguration([]byte(
+			"destinationPath: s3://bucket/path\n" +
+				"serverName: cluster-example\n" +
+				"s3Credentials:\n" +
+				"  inheritFromIAMRole: true\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configuration.DestinationPath).To(Equal("s3://bucket/path"))
+		Expect(configuration.ServerName).To(Equal("cluster-example"))
+		Expect(configuration.AWS).ToNot(BeNil())
+		Expect(configuration.AWS.InheritFromIAMRole).To(BeTrue())
+	})
+
+	It("parses a JSON configuration", func() {
+		configuration, err := parseConfiguration([]byte(`{"destinationPath": "gs://bucket", "serverName": "pg"}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configuration.DestinationPath).To(Equal("gs://bucket"))
+		Expect(configuration.ServerName).To(Equal("pg"))
+	})
+
+	It("rejects unknown fields and missing destination paths", func() {
+		_, err := parseConfiguration([]byte("destinationPath: s3://bucket\nunknownField: 1\n"))
+		Expect(err).To(HaveOccurred())
+
+		_, err = parseConfiguration([]byte("serverName: pg\n"))
+		Expect(err).To(MatchError(ContainSubstring("destinationPath")))
+	})
+})
+
+var _ = Describe("readEnvFile", func() {
+	It("reads the variables skipping comments and empty lines", func() {
+		fileName := filepath.Join(GinkgoT().TempDir(), "env")
+		Expect(os.WriteFile(fileName, []byte(
+			"# credentials\n\nAWS_ACCESS_KEY_ID=key\nexport AWS_SECRET_ACCESS_KEY=a=b\n"), 0o600)).To(Succeed())
+
+		env, err := readEnvFile(fileName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env).To(Equal([]string{"AWS_ACCESS_KEY_ID=key", "AWS_SECRET_ACCESS_KEY=a=b"}))
+	})
+
+	It("rejects lines without an assignment", func() {
+		fileName := filepath.Join(GinkgoT().TempDir(), "env")
+		Expect(os.WriteFile(fileName, []byte("AWS_ACCESS_KEY_ID\n"), 0o600)).To(Succeed())
+
+		_, err := readEnvFile(fileName)
+		Expect(err).To(MatchError(ContainSubstring("invalid line 1")))
+	})
+})
+
+var _ = Describe("secretsDirClient", func() {
+	var directory string
+
+	BeforeEach(func() {
+		directory = GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(directory, "aws-creds"), 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(directory, "aws-creds", "ACCESS_KEY_ID"),
+			[]byte("key"), 0o600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(directory, "aws-creds", "..data"),
+			[]byte("ignored"), 0o600)).To(Succeed())
+	})
+
+	It("reads the secrets from the directory", func() {
+		c := &secretsDirClient{directory: directory}
+		secret := &corev1.Secret{}
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "aws-creds"}, secret)).To(Succeed())
+		Expect(secret.Data).To(Equal(map[string][]byte{"ACCESS_KEY_ID": []byte("key")}))
+
+		value, err := c.getSecretValue(context.Background(), "aws-creds", "ACCESS_KEY_ID")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("key")))
+	})
+
+	It("reports missing secrets and keys", func() {
+		c := &secretsDirClient{directory: directory}
+		err := c.Get(context.Background(), client.ObjectKey{Name: "missing"}, &corev1.Secret{})
+		Expect(apierrs.IsNotFound(err)).To(BeTrue())
+
+		_, err = c.getSecretValue(context.Background(), "aws-creds", "SECRET_ACCESS_KEY")
+		Expect(err).To(MatchError(ContainSubstring("missing key SECRET_ACCESS_KEY")))
+	})
+
+	It("rejects secret names escaping the directory", func() {
+		c := &secretsDirClient{directory: directory}
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "../etc"}, &corev1.Secret{})).ToNot(Succeed())
+	})
+
+	It("requires the secrets directory", func() {
+		c := &secretsDirClient{}
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "aws-creds"}, &corev1.Secret{})).
+			To(MatchError(ContainSubstring("-secrets-dir")))
+	})
+})
+
+var _ = Describe("env", func() {
+	It("writes the files of the cloud provider SDKs in the scratch directory", func(ctx SpecContext) {
+		secretsDir := GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(secretsDir, "gcs-creds"), 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(secretsDir, "gcs-creds", "credentials.json"),
+			[]byte("{}"), 0o600)).To(Succeed())
+
+		c := &cli{
+			secretsDir: secretsDir,
+			parsedConfig: &api.BarmanObjectStoreConfiguration{
+				DestinationPath: "gs://bucket/path",
+				BarmanCredentials: api.BarmanCredentials{
+					Google: &api.GoogleCredentials{
+						ApplicationCredentials: &machineryapi.SecretKeySelector{
+							LocalObjectReference: machineryapi.LocalObjectReference{Name: "gcs-creds"},
+							Key:                  "credentials.json",
+						},
+					},
+				},
+			},
+		}
+		DeferCleanup(c.cleanup)
+
+		env, err := c.env(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.scratchDir).ToNot(BeEmpty())
+
+		credentialsFile := filepath.Join(c.scratchDir, ".application_credentials.json")
+		Expect(env).To(ContainElement("GOOGLE_APPLICATION_CREDENTIALS=" + credentialsFile))
+		Expect(credentialsFile).To(BeAnExistingFile())
+	})
+})
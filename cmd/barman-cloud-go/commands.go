@@ -0,0 +1,424 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+	"github.com/cloudnative-pg/barman-cloud/pkg/command"
+	"github.com/cloudnative-pg/barman-cloud/pkg/restorer"
+	"github.com/cloudnative-pg/barman-cloud/pkg/walarchive"
+	"github.com/cloudnative-pg/barman-cloud/pkg/walinspect"
+)
+
+func runList(ctx context.Context, c *cli, args []string) error {
+	c.newFlagSet()
+	if err := c.parse(args, 0); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = command.ContextWithKeepStatus(ctx, true)
+	backupList, err := command.GetBackupList(ctx, configuration, serverName, env)
+	if err != nil {
+		return err
+	}
+
+	return printBackups(c.stdout, c.output, backupList.List)
+}
+
+func runShow(ctx context.Context, c *cli, args []string) error {
+	c.newFlagSet()
+	if err := c.parse(args, 1); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = command.ContextWithKeepStatus(ctx, true)
+	backup, err := command.GetBackupByName(ctx, c.flags.Arg(0), serverName, configuration, env)
+	if err != nil {
+		return err
+	}
+	if backup == nil {
+		return errors.New("backup not found")
+	}
+
+	return printBackup(c.stdout, c.output, backup)
+}
+
+func runLatest(ctx context.Context, c *cli, args []string) error {
+	c.newFlagSet()
+	if err := c.parse(args, 0); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = command.ContextWithKeepStatus(ctx, true)
+	backup, err := command.GetLatestBackup(ctx, serverName, configuration, env)
+	if err != nil {
+		return err
+	}
+	if backup == nil {
+		return errors.New("no successful backup found")
+	}
+
+	return printBackup(c.stdout, c.output, backup)
+}
+
+// recoveryTarget is the recovery target passed on the command line
+type recoveryTarget struct {
+	backupID   string
+	targetTime string
+	targetLSN  string
+	targetTLI  string
+	targetXID  string
+	targetName string
+}
+
+// GetBackupID implements the recovery target interface of the catalog
+func (target *recoveryTarget) GetBackupID() string {
+	return target.backupID
+}
+
+// GetTargetTime implements the recovery target interface of the catalog
+func (target *recoveryTarget) GetTargetTime() string {
+	return target.targetTime
+}
+
+// GetTargetLSN implements the recovery target interface of the catalog
+func (target *recoveryTarget) GetTargetLSN() string {
+	return target.targetLSN
+}
+
+// GetTargetTLI implements the recovery target interface of the catalog
+func (target *recoveryTarget) GetTargetTLI() string {
+	return target.targetTLI
+}
+
+// GetTargetXID implements the recovery target interface of the catalog
+func (target *recoveryTarget) GetTargetXID() string {
+	return target.targetXID
+}
+
+// GetTargetName implements the recovery target interface of the catalog
+func (target *recoveryTarget) GetTargetName() string {
+	return target.targetName
+}
+
+// addRecoveryTargetFlags defines the flags describing the recovery target
+func addRecoveryTargetFlags(flags *flag.FlagSet, target *recoveryTarget) {
+	flags.StringVar(&target.backupID, "backup-id", "", "the ID of the backup to be used")
+	flags.StringVar(&target.targetTime, "target-time", "", "the recovery target time")
+	flags.StringVar(&target.targetLSN, "target-lsn", "", "the recovery target LSN")
+	flags.StringVar(&target.targetTLI, "target-tli", "",
+		"the recovery target timeline, either a number or \"latest\"")
+	flags.StringVar(&target.targetXID, "target-xid", "",
+		"the recovery target transaction ID, resolved by reading the WAL archive")
+	flags.StringVar(&target.targetName, "target-name", "",
+		"the recovery target restore point, resolved by reading the WAL archive")
+}
+
+// loadRecoveryCatalog gets the backup catalog, including the history of
+// the target timeline, and the locator needed to resolve the target
+func (c *cli) loadRecoveryCatalog(
+	ctx context.Context,
+	target *recoveryTarget,
+) (*catalog.Catalog, catalog.RecoveryPointLocator, error) {
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backupList, err := command.GetBackupList(ctx, configuration, serverName, env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	walRestorer, options, err := c.walRestorer(ctx, configuration, serverName, env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	historyFetcher := c.timelineHistoryFetcher(walRestorer, options)
+	if err := backupList.LoadTimelineHistory(target.targetTLI, historyFetcher); err != nil {
+		return nil, nil, err
+	}
+
+	var locator catalog.RecoveryPointLocator
+	if target.targetXID != "" || target.targetName != "" {
+		locator = walinspect.NewLocator(c.walSegmentFetcher(walRestorer, options))
+	}
+
+	return backupList, locator, nil
+}
+
+func runFind(ctx context.Context, c *cli, args []string) error {
+	var target recoveryTarget
+	addRecoveryTargetFlags(c.newFlagSet(), &target)
+	if err := c.parse(args, 0); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	backupList, locator, err := c.loadRecoveryCatalog(ctx, &target)
+	if err != nil {
+		return err
+	}
+
+	backup, err := backupList.FindBackupInfoWithLocator(&target, locator)
+	if err != nil {
+		return err
+	}
+	if backup == nil {
+		return errors.New("no backup found for the recovery target")
+	}
+
+	return printBackup(c.stdout, c.output, backup)
+}
+
+func runPlan(ctx context.Context, c *cli, args []string) error {
+	var target recoveryTarget
+	addRecoveryTargetFlags(c.newFlagSet(), &target)
+	if err := c.parse(args, 0); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	backupList, locator, err := c.loadRecoveryCatalog(ctx, &target)
+	if err != nil {
+		return err
+	}
+
+	plan, err := backupList.PlanRecovery(&target, locator)
+	if err != nil {
+		return err
+	}
+
+	return printRecoveryPlan(c.stdout, c.output, plan)
+}
+
+// walRestorer creates a restorer fetching the files from the WAL archive,
+// returning the options to be passed to it
+func (c *cli) walRestorer(
+	ctx context.Context,
+	configuration *api.BarmanObjectStoreConfiguration,
+	serverName string,
+	env []string,
+) (*restorer.WALRestorer, []string, error) {
+	options, err := command.CloudWalRestoreOptions(ctx, configuration, serverName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scratchDir, err := c.getScratchDir()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	walRestorer, err := restorer.New(ctx, env, filepath.Join(scratchDir, "spool"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return walRestorer, options, nil
+}
+
+// walSegmentFetcher adapts a restorer to read WAL segments
+func (c *cli) walSegmentFetcher(walRestorer *restorer.WALRestorer, options []string) walinspect.SegmentFetcher {
+	return func(walName string) ([]byte, error) {
+		content, err := walRestorer.Fetch(walName, c.scratchDir, options)
+		if errors.Is(err, restorer.ErrWALNotFound) {
+			return nil, fmt.Errorf("%w: %s", walinspect.ErrSegmentNotFound, walName)
+		}
+		return content, err
+	}
+}
+
+// timelineHistoryFetcher adapts a restorer to read timeline history files
+func (c *cli) timelineHistoryFetcher(
+	walRestorer *restorer.WALRestorer,
+	options []string,
+) catalog.TimelineHistoryFetcher {
+	return func(tli int) ([]byte, error) {
+		content, err := walRestorer.Fetch(catalog.TimelineHistoryFileName(tli), c.scratchDir, options)
+		if errors.Is(err, restorer.ErrWALNotFound) {
+			return nil, fmt.Errorf("%w: timeline %d", catalog.ErrTimelineHistoryNotFound, tli)
+		}
+		return content, err
+	}
+}
+
+func runDelete(ctx context.Context, c *cli, args []string) error {
+	var retentionPolicy string
+	flags := c.newFlagSet()
+	flags.StringVar(&retentionPolicy, "policy", "",
+		"the retention policy, expressed as a number followed by d, w or m (i.e. 30d)")
+	if err := c.parse(args, 0); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	if retentionPolicy == "" {
+		_, _ = fmt.Fprintln(c.stderr, "The -policy flag is required")
+		return errUsage
+	}
+
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	return command.DeleteBackupsByPolicy(ctx, configuration, serverName, env, retentionPolicy)
+}
+
+func runKeep(ctx context.Context, c *cli, args []string) error {
+	var target string
+	var release, status bool
+	flags := c.newFlagSet()
+	flags.StringVar(&target, "target", "", "keep the backup with the given target (full or standalone)")
+	flags.BoolVar(&release, "release", false, "release the backup, making it subject to the retention policies")
+	flags.BoolVar(&status, "status", false, "show the keep status of the backup")
+	if err := c.parse(args, 1); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	actions := 0
+	for _, selected := range []bool{target != "", release, status} {
+		if selected {
+			actions++
+		}
+	}
+	if actions != 1 {
+		_, _ = fmt.Fprintln(c.stderr, "Exactly one of the -target, -release and -status flags is required")
+		return errUsage
+	}
+
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	backupID := c.flags.Arg(0)
+	switch {
+	case release:
+		return command.ReleaseBackup(ctx, configuration, serverName, env, backupID)
+	case status:
+		keepTarget, err := command.GetKeepStatus(ctx, configuration, serverName, env, backupID)
+		if err != nil {
+			return err
+		}
+		return printKeepStatus(c.stdout, c.output, keepTarget)
+	default:
+		return command.KeepBackup(ctx, configuration, serverName, env, backupID, catalog.KeepTarget(target))
+	}
+}
+
+func runArchive(ctx context.Context, c *cli, args []string) error {
+	c.newFlagSet()
+	if err := c.parse(args, 1); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	options, err := command.WalArchiveOptions(ctx, configuration, serverName)
+	if err != nil {
+		return err
+	}
+
+	archiver := &walarchive.BarmanArchiver{Env: env}
+	return archiver.Archive(ctx, c.flags.Arg(0), options)
+}
+
+func runRestore(ctx context.Context, c *cli, args []string) error {
+	c.newFlagSet()
+	if err := c.parse(args, 2); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	walRestorer, options, err := c.walRestorer(ctx, configuration, serverName, env)
+	if err != nil {
+		return err
+	}
+
+	return walRestorer.Restore(c.flags.Arg(0), c.flags.Arg(1), options)
+}
+
+func runCheck(ctx context.Context, c *cli, args []string) error {
+	var timeline uint32
+	flags := c.newFlagSet()
+	flags.Func("timeline",
+		"the timeline of the server, whose preceding timelines may be in the WAL archive",
+		func(value string) error {
+			parsed, err := strconv.ParseUint(value, 10, 32)
+			timeline = uint32(parsed)
+			return err
+		})
+	if err := c.parse(args, 0); err != nil {
+		return err
+	}
+	defer c.cleanup()
+
+	configuration, serverName, env, err := c.prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	options, err := command.CheckWalArchiveTimelineOptions(ctx, configuration, serverName, timeline)
+	if err != nil {
+		return err
+	}
+
+	archiver := &walarchive.BarmanArchiver{Env: env}
+	return archiver.CheckWalArchiveDestination(ctx, options)
+}
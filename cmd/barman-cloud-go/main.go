@@ -0,0 +1,124 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package main is barman-cloud-go, a command-line interface exposing the
+// operations of this library on a barman object store, such as listing the
+// backup catalog, enforcing retention policies and archiving or restoring
+// WAL files, without writing Go code.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitCodeUsage is the exit code used when the command line is not valid
+const exitCodeUsage = 2
+
+// errUsage is returned when the command line is not valid
+var errUsage = errors.New("invalid command line")
+
+// subcommand is a command of barman-cloud-go
+type subcommand struct {
+	// The name of the subcommand
+	name string
+
+	// The arguments, following the flags, shown in the usage
+	arguments string
+
+	// The description shown in the usage
+	description string
+
+	// The function defining the specific flags and running the subcommand
+	run func(ctx context.Context, cli *cli, args []string) error
+}
+
+var subcommands = []subcommand{
+	{name: "list", description: "list the backups in the object store", run: runList},
+	{name: "show", arguments: "<backup-id-or-name>", description: "show a backup", run: runShow},
+	{name: "latest", description: "show the latest successful backup", run: runLatest},
+	{name: "find", description: "find the backup to be used to reach a recovery target", run: runFind},
+	{name: "plan", description: "describe the recovery needed to reach a recovery target", run: runPlan},
+	{name: "delete", description: "delete the backups according to a retention policy", run: runDelete},
+	{
+		name:        "keep",
+		arguments:   "<backup-id-or-name>",
+		description: "keep a backup regardless of the retention policies, or release it",
+		run:         runKeep,
+	},
+	{name: "archive", arguments: "<wal-file-path>", description: "archive a WAL file", run: runArchive},
+	{
+		name:        "restore",
+		arguments:   "<wal-name> <destination-path>",
+		description: "restore a WAL file",
+		run:         runRestore,
+	},
+	{name: "check", description: "check that the WAL archive can be used by the server", run: runCheck},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	exitCode := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(exitCode)
+}
+
+// run executes barman-cloud-go with the passed arguments, returning the exit code
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return exitCodeUsage
+	}
+
+	for _, sub := range subcommands {
+		if sub.name != args[0] {
+			continue
+		}
+
+		err := sub.run(ctx, &cli{command: sub, stdout: stdout, stderr: stderr}, args[1:])
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, errUsage):
+			return exitCodeUsage
+		default:
+			_, _ = fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	if args[0] != "help" && args[0] != "-h" && args[0] != "--help" {
+		_, _ = fmt.Fprintf(stderr, "Unknown command %q\n\n", args[0])
+	}
+	printUsage(stderr)
+	return exitCodeUsage
+}
+
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Usage: barman-cloud-go <command> [flags] [arguments]\n\nCommands:\n")
+	for _, sub := range subcommands {
+		_, _ = fmt.Fprintf(w, "  %-8s %s\n", sub.name, sub.description)
+	}
+	_, _ = fmt.Fprintf(w, "\nUse \"barman-cloud-go <command> -help\" for the flags of a command.\n")
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("barman-cloud-go", func() {
+	var (
+		backupsDirectory string
+		configPath       string
+		stdout           *bytes.Buffer
+		stderr           *bytes.Buffer
+	)
+
+	writeBackupInfo := func(backupID string, name string, beginTime string, endTime string) {
+		directory := filepath.Join(backupsDirectory, "cluster-example", "base", backupID)
+		Expect(os.MkdirAll(directory, 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(directory, "backup.info"), []byte(
+			"backup_label='START WAL LOCATION: 0/2000028'\n"+
+				"backup_name="+name+"\n"+
+				"begin_time="+beginTime+"\n"+
+				"begin_wal=000000010000000000000002\n"+
+				"begin_xlog=0/2000028\n"+
+				"end_time="+endTime+"\n"+
+				"end_wal=000000010000000000000002\n"+
+				"end_xlog=0/2000100\n"+
+				"error=None\n"+
+				"status=DONE\n"+
+				"systemid=7143486741397123456\n"+
+				"timeline=1\n"), 0o600)).To(Succeed())
+	}
+
+	execute := func(args ...string) int {
+		stdout.Reset()
+		stderr.Reset()
+		return run(context.Background(), args, stdout, stderr)
+	}
+
+	BeforeEach(func() {
+		tempDir := GinkgoT().TempDir()
+		backupsDirectory = filepath.Join(tempDir, "backups")
+		Expect(os.MkdirAll(backupsDirectory, 0o750)).To(Succeed())
+
+		configPath = filepath.Join(tempDir, "config.yaml")
+		Expect(os.WriteFile(configPath, []byte(
+			"destinationPath: file://"+backupsDirectory+"\nserverName: cluster-example\n"), 0o600)).To(Succeed())
+
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+
+		writeBackupInfo("20240101T000000", "first", "2024-01-01 00:00:00+00:00", "2024-01-01 00:10:00+00:00")
+		writeBackupInfo("20240105T000000", "second", "2024-01-05 00:00:00+00:00", "2024-01-05 00:10:00+00:00")
+	})
+
+	It("prints the usage when the command is unknown", func() {
+		Expect(execute("unknown")).To(Equal(exitCodeUsage))
+		Expect(stderr.String()).To(ContainSubstring("Unknown command"))
+		Expect(stderr.String()).To(ContainSubstring("Usage: barman-cloud-go"))
+	})
+
+	It("requires the configuration", func() {
+		Expect(execute("list")).To(Equal(exitCodeUsage))
+		Expect(stderr.String()).To(ContainSubstring("-config"))
+	})
+
+	It("lists the backups as a table", func() {
+		Expect(execute("list", "-config", configPath)).To(Equal(0), stderr.String())
+		Expect(stdout.String()).To(ContainSubstring("ID"))
+		Expect(stdout.String()).To(ContainSubstring("20240101T000000"))
+		Expect(stdout.String()).To(ContainSubstring("20240105T000000"))
+	})
+
+	It("lists the backups as JSON", func() {
+		Expect(execute("list", "-config", configPath, "-output", "json")).To(Equal(0), stderr.String())
+
+		var backups []catalog.BarmanBackup
+		Expect(json.Unmarshal(stdout.Bytes(), &backups)).To(Succeed())
+		Expect(backups).To(HaveLen(2))
+	})
+
+	It("shows the latest backup and a backup by name", func() {
+		Expect(execute("latest", "-config", configPath, "-output", "json")).To(Equal(0), stderr.String())
+		var backup catalog.BarmanBackup
+		Expect(json.Unmarshal(stdout.Bytes(), &backup)).To(Succeed())
+		Expect(backup.ID).To(Equal("20240105T000000"))
+
+		Expect(execute("show", "-config", configPath, "first")).To(Equal(0), stderr.String())
+		Expect(stdout.String()).To(ContainSubstring("20240101T000000"))
+	})
+
+	It("finds the backup for a recovery target", func() {
+		Expect(execute("find", "-config", configPath, "-output", "json",
+			"-target-time", "2024-01-03 00:00:00+00:00")).To(Equal(0), stderr.String())
+		var backup catalog.BarmanBackup
+		Expect(json.Unmarshal(stdout.Bytes(), &backup)).To(Succeed())
+		Expect(backup.ID).To(Equal("20240101T000000"))
+	})
+
+	It("describes the recovery plan", func() {
+		Expect(execute("plan", "-config", configPath, "-output", "json",
+			"-target-time", "2024-01-03 00:00:00+00:00")).To(Equal(0), stderr.String())
+		var plan catalog.RecoveryPlan
+		Expect(json.Unmarshal(stdout.Bytes(), &plan)).To(Succeed())
+		Expect(plan.Backup.ID).To(Equal("20240101T000000"))
+		Expect(plan.FirstWAL).To(Equal("000000010000000000000002"))
+
+		Expect(execute("plan", "-config", configPath)).To(Equal(0), stderr.String())
+		Expect(stdout.String()).To(ContainSubstring("20240105T000000"))
+	})
+
+	It("reads the WAL archive to find the backup for a restore point", func() {
+		Expect(execute("find", "-config", configPath, "-target-name", "before_upgrade")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("recovery target not found in the WAL archive"))
+	})
+
+	It("archives and restores a WAL file", func() {
+		walPath := filepath.Join(GinkgoT().TempDir(), "000000010000000000000003")
+		Expect(os.WriteFile(walPath, []byte("wal content"), 0o600)).To(Succeed())
+		Expect(execute("archive", "-config", configPath, walPath)).To(Equal(0), stderr.String())
+
+		destination := filepath.Join(GinkgoT().TempDir(), "RECOVERYXLOG")
+		Expect(execute("restore", "-config", configPath,
+			"000000010000000000000003", destination)).To(Equal(0), stderr.String())
+		Expect(os.ReadFile(destination)).To(Equal([]byte("wal content")))
+
+		Expect(execute("check", "-config", configPath)).To(Equal(1))
+		Expect(execute("check", "-config", configPath, "-timeline", "1")).To(Equal(1))
+		Expect(execute("check", "-config", configPath, "-timeline", "2")).To(Equal(0), stderr.String())
+	})
+
+	It("requires a retention policy to delete backups", func() {
+		Expect(execute("delete", "-config", configPath)).To(Equal(exitCodeUsage))
+		Expect(execute("delete", "-config", configPath, "-policy", "1d")).To(Equal(0), stderr.String())
+	})
+
+	It("keeps a backup regardless of the retention policy", func() {
+		Expect(execute("keep", "-config", configPath, "first")).To(Equal(exitCodeUsage))
+		Expect(execute("keep", "-config", configPath, "-target", "full", "first")).To(Equal(0), stderr.String())
+		Expect(execute("keep", "-config", configPath, "-status", "first")).To(Equal(0), stderr.String())
+		Expect(stdout.String()).To(Equal("Keep: full\n"))
+
+		Expect(execute("delete", "-config", configPath, "-policy", "1d")).To(Equal(0), stderr.String())
+		Expect(execute("show", "-config", configPath, "first")).To(Equal(0), stderr.String())
+
+		Expect(execute("keep", "-config", configPath, "-release", "first")).To(Equal(0), stderr.String())
+		Expect(execute("keep", "-config", configPath, "-status", "-output", "json", "first")).
+			To(Equal(0), stderr.String())
+		Expect(stdout.String()).To(MatchJSON(`{"keep": ""}`))
+	})
+})
@@ -0,0 +1,191 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+)
+
+// printBackups prints a list of backups in the requested format
+func printBackups(w io.Writer, format string, backups []catalog.BarmanBackup) error {
+	if format == outputJSON {
+		if backups == nil {
+			backups = []catalog.BarmanBackup{}
+		}
+		return printJSON(w, backups)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tBEGIN TIME\tEND TIME\tBEGIN WAL\tEND WAL\tTIMELINE\tSIZE")
+	for _, backup := range backups {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			backup.ID,
+			valueOrDash(backup.BackupName),
+			valueOrDash(string(backup.Status)),
+			formatTime(backup.BeginTime),
+			formatTime(backup.EndTime),
+			valueOrDash(backup.BeginWal),
+			valueOrDash(backup.EndWal),
+			backup.TimeLine,
+			formatSize(backup.Size),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// printBackup prints a single backup in the requested format
+func printBackup(w io.Writer, format string, backup *catalog.BarmanBackup) error {
+	if format == outputJSON {
+		return printJSON(w, backup)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	rows := [][2]string{
+		{"ID", backup.ID},
+		{"Name", valueOrDash(backup.BackupName)},
+		{"Status", valueOrDash(string(backup.Status))},
+		{"Type", backup.GetBackupType()},
+		{"Parent backup", valueOrDash(backup.ParentBackupID)},
+		{"Label", valueOrDash(backup.Label)},
+		{"PostgreSQL version", formatVersion(backup.Version)},
+		{"System ID", valueOrDash(backup.SystemID)},
+		{"Timeline", fmt.Sprint(backup.TimeLine)},
+		{"Begin time", formatTime(backup.BeginTime)},
+		{"End time", formatTime(backup.EndTime)},
+		{"Begin WAL", valueOrDash(backup.BeginWal)},
+		{"End WAL", valueOrDash(backup.EndWal)},
+		{"Begin LSN", valueOrDash(backup.BeginLSN)},
+		{"End LSN", valueOrDash(backup.EndLSN)},
+		{"Size", formatSize(backup.Size)},
+		{"Deduplicated size", formatSize(backup.DeduplicatedSize)},
+		{"Compression", valueOrDash(backup.Compression)},
+		{"Keep", valueOrDash(string(backup.Keep))},
+	}
+	for _, tablespace := range backup.Tablespaces {
+		rows = append(rows, [2]string{
+			"Tablespace",
+			fmt.Sprintf("%s (OID %d) at %s", tablespace.Name, tablespace.OID, tablespace.Location),
+		})
+	}
+	if backup.Error != "" {
+		rows = append(rows, [2]string{"Error", backup.Error})
+	}
+
+	for _, row := range rows {
+		_, _ = fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+	}
+
+	return tw.Flush()
+}
+
+// printRecoveryPlan prints a recovery plan in the requested format
+func printRecoveryPlan(w io.Writer, format string, plan *catalog.RecoveryPlan) error {
+	if format == outputJSON {
+		return printJSON(w, plan)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	backupID := "-"
+	if plan.Backup != nil {
+		backupID = plan.Backup.ID
+	}
+	timelines := make([]string, len(plan.Timelines))
+	for idx, tli := range plan.Timelines {
+		timelines[idx] = fmt.Sprint(tli)
+	}
+	lastWAL := valueOrDash(plan.LastWAL)
+	if plan.LastWAL != "" && !plan.LastWALContainsTarget {
+		lastWAL += " (consistency point, the recovery continues until the target is reached)"
+	}
+	estimatedSize := fmt.Sprintf("%d bytes", plan.EstimatedBytes)
+	if plan.EstimateIsLowerBound {
+		estimatedSize = "at least " + estimatedSize
+	}
+
+	rows := [][2]string{
+		{"Backup", backupID},
+		{"Reason", plan.Reason},
+		{"First WAL", valueOrDash(plan.FirstWAL)},
+		{"Last WAL", lastWAL},
+		{"Timelines", valueOrDash(strings.Join(timelines, ", "))},
+		{"Estimated size", estimatedSize},
+	}
+	for _, warning := range plan.Warnings {
+		rows = append(rows, [2]string{"Warning", warning})
+	}
+
+	for _, row := range rows {
+		_, _ = fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+	}
+
+	return tw.Flush()
+}
+
+// printKeepStatus prints the keep status of a backup in the requested format
+func printKeepStatus(w io.Writer, format string, target catalog.KeepTarget) error {
+	if format == outputJSON {
+		return printJSON(w, map[string]catalog.KeepTarget{"keep": target})
+	}
+
+	_, err := fmt.Fprintf(w, "Keep: %s\n", valueOrDash(string(target)))
+	return err
+}
+
+func printJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatSize(size int64) string {
+	if size <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", size)
+}
+
+func formatVersion(version int) string {
+	if version <= 0 {
+		return "-"
+	}
+	return fmt.Sprint(version)
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
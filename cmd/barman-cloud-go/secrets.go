@@ -0,0 +1,117 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// secretsDirClient is a Kubernetes client reading the secrets
+// from a local directory, laid out as <secret-name>/<key> like
+// mounted Kubernetes secrets. Only the Get method is implemented,
+// as it's the only one used to gather the credentials.
+type secretsDirClient struct {
+	client.Client
+
+	// The directory containing the secrets
+	directory string
+}
+
+// Get reads a secret from the secrets directory
+func (c *secretsDirClient) Get(
+	_ context.Context,
+	key client.ObjectKey,
+	obj client.Object,
+	_ ...client.GetOption,
+) error {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return fmt.Errorf("unsupported object type %T", obj)
+	}
+
+	if c.directory == "" {
+		return fmt.Errorf("secret %s is referenced by the configuration, but -secrets-dir is not set", key.Name)
+	}
+
+	if key.Name == "" || strings.ContainsAny(key.Name, `/\`) || strings.HasPrefix(key.Name, ".") {
+		return fmt.Errorf("invalid secret name %q", key.Name)
+	}
+
+	secretDirectory := filepath.Join(c.directory, key.Name)
+	entries, err := os.ReadDir(secretDirectory)
+	if errors.Is(err, os.ErrNotExist) {
+		return apierrs.NewNotFound(corev1.Resource("secrets"), key.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	secret.Name = key.Name
+	secret.Namespace = key.Namespace
+	secret.Data = make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		// Skip the hidden files, such as the "..data" symlink
+		// created when mounting a Kubernetes secret
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		fileName := filepath.Join(secretDirectory, entry.Name())
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		content, err := os.ReadFile(fileName) // #nosec G304
+		if err != nil {
+			return err
+		}
+		secret.Data[entry.Name()] = content
+	}
+
+	return nil
+}
+
+// getSecretValue gets the value of a key inside a secret
+func (c *secretsDirClient) getSecretValue(ctx context.Context, name, key string) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, secret); err != nil {
+		return nil, fmt.Errorf("while getting secret %s: %w", name, err)
+	}
+
+	value, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("missing key %s, inside secret %s", key, name)
+	}
+
+	return value, nil
+}
@@ -24,10 +24,12 @@ SPDX-License-Identifier: Apache-2.0
 package api
 
 import (
+	"regexp"
 	"slices"
 	"strings"
 
 	machineryapi "github.com/cloudnative-pg/machinery/pkg/api"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
@@ -71,6 +73,45 @@ const (
 	CompressionTypeZstd = CompressionType("zstd")
 )
 
+// S3AddressingStyle is the addressing style used to reach an S3 bucket
+type S3AddressingStyle string
+
+const (
+	// S3AddressingStyleAuto lets the AWS SDK choose the addressing style
+	S3AddressingStyleAuto = S3AddressingStyle("auto")
+
+	// S3AddressingStyleVirtual means the bucket name is part of the host name
+	S3AddressingStyleVirtual = S3AddressingStyle("virtual")
+
+	// S3AddressingStylePath means the bucket name is part of the URL path
+	S3AddressingStylePath = S3AddressingStyle("path")
+)
+
+// S3SignatureVersion is the version of the algorithm used to sign S3 requests
+type S3SignatureVersion string
+
+const (
+	// S3SignatureVersionV2 means requests are signed using the legacy SigV2 algorithm
+	S3SignatureVersionV2 = S3SignatureVersion("s3")
+
+	// S3SignatureVersionV4 means requests are signed using the SigV4 algorithm
+	S3SignatureVersionV4 = S3SignatureVersion("s3v4")
+)
+
+// S3ChecksumMode controls when the checksums of S3 requests and
+// responses are computed
+type S3ChecksumMode string
+
+const (
+	// S3ChecksumModeWhenSupported means checksums are computed whenever
+	// the operation supports them
+	S3ChecksumModeWhenSupported = S3ChecksumMode("when_supported")
+
+	// S3ChecksumModeWhenRequired means checksums are computed only when
+	// the operation requires them
+	S3ChecksumModeWhenRequired = S3ChecksumMode("when_required")
+)
+
 // BarmanCredentials an object containing the potential credentials for each cloud provider
 type BarmanCredentials struct {
 	// The credentials to use to upload data to Google Cloud Storage
@@ -92,6 +133,9 @@ type BarmanCredentials struct {
 // - explicitly passing accessKeyId and secretAccessKey
 //
 // - inheriting the role from the pod environment by setting inheritFromIAMRole to true
+//
+// It also contains the options needed to reach S3-compatible object stores,
+// such as Ceph RGW, MinIO or Wasabi.
 type S3Credentials struct {
 	// The reference to the access key id
 	// +optional
@@ -112,6 +156,39 @@ type S3Credentials struct {
 	// Use the role based authentication without providing explicitly the keys.
 	// +optional
 	InheritFromIAMRole bool `json:"inheritFromIAMRole,omitempty"`
+
+	// The name of the region, to be used instead of the reference
+	// to the secret containing it
+	// +optional
+	RegionName string `json:"regionName,omitempty"`
+
+	// The addressing style used to reach the bucket. Available options
+	// are `auto` (default), `virtual` and `path`. Most on-premise
+	// S3-compatible object stores require `path`.
+	// +kubebuilder:validation:Enum=auto;virtual;path
+	// +optional
+	AddressingStyle S3AddressingStyle `json:"addressingStyle,omitempty"`
+
+	// The version of the algorithm used to sign the requests. Available
+	// options are `s3v4` (default) and `s3`, the legacy SigV2 algorithm
+	// which is still required by some S3-compatible object stores.
+	// +kubebuilder:validation:Enum=s3;s3v4
+	// +optional
+	SignatureVersion S3SignatureVersion `json:"signatureVersion,omitempty"`
+
+	// Control when the checksums of the requests are calculated. Available
+	// options are `when_supported` (default) and `when_required`. Set it to
+	// `when_required` when the object store doesn't support the checksum
+	// headers sent by recent versions of the AWS SDK.
+	// +kubebuilder:validation:Enum=when_supported;when_required
+	// +optional
+	RequestChecksumCalculation S3ChecksumMode `json:"requestChecksumCalculation,omitempty"`
+
+	// Control when the checksums of the responses are validated. Available
+	// options are `when_supported` (default) and `when_required`.
+	// +kubebuilder:validation:Enum=when_supported;when_required
+	// +optional
+	ResponseChecksumValidation S3ChecksumMode `json:"responseChecksumValidation,omitempty"`
 }
 
 // AzureCredentials is the type for the credentials to be used to upload
@@ -165,6 +242,18 @@ type GoogleCredentials struct {
 	GKEEnvironment bool `json:"gkeEnvironment,omitempty"`
 }
 
+// TLSConfiguration is the client-side TLS configuration used to connect to
+// the object store endpoint, i.e. an on-premise MinIO or Ceph.
+// Only the CA bundle can be set, as the barman-cloud tools and the cloud
+// provider SDKs they use have no setting to present a client certificate,
+// to override the server name or to skip the verification.
+type TLSConfiguration struct {
+	// The secret containing the CA bundle used to verify the certificate
+	// presented by the object store endpoint
+	// +optional
+	CA *machineryapi.SecretKeySelector `json:"ca,omitempty"`
+}
+
 // BarmanObjectStoreConfiguration contains the backup configuration
 // using Barman against an S3-compatible object storage
 type BarmanObjectStoreConfiguration struct {
@@ -182,6 +271,11 @@ type BarmanObjectStoreConfiguration struct {
 	// +optional
 	EndpointCA *machineryapi.SecretKeySelector `json:"endpointCA,omitempty"`
 
+	// The client-side TLS configuration to be used when connecting
+	// to the object store endpoint
+	// +optional
+	TLS *TLSConfiguration `json:"tls,omitempty"`
+
 	// The path where to store the backup (i.e. s3://bucket/path/to/folder)
 	// this path, with different destination folders, will be used for WALs
 	// and for data
@@ -244,6 +338,25 @@ type WalBackupConfiguration struct {
 	// +kubebuilder:validation:Minimum=1
 	// +optional
 	MaxParallel int `json:"maxParallel,omitempty"`
+
+	// The maximum number of bytes per second transferred while archiving
+	// and restoring WAL files, shared by the WAL files processed in
+	// parallel and by the processes using the same spool directory.
+	// The limit is enforced on average, as every WAL file is transferred
+	// at full speed once admitted. If not specified, the bandwidth is
+	// not limited.
+	// +optional
+	MaxBandwidth *resource.Quantity `json:"maxBandwidth,omitempty"`
+
+	// The maximum number of WAL files archived or restored per second,
+	// shared by the WAL files processed in parallel and by the processes
+	// using the same spool directory. This helps staying below the request
+	// rate limits of the object store. If not specified, the request rate
+	// is not limited.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxRequestsPerSecond *int32 `json:"maxRequestsPerSecond,omitempty"`
+
 	// Additional arguments that can be appended to the 'barman-cloud-wal-archive'
 	// command-line invocation. These arguments provide flexibility to customize
 	// the WAL archive process further, according to specific requirements or configurations.
@@ -301,6 +414,13 @@ type DataBackupConfiguration struct {
 	// +optional
 	Jobs *int32 `json:"jobs,omitempty"`
 
+	// The maximum number of bytes per second uploaded while taking a
+	// backup, passed to barman-cloud-backup as `--max-bandwidth` and
+	// requiring barman >= 2.18.0. If not specified, the bandwidth is
+	// not limited.
+	// +optional
+	MaxBandwidth *resource.Quantity `json:"maxBandwidth,omitempty"`
+
 	// Control whether the I/O workload for the backup initial checkpoint will
 	// be limited, according to the `checkpoint_completion_target` setting on
 	// the PostgreSQL server. If set to true, an immediate checkpoint will be
@@ -445,9 +565,86 @@ func (s3 *S3Credentials) ValidateAwsCredentials(path *field.Path) field.ErrorLis
 		)
 	}
 
+	return append(allErrors, s3.validateS3Options(path)...)
+}
+
+var regexS3Region = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// validateS3Options validates the options used to reach S3-compatible object stores
+func (s3 *S3Credentials) validateS3Options(path *field.Path) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	if s3.RegionName != "" && s3.RegionReference != nil {
+		allErrors = append(
+			allErrors,
+			field.Invalid(
+				path.Child("regionName"),
+				s3.RegionName,
+				"regionName and region are mutually exclusive",
+			))
+	}
+
+	if s3.RegionName != "" && !regexS3Region.MatchString(s3.RegionName) {
+		allErrors = append(
+			allErrors,
+			field.Invalid(
+				path.Child("regionName"),
+				s3.RegionName,
+				"must consist of lower case alphanumeric characters separated by '-'",
+			))
+	}
+
+	addressingStyles := []S3AddressingStyle{S3AddressingStyleAuto, S3AddressingStyleVirtual, S3AddressingStylePath}
+	if s3.AddressingStyle != "" && !slices.Contains(addressingStyles, s3.AddressingStyle) {
+		allErrors = append(
+			allErrors,
+			field.NotSupported(
+				path.Child("addressingStyle"),
+				s3.AddressingStyle,
+				addressingStyles,
+			))
+	}
+
+	signatureVersions := []S3SignatureVersion{S3SignatureVersionV2, S3SignatureVersionV4}
+	if s3.SignatureVersion != "" && !slices.Contains(signatureVersions, s3.SignatureVersion) {
+		allErrors = append(
+			allErrors,
+			field.NotSupported(
+				path.Child("signatureVersion"),
+				s3.SignatureVersion,
+				signatureVersions,
+			))
+	}
+
+	checksumModes := []S3ChecksumMode{S3ChecksumModeWhenSupported, S3ChecksumModeWhenRequired}
+	if s3.RequestChecksumCalculation != "" && !slices.Contains(checksumModes, s3.RequestChecksumCalculation) {
+		allErrors = append(
+			allErrors,
+			field.NotSupported(
+				path.Child("requestChecksumCalculation"),
+				s3.RequestChecksumCalculation,
+				checksumModes,
+			))
+	}
+	if s3.ResponseChecksumValidation != "" && !slices.Contains(checksumModes, s3.ResponseChecksumValidation) {
+		allErrors = append(
+			allErrors,
+			field.NotSupported(
+				path.Child("responseChecksumValidation"),
+				s3.ResponseChecksumValidation,
+				checksumModes,
+			))
+	}
+
 	return allErrors
 }
 
+// RequiresAWSConfigFile returns true when the S3 options can only
+// be applied through the AWS configuration file
+func (s3 *S3Credentials) RequiresAWSConfigFile() bool {
+	return s3 != nil && (s3.AddressingStyle != "" || s3.SignatureVersion != "")
+}
+
 // ValidateGCSCredentials validates the GCS credentials
 func (gcs *GoogleCredentials) ValidateGCSCredentials(path *field.Path) field.ErrorList {
 	allErrors := field.ErrorList{}
@@ -508,6 +705,14 @@ func (cfg *WalBackupConfiguration) AppendRestoreAdditionalCommandArgs(options []
 }
 
 func appendAdditionalCommandArgs(additionalCommandArgs []string, options []string) []string {
+	accepted, _ := SplitAdditionalCommandArgs(additionalCommandArgs, options)
+	return append(options, accepted...)
+}
+
+// SplitAdditionalCommandArgs splits the additional command arguments between
+// the accepted ones and the ones which are dropped because the passed
+// options already contain them
+func SplitAdditionalCommandArgs(additionalCommandArgs []string, options []string) (accepted, dropped []string) {
 	optionKeys := map[string]bool{}
 	for _, option := range options {
 		key := strings.Split(option, "=")[0]
@@ -517,10 +722,11 @@ func appendAdditionalCommandArgs(additionalCommandArgs []string, options []strin
 	}
 	for _, additionalCommandArg := range additionalCommandArgs {
 		key := strings.Split(additionalCommandArg, "=")[0]
-		if key == "" || slices.Contains(options, key) || optionKeys[key] {
+		if key == "" || slices.Contains(options, key) || slices.Contains(accepted, key) || optionKeys[key] {
+			dropped = append(dropped, additionalCommandArg)
 			continue
 		}
-		options = append(options, additionalCommandArg)
+		accepted = append(accepted, additionalCommandArg)
 	}
-	return options
+	return accepted, dropped
 }
@@ -362,3 +362,74 @@ var _ = Describe("azure credentials", func() {
 		Expect(azureCredentials.ValidateAzureCredentials(path)).ToNot(BeEmpty())
 	})
 })
+
+var _ = Describe("S3 options", func() {
+	path := field.NewPath("spec", "s3Credentials")
+
+	It("is correct when using the options of an S3-compatible object store", func() {
+		s3Credentials := S3Credentials{
+			InheritFromIAMRole:         true,
+			RegionName:                 "eu-central-1",
+			AddressingStyle:            S3AddressingStylePath,
+			SignatureVersion:           S3SignatureVersionV2,
+			RequestChecksumCalculation: S3ChecksumModeWhenRequired,
+			ResponseChecksumValidation: S3ChecksumModeWhenRequired,
+		}
+		Expect(s3Credentials.ValidateAwsCredentials(path)).To(BeEmpty())
+		Expect(s3Credentials.RequiresAWSConfigFile()).To(BeTrue())
+	})
+
+	It("doesn't require a configuration file when only the region is specified", func() {
+		s3Credentials := S3Credentials{
+			InheritFromIAMRole: true,
+			RegionName:         "us-east-1",
+		}
+		Expect(s3Credentials.ValidateAwsCredentials(path)).To(BeEmpty())
+		Expect(s3Credentials.RequiresAWSConfigFile()).To(BeFalse())
+	})
+
+	It("is not correct when the region is specified twice", func() {
+		s3Credentials := S3Credentials{
+			InheritFromIAMRole: true,
+			RegionName:         "us-east-1",
+			RegionReference: &machineryapi.SecretKeySelector{
+				LocalObjectReference: machineryapi.LocalObjectReference{
+					Name: "s3-config",
+				},
+				Key: "region",
+			},
+		}
+		errs := s3Credentials.ValidateAwsCredentials(path)
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Field).To(Equal("spec.s3Credentials.regionName"))
+	})
+
+	It("is not correct when the region name is malformed", func() {
+		s3Credentials := S3Credentials{
+			InheritFromIAMRole: true,
+			RegionName:         "US East",
+		}
+		Expect(s3Credentials.ValidateAwsCredentials(path)).To(HaveLen(1))
+	})
+
+	It("is not correct when using unknown values", func() {
+		s3Credentials := S3Credentials{
+			InheritFromIAMRole:         true,
+			AddressingStyle:            "dns",
+			SignatureVersion:           "v5",
+			RequestChecksumCalculation: "always",
+			ResponseChecksumValidation: "never",
+		}
+		Expect(s3Credentials.ValidateAwsCredentials(path)).To(HaveLen(4))
+	})
+})
+
+var _ = Describe("SplitAdditionalCommandArgs", func() {
+	It("reports the arguments duplicating the existing options", func() {
+		accepted, dropped := SplitAdditionalCommandArgs(
+			[]string{"--read-timeout=60", "--jobs=4", "--immediate-checkpoint", "--immediate-checkpoint"},
+			[]string{"--jobs", "2", "s3://bucket", "server"})
+		Expect(accepted).To(Equal([]string{"--read-timeout=60", "--immediate-checkpoint"}))
+		Expect(dropped).To(Equal([]string{"--jobs=4", "--immediate-checkpoint"}))
+	})
+})
@@ -0,0 +1,241 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	machineryapi "github.com/cloudnative-pg/machinery/pkg/api"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// CloudProviderAWS is the name of the AWS S3 cloud provider
+	CloudProviderAWS = "aws-s3"
+
+	// CloudProviderAzure is the name of the Azure Blob Storage cloud provider
+	CloudProviderAzure = "azure-blob-storage"
+
+	// CloudProviderGoogle is the name of the Google Cloud Storage cloud provider
+	CloudProviderGoogle = "google-cloud-storage"
+
+	// CloudProviderFile is the name of the local filesystem provider,
+	// selected by destination paths using the file:// scheme
+	CloudProviderFile = "file"
+)
+
+// fileDestinationPathPrefix is the prefix of the destination
+// paths targeting the local filesystem provider
+const fileDestinationPathPrefix = CloudProviderFile + "://"
+
+// CloudProvider is an object store provider that can be targeted
+// by a BarmanObjectStoreConfiguration. Every provider contributes the
+// validation of its configuration, the barman-cloud command-line
+// options and the environment needed to reach it, so that
+// supporting a new provider only requires registering it.
+type CloudProvider interface {
+	// Name returns the name of the provider, which is the value
+	// of the barman-cloud --cloud-provider option
+	Name() string
+
+	// IsConfigured returns true when the passed configuration
+	// is targeting this provider
+	IsConfigured(configuration *BarmanObjectStoreConfiguration) bool
+
+	// Validate validates the part of the configuration
+	// which is specific to this provider
+	Validate(configuration *BarmanObjectStoreConfiguration, path *field.Path) field.ErrorList
+
+	// AppendOptions appends the provider-specific barman-cloud options
+	// to the passed ones. The --cloud-provider option is already included.
+	AppendOptions(ctx context.Context, options []string, credentials BarmanCredentials) ([]string, error)
+
+	// EnvSet appends the provider-specific environment variables
+	// of the barman-cloud tools to the passed ones
+	EnvSet(
+		ctx context.Context,
+		configuration *BarmanObjectStoreConfiguration,
+		env []string,
+		envOptions EnvOptions,
+	) ([]string, error)
+}
+
+// SecretValueGetter gets the value of a key inside a secret
+type SecretValueGetter func(ctx context.Context, reference *machineryapi.SecretKeySelector) ([]byte, error)
+
+// EnvOptions contains what the cloud providers need
+// to prepare the environment of the barman-cloud tools
+// +kubebuilder:object:generate=false
+type EnvOptions struct {
+	// GetSecretValue reads the secrets referenced by the configuration
+	GetSecretValue SecretValueGetter
+
+	// ScratchDirectory is the directory where the files needed by the
+	// cloud provider SDKs, such as the Google application credentials,
+	// are written
+	ScratchDirectory string
+}
+
+var (
+	cloudProvidersMutex sync.RWMutex
+	cloudProviders      []CloudProvider
+)
+
+func init() {
+	RegisterCloudProvider(awsCloudProvider{})
+	RegisterCloudProvider(azureCloudProvider{})
+	RegisterCloudProvider(googleCloudProvider{})
+	RegisterCloudProvider(fileCloudProvider{})
+}
+
+// RegisterCloudProvider adds a cloud provider to the registry, replacing
+// the one having the same name if it was already registered.
+// Providers are detected in registration order.
+func RegisterCloudProvider(provider CloudProvider) {
+	cloudProvidersMutex.Lock()
+	defer cloudProvidersMutex.Unlock()
+
+	idx := slices.IndexFunc(cloudProviders, func(p CloudProvider) bool {
+		return p.Name() == provider.Name()
+	})
+	if idx >= 0 {
+		cloudProviders[idx] = provider
+		return
+	}
+	cloudProviders = append(cloudProviders, provider)
+}
+
+// GetCloudProviders returns the registered cloud providers
+func GetCloudProviders() []CloudProvider {
+	cloudProvidersMutex.RLock()
+	defer cloudProvidersMutex.RUnlock()
+
+	return slices.Clone(cloudProviders)
+}
+
+// GetCloudProvider returns the cloud provider registered with the
+// passed name, or nil if there's no such provider
+func GetCloudProvider(name string) CloudProvider {
+	for _, provider := range GetCloudProviders() {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+
+	return nil
+}
+
+// DetectCloudProviders returns every registered cloud provider
+// targeted by the passed configuration
+func DetectCloudProviders(configuration *BarmanObjectStoreConfiguration) []CloudProvider {
+	var result []CloudProvider
+	for _, provider := range GetCloudProviders() {
+		if provider.IsConfigured(configuration) {
+			result = append(result, provider)
+		}
+	}
+
+	return result
+}
+
+// DetectCloudProvider returns the first registered cloud provider targeted
+// by the passed configuration, or nil if there's no such provider
+func DetectCloudProvider(configuration *BarmanObjectStoreConfiguration) CloudProvider {
+	for _, provider := range GetCloudProviders() {
+		if provider.IsConfigured(configuration) {
+			return provider
+		}
+	}
+
+	return nil
+}
+
+// validateDestinationPathURL checks that the destination path is a URL
+// using one of the passed schemes and containing the bucket name.
+// An empty destination path is rejected by the CRD schema.
+func validateDestinationPathURL(destinationPath string, path *field.Path, schemes ...string) field.ErrorList {
+	allErrors := field.ErrorList{}
+	if destinationPath == "" {
+		return allErrors
+	}
+
+	destinationURL, err := url.Parse(destinationPath)
+	if err != nil {
+		return append(allErrors, field.Invalid(path, destinationPath, err.Error()))
+	}
+
+	if !slices.Contains(schemes, destinationURL.Scheme) {
+		expected := make([]string, len(schemes))
+		for idx := range schemes {
+			expected[idx] = schemes[idx] + "://"
+		}
+		return append(allErrors, field.Invalid(
+			path,
+			destinationPath,
+			fmt.Sprintf("the destination path must use the %s scheme for the configured credentials",
+				strings.Join(expected, " or ")),
+		))
+	}
+
+	if destinationURL.Host == "" {
+		allErrors = append(allErrors, field.Invalid(
+			path,
+			destinationPath,
+			"the destination path must contain the bucket name",
+		))
+	}
+
+	return allErrors
+}
+
+// IsFileDestinationPath returns true when the destination path
+// targets the local filesystem provider
+func IsFileDestinationPath(destinationPath string) bool {
+	return strings.HasPrefix(destinationPath, fileDestinationPathPrefix)
+}
+
+// ParseFileDestinationPath returns the local directory referenced by a
+// destination path using the file:// scheme, i.e. file:///var/lib/backups
+func ParseFileDestinationPath(destinationPath string) (string, error) {
+	destinationURL, err := url.Parse(destinationPath)
+	if err != nil {
+		return "", err
+	}
+
+	if destinationURL.Scheme != CloudProviderFile {
+		return "", fmt.Errorf("the destination path must use the %s scheme", fileDestinationPathPrefix)
+	}
+
+	if destinationURL.Host != "" && destinationURL.Host != "localhost" {
+		return "", fmt.Errorf("the destination path must not contain a host name")
+	}
+
+	if !filepath.IsAbs(destinationURL.Path) {
+		return "", fmt.Errorf("the destination path must contain an absolute path")
+	}
+
+	return filepath.Clean(destinationURL.Path), nil
+}
@@ -0,0 +1,207 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudnative-pg/machinery/pkg/fileutils"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// +kubebuilder:object:generate=false
+type awsCloudProvider struct{}
+
+func (awsCloudProvider) Name() string {
+	return CloudProviderAWS
+}
+
+func (awsCloudProvider) IsConfigured(configuration *BarmanObjectStoreConfiguration) bool {
+	return configuration.AWS != nil
+}
+
+func (awsCloudProvider) Validate(configuration *BarmanObjectStoreConfiguration, path *field.Path) field.ErrorList {
+	allErrors := validateDestinationPathURL(configuration.DestinationPath, path.Child("destinationPath"), "s3")
+	allErrors = append(allErrors, configuration.AWS.ValidateAwsCredentials(path.Child("s3Credentials"))...)
+	return append(allErrors, validateS3SignatureVersion(configuration, path)...)
+}
+
+// AppendOptions doesn't add any option, as the S3 options are applied
+// through the environment and the AWS configuration file
+func (awsCloudProvider) AppendOptions(
+	_ context.Context,
+	options []string,
+	_ BarmanCredentials,
+) ([]string, error) {
+	return options, nil
+}
+
+func (awsCloudProvider) EnvSet(
+	ctx context.Context,
+	configuration *BarmanObjectStoreConfiguration,
+	env []string,
+	envOptions EnvOptions,
+) ([]string, error) {
+	// check if AWS credentials are defined
+	s3credentials := configuration.AWS
+	if s3credentials == nil {
+		return nil, fmt.Errorf("missing S3 credentials")
+	}
+
+	env, err := envSetS3Options(s3credentials, env, envOptions.ScratchDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	if s3credentials.InheritFromIAMRole {
+		return env, nil
+	}
+
+	// Get access key ID
+	if s3credentials.AccessKeyIDReference == nil {
+		return nil, fmt.Errorf("missing access key ID")
+	}
+	accessKeyID, accessKeyErr := envOptions.GetSecretValue(ctx, s3credentials.AccessKeyIDReference)
+	if accessKeyErr != nil {
+		return nil, accessKeyErr
+	}
+
+	// Get secret access key
+	if s3credentials.SecretAccessKeyReference == nil {
+		return nil, fmt.Errorf("missing secret access key")
+	}
+	secretAccessKey, secretAccessErr := envOptions.GetSecretValue(ctx, s3credentials.SecretAccessKeyReference)
+	if secretAccessErr != nil {
+		return nil, secretAccessErr
+	}
+
+	if s3credentials.RegionReference != nil {
+		region, regionErr := envOptions.GetSecretValue(ctx, s3credentials.RegionReference)
+		if regionErr != nil {
+			return nil, regionErr
+		}
+		env = append(env, fmt.Sprintf("AWS_DEFAULT_REGION=%s", region))
+	}
+
+	// Get session token secret
+	if s3credentials.SessionToken != nil {
+		sessionKey, sessErr := envOptions.GetSecretValue(ctx, s3credentials.SessionToken)
+		if sessErr != nil {
+			return nil, sessErr
+		}
+		env = append(env, fmt.Sprintf("AWS_SESSION_TOKEN=%s", sessionKey))
+	}
+
+	env = append(env, fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", accessKeyID))
+	env = append(env, fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", secretAccessKey))
+
+	return env, nil
+}
+
+// awsConfigFileName is the name of the AWS configuration file, stored in
+// the scratch directory, containing the options used to reach
+// S3-compatible object stores
+const awsConfigFileName = ".aws_config"
+
+// envSetS3Options sets the AWS environment variables needed to reach
+// S3-compatible object stores, writing the AWS configuration file if needed
+func envSetS3Options(
+	s3credentials *S3Credentials,
+	env []string,
+	scratchDirectory string,
+) ([]string, error) {
+	if s3credentials.RegionName != "" {
+		env = append(env, fmt.Sprintf("AWS_DEFAULT_REGION=%s", s3credentials.RegionName))
+	}
+
+	if s3credentials.RequestChecksumCalculation != "" {
+		env = append(env, fmt.Sprintf("AWS_REQUEST_CHECKSUM_CALCULATION=%s",
+			s3credentials.RequestChecksumCalculation))
+	}
+
+	if s3credentials.ResponseChecksumValidation != "" {
+		env = append(env, fmt.Sprintf("AWS_RESPONSE_CHECKSUM_VALIDATION=%s",
+			s3credentials.ResponseChecksumValidation))
+	}
+
+	if !s3credentials.RequiresAWSConfigFile() {
+		return env, nil
+	}
+
+	awsConfigFileLocation := filepath.Join(scratchDirectory, awsConfigFileName)
+	if _, err := fileutils.WriteFileAtomic(
+		awsConfigFileLocation, []byte(awsConfigFileContent(s3credentials)), 0o600); err != nil {
+		return nil, fmt.Errorf("while writing the AWS configuration file: %w", err)
+	}
+
+	env = append(env, fmt.Sprintf("AWS_CONFIG_FILE=%s", awsConfigFileLocation))
+	return env, nil
+}
+
+// awsConfigFileContent generates the AWS configuration file holding the
+// S3 options in the default profile. No profile is passed to barman-cloud,
+// as botocore ignores the credentials set in the environment when a
+// profile is explicitly selected.
+func awsConfigFileContent(s3credentials *S3Credentials) string {
+	var content strings.Builder
+	content.WriteString("[default]\n")
+	content.WriteString("s3 =\n")
+	if s3credentials.AddressingStyle != "" {
+		fmt.Fprintf(&content, "    addressing_style = %s\n", s3credentials.AddressingStyle)
+	}
+	if s3credentials.SignatureVersion != "" {
+		fmt.Fprintf(&content, "    signature_version = %s\n", s3credentials.SignatureVersion)
+	}
+	return content.String()
+}
+
+// validateS3SignatureVersion checks that the server-side encryption options
+// can be used with the selected signature version, as AWS KMS requires SigV4
+func validateS3SignatureVersion(
+	barmanObjectStore *BarmanObjectStoreConfiguration,
+	path *field.Path,
+) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	if barmanObjectStore.AWS.SignatureVersion != S3SignatureVersionV2 {
+		return allErrors
+	}
+
+	if barmanObjectStore.Wal != nil && barmanObjectStore.Wal.Encryption == EncryptionTypeNoneAWSKMS {
+		allErrors = append(allErrors, field.Invalid(
+			path.Child("wal", "encryption"),
+			barmanObjectStore.Wal.Encryption,
+			"aws:kms encryption requires the s3v4 signature version",
+		))
+	}
+
+	if barmanObjectStore.Data != nil && barmanObjectStore.Data.Encryption == EncryptionTypeNoneAWSKMS {
+		allErrors = append(allErrors, field.Invalid(
+			path.Child("data", "encryption"),
+			barmanObjectStore.Data.Encryption,
+			"aws:kms encryption requires the s3v4 signature version",
+		))
+	}
+
+	return allErrors
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// +kubebuilder:object:generate=false
+type azureCloudProvider struct{}
+
+func (azureCloudProvider) Name() string {
+	return CloudProviderAzure
+}
+
+func (azureCloudProvider) IsConfigured(configuration *BarmanObjectStoreConfiguration) bool {
+	return configuration.Azure != nil
+}
+
+func (azureCloudProvider) Validate(configuration *BarmanObjectStoreConfiguration, path *field.Path) field.ErrorList {
+	return append(
+		validateAzureDestinationPath(configuration.DestinationPath, path.Child("destinationPath")),
+		configuration.Azure.ValidateAzureCredentials(path.Child("azureCredentials"))...)
+}
+
+func (azureCloudProvider) AppendOptions(
+	_ context.Context,
+	options []string,
+	credentials BarmanCredentials,
+) ([]string, error) {
+	switch {
+	case credentials.Azure.UseDefaultAzureCredentials:
+		options = append(
+			options,
+			"--credential",
+			"default")
+	case credentials.Azure.InheritFromAzureAD:
+		options = append(
+			options,
+			"--credential",
+			"managed-identity")
+	}
+
+	return options, nil
+}
+
+func (azureCloudProvider) EnvSet(
+	ctx context.Context,
+	configuration *BarmanObjectStoreConfiguration,
+	env []string,
+	envOptions EnvOptions,
+) ([]string, error) {
+	// check if Azure credentials are defined
+	if configuration.Azure == nil {
+		return nil, fmt.Errorf("missing Azure credentials")
+	}
+
+	if configuration.Azure.InheritFromAzureAD {
+		return env, nil
+	}
+
+	if configuration.Azure.UseDefaultAzureCredentials {
+		return env, nil
+	}
+
+	// Get storage account name
+	if configuration.Azure.StorageAccount != nil {
+		storageAccount, err := envOptions.GetSecretValue(ctx, configuration.Azure.StorageAccount)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf("AZURE_STORAGE_ACCOUNT=%s", storageAccount))
+	}
+
+	// Get the storage key
+	if configuration.Azure.StorageKey != nil {
+		storageKey, err := envOptions.GetSecretValue(ctx, configuration.Azure.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf("AZURE_STORAGE_KEY=%s", storageKey))
+	}
+
+	// Get the SAS token
+	if configuration.Azure.StorageSasToken != nil {
+		storageSasToken, err := envOptions.GetSecretValue(ctx, configuration.Azure.StorageSasToken)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf("AZURE_STORAGE_SAS_TOKEN=%s", storageSasToken))
+	}
+
+	if configuration.Azure.ConnectionString != nil {
+		connString, err := envOptions.GetSecretValue(ctx, configuration.Azure.ConnectionString)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf("AZURE_STORAGE_CONNECTION_STRING=%s", connString))
+	}
+
+	return env, nil
+}
+
+// azureBlobStorageDomain is the domain of the Azure Blob Storage
+// accounts in the Azure public cloud
+const azureBlobStorageDomain = ".blob.core.windows.net"
+
+// validateAzureDestinationPath checks that the destination path contains the
+// storage account URL followed by the container, such as
+// https://account.blob.core.windows.net/container/path. When a custom domain
+// is used, as with the Azurite emulator, the path starts with the account name.
+func validateAzureDestinationPath(destinationPath string, path *field.Path) field.ErrorList {
+	allErrors := validateDestinationPathURL(destinationPath, path, "https", "http")
+	if len(allErrors) > 0 || destinationPath == "" {
+		return allErrors
+	}
+
+	destinationURL, _ := url.Parse(destinationPath)
+	segments := strings.Split(strings.Trim(destinationURL.Path, "/"), "/")
+	requiredSegments := 2
+	if strings.HasSuffix(strings.ToLower(destinationURL.Hostname()), azureBlobStorageDomain) {
+		requiredSegments = 1
+	}
+
+	if len(segments) < requiredSegments || segments[0] == "" {
+		allErrors = append(allErrors, field.Invalid(
+			path,
+			destinationPath,
+			"the destination path must contain the storage account URL followed by the container name",
+		))
+	}
+
+	return allErrors
+}
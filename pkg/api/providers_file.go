@@ -0,0 +1,101 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package api
+
+import (
+	"context"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// fileSupportedCompressions are the WAL compressions supported by the
+// local filesystem object store, where the empty one means no compression
+var fileSupportedCompressions = []CompressionType{CompressionTypeNone, CompressionTypeGzip}
+
+// +kubebuilder:object:generate=false
+type fileCloudProvider struct{}
+
+func (fileCloudProvider) Name() string {
+	return CloudProviderFile
+}
+
+func (fileCloudProvider) IsConfigured(configuration *BarmanObjectStoreConfiguration) bool {
+	return IsFileDestinationPath(configuration.DestinationPath)
+}
+
+func (fileCloudProvider) Validate(configuration *BarmanObjectStoreConfiguration, path *field.Path) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	if _, err := ParseFileDestinationPath(configuration.DestinationPath); err != nil {
+		allErrors = append(allErrors, field.Invalid(
+			path.Child("destinationPath"),
+			configuration.DestinationPath,
+			err.Error(),
+		))
+	}
+
+	if configuration.EndpointURL != "" {
+		allErrors = append(allErrors, field.Forbidden(
+			path.Child("endpointURL"),
+			"not supported by the local filesystem object store",
+		))
+	}
+
+	if configuration.Wal != nil {
+		if !slices.Contains(fileSupportedCompressions, configuration.Wal.Compression) {
+			allErrors = append(allErrors, field.NotSupported(
+				path.Child("wal", "compression"),
+				configuration.Wal.Compression,
+				fileSupportedCompressions,
+			))
+		}
+
+		if configuration.Wal.Encryption != EncryptionTypeNone {
+			allErrors = append(allErrors, field.Forbidden(
+				path.Child("wal", "encryption"),
+				"not supported by the local filesystem object store",
+			))
+		}
+	}
+
+	return allErrors
+}
+
+// AppendOptions doesn't add any option, as the local filesystem
+// object store is handled natively instead of by barman-cloud
+func (fileCloudProvider) AppendOptions(
+	_ context.Context,
+	options []string,
+	_ BarmanCredentials,
+) ([]string, error) {
+	return options, nil
+}
+
+// EnvSet doesn't add any variable, as the local
+// filesystem object store requires no credentials
+func (fileCloudProvider) EnvSet(
+	_ context.Context,
+	_ *BarmanObjectStoreConfiguration,
+	env []string,
+	_ EnvOptions,
+) ([]string, error) {
+	return env, nil
+}
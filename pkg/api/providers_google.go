@@ -0,0 +1,103 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cloudnative-pg/machinery/pkg/fileutils"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// +kubebuilder:object:generate=false
+type googleCloudProvider struct{}
+
+func (googleCloudProvider) Name() string {
+	return CloudProviderGoogle
+}
+
+func (googleCloudProvider) IsConfigured(configuration *BarmanObjectStoreConfiguration) bool {
+	return configuration.Google != nil
+}
+
+func (googleCloudProvider) Validate(configuration *BarmanObjectStoreConfiguration, path *field.Path) field.ErrorList {
+	return append(
+		validateDestinationPathURL(configuration.DestinationPath, path.Child("destinationPath"), "gs"),
+		configuration.Google.ValidateGCSCredentials(path.Child("googleCredentials"))...)
+}
+
+func (googleCloudProvider) AppendOptions(
+	_ context.Context,
+	options []string,
+	_ BarmanCredentials,
+) ([]string, error) {
+	return options, nil
+}
+
+// googleApplicationCredentialsFileName is the name of the file, stored in the
+// scratch directory, containing the Google application credentials
+const googleApplicationCredentialsFileName = ".application_credentials.json"
+
+func (googleCloudProvider) EnvSet(
+	ctx context.Context,
+	configuration *BarmanObjectStoreConfiguration,
+	env []string,
+	envOptions EnvOptions,
+) ([]string, error) {
+	var applicationCredentialsContent []byte
+
+	googleCredentials := configuration.Google
+	credentialsPath := filepath.Join(envOptions.ScratchDirectory, googleApplicationCredentialsFileName)
+
+	if googleCredentials.GKEEnvironment &&
+		googleCredentials.ApplicationCredentials == nil {
+		return env, reconcileGoogleCredentials(googleCredentials, applicationCredentialsContent, credentialsPath)
+	}
+
+	applicationCredentialsContent, err := envOptions.GetSecretValue(ctx, googleCredentials.ApplicationCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := reconcileGoogleCredentials(
+		googleCredentials, applicationCredentialsContent, credentialsPath); err != nil {
+		return nil, err
+	}
+
+	env = append(env, fmt.Sprintf("GOOGLE_APPLICATION_CREDENTIALS=%s", credentialsPath))
+
+	return env, nil
+}
+
+func reconcileGoogleCredentials(
+	googleCredentials *GoogleCredentials,
+	applicationCredentialsContent []byte,
+	credentialsPath string,
+) error {
+	if googleCredentials == nil {
+		return fileutils.RemoveFile(credentialsPath)
+	}
+
+	_, err := fileutils.WriteFileAtomic(credentialsPath, applicationCredentialsContent, 0o600)
+
+	return err
+}
@@ -0,0 +1,164 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package api
+
+import (
+	"context"
+	"strings"
+
+	machineryapi "github.com/cloudnative-pg/machinery/pkg/api"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeCloudProvider struct {
+	errors field.ErrorList
+}
+
+func (fakeCloudProvider) Name() string {
+	return "fake"
+}
+
+func (fakeCloudProvider) IsConfigured(configuration *BarmanObjectStoreConfiguration) bool {
+	return strings.HasPrefix(configuration.DestinationPath, "fake://")
+}
+
+func (p fakeCloudProvider) Validate(_ *BarmanObjectStoreConfiguration, _ *field.Path) field.ErrorList {
+	return p.errors
+}
+
+func (fakeCloudProvider) AppendOptions(_ context.Context, options []string, _ BarmanCredentials) ([]string, error) {
+	return append(options, "--fake-option"), nil
+}
+
+func (fakeCloudProvider) EnvSet(
+	ctx context.Context,
+	_ *BarmanObjectStoreConfiguration,
+	env []string,
+	envOptions EnvOptions,
+) ([]string, error) {
+	token, err := envOptions.GetSecretValue(ctx, &machineryapi.SecretKeySelector{Key: "token"})
+	if err != nil {
+		return nil, err
+	}
+	return append(env, "FAKE_TOKEN="+string(token)), nil
+}
+
+var _ = Describe("Cloud provider registry", func() {
+	It("contains the built-in providers", func() {
+		Expect(GetCloudProvider(CloudProviderAWS)).ToNot(BeNil())
+		Expect(GetCloudProvider(CloudProviderAzure)).ToNot(BeNil())
+		Expect(GetCloudProvider(CloudProviderGoogle)).ToNot(BeNil())
+		Expect(GetCloudProvider("unknown")).To(BeNil())
+	})
+
+	It("detects the provider from the credentials", func() {
+		configuration := &BarmanObjectStoreConfiguration{
+			BarmanCredentials: BarmanCredentials{
+				Google: &GoogleCredentials{GKEEnvironment: true},
+			},
+		}
+		Expect(DetectCloudProvider(configuration).Name()).To(Equal(CloudProviderGoogle))
+		Expect(DetectCloudProvider(&BarmanObjectStoreConfiguration{})).To(BeNil())
+	})
+
+	It("detects every configured provider", func() {
+		configuration := &BarmanObjectStoreConfiguration{
+			BarmanCredentials: BarmanCredentials{
+				AWS:   &S3Credentials{InheritFromIAMRole: true},
+				Azure: &AzureCredentials{InheritFromAzureAD: true},
+			},
+		}
+		Expect(DetectCloudProviders(configuration)).To(HaveLen(2))
+	})
+
+	It("accepts additional providers and replaces them by name", func() {
+		configuration := &BarmanObjectStoreConfiguration{DestinationPath: "fake://bucket"}
+
+		RegisterCloudProvider(fakeCloudProvider{})
+		providersCount := len(GetCloudProviders())
+		Expect(DetectCloudProvider(configuration).Name()).To(Equal("fake"))
+		Expect(DetectCloudProvider(configuration).Validate(configuration, nil)).To(BeEmpty())
+
+		RegisterCloudProvider(fakeCloudProvider{
+			errors: field.ErrorList{field.Required(field.NewPath("destinationPath"), "")},
+		})
+		Expect(GetCloudProviders()).To(HaveLen(providersCount))
+		Expect(DetectCloudProvider(configuration).Validate(configuration, nil)).To(HaveLen(1))
+	})
+
+	It("gets the options and the environment from the provider", func(ctx SpecContext) {
+		configuration := &BarmanObjectStoreConfiguration{DestinationPath: "fake://bucket"}
+		RegisterCloudProvider(fakeCloudProvider{})
+		provider := DetectCloudProvider(configuration)
+
+		options, err := provider.AppendOptions(ctx, []string{"--cloud-provider", "fake"}, configuration.BarmanCredentials)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options).To(Equal([]string{"--cloud-provider", "fake", "--fake-option"}))
+
+		env, err := provider.EnvSet(ctx, configuration, nil, EnvOptions{
+			GetSecretValue: func(_ context.Context, reference *machineryapi.SecretKeySelector) ([]byte, error) {
+				return []byte(reference.Key + "-value"), nil
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env).To(Equal([]string{"FAKE_TOKEN=token-value"}))
+	})
+})
+
+var _ = Describe("Local filesystem provider", func() {
+	It("is selected by the file:// scheme", func() {
+		configuration := &BarmanObjectStoreConfiguration{DestinationPath: "file:///var/lib/backups"}
+		Expect(DetectCloudProvider(configuration).Name()).To(Equal(CloudProviderFile))
+		Expect(IsFileDestinationPath("s3://bucket/path")).To(BeFalse())
+	})
+
+	It("parses the destination path", func() {
+		Expect(ParseFileDestinationPath("file:///var/lib/backups/")).To(Equal("/var/lib/backups"))
+		Expect(ParseFileDestinationPath("file://localhost/var/lib/backups")).To(Equal("/var/lib/backups"))
+
+		_, err := ParseFileDestinationPath("file://remote/var/lib/backups")
+		Expect(err).To(HaveOccurred())
+
+		_, err = ParseFileDestinationPath("s3://bucket/path")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects the options it doesn't support", func() {
+		configuration := &BarmanObjectStoreConfiguration{
+			DestinationPath: "file:///var/lib/backups",
+			EndpointURL:     "https://s3.example.com",
+			Wal: &WalBackupConfiguration{
+				Compression: CompressionTypeBzip2,
+				Encryption:  EncryptionTypeAES256,
+			},
+		}
+		errors := fileCloudProvider{}.Validate(configuration, field.NewPath("spec"))
+		Expect(errors).To(HaveLen(3))
+		Expect(errors[1].Field).To(Equal("spec.wal.compression"))
+		Expect(errors[1].Detail).To(ContainSubstring(`supported values: "", "gzip"`))
+
+		configuration.EndpointURL = ""
+		configuration.Wal = &WalBackupConfiguration{Compression: CompressionTypeGzip}
+		Expect(fileCloudProvider{}.Validate(configuration, field.NewPath("spec"))).To(BeEmpty())
+	})
+})
@@ -20,9 +20,17 @@ SPDX-License-Identifier: Apache-2.0
 package webhooks
 
 import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 
 	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/command"
 	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
 )
 
@@ -37,41 +45,473 @@ func ValidateBackupConfiguration(
 		return nil
 	}
 
-	credentialsCount := 0
-	if barmanObjectStore.Azure != nil {
-		credentialsCount++
-		allErrors = barmanObjectStore.Azure.ValidateAzureCredentials(
-			path.Child("azureCredentials"),
-		)
-	}
-	if barmanObjectStore.AWS != nil {
-		credentialsCount++
-		allErrors = barmanObjectStore.AWS.ValidateAwsCredentials(
-			path.Child("awsCredentials"),
-		)
-	}
-	if barmanObjectStore.Google != nil {
-		credentialsCount++
-		allErrors = barmanObjectStore.Google.ValidateGCSCredentials(
-			field.NewPath("spec", "backupConfiguration", "googleCredentials"))
+	allErrors = append(
+		allErrors,
+		validateEndpointURL(barmanObjectStore.EndpointURL, path.Child("endpointURL"))...)
+	allErrors = append(
+		allErrors,
+		validateServerName(barmanObjectStore.ServerName, path.Child("serverName"))...)
+
+	providers := api.DetectCloudProviders(barmanObjectStore)
+	for _, provider := range providers {
+		allErrors = append(allErrors, provider.Validate(barmanObjectStore, path)...)
 	}
-	if credentialsCount == 0 {
+	if len(providers) == 0 {
 		allErrors = append(allErrors, field.Invalid(
 			path,
 			barmanObjectStore,
 			"missing credentials. "+
-				"One and only one of azureCredentials, s3Credentials and googleCredentials are required",
+				"One and only one of azureCredentials, s3Credentials and googleCredentials are required "+
+				"(none when destinationPath uses the file:// scheme)",
 		))
 	}
-	if credentialsCount > 1 {
+	if len(providers) > 1 {
 		allErrors = append(allErrors, field.Invalid(
 			path,
 			barmanObjectStore,
 			"too many credentials. "+
-				"One and only one of azureCredentials, s3Credentials and googleCredentials are required",
+				"One and only one of azureCredentials, s3Credentials and googleCredentials are required "+
+				"(none when destinationPath uses the file:// scheme)",
 		))
 	}
 
+	allErrors = append(allErrors, validateAllAdditionalCommandArgs(barmanObjectStore, path)...)
+	allErrors = append(allErrors, validateAllTags(barmanObjectStore, path)...)
+	allErrors = append(allErrors, validateRateLimits(barmanObjectStore, path)...)
+
+	if barmanObjectStore.TLS != nil {
+		if barmanObjectStore.TLS.CA != nil && barmanObjectStore.EndpointCA != nil {
+			allErrors = append(allErrors, field.Invalid(
+				path.Child("tls", "ca"),
+				barmanObjectStore.TLS.CA,
+				"endpointCA and tls.ca are mutually exclusive",
+			))
+		}
+	}
+
+	return allErrors
+}
+
+// validateEndpointURL checks that the endpoint URL, when set,
+// is an absolute HTTP or HTTPS URL
+func validateEndpointURL(endpointURL string, path *field.Path) field.ErrorList {
+	if endpointURL == "" {
+		return nil
+	}
+
+	parsedURL, err := url.Parse(endpointURL)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, endpointURL, err.Error())}
+	}
+
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return field.ErrorList{field.Invalid(path, endpointURL, "the endpoint URL must use the http or https scheme")}
+	}
+
+	if parsedURL.Host == "" {
+		return field.ErrorList{field.Invalid(path, endpointURL, "the endpoint URL must contain a host name")}
+	}
+
+	return nil
+}
+
+// serverNameRegex matches the server names which can be used as a
+// directory name inside the destination path
+var serverNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validateServerName checks that the server name, when set,
+// only contains letters, digits, dots, dashes and underscores
+func validateServerName(serverName string, path *field.Path) field.ErrorList {
+	if serverName == "" || serverNameRegex.MatchString(serverName) {
+		return nil
+	}
+
+	return field.ErrorList{field.Invalid(
+		path,
+		serverName,
+		"the server name must start with a letter or a digit and "+
+			"only contain letters, digits, '.', '-' and '_'",
+	)}
+}
+
+// validateRateLimits checks that the bandwidth and request rate
+// limits, when set, are positive
+func validateRateLimits(
+	barmanObjectStore *api.BarmanObjectStoreConfiguration,
+	path *field.Path,
+) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	validateBandwidth := func(bandwidth *resource.Quantity, bandwidthPath *field.Path) {
+		if bandwidth != nil && bandwidth.Sign() <= 0 {
+			allErrors = append(allErrors, field.Invalid(
+				bandwidthPath, bandwidth.String(), "the bandwidth limit must be positive"))
+		}
+	}
+
+	if barmanObjectStore.Wal != nil {
+		validateBandwidth(barmanObjectStore.Wal.MaxBandwidth, path.Child("wal", "maxBandwidth"))
+		if rate := barmanObjectStore.Wal.MaxRequestsPerSecond; rate != nil && *rate < 1 {
+			allErrors = append(allErrors, field.Invalid(
+				path.Child("wal", "maxRequestsPerSecond"), *rate, "the request rate limit must be at least 1"))
+		}
+	}
+
+	if barmanObjectStore.Data != nil {
+		validateBandwidth(barmanObjectStore.Data.MaxBandwidth, path.Child("data", "maxBandwidth"))
+	}
+
+	return allErrors
+}
+
+// validateAllAdditionalCommandArgs validates the additional command
+// arguments of every barman-cloud tool
+func validateAllAdditionalCommandArgs(
+	barmanObjectStore *api.BarmanObjectStoreConfiguration,
+	path *field.Path,
+) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	if barmanObjectStore.Wal != nil {
+		allErrors = append(allErrors, validateAdditionalCommandArgs(
+			utils.BarmanCloudWalArchive,
+			barmanObjectStore.Wal.ArchiveAdditionalCommandArgs,
+			path.Child("wal", "archiveAdditionalCommandArgs"))...)
+		allErrors = append(allErrors, validateAdditionalCommandArgs(
+			utils.BarmanCloudWalRestore,
+			barmanObjectStore.Wal.RestoreAdditionalCommandArgs,
+			path.Child("wal", "restoreAdditionalCommandArgs"))...)
+	}
+
+	if barmanObjectStore.Data != nil {
+		allErrors = append(allErrors, validateAdditionalCommandArgs(
+			utils.BarmanCloudBackup,
+			barmanObjectStore.Data.AdditionalCommandArgs,
+			path.Child("data", "additionalCommandArgs"))...)
+		allErrors = append(allErrors, validateAdditionalCommandArgs(
+			utils.BarmanCloudRestore,
+			barmanObjectStore.Data.RestoreAdditionalCommandArgs,
+			path.Child("data", "restoreAdditionalCommandArgs"))...)
+	}
+
+	return allErrors
+}
+
+// validateAdditionalCommandArgs checks that the additional command arguments
+// only contain flags, and that none of them is set by this library. As the flag
+// catalog may lag behind barman, the unknown flags are only reported as warnings
+// by getUnknownCommandArgsWarnings.
+func validateAdditionalCommandArgs(tool string, args []string, path *field.Path) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if !strings.HasPrefix(arg, "-") {
+			allErrors = append(allErrors, field.Invalid(
+				path.Index(idx),
+				arg,
+				"positional arguments are not allowed",
+			))
+			continue
+		}
+
+		flag, err := utils.LookupBarmanCloudFlag(tool, arg)
+		if err != nil {
+			return append(allErrors, field.InternalError(path, err))
+		}
+
+		if flag == nil {
+			// the following argument is taken as the value of this flag,
+			// as we don't know if it expects one
+			if !strings.Contains(arg, "=") && idx+1 < len(args) && !strings.HasPrefix(args[idx+1], "-") {
+				idx++
+			}
+			continue
+		}
+
+		if flag.Forbidden {
+			allErrors = append(allErrors, field.Forbidden(
+				path.Index(idx),
+				fmt.Sprintf("%s cannot be used as it is %s", arg, flag.Reason),
+			))
+		}
+
+		if flag.HasValue && !strings.Contains(arg, "=") {
+			if idx+1 >= len(args) {
+				allErrors = append(allErrors, field.Invalid(
+					path.Index(idx),
+					arg,
+					"missing flag value",
+				))
+			}
+			// the following argument is the value of this flag
+			idx++
+		}
+	}
+
+	return allErrors
+}
+
+// getAdditionalCommandArgsWarnings reports the additional command arguments which
+// are dropped because the same flags are already set by the configuration, as
+// detected by building the command lines of the barman-cloud tools
+func getAdditionalCommandArgsWarnings(
+	barmanObjectStore *api.BarmanObjectStoreConfiguration,
+	path *field.Path,
+) []string {
+	var warnings []string
+	reportDropped := func(options *command.Options, err error, argsPath *field.Path) {
+		if err != nil {
+			return
+		}
+		for _, arg := range options.DroppedAdditionalArgs() {
+			warnings = append(
+				warnings,
+				fmt.Sprintf("%s: %q is ignored, as the same option is already set by the configuration",
+					argsPath, arg))
+		}
+	}
+
+	// The command line builders don't check the capabilities of the
+	// installed barman version, so no process is run by the webhook
+	ctx := context.Background()
+	if barmanObjectStore.Wal != nil {
+		options, err := command.NewWalArchiveOptions(ctx, barmanObjectStore, "")
+		reportDropped(options, err, path.Child("wal", "archiveAdditionalCommandArgs"))
+
+		options, err = command.NewWalRestoreOptions(ctx, barmanObjectStore, "")
+		reportDropped(options, err, path.Child("wal", "restoreAdditionalCommandArgs"))
+	}
+
+	if barmanObjectStore.Data != nil {
+		options, err := command.NewBackupOptions(ctx, barmanObjectStore, "", "")
+		reportDropped(options, err, path.Child("data", "additionalCommandArgs"))
+	}
+
+	return warnings
+}
+
+// getUnknownCommandArgsWarnings reports the additional command arguments which
+// are not flags known to be accepted by the barman-cloud tools
+func getUnknownCommandArgsWarnings(
+	barmanObjectStore *api.BarmanObjectStoreConfiguration,
+	path *field.Path,
+) []string {
+	var warnings []string
+	reportUnknown := func(tool string, args []string, argsPath *field.Path) {
+		for idx, arg := range args {
+			if !strings.HasPrefix(arg, "-") {
+				continue
+			}
+
+			flag, err := utils.LookupBarmanCloudFlag(tool, arg)
+			if err != nil || flag != nil {
+				continue
+			}
+
+			warnings = append(
+				warnings,
+				fmt.Sprintf("%s: %q is not a flag known to be accepted by %s, it will be passed as is",
+					argsPath.Index(idx), arg, tool))
+		}
+	}
+
+	if barmanObjectStore.Wal != nil {
+		reportUnknown(utils.BarmanCloudWalArchive,
+			barmanObjectStore.Wal.ArchiveAdditionalCommandArgs, path.Child("wal", "archiveAdditionalCommandArgs"))
+		reportUnknown(utils.BarmanCloudWalRestore,
+			barmanObjectStore.Wal.RestoreAdditionalCommandArgs, path.Child("wal", "restoreAdditionalCommandArgs"))
+	}
+
+	if barmanObjectStore.Data != nil {
+		reportUnknown(utils.BarmanCloudBackup,
+			barmanObjectStore.Data.AdditionalCommandArgs, path.Child("data", "additionalCommandArgs"))
+		reportUnknown(utils.BarmanCloudRestore,
+			barmanObjectStore.Data.RestoreAdditionalCommandArgs, path.Child("data", "restoreAdditionalCommandArgs"))
+	}
+
+	return warnings
+}
+
+// GetBackupConfigurationWarnings returns the non-fatal issues detected in the
+// backup configuration, to be reported back to the user as admission warnings
+func GetBackupConfigurationWarnings(
+	barmanObjectStore *api.BarmanObjectStoreConfiguration,
+	path *field.Path,
+) []string {
+	if barmanObjectStore == nil {
+		return nil
+	}
+
+	var warnings []string
+	if barmanObjectStore.AWS != nil {
+		s3Path := path.Child("s3Credentials")
+		if barmanObjectStore.AWS.SignatureVersion == api.S3SignatureVersionV2 {
+			warnings = append(
+				warnings,
+				fmt.Sprintf("%s: the SigV2 signature version is deprecated and not supported by AWS S3, "+
+					"use it only with S3-compatible object stores requiring it", s3Path.Child("signatureVersion")))
+		}
+
+		if barmanObjectStore.AWS.AddressingStyle == api.S3AddressingStylePath &&
+			barmanObjectStore.EndpointURL == "" {
+			warnings = append(
+				warnings,
+				fmt.Sprintf("%s: the path addressing style is deprecated by AWS S3, "+
+					"set endpointURL when using an S3-compatible object store", s3Path.Child("addressingStyle")))
+		}
+	}
+
+	if barmanObjectStore.Azure != nil {
+		if barmanObjectStore.EndpointURL != "" {
+			warnings = append(
+				warnings,
+				fmt.Sprintf("%s: the endpoint URL is ignored by Azure Blob Storage, "+
+					"the storage account URL is taken from destinationPath", path.Child("endpointURL")))
+		}
+
+		if destinationURL, err := url.Parse(barmanObjectStore.DestinationPath); err == nil &&
+			destinationURL.Scheme == "http" {
+			warnings = append(
+				warnings,
+				fmt.Sprintf("%s: the storage account is reached without TLS, "+
+					"use https unless connecting to a local emulator", path.Child("destinationPath")))
+		}
+	}
+
+	if api.IsFileDestinationPath(barmanObjectStore.DestinationPath) {
+		warnings = append(
+			warnings,
+			fmt.Sprintf("%s: the local filesystem object store only archives and restores WAL files, "+
+				"base backups are not supported and must be copied into the directory by other means",
+				path.Child("destinationPath")))
+	}
+
+	warnings = append(warnings, getUnknownCommandArgsWarnings(barmanObjectStore, path)...)
+	warnings = append(warnings, getAdditionalCommandArgsWarnings(barmanObjectStore, path)...)
+
+	if barmanObjectStore.EndpointCA != nil && barmanObjectStore.EndpointURL == "" &&
+		barmanObjectStore.Azure == nil {
+		warnings = append(
+			warnings,
+			fmt.Sprintf("%s: the CA bundle is set but endpointURL is not, "+
+				"the default endpoint of the cloud provider will be used", path.Child("endpointCA")))
+	}
+
+	if barmanObjectStore.TLS != nil && barmanObjectStore.EndpointURL == "" {
+		warnings = append(
+			warnings,
+			fmt.Sprintf("%s: the TLS configuration is set but endpointURL is not, "+
+				"the default endpoint of the cloud provider will be used", path.Child("tls")))
+	}
+
+	return warnings
+}
+
+// ValidateBarmanVersionCompatibility validates the backup configuration against
+// the capabilities of the barman version installed in the image which will use it,
+// such as "3.12.1". Nothing is validated when the version is not known.
+func ValidateBarmanVersionCompatibility(
+	barmanObjectStore *api.BarmanObjectStoreConfiguration,
+	barmanVersion string,
+	path *field.Path,
+) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	if barmanObjectStore == nil || barmanVersion == "" {
+		return nil
+	}
+
+	checkCompression := func(compression api.CompressionType, compressionPath *field.Path) {
+		capability, ok := utils.CompressionCapability(string(compression))
+		if !ok {
+			return
+		}
+		if err := utils.CheckBarmanCapability(barmanVersion, capability); err != nil {
+			allErrors = append(allErrors, field.Invalid(compressionPath, compression, err.Error()))
+		}
+	}
+
+	if barmanObjectStore.Wal != nil {
+		checkCompression(barmanObjectStore.Wal.Compression, path.Child("wal", "compression"))
+		allErrors = append(allErrors, validateAdditionalCommandArgsVersion(
+			utils.BarmanCloudWalArchive,
+			barmanObjectStore.Wal.ArchiveAdditionalCommandArgs,
+			barmanVersion,
+			path.Child("wal", "archiveAdditionalCommandArgs"))...)
+		allErrors = append(allErrors, validateAdditionalCommandArgsVersion(
+			utils.BarmanCloudWalRestore,
+			barmanObjectStore.Wal.RestoreAdditionalCommandArgs,
+			barmanVersion,
+			path.Child("wal", "restoreAdditionalCommandArgs"))...)
+	}
+
+	if barmanObjectStore.Data != nil {
+		checkCompression(barmanObjectStore.Data.Compression, path.Child("data", "compression"))
+		if barmanObjectStore.Data.MaxBandwidth != nil {
+			if err := utils.CheckBarmanCapability(barmanVersion, utils.BarmanCapabilityMaxBandwidth); err != nil {
+				allErrors = append(allErrors, field.Invalid(
+					path.Child("data", "maxBandwidth"),
+					barmanObjectStore.Data.MaxBandwidth.String(),
+					err.Error()))
+			}
+		}
+		allErrors = append(allErrors, validateAdditionalCommandArgsVersion(
+			utils.BarmanCloudBackup,
+			barmanObjectStore.Data.AdditionalCommandArgs,
+			barmanVersion,
+			path.Child("data", "additionalCommandArgs"))...)
+		allErrors = append(allErrors, validateAdditionalCommandArgsVersion(
+			utils.BarmanCloudRestore,
+			barmanObjectStore.Data.RestoreAdditionalCommandArgs,
+			barmanVersion,
+			path.Child("data", "restoreAdditionalCommandArgs"))...)
+	}
+
+	if barmanObjectStore.Azure != nil && barmanObjectStore.Azure.UseDefaultAzureCredentials {
+		if err := utils.CheckBarmanCapability(
+			barmanVersion,
+			utils.BarmanCapabilityAzureDefaultCredential,
+		); err != nil {
+			allErrors = append(allErrors, field.Invalid(
+				path.Child("azureCredentials", "useDefaultAzureCredentials"),
+				true,
+				err.Error()))
+		}
+	}
+
+	return allErrors
+}
+
+// validateAdditionalCommandArgsVersion checks that the flags passed as additional
+// command arguments are supported by the barman version
+func validateAdditionalCommandArgsVersion(
+	tool string,
+	args []string,
+	barmanVersion string,
+	path *field.Path,
+) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	for idx, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		flag, err := utils.LookupBarmanCloudFlag(tool, arg)
+		if err != nil {
+			return append(allErrors, field.InternalError(path, err))
+		}
+
+		if flag != nil && !flag.IsSupportedBy(barmanVersion) {
+			allErrors = append(allErrors, field.Invalid(
+				path.Index(idx),
+				arg,
+				fmt.Sprintf("%s requires barman >= %s, found %s", flag.Names[len(flag.Names)-1], flag.Since, barmanVersion),
+			))
+		}
+	}
+
 	return allErrors
 }
 
@@ -20,7 +20,13 @@ SPDX-License-Identifier: Apache-2.0
 package webhooks
 
 import (
+	"os"
+	"path/filepath"
+
+	machineryapi "github.com/cloudnative-pg/machinery/pkg/api"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
 
 	api "github.com/cloudnative-pg/barman-cloud/pkg/api"
 
@@ -40,6 +46,424 @@ var _ = Describe("Backup validation", func() {
 		err := ValidateBackupConfiguration(nil, nil)
 		Expect(err).To(BeEmpty())
 	})
+
+	It("doesn't require credentials for local filesystem destinations", func() {
+		err := ValidateBackupConfiguration(
+			&api.BarmanObjectStoreConfiguration{DestinationPath: "file:///var/lib/backups"},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(err).To(BeEmpty())
+
+		err = ValidateBackupConfiguration(
+			&api.BarmanObjectStoreConfiguration{
+				DestinationPath: "file:///var/lib/backups",
+				BarmanCredentials: api.BarmanCredentials{
+					AWS: &api.S3Credentials{InheritFromIAMRole: true},
+				},
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(err).ToNot(BeEmpty())
+	})
+
+	It("complain if the CA bundle is specified twice", func() {
+		caSecret := &machineryapi.SecretKeySelector{
+			LocalObjectReference: machineryapi.LocalObjectReference{
+				Name: "object-store-ca",
+			},
+			Key: "ca.crt",
+		}
+		err := ValidateBackupConfiguration(
+			&api.BarmanObjectStoreConfiguration{
+				BarmanCredentials: api.BarmanCredentials{
+					AWS: &api.S3Credentials{InheritFromIAMRole: true},
+				},
+				EndpointCA: caSecret,
+				TLS:        &api.TLSConfiguration{CA: caSecret},
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(err).To(HaveLen(1))
+		Expect(err[0].Field).To(Equal("spec.backupConfiguration.tls.ca"))
+	})
+})
+
+var _ = Describe("S3 options validation", func() {
+	It("complain if aws:kms encryption is used with SigV2", func() {
+		err := ValidateBackupConfiguration(
+			&api.BarmanObjectStoreConfiguration{
+				BarmanCredentials: api.BarmanCredentials{
+					AWS: &api.S3Credentials{
+						InheritFromIAMRole: true,
+						SignatureVersion:   api.S3SignatureVersionV2,
+					},
+				},
+				Wal: &api.WalBackupConfiguration{
+					Encryption: api.EncryptionTypeNoneAWSKMS,
+				},
+				Data: &api.DataBackupConfiguration{
+					Encryption: api.EncryptionTypeAES256,
+				},
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(err).To(HaveLen(1))
+		Expect(err[0].Field).To(Equal("spec.backupConfiguration.wal.encryption"))
+	})
+
+	It("warns if SigV2 is used", func() {
+		warnings := GetBackupConfigurationWarnings(
+			&api.BarmanObjectStoreConfiguration{
+				BarmanCredentials: api.BarmanCredentials{
+					AWS: &api.S3Credentials{
+						InheritFromIAMRole: true,
+						SignatureVersion:   api.S3SignatureVersionV2,
+					},
+				},
+				EndpointURL: "https://rgw.example.com",
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(warnings).To(HaveLen(1))
+	})
+
+	It("warns that the local filesystem object store doesn't take base backups", func() {
+		warnings := GetBackupConfigurationWarnings(
+			&api.BarmanObjectStoreConfiguration{DestinationPath: "file:///var/lib/backups"},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(warnings).To(HaveLen(1))
+		Expect(warnings[0]).To(ContainSubstring("base backups are not supported"))
+	})
+
+	It("warns if path addressing is used against AWS S3", func() {
+		warnings := GetBackupConfigurationWarnings(
+			&api.BarmanObjectStoreConfiguration{
+				BarmanCredentials: api.BarmanCredentials{
+					AWS: &api.S3Credentials{
+						InheritFromIAMRole: true,
+						AddressingStyle:    api.S3AddressingStylePath,
+					},
+				},
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(warnings).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Destination and endpoint validation", func() {
+	DescribeTable("checks the destination path against the credentials",
+		func(destinationPath string, credentials api.BarmanCredentials, expectedField string) {
+			err := ValidateBackupConfiguration(
+				&api.BarmanObjectStoreConfiguration{
+					DestinationPath:   destinationPath,
+					BarmanCredentials: credentials,
+				},
+				field.NewPath("spec", "backupConfiguration"))
+			if expectedField == "" {
+				Expect(err).To(BeEmpty())
+				return
+			}
+			Expect(err).To(HaveLen(1))
+			Expect(err[0].Field).To(Equal(expectedField))
+		},
+		Entry("S3 bucket",
+			"s3://bucket/path",
+			api.BarmanCredentials{AWS: &api.S3Credentials{InheritFromIAMRole: true}},
+			""),
+		Entry("S3 credentials with a GCS bucket",
+			"gs://bucket/path",
+			api.BarmanCredentials{AWS: &api.S3Credentials{InheritFromIAMRole: true}},
+			"spec.backupConfiguration.destinationPath"),
+		Entry("GCS bucket",
+			"gs://bucket/path",
+			api.BarmanCredentials{Google: &api.GoogleCredentials{GKEEnvironment: true}},
+			""),
+		Entry("Azure container",
+			"https://account.blob.core.windows.net/container/path",
+			api.BarmanCredentials{Azure: &api.AzureCredentials{InheritFromAzureAD: true}},
+			""),
+		Entry("Azure emulator container",
+			"http://azurite:10000/account/container",
+			api.BarmanCredentials{Azure: &api.AzureCredentials{InheritFromAzureAD: true}},
+			""),
+		Entry("Azure credentials with an S3 bucket",
+			"s3://bucket/path",
+			api.BarmanCredentials{Azure: &api.AzureCredentials{InheritFromAzureAD: true}},
+			"spec.backupConfiguration.destinationPath"),
+		Entry("Azure storage account without a container",
+			"https://account.blob.core.windows.net/",
+			api.BarmanCredentials{Azure: &api.AzureCredentials{InheritFromAzureAD: true}},
+			"spec.backupConfiguration.destinationPath"),
+	)
+
+	It("complain if the endpoint URL is not valid", func() {
+		for _, endpointURL := range []string{"minio:9000", "ftp://minio", "https://"} {
+			err := ValidateBackupConfiguration(
+				&api.BarmanObjectStoreConfiguration{
+					DestinationPath: "s3://bucket",
+					EndpointURL:     endpointURL,
+					BarmanCredentials: api.BarmanCredentials{
+						AWS: &api.S3Credentials{InheritFromIAMRole: true},
+					},
+				},
+				field.NewPath("spec", "backupConfiguration"))
+			Expect(err).To(HaveLen(1), endpointURL)
+			Expect(err[0].Field).To(Equal("spec.backupConfiguration.endpointURL"))
+		}
+	})
+
+	It("complain if the server name is not valid", func() {
+		err := ValidateBackupConfiguration(
+			&api.BarmanObjectStoreConfiguration{
+				DestinationPath: "s3://bucket",
+				ServerName:      "../cluster",
+				BarmanCredentials: api.BarmanCredentials{
+					AWS: &api.S3Credentials{InheritFromIAMRole: true},
+				},
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(err).To(HaveLen(1))
+		Expect(err[0].Field).To(Equal("spec.backupConfiguration.serverName"))
+	})
+})
+
+var _ = Describe("Rate limits validation", func() {
+	validate := func(wal *api.WalBackupConfiguration, data *api.DataBackupConfiguration) field.ErrorList {
+		return ValidateBackupConfiguration(
+			&api.BarmanObjectStoreConfiguration{
+				DestinationPath: "s3://bucket",
+				BarmanCredentials: api.BarmanCredentials{
+					AWS: &api.S3Credentials{InheritFromIAMRole: true},
+				},
+				Wal:  wal,
+				Data: data,
+			},
+			field.NewPath("spec"))
+	}
+
+	It("accepts positive limits", func() {
+		Expect(validate(
+			&api.WalBackupConfiguration{
+				MaxBandwidth:         ptr.To(resource.MustParse("10Mi")),
+				MaxRequestsPerSecond: ptr.To(int32(50)),
+			},
+			&api.DataBackupConfiguration{MaxBandwidth: ptr.To(resource.MustParse("100M"))},
+		)).To(BeEmpty())
+	})
+
+	It("rejects the limits which are not positive", func() {
+		err := validate(
+			&api.WalBackupConfiguration{
+				MaxBandwidth:         ptr.To(resource.MustParse("0")),
+				MaxRequestsPerSecond: ptr.To(int32(0)),
+			},
+			&api.DataBackupConfiguration{MaxBandwidth: ptr.To(resource.MustParse("-1Mi"))},
+		)
+		Expect(err).To(HaveLen(3))
+		Expect(err[0].Field).To(Equal("spec.wal.maxBandwidth"))
+		Expect(err[1].Field).To(Equal("spec.wal.maxRequestsPerSecond"))
+		Expect(err[2].Field).To(Equal("spec.data.maxBandwidth"))
+	})
+})
+
+var _ = Describe("Additional command arguments validation", func() {
+	validate := func(wal *api.WalBackupConfiguration, data *api.DataBackupConfiguration) field.ErrorList {
+		return ValidateBackupConfiguration(
+			&api.BarmanObjectStoreConfiguration{
+				DestinationPath: "s3://bucket",
+				BarmanCredentials: api.BarmanCredentials{
+					AWS: &api.S3Credentials{InheritFromIAMRole: true},
+				},
+				Wal:  wal,
+				Data: data,
+			},
+			field.NewPath("spec", "backupConfiguration"))
+	}
+
+	It("accepts the flags known to the barman-cloud tools", func() {
+		Expect(validate(
+			&api.WalBackupConfiguration{
+				ArchiveAdditionalCommandArgs: []string{"--read-timeout", "60", "--max-concurrency=4"},
+				RestoreAdditionalCommandArgs: []string{"--no-partial"},
+			},
+			&api.DataBackupConfiguration{
+				AdditionalCommandArgs: []string{
+					"--min-chunk-size=5MB", "-S", "1GB",
+					"--snapshot-instance", "pg-1", "--snapshot-disk=disk-1", "--gcp-project", "project",
+				},
+				RestoreAdditionalCommandArgs: []string{"--read-timeout=60"},
+			},
+		)).To(BeEmpty())
+	})
+
+	It("rejects positional arguments", func() {
+		err := validate(
+			&api.WalBackupConfiguration{
+				RestoreAdditionalCommandArgs: []string{"--read-timeout=60", "value"},
+			},
+			nil,
+		)
+		Expect(err).To(HaveLen(1))
+		Expect(err[0].Field).To(Equal("spec.backupConfiguration.wal.restoreAdditionalCommandArgs[1]"))
+	})
+
+	It("warns about the unknown flags without rejecting them", func() {
+		wal := &api.WalBackupConfiguration{
+			RestoreAdditionalCommandArgs: []string{"--jobs", "2"},
+		}
+		data := &api.DataBackupConfiguration{
+			AdditionalCommandArgs: []string{"--snapshot-instance", "pg-1", "--new-flag"},
+		}
+		Expect(validate(wal, data)).To(BeEmpty())
+
+		warnings := GetBackupConfigurationWarnings(
+			&api.BarmanObjectStoreConfiguration{Wal: wal, Data: data},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(warnings).To(ConsistOf(
+			ContainSubstring(`wal.restoreAdditionalCommandArgs[0]: "--jobs" is not a flag known`),
+			ContainSubstring(`data.additionalCommandArgs[2]: "--new-flag" is not a flag known`),
+		))
+	})
+
+	It("rejects the flags set by the library and missing values", func() {
+		err := validate(nil, &api.DataBackupConfiguration{
+			AdditionalCommandArgs: []string{
+				"--name=backup", "--endpoint-url", "https://minio", "--immediate-checkpoint",
+				"--jobs=2", "-e", "AES256", "--read-timeout",
+			},
+		})
+		Expect(err).To(HaveLen(6))
+		for idx := range 5 {
+			Expect(err[idx].Type).To(Equal(field.ErrorTypeForbidden))
+		}
+		Expect(err[5].Field).To(Equal("spec.backupConfiguration.data.additionalCommandArgs[7]"))
+	})
+
+	It("warns about the arguments dropped as duplicates", func() {
+		warnings := GetBackupConfigurationWarnings(
+			&api.BarmanObjectStoreConfiguration{
+				Wal: &api.WalBackupConfiguration{
+					Compression:                  api.CompressionTypeGzip,
+					ArchiveAdditionalCommandArgs: []string{"--gzip", "--read-timeout=60"},
+					RestoreAdditionalCommandArgs: []string{"--endpoint-url=https://other.example.com"},
+				},
+				Data: &api.DataBackupConfiguration{
+					ImmediateCheckpoint:   true,
+					AdditionalCommandArgs: []string{"--immediate-checkpoint", "--name=backup"},
+				},
+				EndpointURL: "https://minio.example.com",
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(warnings).To(ConsistOf(
+			ContainSubstring(`wal.archiveAdditionalCommandArgs: "--gzip"`),
+			ContainSubstring(`wal.restoreAdditionalCommandArgs: "--endpoint-url=https://other.example.com"`),
+			ContainSubstring(`data.additionalCommandArgs: "--immediate-checkpoint"`),
+			ContainSubstring(`data.additionalCommandArgs: "--name=backup"`),
+		))
+	})
+})
+
+var _ = Describe("Barman version compatibility", func() {
+	configuration := &api.BarmanObjectStoreConfiguration{
+		BarmanCredentials: api.BarmanCredentials{
+			Azure: &api.AzureCredentials{UseDefaultAzureCredentials: true},
+		},
+		Wal: &api.WalBackupConfiguration{
+			Compression:                  api.CompressionTypeZstd,
+			ArchiveAdditionalCommandArgs: []string{"--read-timeout=60"},
+		},
+		Data: &api.DataBackupConfiguration{
+			Compression: api.CompressionTypeGzip,
+		},
+	}
+
+	It("doesn't complain if the version is not known", func() {
+		Expect(ValidateBarmanVersionCompatibility(configuration, "", field.NewPath("spec"))).To(BeEmpty())
+	})
+
+	It("doesn't complain if the version supports the configuration", func() {
+		Expect(ValidateBarmanVersionCompatibility(configuration, "3.14.0", field.NewPath("spec"))).To(BeEmpty())
+	})
+
+	It("complains about the features not supported by an older version", func() {
+		err := ValidateBarmanVersionCompatibility(configuration, "2.18.0", field.NewPath("spec"))
+		Expect(err).To(HaveLen(3))
+		Expect(err[0].Field).To(Equal("spec.wal.compression"))
+		Expect(err[0].Detail).To(HavePrefix("compression zstd requires barman >= 3.12.0"))
+		Expect(err[1].Field).To(Equal("spec.wal.archiveAdditionalCommandArgs[0]"))
+		Expect(err[2].Field).To(Equal("spec.azureCredentials.useDefaultAzureCredentials"))
+	})
+
+	It("complains about the backup bandwidth limit before barman 2.18.0", func() {
+		configuration := &api.BarmanObjectStoreConfiguration{
+			Data: &api.DataBackupConfiguration{MaxBandwidth: ptr.To(resource.MustParse("10Mi"))},
+		}
+		Expect(ValidateBarmanVersionCompatibility(configuration, "2.18.0", field.NewPath("spec"))).To(BeEmpty())
+
+		err := ValidateBarmanVersionCompatibility(configuration, "2.17.0", field.NewPath("spec"))
+		Expect(err).To(HaveLen(1))
+		Expect(err[0].Field).To(Equal("spec.data.maxBandwidth"))
+	})
+})
+
+var _ = Describe("Backup configuration warnings", func() {
+	It("doesn't warn if the configuration is not provided", func() {
+		Expect(GetBackupConfigurationWarnings(nil, nil)).To(BeEmpty())
+	})
+
+	It("warns if the TLS configuration is used without a custom endpoint", func() {
+		warnings := GetBackupConfigurationWarnings(
+			&api.BarmanObjectStoreConfiguration{
+				TLS: &api.TLSConfiguration{CA: &machineryapi.SecretKeySelector{Key: "ca.crt"}},
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(warnings).To(HaveLen(1))
+	})
+
+	It("warns if the CA bundle is used without a custom endpoint", func() {
+		warnings := GetBackupConfigurationWarnings(
+			&api.BarmanObjectStoreConfiguration{
+				EndpointCA: &machineryapi.SecretKeySelector{Key: "ca.crt"},
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(warnings).To(HaveLen(1))
+	})
+
+	It("warns if Azure is used with an endpoint URL or without TLS", func() {
+		warnings := GetBackupConfigurationWarnings(
+			&api.BarmanObjectStoreConfiguration{
+				DestinationPath: "http://azurite:10000/account/container",
+				EndpointURL:     "https://azurite:10000",
+				BarmanCredentials: api.BarmanCredentials{
+					Azure: &api.AzureCredentials{InheritFromAzureAD: true},
+				},
+			},
+			field.NewPath("spec", "backupConfiguration"))
+		Expect(warnings).To(HaveLen(2))
+	})
+
+	It("doesn't run barman to validate the additional command arguments", func() {
+		// This barman-cloud-backup leaves a marker when run, and fails so
+		// that the detected version is never cached
+		binDir := GinkgoT().TempDir()
+		marker := filepath.Join(binDir, "executed")
+		script := "#!/bin/sh\ntouch " + marker + "\nexit 1\n"
+		Expect(os.WriteFile(filepath.Join(binDir, "barman-cloud-backup"), []byte(script), 0o700)).To(Succeed())
+		GinkgoT().Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		configuration := &api.BarmanObjectStoreConfiguration{
+			DestinationPath: "https://account.blob.core.windows.net/container",
+			BarmanCredentials: api.BarmanCredentials{
+				Azure: &api.AzureCredentials{UseDefaultAzureCredentials: true},
+			},
+			Wal: &api.WalBackupConfiguration{
+				ArchiveAdditionalCommandArgs: []string{"--read-timeout=60"},
+				RestoreAdditionalCommandArgs: []string{"--read-timeout=60"},
+			},
+			Data: &api.DataBackupConfiguration{
+				AdditionalCommandArgs: []string{"--read-timeout=60"},
+			},
+		}
+		path := field.NewPath("spec", "backupConfiguration")
+		ValidateBackupConfiguration(configuration, path)
+		GetBackupConfigurationWarnings(configuration, path)
+		Expect(marker).ToNot(BeAnExistingFile())
+	})
 })
 
 var _ = Describe("Retention Policy Validation", func() {
@@ -0,0 +1,137 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package webhooks
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
+)
+
+// tagLimits are the limits a cloud provider applies to the tags of an object
+type tagLimits struct {
+	// The maximum number of tags of an object
+	maxTags int
+
+	// The maximum length of a key, in characters
+	maxKeyLength int
+
+	// The maximum length of a value, in characters
+	maxValueLength int
+
+	// The characters allowed in keys and values
+	allowedCharacters *regexp.Regexp
+
+	// A human-readable description of allowedCharacters
+	allowedCharactersDescription string
+
+	// The prefix reserved by the provider, which cannot be used in keys
+	reservedKeyPrefix string
+}
+
+// providerTagLimits maps the cloud providers to the limits of their object tags
+var providerTagLimits = map[string]tagLimits{
+	api.CloudProviderAWS: {
+		maxTags:                      10,
+		maxKeyLength:                 128,
+		maxValueLength:               256,
+		allowedCharacters:            regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`),
+		allowedCharactersDescription: "letters, numbers, spaces and the characters _ . : / = + - @",
+		reservedKeyPrefix:            "aws:",
+	},
+	api.CloudProviderAzure: {
+		maxTags:                      10,
+		maxKeyLength:                 128,
+		maxValueLength:               256,
+		allowedCharacters:            regexp.MustCompile(`^[a-zA-Z0-9 +\-./:=_]*$`),
+		allowedCharactersDescription: "ASCII letters, numbers, spaces and the characters + - . / : = _",
+	},
+}
+
+// validateAllTags validates the tags and the history tags
+func validateAllTags(barmanObjectStore *api.BarmanObjectStoreConfiguration, path *field.Path) field.ErrorList {
+	var limits *tagLimits
+	if provider := api.DetectCloudProvider(barmanObjectStore); provider != nil {
+		if providerLimits, ok := providerTagLimits[provider.Name()]; ok {
+			limits = &providerLimits
+		}
+	}
+
+	return append(
+		validateTags(barmanObjectStore.Tags, limits, path.Child("tags")),
+		validateTags(barmanObjectStore.HistoryTags, limits, path.Child("historyTags"))...)
+}
+
+// validateTags checks that the tags can be passed to barman and, when
+// the limits are known, that they will be accepted by the cloud provider
+func validateTags(tags map[string]string, limits *tagLimits, path *field.Path) field.ErrorList {
+	allErrors := field.ErrorList{}
+
+	if limits != nil && len(tags) > limits.maxTags {
+		allErrors = append(allErrors, field.TooMany(path, len(tags), limits.maxTags))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		value := tags[key]
+		keyPath := path.Key(key)
+
+		if err := utils.ValidateBarmanTag(key, value); err != nil {
+			allErrors = append(allErrors, field.Invalid(keyPath, value, err.Error()))
+			continue
+		}
+
+		if limits == nil {
+			continue
+		}
+
+		if utf8.RuneCountInString(key) > limits.maxKeyLength {
+			allErrors = append(allErrors, field.TooLong(keyPath, key, limits.maxKeyLength))
+		}
+
+		if utf8.RuneCountInString(value) > limits.maxValueLength {
+			allErrors = append(allErrors, field.TooLong(keyPath, value, limits.maxValueLength))
+		}
+
+		if !limits.allowedCharacters.MatchString(key) || !limits.allowedCharacters.MatchString(value) {
+			allErrors = append(allErrors, field.Invalid(
+				keyPath,
+				value,
+				"tag keys and values can only contain "+limits.allowedCharactersDescription,
+			))
+		}
+
+		if limits.reservedKeyPrefix != "" && strings.HasPrefix(strings.ToLower(key), limits.reservedKeyPrefix) {
+			allErrors = append(allErrors, field.Invalid(
+				keyPath,
+				key,
+				"tag keys cannot start with the reserved prefix "+limits.reservedKeyPrefix,
+			))
+		}
+	}
+
+	return allErrors
+}
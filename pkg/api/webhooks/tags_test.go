@@ -0,0 +1,89 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package webhooks
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tags validation", func() {
+	validate := func(credentials api.BarmanCredentials, destinationPath string, tags map[string]string) field.ErrorList {
+		return ValidateBackupConfiguration(
+			&api.BarmanObjectStoreConfiguration{
+				BarmanCredentials: credentials,
+				DestinationPath:   destinationPath,
+				HistoryTags:       tags,
+			},
+			field.NewPath("spec"))
+	}
+	aws := api.BarmanCredentials{AWS: &api.S3Credentials{InheritFromIAMRole: true}}
+	azure := api.BarmanCredentials{Azure: &api.AzureCredentials{InheritFromAzureAD: true}}
+	google := api.BarmanCredentials{Google: &api.GoogleCredentials{GKEEnvironment: true}}
+
+	It("accepts valid tags", func() {
+		Expect(validate(aws, "s3://bucket", map[string]string{"team": "dba", "owner": "dba@example.com"})).
+			To(BeEmpty())
+	})
+
+	It("complains about too many tags", func() {
+		tags := make(map[string]string)
+		for idx := range 11 {
+			tags[fmt.Sprintf("key%d", idx)] = "value"
+		}
+		err := validate(aws, "s3://bucket", tags)
+		Expect(err).To(HaveLen(1))
+		Expect(err[0].Type).To(Equal(field.ErrorTypeTooMany))
+		Expect(err[0].Field).To(Equal("spec.historyTags"))
+
+		Expect(validate(google, "gs://bucket", tags)).To(BeEmpty())
+	})
+
+	It("complains about keys and values too long", func() {
+		err := validate(aws, "s3://bucket", map[string]string{
+			strings.Repeat("k", 129): strings.Repeat("v", 257),
+		})
+		Expect(err).To(HaveLen(2))
+		Expect(err[0].Type).To(Equal(field.ErrorTypeTooLong))
+		Expect(err[1].Type).To(Equal(field.ErrorTypeTooLong))
+	})
+
+	It("complains about the characters not allowed by the provider", func() {
+		Expect(validate(aws, "s3://bucket", map[string]string{"owner": "alice,bob"})).To(HaveLen(1))
+		Expect(validate(azure, "https://account.blob.core.windows.net/container",
+			map[string]string{"owner": "dba@example.com"})).To(HaveLen(1))
+		Expect(validate(google, "gs://bucket", map[string]string{"owner": "alice,bob"})).To(BeEmpty())
+	})
+
+	It("complains about reserved keys and empty keys", func() {
+		err := validate(aws, "s3://bucket", map[string]string{"aws:createdBy": "me"})
+		Expect(err).To(HaveLen(1))
+		Expect(err[0].Field).To(Equal("spec.historyTags[aws:createdBy]"))
+
+		Expect(validate(google, "gs://bucket", map[string]string{"": "value"})).To(HaveLen(1))
+	})
+})
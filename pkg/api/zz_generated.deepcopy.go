@@ -101,6 +101,11 @@ func (in *BarmanObjectStoreConfiguration) DeepCopyInto(out *BarmanObjectStoreCon
 		*out = new(pkgapi.SecretKeySelector)
 		**out = **in
 	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(TLSConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Wal != nil {
 		in, out := &in.Wal, &out.Wal
 		*out = new(WalBackupConfiguration)
@@ -145,6 +150,11 @@ func (in *DataBackupConfiguration) DeepCopyInto(out *DataBackupConfiguration) {
 		*out = new(int32)
 		**out = **in
 	}
+	if in.MaxBandwidth != nil {
+		in, out := &in.MaxBandwidth, &out.MaxBandwidth
+		x := (*in).DeepCopy()
+		*out = &x
+	}
 	if in.AdditionalCommandArgs != nil {
 		in, out := &in.AdditionalCommandArgs, &out.AdditionalCommandArgs
 		*out = make([]string, len(*in))
@@ -222,9 +232,39 @@ func (in *S3Credentials) DeepCopy() *S3Credentials {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSConfiguration) DeepCopyInto(out *TLSConfiguration) {
+	*out = *in
+	if in.CA != nil {
+		in, out := &in.CA, &out.CA
+		*out = new(pkgapi.SecretKeySelector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSConfiguration.
+func (in *TLSConfiguration) DeepCopy() *TLSConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WalBackupConfiguration) DeepCopyInto(out *WalBackupConfiguration) {
 	*out = *in
+	if in.MaxBandwidth != nil {
+		in, out := &in.MaxBandwidth, &out.MaxBandwidth
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.MaxRequestsPerSecond != nil {
+		in, out := &in.MaxRequestsPerSecond, &out.MaxRequestsPerSecond
+		*out = new(int32)
+		**out = **in
+	}
 	if in.ArchiveAdditionalCommandArgs != nil {
 		in, out := &in.ArchiveAdditionalCommandArgs, &out.ArchiveAdditionalCommandArgs
 		*out = make([]string, len(*in))
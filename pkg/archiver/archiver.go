@@ -23,6 +23,7 @@ package archiver
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/cloudnative-pg/machinery/pkg/log"
@@ -30,6 +31,8 @@ import (
 	"github.com/cloudnative-pg/barman-cloud/pkg/api"
 	"github.com/cloudnative-pg/barman-cloud/pkg/command"
 	"github.com/cloudnative-pg/barman-cloud/pkg/spool"
+	"github.com/cloudnative-pg/barman-cloud/pkg/stats"
+	"github.com/cloudnative-pg/barman-cloud/pkg/throttle"
 	"github.com/cloudnative-pg/barman-cloud/pkg/walarchive"
 )
 
@@ -39,13 +42,22 @@ type WALArchiver struct {
 	// The spool of WAL files to be archived in parallel
 	spool *spool.WALSpool
 
+	// The directory of the spool, also holding the state
+	// of the archival into multiple destinations
+	spoolDirectory string
+
 	// The environment that should be used to invoke barman-cloud-wal-archive
 	env []string
 
+	// The data directory of PostgreSQL, where the WAL
+	// files ready to be archived are found
 	pgDataDirectory string
 
 	// this should become a grpc interface
 	barmanArchiver *walarchive.BarmanArchiver
+
+	// The statistics of the archival of the WAL files
+	statistics *stats.File
 }
 
 // WALArchiverResult contains the result of the archival of one WAL
@@ -61,6 +73,10 @@ type WALArchiverResult struct {
 
 	// The time when end barman-cloud-wal-archive ended
 	EndTime time.Time
+
+	// The result of the archival into every destination, only
+	// set when archiving into multiple destinations
+	Destinations []DestinationResult
 }
 
 // New creates a new WAL archiver
@@ -81,6 +97,7 @@ func New(
 
 	archiver = &WALArchiver{
 		spool:           walArchiveSpool,
+		spoolDirectory:  spoolDirectory,
 		env:             env,
 		pgDataDirectory: pgDataDirectory,
 		barmanArchiver: &walarchive.BarmanArchiver{
@@ -88,10 +105,23 @@ func New(
 			Touch:               walArchiveSpool.Touch,
 			EmptyWalArchivePath: emptyWalArchivePath,
 		},
+		statistics: stats.NewFile(filepath.Join(spoolDirectory, statisticsFileName)),
 	}
 	return archiver, nil
 }
 
+// limiterStateFileName is the name of the file, inside the spool,
+// holding the state of the limiter shared by the archivals
+const limiterStateFileName = ".limiter.json"
+
+// SetLimiter limits the bandwidth and the request rate used
+// by the archivals, such as with throttle.NewFromConfiguration.
+// The limits are shared through the spool by the archivers using it.
+func (archiver *WALArchiver) SetLimiter(limiter *throttle.Limiter) {
+	archiver.barmanArchiver.Limiter = limiter.WithSharedState(
+		filepath.Join(archiver.spoolDirectory, limiterStateFileName))
+}
+
 // DeleteFromSpool checks if a WAL file is in the spool and, if it is, remove it
 func (archiver *WALArchiver) DeleteFromSpool(walName string) (hasBeenDeleted bool, err error) {
 	var isContained bool
@@ -121,6 +151,27 @@ func (archiver *WALArchiver) ArchiveList(
 			EndTime:   re.EndTime,
 		})
 	}
+	archiver.recordStatistics(ctx, result)
+	return result
+}
+
+// ArchiveBatch archives a list of WAL files with a single barman process,
+// falling back to ArchiveList when the barman installation doesn't allow it
+func (archiver *WALArchiver) ArchiveBatch(
+	ctx context.Context,
+	walNames []string,
+	options []string,
+) (result []WALArchiverResult) {
+	res := archiver.barmanArchiver.ArchiveBatch(ctx, walNames, options)
+	for _, re := range res {
+		result = append(result, WALArchiverResult{
+			WalName:   re.WalName,
+			Err:       re.Err,
+			StartTime: re.StartTime,
+			EndTime:   re.EndTime,
+		})
+	}
+	archiver.recordStatistics(ctx, result)
 	return result
 }
 
@@ -141,26 +192,5 @@ func (archiver *WALArchiver) BarmanCloudCheckWalArchiveOptions(
 	configuration *api.BarmanObjectStoreConfiguration,
 	clusterName string,
 ) ([]string, error) {
-	var options []string
-	if len(configuration.EndpointURL) > 0 {
-		options = append(
-			options,
-			"--endpoint-url",
-			configuration.EndpointURL)
-	}
-
-	options, err := command.AppendCloudProviderOptionsFromConfiguration(ctx, options, configuration)
-	if err != nil {
-		return nil, err
-	}
-
-	serverName := clusterName
-	if len(configuration.ServerName) != 0 {
-		serverName = configuration.ServerName
-	}
-	options = append(
-		options,
-		configuration.DestinationPath,
-		serverName)
-	return options, nil
+	return command.CheckWalArchiveOptions(ctx, configuration, clusterName)
 }
@@ -21,11 +21,9 @@ package archiver
 
 import (
 	"context"
-	"fmt"
 
 	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
 	barmanCommand "github.com/cloudnative-pg/barman-cloud/pkg/command"
-	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
 )
 
 // BarmanCloudWalArchiveOptions calculates the set of options to be
@@ -35,56 +33,5 @@ func (archiver *WALArchiver) BarmanCloudWalArchiveOptions(
 	configuration *barmanApi.BarmanObjectStoreConfiguration,
 	clusterName string,
 ) ([]string, error) {
-	var options []string
-	if configuration.Wal != nil {
-		if len(configuration.Wal.Compression) != 0 {
-			options = append(
-				options,
-				fmt.Sprintf("--%v", configuration.Wal.Compression))
-		}
-		if len(configuration.Wal.Encryption) != 0 {
-			options = append(
-				options,
-				"-e",
-				string(configuration.Wal.Encryption))
-		}
-		options = configuration.Wal.AppendArchiveAdditionalCommandArgs(options)
-	}
-	if len(configuration.EndpointURL) > 0 {
-		options = append(
-			options,
-			"--endpoint-url",
-			configuration.EndpointURL)
-	}
-
-	if len(configuration.Tags) > 0 {
-		tags, err := utils.MapToBarmanTagsFormat("--tags", configuration.Tags)
-		if err != nil {
-			return nil, err
-		}
-		options = append(options, tags...)
-	}
-
-	if len(configuration.HistoryTags) > 0 {
-		historyTags, err := utils.MapToBarmanTagsFormat("--history-tags", configuration.HistoryTags)
-		if err != nil {
-			return nil, err
-		}
-		options = append(options, historyTags...)
-	}
-
-	options, err := barmanCommand.AppendCloudProviderOptionsFromConfiguration(ctx, options, configuration)
-	if err != nil {
-		return nil, err
-	}
-
-	serverName := clusterName
-	if len(configuration.ServerName) != 0 {
-		serverName = configuration.ServerName
-	}
-	options = append(
-		options,
-		configuration.DestinationPath,
-		serverName)
-	return options, nil
+	return barmanCommand.WalArchiveOptions(ctx, configuration, clusterName)
 }
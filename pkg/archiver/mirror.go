@@ -0,0 +1,462 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package archiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/cloudnative-pg/machinery/pkg/fileutils"
+	"github.com/cloudnative-pg/machinery/pkg/log"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/command"
+	"github.com/cloudnative-pg/barman-cloud/pkg/spool"
+	"github.com/cloudnative-pg/barman-cloud/pkg/walarchive"
+)
+
+// MirrorPolicy decides when a WAL file archived into
+// multiple destinations is considered to be archived
+type MirrorPolicy string
+
+const (
+	// MirrorPolicyAll requires every destination to archive the WAL file
+	MirrorPolicyAll MirrorPolicy = "all"
+
+	// MirrorPolicyQuorum requires the majority of the destinations to archive
+	// the WAL file. The other destinations catch up asynchronously.
+	MirrorPolicyQuorum MirrorPolicy = "quorum"
+
+	// MirrorPolicyPrimaryOnly only requires the primary destination to archive
+	// the WAL file. The other destinations catch up asynchronously.
+	MirrorPolicyPrimaryOnly MirrorPolicy = "primary-only"
+)
+
+const (
+	// destinationsDirectory is the directory, inside the spool, holding
+	// the state of the archival into every destination
+	destinationsDirectory = ".destinations"
+
+	// archivedDirectory contains the markers of the WAL files already
+	// archived into a destination, which are not uploaded again when
+	// PostgreSQL retries the archival
+	archivedDirectory = "archived"
+
+	// pendingDirectory contains a copy of the WAL files which a
+	// destination missed, waiting to be archived by CatchUp
+	pendingDirectory = "pending"
+)
+
+// destinationNameRegex is the format of a valid destination name,
+// which is used as a directory name inside the spool
+var destinationNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+var (
+	// ErrInvalidDestinations is returned when the destinations
+	// or the mirror policy are not valid
+	ErrInvalidDestinations = errors.New("invalid archive destinations")
+
+	// ErrMirrorPolicyNotSatisfied is returned when a WAL file has not been
+	// archived into enough destinations to satisfy the mirror policy
+	ErrMirrorPolicyNotSatisfied = errors.New("mirror policy not satisfied")
+)
+
+// Destination is an object store receiving a copy of the WAL files
+type Destination struct {
+	// The name of the destination, which must be unique and
+	// is used to track the state of the archival in the spool
+	Name string
+
+	// The options of barman-cloud-wal-archive, as
+	// built by BarmanCloudWalArchiveOptions
+	Options []string
+
+	// The environment needed by barman-cloud to reach the object store,
+	// as built by credentials.EnvSetCloudCredentialsAndCertificates.
+	// When nil, the environment of the archiver is used.
+	Env []string
+
+	// True for the primary destination. When no destination is
+	// marked as primary, the first one is considered the primary.
+	Primary bool
+}
+
+// DestinationResult contains the result of the archival of
+// one WAL file into a destination
+type DestinationResult struct {
+	// The name of the destination
+	Name string
+
+	// If not nil, this is the error that has been detected
+	Err error
+
+	// True if the WAL file had already been archived into the
+	// destination by a previous attempt, and was not uploaded again
+	AlreadyArchived bool
+
+	// True if the archival failed, and the WAL file has been
+	// queued to be archived later by CatchUp
+	Pending bool
+
+	// The time when we started archiving the WAL file
+	StartTime time.Time
+
+	// The time when the archival of the WAL file ended
+	EndTime time.Time
+}
+
+// NewDestination creates a destination from the configuration of an object store
+// and the environment needed to reach it
+func NewDestination(
+	ctx context.Context,
+	name string,
+	configuration *api.BarmanObjectStoreConfiguration,
+	clusterName string,
+	env []string,
+	primary bool,
+) (*Destination, error) {
+	options, err := command.WalArchiveOptions(ctx, configuration, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Destination{
+		Name:    name,
+		Options: options,
+		Env:     env,
+		Primary: primary,
+	}, nil
+}
+
+// ArchiveListToDestinations archives a list of WAL files in parallel into
+// multiple destinations, considering every WAL file archived according to the
+// mirror policy. As in ArchiveList, the WAL files following the first one are
+// added to the spool once archived.
+func (archiver *WALArchiver) ArchiveListToDestinations(
+	ctx context.Context,
+	walNames []string,
+	destinations []Destination,
+	policy MirrorPolicy,
+) ([]WALArchiverResult, error) {
+	if err := validateDestinations(destinations, policy); err != nil {
+		return nil, err
+	}
+
+	contextLog := log.FromContext(ctx)
+	result := make([]WALArchiverResult, len(walNames))
+
+	var waitGroup sync.WaitGroup
+	for idx := range walNames {
+		waitGroup.Add(1)
+		go func(walIndex int) {
+			defer waitGroup.Done()
+
+			result[walIndex] = archiver.archiveToDestinations(ctx, walNames[walIndex], destinations, policy)
+			walStatus := &result[walIndex]
+
+			walContextLog := contextLog.WithValues(
+				"walName", walStatus.WalName,
+				"startTime", walStatus.StartTime,
+				"endTime", walStatus.EndTime,
+				"elapsedWalTime", walStatus.EndTime.Sub(walStatus.StartTime),
+			)
+
+			if walStatus.Err != nil {
+				walContextLog.Warning(
+					"Failed archiving WAL: PostgreSQL will retry",
+					"error", walStatus.Err)
+				return
+			}
+
+			if walIndex == 0 {
+				walContextLog.Info("Archived WAL file")
+				return
+			}
+
+			if err := archiver.spool.Touch(walNames[walIndex]); err != nil {
+				walContextLog.Warning(
+					"WAL file pre-archived, but it could not be added to the spool. PostgreSQL will retry",
+					"error", err)
+				return
+			}
+
+			walContextLog.Info("Pre-archived WAL file (parallel)")
+		}(idx)
+	}
+
+	waitGroup.Wait()
+	archiver.recordStatistics(ctx, result)
+	return result, nil
+}
+
+// CatchUp archives the WAL files which the destinations missed while
+// the mirror policy was satisfied by the other ones. The WAL files
+// archived successfully are removed from the queue.
+func (archiver *WALArchiver) CatchUp(ctx context.Context, destinations []Destination) error {
+	contextLog := log.FromContext(ctx)
+
+	var errs []error
+	for idx := range destinations {
+		destination := &destinations[idx]
+		pendingSpool, err := archiver.destinationSpool(destination.Name, pendingDirectory)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		walNames, err := pendingSpool.List()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("while listing the WAL files pending for %q: %w", destination.Name, err))
+			continue
+		}
+
+		for _, walName := range walNames {
+			if err := archiver.destinationArchiver(destination).Archive(
+				ctx,
+				pendingSpool.FileName(walName),
+				destination.Options,
+			); err != nil {
+				errs = append(errs, fmt.Errorf("while archiving WAL %q into %q: %w", walName, destination.Name, err))
+				continue
+			}
+
+			if err := pendingSpool.Remove(walName); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			contextLog.Info("Archived pending WAL file", "walName", walName, "destination", destination.Name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// archiveToDestinations archives a WAL file into every destination in parallel
+func (archiver *WALArchiver) archiveToDestinations(
+	ctx context.Context,
+	walName string,
+	destinations []Destination,
+	policy MirrorPolicy,
+) WALArchiverResult {
+	result := WALArchiverResult{
+		WalName:      walName,
+		StartTime:    time.Now(),
+		Destinations: make([]DestinationResult, len(destinations)),
+	}
+
+	var waitGroup sync.WaitGroup
+	for idx := range destinations {
+		waitGroup.Add(1)
+		go func(destinationIndex int) {
+			defer waitGroup.Done()
+			result.Destinations[destinationIndex] = archiver.archiveToDestination(
+				ctx, walName, &destinations[destinationIndex])
+		}(idx)
+	}
+	waitGroup.Wait()
+
+	result.Err = archiver.applyMirrorPolicy(walName, destinations, policy, result.Destinations)
+	result.EndTime = time.Now()
+	return result
+}
+
+// archiveToDestination archives a WAL file into a destination, unless
+// a previous attempt already did it
+func (archiver *WALArchiver) archiveToDestination(
+	ctx context.Context,
+	walName string,
+	destination *Destination,
+) DestinationResult {
+	result := DestinationResult{
+		Name:      destination.Name,
+		StartTime: time.Now(),
+	}
+	defer func() {
+		result.EndTime = time.Now()
+	}()
+
+	archivedSpool, err := archiver.destinationSpool(destination.Name, archivedDirectory)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if result.AlreadyArchived, err = archivedSpool.Contains(walName); err != nil || result.AlreadyArchived {
+		result.Err = err
+		return result
+	}
+
+	if result.Err = archiver.destinationArchiver(destination).Archive(
+		ctx, walName, destination.Options); result.Err != nil {
+		return result
+	}
+
+	if err := archivedSpool.Touch(walName); err != nil {
+		log.FromContext(ctx).Warning(
+			"WAL file archived, but it could not be marked as such. It will be uploaded again on retry",
+			"walName", walName,
+			"destination", destination.Name,
+			"error", err)
+	}
+
+	return result
+}
+
+// destinationArchiver gets the barman archiver uploading
+// into a destination with the environment of the destination
+func (archiver *WALArchiver) destinationArchiver(destination *Destination) *walarchive.BarmanArchiver {
+	if destination.Env == nil {
+		return archiver.barmanArchiver
+	}
+
+	barmanArchiver := *archiver.barmanArchiver
+	barmanArchiver.Env = destination.Env
+	return &barmanArchiver
+}
+
+// applyMirrorPolicy checks if a WAL file has been archived according to
+// the mirror policy. When it has, the destinations which failed are queued
+// for CatchUp and the markers of the archived WAL file are removed.
+func (archiver *WALArchiver) applyMirrorPolicy(
+	walName string,
+	destinations []Destination,
+	policy MirrorPolicy,
+	results []DestinationResult,
+) error {
+	primaryIndex := getPrimaryIndex(destinations)
+
+	var errs []error
+	for idx := range results {
+		if results[idx].Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", results[idx].Name, results[idx].Err))
+		}
+	}
+	succeeded := len(results) - len(errs)
+
+	var satisfied bool
+	switch policy {
+	case MirrorPolicyAll:
+		satisfied = succeeded == len(results)
+	case MirrorPolicyQuorum:
+		satisfied = succeeded*2 > len(results)
+	case MirrorPolicyPrimaryOnly:
+		satisfied = results[primaryIndex].Err == nil
+	}
+	if !satisfied {
+		return fmt.Errorf("%w (%s): %w", ErrMirrorPolicyNotSatisfied, policy, errors.Join(errs...))
+	}
+
+	for idx := range results {
+		if results[idx].Err == nil {
+			continue
+		}
+		if err := archiver.queuePending(walName, destinations[idx].Name); err != nil {
+			return fmt.Errorf("while queueing WAL %q for %q: %w", walName, destinations[idx].Name, err)
+		}
+		results[idx].Pending = true
+	}
+
+	for idx := range destinations {
+		archivedSpool, err := archiver.destinationSpool(destinations[idx].Name, archivedDirectory)
+		if err != nil {
+			return err
+		}
+		if err := archivedSpool.Remove(walName); err != nil && !errors.Is(err, spool.ErrorNonExistentFile) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queuePending copies a WAL file into the queue of a destination, as
+// PostgreSQL is free to remove it once it has been archived
+func (archiver *WALArchiver) queuePending(walName string, destinationName string) error {
+	pendingSpool, err := archiver.destinationSpool(destinationName, pendingDirectory)
+	if err != nil {
+		return err
+	}
+
+	baseName := path.Base(walName)
+	if err := fileutils.CopyFile(walName, pendingSpool.TempFileName(baseName)); err != nil {
+		pendingSpool.CleanupTemp(baseName)
+		return err
+	}
+
+	return pendingSpool.Commit(baseName)
+}
+
+// destinationSpool gets the spool holding the state of a destination
+func (archiver *WALArchiver) destinationSpool(destinationName string, kind string) (*spool.WALSpool, error) {
+	return spool.New(filepath.Join(archiver.spoolDirectory, destinationsDirectory, destinationName, kind))
+}
+
+// validateDestinations checks the destinations and the mirror policy
+func validateDestinations(destinations []Destination, policy MirrorPolicy) error {
+	switch policy {
+	case MirrorPolicyAll, MirrorPolicyQuorum, MirrorPolicyPrimaryOnly:
+	default:
+		return fmt.Errorf("%w: unknown mirror policy %q", ErrInvalidDestinations, policy)
+	}
+
+	if len(destinations) == 0 {
+		return fmt.Errorf("%w: no destination", ErrInvalidDestinations)
+	}
+
+	names := make(map[string]struct{}, len(destinations))
+	primaries := 0
+	for _, destination := range destinations {
+		if !destinationNameRegex.MatchString(destination.Name) {
+			return fmt.Errorf("%w: invalid destination name %q", ErrInvalidDestinations, destination.Name)
+		}
+		if _, found := names[destination.Name]; found {
+			return fmt.Errorf("%w: duplicate destination name %q", ErrInvalidDestinations, destination.Name)
+		}
+		names[destination.Name] = struct{}{}
+
+		if destination.Primary {
+			primaries++
+		}
+	}
+
+	if primaries > 1 {
+		return fmt.Errorf("%w: more than one primary destination", ErrInvalidDestinations)
+	}
+
+	return nil
+}
+
+// getPrimaryIndex gets the index of the primary destination
+func getPrimaryIndex(destinations []Destination) int {
+	for idx := range destinations {
+		if destinations[idx].Primary {
+			return idx
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,226 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package archiver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Archival into multiple destinations", func() {
+	const (
+		firstWAL  = "000000010000000000000001"
+		secondWAL = "000000010000000000000002"
+	)
+
+	var (
+		tempDir  string
+		walPaths []string
+		archiver *WALArchiver
+	)
+
+	// destination creates a destination archiving into a local directory.
+	// A broken destination points inside a regular file, and cannot archive
+	// anything until the file is removed.
+	destination := func(ctx SpecContext, name string, primary bool, broken bool) Destination {
+		directory := filepath.Join(tempDir, "stores", name)
+		if broken {
+			Expect(os.MkdirAll(filepath.Dir(directory), 0o750)).To(Succeed())
+			Expect(os.WriteFile(directory, nil, 0o600)).To(Succeed())
+		}
+
+		result, err := NewDestination(ctx, name, &barmanApi.BarmanObjectStoreConfiguration{
+			DestinationPath: "file://" + directory,
+		}, "cluster", nil, primary)
+		Expect(err).ToNot(HaveOccurred())
+		return *result
+	}
+
+	archivedWALPath := func(name string, walName string) string {
+		return filepath.Join(tempDir, "stores", name, "cluster", "wals", walName[:16], walName)
+	}
+
+	repair := func(name string) {
+		Expect(os.Remove(filepath.Join(tempDir, "stores", name))).To(Succeed())
+	}
+
+	BeforeEach(func(ctx SpecContext) {
+		tempDir = GinkgoT().TempDir()
+
+		walDirectory := filepath.Join(tempDir, "pg_wal")
+		Expect(os.MkdirAll(walDirectory, 0o750)).To(Succeed())
+		walPaths = nil
+		for _, walName := range []string{firstWAL, secondWAL} {
+			walPath := filepath.Join(walDirectory, walName)
+			Expect(os.WriteFile(walPath, []byte(walName), 0o600)).To(Succeed())
+			walPaths = append(walPaths, walPath)
+		}
+
+		var err error
+		archiver, err = New(ctx, nil, filepath.Join(tempDir, "spool"), tempDir, filepath.Join(tempDir, "empty"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("archives the WAL files into every destination", func(ctx SpecContext) {
+		destinations := []Destination{
+			destination(ctx, "primary", true, false),
+			destination(ctx, "replica", false, false),
+		}
+
+		results, err := archiver.ArchiveListToDestinations(ctx, walPaths, destinations, MirrorPolicyAll)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(2))
+		for _, result := range results {
+			Expect(result.Err).ToNot(HaveOccurred())
+			Expect(result.Destinations).To(HaveLen(2))
+		}
+
+		for _, name := range []string{"primary", "replica"} {
+			Expect(archivedWALPath(name, firstWAL)).To(BeAnExistingFile())
+			Expect(archivedWALPath(name, secondWAL)).To(BeAnExistingFile())
+		}
+
+		Expect(archiver.DeleteFromSpool(secondWAL)).To(BeTrue())
+		Expect(archiver.DeleteFromSpool(firstWAL)).To(BeFalse())
+
+		statistics, err := archiver.Statistics()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statistics.Count).To(Equal(int64(2)))
+		Expect(statistics.Bytes).To(Equal(int64(len(firstWAL) + len(secondWAL))))
+		Expect(statistics.FailedCount).To(BeZero())
+	})
+
+	It("archives into every destination with its own environment", func(ctx SpecContext) {
+		binDirectory := filepath.Join(tempDir, "bin")
+		Expect(os.MkdirAll(binDirectory, 0o750)).To(Succeed())
+		callsPath := filepath.Join(tempDir, "calls")
+		// #nosec G306
+		Expect(os.WriteFile(filepath.Join(binDirectory, "barman-cloud-wal-archive"), []byte(
+			"#!/bin/sh\necho \"$1 $CREDENTIALS\" >> "+callsPath+"\n"), 0o755)).To(Succeed())
+		GinkgoT().Setenv("PATH", binDirectory+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		destinations := []Destination{
+			{Name: "first", Options: []string{"s3://first", "cluster"}, Env: []string{"CREDENTIALS=first"}},
+			{Name: "second", Options: []string{"s3://second", "cluster"}, Env: []string{"CREDENTIALS=second"}},
+		}
+		results, err := archiver.ArchiveListToDestinations(ctx, walPaths[:1], destinations, MirrorPolicyAll)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].Err).ToNot(HaveOccurred())
+
+		calls, err := os.ReadFile(callsPath) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Split(strings.TrimSpace(string(calls)), "\n")).To(ConsistOf(
+			"s3://first first",
+			"s3://second second",
+		))
+	})
+
+	It("doesn't upload again a WAL file into the destinations already having it", func(ctx SpecContext) {
+		destinations := []Destination{
+			destination(ctx, "primary", true, false),
+			destination(ctx, "replica", false, true),
+		}
+
+		results, err := archiver.ArchiveListToDestinations(ctx, walPaths[:1], destinations, MirrorPolicyAll)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(errors.Is(results[0].Err, ErrMirrorPolicyNotSatisfied)).To(BeTrue())
+		Expect(results[0].Destinations[0].Err).ToNot(HaveOccurred())
+		Expect(results[0].Destinations[1].Err).To(HaveOccurred())
+
+		statistics, err := archiver.Statistics()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statistics.FailedCount).To(Equal(int64(1)))
+		Expect(statistics.LastFailedWAL).To(Equal(walPaths[0]))
+
+		repair("replica")
+		results, err = archiver.ArchiveListToDestinations(ctx, walPaths[:1], destinations, MirrorPolicyAll)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results[0].Err).ToNot(HaveOccurred())
+		Expect(results[0].Destinations[0].AlreadyArchived).To(BeTrue())
+		Expect(results[0].Destinations[1].AlreadyArchived).To(BeFalse())
+		Expect(archivedWALPath("replica", firstWAL)).To(BeAnExistingFile())
+	})
+
+	It("queues the WAL files for the destinations missing the quorum", func(ctx SpecContext) {
+		destinations := []Destination{
+			destination(ctx, "first", false, false),
+			destination(ctx, "second", false, false),
+			destination(ctx, "third", false, true),
+		}
+
+		results, err := archiver.ArchiveListToDestinations(ctx, walPaths, destinations, MirrorPolicyQuorum)
+		Expect(err).ToNot(HaveOccurred())
+		for _, result := range results {
+			Expect(result.Err).ToNot(HaveOccurred())
+			Expect(result.Destinations[2].Err).To(HaveOccurred())
+			Expect(result.Destinations[2].Pending).To(BeTrue())
+		}
+
+		Expect(archiver.CatchUp(ctx, destinations)).ToNot(Succeed())
+
+		repair("third")
+		Expect(archiver.CatchUp(ctx, destinations)).To(Succeed())
+		Expect(archivedWALPath("third", firstWAL)).To(BeAnExistingFile())
+		Expect(archivedWALPath("third", secondWAL)).To(BeAnExistingFile())
+		Expect(os.ReadFile(archivedWALPath("third", secondWAL))).To(Equal([]byte(secondWAL)))
+	})
+
+	It("only requires the primary destination with the primary-only policy", func(ctx SpecContext) {
+		destinations := []Destination{
+			destination(ctx, "replica", false, true),
+			destination(ctx, "primary", true, false),
+		}
+		results, err := archiver.ArchiveListToDestinations(ctx, walPaths[:1], destinations, MirrorPolicyPrimaryOnly)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results[0].Err).ToNot(HaveOccurred())
+		Expect(results[0].Destinations[0].Pending).To(BeTrue())
+
+		destinations = []Destination{
+			destination(ctx, "broken-primary", true, true),
+			destination(ctx, "working-replica", false, false),
+		}
+		results, err = archiver.ArchiveListToDestinations(ctx, walPaths[:1], destinations, MirrorPolicyPrimaryOnly)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(errors.Is(results[0].Err, ErrMirrorPolicyNotSatisfied)).To(BeTrue())
+		Expect(results[0].Destinations[1].Pending).To(BeFalse())
+	})
+
+	DescribeTable("refuses invalid destinations",
+		func(destinations []Destination, policy MirrorPolicy) {
+			_, err := archiver.ArchiveListToDestinations(context.Background(), walPaths, destinations, policy)
+			Expect(errors.Is(err, ErrInvalidDestinations)).To(BeTrue())
+		},
+		Entry("no destination", nil, MirrorPolicyAll),
+		Entry("unknown policy", []Destination{{Name: "first"}}, MirrorPolicy("some")),
+		Entry("duplicate names", []Destination{{Name: "first"}, {Name: "first"}}, MirrorPolicyAll),
+		Entry("invalid name", []Destination{{Name: "../first"}}, MirrorPolicyAll),
+		Entry("two primaries", []Destination{{Name: "first", Primary: true}, {Name: "second", Primary: true}},
+			MirrorPolicyAll),
+	)
+})
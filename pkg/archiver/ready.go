@@ -0,0 +1,134 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package archiver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cloudnative-pg/machinery/pkg/log"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+const (
+	// archiveStatusDirectory is the directory, relative to the
+	// WAL directory, where PostgreSQL tracks the archival status
+	archiveStatusDirectory = "archive_status"
+
+	// readySuffix is the suffix of the files marking the
+	// WAL files which are ready to be archived
+	readySuffix = ".ready"
+)
+
+// GatherWALFilesToArchive reads from the archive status the list of WAL files
+// which can be archived in parallel. The requested WAL file, which PostgreSQL
+// is waiting for, is always the first of the list, followed by at most
+// parallel-1 WAL segments marked as ready and following it in LSN order on
+// the same timeline. The WAL segments of other timelines are left to their
+// own requests, as their names don't sort as their position in the WAL stream.
+// Timeline history, partial and backup label files are never archived ahead,
+// as PostgreSQL archives them with priority when they become ready, and no
+// WAL file is archived ahead when the requested one is not a WAL segment.
+// The WAL files already in the spool are skipped.
+func (archiver *WALArchiver) GatherWALFilesToArchive(
+	ctx context.Context,
+	requestedWALFile string,
+	parallel int,
+) []string {
+	contextLog := log.FromContext(ctx)
+	walList := []string{requestedWALFile}
+
+	requestedWALName, err := wal.Parse(filepath.Base(requestedWALFile))
+	if parallel <= 1 || archiver.pgDataDirectory == "" || err != nil || requestedWALName.Kind != wal.KindSegment {
+		return walList
+	}
+
+	archiveStatusPath := filepath.Join(archiver.pgDataDirectory, "pg_wal", archiveStatusDirectory)
+	entries, err := os.ReadDir(archiveStatusPath)
+	if err != nil {
+		contextLog.Warning("Cannot read the archive status, archiving only the requested WAL file",
+			"archiveStatusPath", archiveStatusPath,
+			"error", err)
+		return walList
+	}
+
+	var readyWALNames []string
+	for _, entry := range entries {
+		walName, isReady := strings.CutSuffix(entry.Name(), readySuffix)
+		if !isReady || entry.IsDir() || !isFollowingSegment(walName, requestedWALName) {
+			continue
+		}
+		readyWALNames = append(readyWALNames, walName)
+	}
+
+	// On the same timeline, the WAL segment names sort
+	// as their position in the WAL stream
+	slices.Sort(readyWALNames)
+
+	walDirectory := filepath.Dir(requestedWALFile)
+	for _, walName := range readyWALNames {
+		if len(walList) >= parallel {
+			break
+		}
+
+		isArchived, err := archiver.spool.Contains(walName)
+		if err != nil {
+			contextLog.Warning("Cannot check if a WAL file has already been archived",
+				"walName", walName,
+				"error", err)
+			continue
+		}
+		if isArchived {
+			continue
+		}
+
+		walList = append(walList, filepath.Join(walDirectory, walName))
+	}
+
+	return walList
+}
+
+// isFollowingSegment checks if a WAL file is a segment following
+// the requested one on the same timeline
+func isFollowingSegment(walName string, requested wal.Name) bool {
+	name, err := wal.Parse(walName)
+	if err != nil || name.Kind != wal.KindSegment || name.Timeline != requested.Timeline {
+		return false
+	}
+
+	return name.Log > requested.Log || (name.Log == requested.Log && name.Segment > requested.Segment)
+}
+
+// ArchiveReady archives the requested WAL file together with the
+// following ones which are ready to be archived, in parallel.
+// See GatherWALFilesToArchive and ArchiveList.
+func (archiver *WALArchiver) ArchiveReady(
+	ctx context.Context,
+	requestedWALFile string,
+	parallel int,
+	options []string,
+) []WALArchiverResult {
+	walNames := archiver.GatherWALFilesToArchive(ctx, requestedWALFile, parallel)
+	return archiver.ArchiveList(ctx, walNames, options)
+}
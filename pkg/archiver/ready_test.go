@@ -0,0 +1,118 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package archiver
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GatherWALFilesToArchive", func() {
+	const requestedWAL = "pg_wal/000000010000000000000002"
+
+	var (
+		tempDir  string
+		archiver *WALArchiver
+	)
+
+	BeforeEach(func(ctx SpecContext) {
+		tempDir = GinkgoT().TempDir()
+		archiveStatusPath := filepath.Join(tempDir, "pgdata", "pg_wal", "archive_status")
+		Expect(os.MkdirAll(archiveStatusPath, 0o750)).To(Succeed())
+		for _, fileName := range []string{
+			"000000010000000000000001.done",
+			"000000010000000000000002.ready",
+			"000000010000000000000005.ready",
+			"000000010000000000000003.ready",
+			"000000010000000000000004.ready",
+			"000000010000000000000003.00000028.backup.ready",
+			"000000010000000000000006.partial.ready",
+			"00000002.history.ready",
+			"000000020000000000000006.ready",
+		} {
+			Expect(os.WriteFile(filepath.Join(archiveStatusPath, fileName), nil, 0o600)).To(Succeed())
+		}
+
+		var err error
+		archiver, err = New(ctx, nil, filepath.Join(tempDir, "spool"), filepath.Join(tempDir, "pgdata"), "")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("gathers the following ready WAL segments in LSN order", func(ctx SpecContext) {
+		Expect(archiver.GatherWALFilesToArchive(ctx, requestedWAL, 4)).To(Equal([]string{
+			requestedWAL,
+			"pg_wal/000000010000000000000003",
+			"pg_wal/000000010000000000000004",
+			"pg_wal/000000010000000000000005",
+		}))
+		Expect(archiver.GatherWALFilesToArchive(ctx, requestedWAL, 10)).To(Equal([]string{
+			requestedWAL,
+			"pg_wal/000000010000000000000003",
+			"pg_wal/000000010000000000000004",
+			"pg_wal/000000010000000000000005",
+		}))
+	})
+
+	It("gathers only the WAL segments of the requested timeline", func(ctx SpecContext) {
+		archiveStatusPath := filepath.Join(tempDir, "pgdata", "pg_wal", "archive_status")
+		for _, fileName := range []string{
+			"000000010000000100000000.ready",
+			"000000020000000000000001.ready",
+			"000000020000000000000007.ready",
+			"000000030000000000000003.ready",
+		} {
+			Expect(os.WriteFile(filepath.Join(archiveStatusPath, fileName), nil, 0o600)).To(Succeed())
+		}
+
+		Expect(archiver.GatherWALFilesToArchive(ctx, requestedWAL, 10)).To(Equal([]string{
+			requestedWAL,
+			"pg_wal/000000010000000000000003",
+			"pg_wal/000000010000000000000004",
+			"pg_wal/000000010000000000000005",
+			"pg_wal/000000010000000100000000",
+		}))
+		Expect(archiver.GatherWALFilesToArchive(ctx, "pg_wal/000000020000000000000005", 10)).To(Equal([]string{
+			"pg_wal/000000020000000000000005",
+			"pg_wal/000000020000000000000006",
+			"pg_wal/000000020000000000000007",
+		}))
+	})
+
+	It("skips the WAL files already in the spool", func(ctx SpecContext) {
+		Expect(archiver.spool.Touch("000000010000000000000003")).To(Succeed())
+		Expect(archiver.GatherWALFilesToArchive(ctx, requestedWAL, 3)).To(Equal([]string{
+			requestedWAL,
+			"pg_wal/000000010000000000000004",
+			"pg_wal/000000010000000000000005",
+		}))
+	})
+
+	It("archives only the requested file when it is not a WAL segment", func(ctx SpecContext) {
+		Expect(archiver.GatherWALFilesToArchive(ctx, "pg_wal/00000002.history", 4)).
+			To(Equal([]string{"pg_wal/00000002.history"}))
+	})
+
+	It("archives only the requested file without parallelism", func(ctx SpecContext) {
+		Expect(archiver.GatherWALFilesToArchive(ctx, requestedWAL, 1)).To(Equal([]string{requestedWAL}))
+	})
+})
@@ -0,0 +1,69 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package archiver
+
+import (
+	"context"
+	"os"
+
+	"github.com/cloudnative-pg/machinery/pkg/log"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/stats"
+)
+
+// statisticsFileName is the name of the file, inside
+// the spool, holding the statistics of the archival
+const statisticsFileName = ".statistics.json"
+
+// Statistics gets the statistics of the archival of the WAL files
+func (archiver *WALArchiver) Statistics() (*stats.Statistics, error) {
+	return archiver.statistics.Read()
+}
+
+// StatisticsPath gets the path of the file holding the
+// statistics of the archival of the WAL files
+func (archiver *WALArchiver) StatisticsPath() string {
+	return archiver.statistics.Path()
+}
+
+// recordStatistics updates the statistics with the results of the
+// archival. Failing to do that doesn't affect the archival.
+func (archiver *WALArchiver) recordStatistics(ctx context.Context, result []WALArchiverResult) {
+	events := make([]stats.Event, len(result))
+	for idx, walStatus := range result {
+		events[idx] = stats.Event{
+			WalName:   walStatus.WalName,
+			Err:       walStatus.Err,
+			StartTime: walStatus.StartTime,
+			EndTime:   walStatus.EndTime,
+		}
+		if walStatus.Err == nil {
+			if info, err := os.Stat(walStatus.WalName); err == nil {
+				events[idx].Bytes = info.Size()
+			}
+		}
+	}
+
+	if err := archiver.statistics.Record(events...); err != nil {
+		log.FromContext(ctx).Warning("Cannot update the archival statistics",
+			"statisticsPath", archiver.statistics.Path(),
+			"error", err)
+	}
+}
@@ -22,9 +22,7 @@ package backup
 import (
 	"context"
 	"errors"
-	"fmt"
 	"os/exec"
-	"strconv"
 
 	"github.com/cloudnative-pg/machinery/pkg/execlog"
 	"github.com/cloudnative-pg/machinery/pkg/log"
@@ -57,33 +55,10 @@ func (b *Command) GetDataConfiguration(
 		return options, nil
 	}
 
-	if len(b.configuration.Data.Compression) != 0 {
-		options = append(
-			options,
-			fmt.Sprintf("--%v", b.configuration.Data.Compression))
-	}
-
-	if len(b.configuration.Data.Encryption) != 0 {
-		options = append(
-			options,
-			"--encryption",
-			string(b.configuration.Data.Encryption))
-	}
-
-	if b.configuration.Data.ImmediateCheckpoint {
-		options = append(
-			options,
-			"--immediate-checkpoint")
-	}
-
-	if b.configuration.Data.Jobs != nil {
-		options = append(
-			options,
-			"--jobs",
-			strconv.Itoa(int(*b.configuration.Data.Jobs)))
-	}
-
-	return b.configuration.Data.AppendAdditionalCommandArgs(options), nil
+	return barmanCommand.NewOptions(utils.BarmanCloudBackup).
+		Flags(options...).
+		DataConfiguration(b.configuration.Data).
+		Args(), nil
 }
 
 // GetBarmanCloudBackupOptions extract the list of command line options to be used with
@@ -93,42 +68,7 @@ func (b *Command) GetBarmanCloudBackupOptions(
 	backupName string,
 	serverName string,
 ) ([]string, error) {
-	options := []string{
-		"--user", "postgres",
-		"--name", backupName,
-	}
-
-	options, err := b.GetDataConfiguration(options)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(b.configuration.Tags) > 0 {
-		tags, err := utils.MapToBarmanTagsFormat("--tags", b.configuration.Tags)
-		if err != nil {
-			return nil, err
-		}
-		options = append(options, tags...)
-	}
-
-	if len(b.configuration.EndpointURL) > 0 {
-		options = append(
-			options,
-			"--endpoint-url",
-			b.configuration.EndpointURL)
-	}
-
-	options, err = barmanCommand.AppendCloudProviderOptionsFromConfiguration(ctx, options, b.configuration)
-	if err != nil {
-		return nil, err
-	}
-
-	options = append(
-		options,
-		b.configuration.DestinationPath,
-		serverName)
-
-	return options, nil
+	return barmanCommand.BackupOptions(ctx, b.configuration, backupName, serverName)
 }
 
 // GetExecutedBackupInfo get the status information about the executed backup
@@ -0,0 +1,195 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+// BackupStatus is the status of a backup, as reported by barman
+type BackupStatus string
+
+const (
+	// BackupStatusEmpty means that the backup has been created but not started
+	BackupStatusEmpty BackupStatus = "EMPTY"
+
+	// BackupStatusStarted means that the backup is running, or has been interrupted
+	BackupStatusStarted BackupStatus = "STARTED"
+
+	// BackupStatusFailed means that the backup failed
+	BackupStatusFailed BackupStatus = "FAILED"
+
+	// BackupStatusWaitingForWALs means that the backup is waiting
+	// for the WAL files needed to make it consistent
+	BackupStatusWaitingForWALs BackupStatus = "WAITING_FOR_WALS"
+
+	// BackupStatusDone means that the backup has been completed successfully
+	BackupStatusDone BackupStatus = "DONE"
+)
+
+const (
+	// BackupTypeFull is a backup containing the whole data directory
+	BackupTypeFull = "full"
+
+	// BackupTypeIncremental is a backup based on a previous one
+	BackupTypeIncremental = "incremental"
+)
+
+// KeepTarget is the recovery target of a backup which has been
+// marked to be kept regardless of the retention policies
+type KeepTarget string
+
+const (
+	// KeepTargetFull keeps the backup and the WAL files
+	// needed to recover it to any point in time
+	KeepTargetFull KeepTarget = "full"
+
+	// KeepTargetStandalone keeps the backup and only
+	// the WAL files needed to make it consistent
+	KeepTargetStandalone KeepTarget = "standalone"
+
+	// keepTargetNone is how barman-cloud-backup-keep
+	// reports a backup which is not kept
+	keepTargetNone = "nokeep"
+)
+
+// ErrInvalidKeepTarget is returned when a keep target is not
+// among the ones supported by barman
+var ErrInvalidKeepTarget = errors.New("invalid keep target")
+
+// ParseKeepTarget parses a keep target, as reported by barman-cloud-backup-keep.
+// An empty target is returned for backups which are not kept.
+func ParseKeepTarget(value string) (KeepTarget, error) {
+	switch target := KeepTarget(strings.TrimSpace(value)); target {
+	case "", keepTargetNone:
+		return "", nil
+	case KeepTargetFull, KeepTargetStandalone:
+		return target, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidKeepTarget, value)
+	}
+}
+
+// IsValid checks if the keep target is supported by barman
+func (target KeepTarget) IsValid() bool {
+	return target == KeepTargetFull || target == KeepTargetStandalone
+}
+
+// BarmanTablespace is a tablespace included in a backup
+type BarmanTablespace struct {
+	// The name of the tablespace
+	Name string `json:"name"`
+
+	// The OID of the tablespace
+	OID int64 `json:"oid"`
+
+	// The location of the tablespace
+	Location string `json:"location"`
+}
+
+// UnmarshalJSON parses a tablespace, which barman
+// serializes as a [name, oid, location] array
+func (tablespace *BarmanTablespace) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		type plainTablespace BarmanTablespace
+		return json.Unmarshal(data, (*plainTablespace)(tablespace))
+	}
+
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid tablespace %s", string(data))
+	}
+
+	if err := json.Unmarshal(fields[0], &tablespace.Name); err != nil {
+		return fmt.Errorf("invalid tablespace name: %w", err)
+	}
+	if err := json.Unmarshal(fields[1], &tablespace.OID); err != nil {
+		return fmt.Errorf("invalid tablespace OID: %w", err)
+	}
+	if err := json.Unmarshal(fields[2], &tablespace.Location); err != nil {
+		return fmt.Errorf("invalid tablespace location: %w", err)
+	}
+
+	return nil
+}
+
+// IsDone checks if the backup has been completed successfully. When the
+// status is not known, as for backups built by hand, the backup is
+// considered completed when both its begin and end times are set.
+func (b *BarmanBackup) IsDone() bool {
+	if b.Status != "" {
+		return b.Status == BackupStatusDone && !b.BeginTime.IsZero() && !b.EndTime.IsZero()
+	}
+
+	return !b.BeginTime.IsZero() && !b.EndTime.IsZero()
+}
+
+// IsKept checks if the backup has been marked to be kept
+// regardless of the retention policies
+func (b *BarmanBackup) IsKept() bool {
+	return b.Keep != ""
+}
+
+// IsFailed checks if the backup failed
+func (b *BarmanBackup) IsFailed() bool {
+	return b.Status == BackupStatusFailed
+}
+
+// IsIncremental checks if the backup is based on a previous one
+func (b *BarmanBackup) IsIncremental() bool {
+	return b.GetBackupType() == BackupTypeIncremental
+}
+
+// GetBackupType gets the backup type, inferring it from the parent
+// backup when barman doesn't report it
+func (b *BarmanBackup) GetBackupType() string {
+	if b.BackupType != "" {
+		return b.BackupType
+	}
+	if b.ParentBackupID != "" {
+		return BackupTypeIncremental
+	}
+	return BackupTypeFull
+}
+
+// GetWALSegmentSize gets the size of the WAL segments of the backup,
+// which is the PostgreSQL default when barman doesn't report it
+func (b *BarmanBackup) GetWALSegmentSize() uint64 {
+	if b.WALSegmentSize > 0 {
+		return uint64(b.WALSegmentSize)
+	}
+	return wal.DefaultSegmentSize
+}
+
+// PostgresMajorVersion gets the major version of PostgreSQL, such
+// as 17, or zero if it's not known. Versions preceding PostgreSQL 10
+// are reported as 9.
+func (b *BarmanBackup) PostgresMajorVersion() int {
+	return b.Version / 10000
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package catalog
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func backupByID(catalog *Catalog, backupID string) *BarmanBackup {
+	for idx := range catalog.List {
+		if catalog.List[idx].ID == backupID {
+			return &catalog.List[idx]
+		}
+	}
+	return nil
+}
+
+var _ = Describe("barman backup metadata", func() {
+	const barmanCloudListOutput = `{
+  "backups_list": [
+    {
+      "backup_id": "20240101T000000",
+      "begin_time_iso": "2024-01-01T00:00:00+00:00",
+      "end_time_iso": "2024-01-01T00:10:00+00:00",
+      "status": "DONE",
+      "compression": "gzip",
+      "size": 104857600,
+      "deduplicated_size": 52428800,
+      "version": 170002,
+      "mode": "concurrent",
+      "server_name": "cluster-example",
+      "xlog_segment_size": 16777216,
+      "children_backup_ids": ["20240102T000000"],
+      "tablespaces": [["tbs1", 16387, "/var/lib/postgresql/tablespaces/tbs1"]],
+      "timeline": 1
+    },
+    {
+      "backup_id": "20240102T000000",
+      "begin_time_iso": "2024-01-02T00:00:00+00:00",
+      "end_time_iso": "2024-01-02T00:10:00+00:00",
+      "status": "FAILED",
+      "error": "failure uploading data",
+      "parent_backup_id": "20240101T000000",
+      "timeline": 1
+    },
+    {
+      "backup_id": "20240103T000000",
+      "begin_time_iso": "2024-01-03T00:00:00+00:00",
+      "status": "STARTED",
+      "tablespaces": null,
+      "timeline": 1
+    }
+  ]
+}`
+
+	It("parses the whole metadata", func() {
+		result, err := NewCatalogFromBarmanCloudBackupList(barmanCloudListOutput)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.List).To(HaveLen(3))
+
+		backup := backupByID(result, "20240101T000000")
+		Expect(backup.Status).To(Equal(BackupStatusDone))
+		Expect(backup.Compression).To(Equal("gzip"))
+		Expect(backup.Size).To(Equal(int64(104857600)))
+		Expect(backup.DeduplicatedSize).To(Equal(int64(52428800)))
+		Expect(backup.Version).To(Equal(170002))
+		Expect(backup.PostgresMajorVersion()).To(Equal(17))
+		Expect(backup.Mode).To(Equal("concurrent"))
+		Expect(backup.ServerName).To(Equal("cluster-example"))
+		Expect(backup.WALSegmentSize).To(Equal(int64(16777216)))
+		Expect(backup.ChildrenBackupIDs).To(Equal([]string{"20240102T000000"}))
+		Expect(backup.Tablespaces).To(Equal([]BarmanTablespace{
+			{Name: "tbs1", OID: 16387, Location: "/var/lib/postgresql/tablespaces/tbs1"},
+		}))
+		Expect(backup.GetBackupType()).To(Equal(BackupTypeFull))
+
+		Expect(backupByID(result, "20240102T000000").IsIncremental()).To(BeTrue())
+		Expect(backupByID(result, "20240102T000000").IsFailed()).To(BeTrue())
+	})
+
+	It("uses the status to detect the completed backups", func() {
+		result, err := NewCatalogFromBarmanCloudBackupList(barmanCloudListOutput)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backupByID(result, "20240101T000000").IsDone()).To(BeTrue())
+		Expect(backupByID(result, "20240102T000000").IsDone()).To(BeFalse())
+		Expect(backupByID(result, "20240103T000000").IsDone()).To(BeFalse())
+		Expect(result.LatestBackupInfo().ID).To(Equal("20240101T000000"))
+	})
+
+	It("falls back to the backup times when the status is not known", func() {
+		backup := BarmanBackup{
+			BeginTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndTime:   time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC),
+		}
+		Expect(backup.IsDone()).To(BeTrue())
+
+		backup.EndTime = time.Time{}
+		Expect(backup.IsDone()).To(BeFalse())
+	})
+
+	It("parses the tablespaces in both the barman and the object format", func() {
+		var tablespaces []BarmanTablespace
+		Expect(json.Unmarshal([]byte(`[["tbs1", 16387, "/tbs1"], {"name": "tbs2", "oid": 16388, "location": "/tbs2"}]`),
+			&tablespaces)).To(Succeed())
+		Expect(tablespaces).To(Equal([]BarmanTablespace{
+			{Name: "tbs1", OID: 16387, Location: "/tbs1"},
+			{Name: "tbs2", OID: 16388, Location: "/tbs2"},
+		}))
+
+		Expect(json.Unmarshal([]byte(`[["tbs1", 16387]]`), &tablespaces)).ToNot(Succeed())
+	})
+})
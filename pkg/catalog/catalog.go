@@ -22,6 +22,7 @@ package catalog
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"sort"
@@ -35,6 +36,9 @@ import (
 type Catalog struct {
 	// The list of backups
 	List []BarmanBackup `json:"backups_list"`
+
+	// The known timeline histories, indexed by timeline
+	timelineHistories map[int]*TimelineHistory
 }
 
 // NewCatalogFromBarmanCloudBackupList parses the output of barman-cloud-backup-list
@@ -70,7 +74,7 @@ func (catalog *Catalog) LatestBackupInfo() *BarmanBackup {
 
 	// Skip errored backups and return the latest valid one
 	for i := len(catalog.List) - 1; i >= 0; i-- {
-		if catalog.List[i].isBackupDone() {
+		if catalog.List[i].IsDone() {
 			return &catalog.List[i]
 		}
 	}
@@ -99,6 +103,15 @@ func (catalog *Catalog) GetBackupIDs() []string {
 // FirstRecoverabilityPoint gets the start time of the first backup in
 // the catalog
 func (catalog *Catalog) FirstRecoverabilityPoint() *time.Time {
+	if firstBackup := catalog.firstCompletedBackup(); firstBackup != nil {
+		return &firstBackup.EndTime
+	}
+
+	return nil
+}
+
+// firstCompletedBackup gets the oldest completed backup, if any
+func (catalog *Catalog) firstCompletedBackup() *BarmanBackup {
 	if catalog.Len() == 0 {
 		return nil
 	}
@@ -108,11 +121,11 @@ func (catalog *Catalog) FirstRecoverabilityPoint() *time.Time {
 
 	// Skip errored backups and return the first valid one
 	for i := 0; i < len(catalog.List); i++ {
-		if !catalog.List[i].isBackupDone() {
+		if !catalog.List[i].IsDone() {
 			continue
 		}
 
-		return &catalog.List[i].EndTime
+		return &catalog.List[i]
 	}
 
 	return nil
@@ -135,39 +148,288 @@ type recoveryTargetAdapter interface {
 	GetTargetTLI() string
 }
 
+// recoveryTargetXIDAdapter is optionally implemented by the
+// recovery targets supporting recovery_target_xid
+type recoveryTargetXIDAdapter interface {
+	GetTargetXID() string
+}
+
+// recoveryTargetNameAdapter is optionally implemented by the
+// recovery targets supporting recovery_target_name
+type recoveryTargetNameAdapter interface {
+	GetTargetName() string
+}
+
+// RecoveryPointLocator locates, inside the WAL archive, the recovery
+// targets which cannot be resolved using the backup metadata. The
+// archive is read starting from the start WAL segment, and stopping
+// before the stop one, which is empty to read up to the end of the
+// archive. An error wrapping ErrRecoveryPointNotFound is returned
+// when the target is not in the inspected part of the archive.
+type RecoveryPointLocator interface {
+	// LocateTransaction gets the position of the commit
+	// or abort record of a top-level transaction
+	LocateTransaction(xid uint32, startWAL string, stopWAL string) (types.LSN, error)
+
+	// LocateRestorePoint gets the position of the first
+	// restore point having the passed name
+	LocateRestorePoint(name string, startWAL string, stopWAL string) (types.LSN, error)
+}
+
+// ErrRecoveryPointNotFound is returned by a RecoveryPointLocator when the
+// recovery target is not in the inspected part of the WAL archive
+var ErrRecoveryPointNotFound = errors.New("recovery target not found in the WAL archive")
+
+// ErrRecoveryPointLocatorRequired is returned when the recovery target
+// can only be resolved by inspecting the WAL archive, and no
+// RecoveryPointLocator has been passed
+var ErrRecoveryPointLocatorRequired = errors.New(
+	"the recovery target requires the WAL archive to be inspected")
+
 // FindBackupInfo finds the backup info that should be used to file
 // a PITR request via target parameters specified within `RecoveryTarget`
 func (catalog *Catalog) FindBackupInfo(
 	recoveryTarget recoveryTargetAdapter,
 ) (*BarmanBackup, error) {
+	return catalog.FindBackupInfoWithLocator(recoveryTarget, nil)
+}
+
+// FindBackupInfoWithLocator finds the backup info that should be used
+// to file a PITR request, like FindBackupInfo does. Transaction IDs and
+// restore point names, set when the recovery target implements the
+// GetTargetXID and GetTargetName methods, are resolved to a position in
+// the WAL archive using the passed locator.
+//
+// When the history of the target timeline is known, the backups taken on
+// its ancestors before the timeline switch are considered too, and an
+// error wrapping ErrTimelineDiverged is returned when every backup is
+// on a diverged timeline.
+func (catalog *Catalog) FindBackupInfoWithLocator(
+	recoveryTarget recoveryTargetAdapter,
+	locator RecoveryPointLocator,
+) (*BarmanBackup, error) {
+	selection, err := catalog.selectBackup(recoveryTarget, locator)
+	if err != nil {
+		return nil, err
+	}
+
+	return selection.backup, nil
+}
+
+// backupSelection is the result of the search of the
+// backup to be used to reach a recovery target
+type backupSelection struct {
+	// The chosen backup, nil if no backup can be used
+	backup *BarmanBackup
+
+	// Why the backup has been chosen
+	reason string
+
+	// The position of the recovery target, when known
+	targetLSN types.LSN
+}
+
+// selectBackup finds the backup to be used to reach the recovery
+// target, returning an error wrapping ErrTimelineDiverged when
+// every backup is on a diverged timeline
+func (catalog *Catalog) selectBackup(
+	recoveryTarget recoveryTargetAdapter,
+	locator RecoveryPointLocator,
+) (*backupSelection, error) {
+	// Set the timeline
+	targetTLI := recoveryTarget.GetTargetTLI()
+
 	// Check that BackupID is not empty. In such case, always use the
 	// backup ID provided by the user.
-	if recoveryTarget.GetBackupID() != "" {
-		return catalog.findBackupFromID(recoveryTarget.GetBackupID())
+	if backupID := recoveryTarget.GetBackupID(); backupID != "" {
+		backup, err := catalog.findBackupFromID(backupID, targetTLI)
+		if err != nil {
+			return nil, err
+		}
+		return &backupSelection{
+			backup:    backup,
+			reason:    fmt.Sprintf("backup %s has been explicitly requested", backupID),
+			targetLSN: types.LSN(recoveryTarget.GetTargetLSN()),
+		}, nil
 	}
 
 	// The user has not specified any backup ID. As a result we need
 	// to automatically detect the backup from which to start the
 	// recovery process.
 
-	// Set the timeline
-	targetTLI := recoveryTarget.GetTargetTLI()
-
 	// Sort the catalog, as that's what the code below expects
 	sort.Sort(catalog)
 
+	selection, err := catalog.findBackupFromTarget(recoveryTarget, targetTLI, locator)
+	if err != nil {
+		return nil, err
+	}
+	if selection.backup == nil {
+		if err := catalog.checkDivergedTimelines(targetTLI); err != nil {
+			return nil, err
+		}
+	}
+
+	return selection, nil
+}
+
+func (catalog *Catalog) findBackupFromTarget(
+	recoveryTarget recoveryTargetAdapter,
+	targetTLI string,
+	locator RecoveryPointLocator,
+) (*backupSelection, error) {
 	// The first step is to check any time based research
 	if t := recoveryTarget.GetTargetTime(); t != "" {
-		return catalog.findClosestBackupFromTargetTime(t, targetTLI)
+		backup, err := catalog.findClosestBackupFromTargetTime(t, targetTLI)
+		return &backupSelection{
+			backup: backup,
+			reason: fmt.Sprintf("latest backup ended before the target time %s", t),
+		}, err
 	}
 
 	// The second step is to check any LSN based research
 	if t := recoveryTarget.GetTargetLSN(); t != "" {
-		return catalog.findClosestBackupFromTargetLSN(t, targetTLI)
+		backup, err := catalog.findClosestBackupFromTargetLSN(t, targetTLI)
+		return &backupSelection{
+			backup:    backup,
+			reason:    fmt.Sprintf("latest backup ended before the target LSN %s", t),
+			targetLSN: types.LSN(t),
+		}, err
+	}
+
+	// The third step is to check the targets requiring
+	// the WAL archive to be inspected
+	if xidTarget, ok := recoveryTarget.(recoveryTargetXIDAdapter); ok {
+		if t := xidTarget.GetTargetXID(); t != "" {
+			targetLSN, err := catalog.locateTargetXID(t, targetTLI, locator)
+			if err != nil || targetLSN == "" {
+				return &backupSelection{}, err
+			}
+			backup, err := catalog.findClosestBackupFromTargetLSN(string(targetLSN), targetTLI)
+			return &backupSelection{
+				backup: backup,
+				reason: fmt.Sprintf(
+					"latest backup ended before the end of transaction %s, found at LSN %s", t, targetLSN),
+				targetLSN: targetLSN,
+			}, err
+		}
+	}
+
+	if nameTarget, ok := recoveryTarget.(recoveryTargetNameAdapter); ok {
+		if t := nameTarget.GetTargetName(); t != "" {
+			targetLSN, err := catalog.locateTargetName(t, targetTLI, locator)
+			if err != nil || targetLSN == "" {
+				return &backupSelection{}, err
+			}
+			backup, err := catalog.findClosestBackupFromTargetLSN(string(targetLSN), targetTLI)
+			return &backupSelection{
+				backup: backup,
+				reason: fmt.Sprintf(
+					"latest backup ended before the restore point %q, found at LSN %s", t, targetLSN),
+				targetLSN: targetLSN,
+			}, err
+		}
 	}
 
 	// The fallback is to use the latest available backup in chronological order
-	return catalog.findLatestBackupFromTimeline(targetTLI), nil
+	return &backupSelection{
+		backup: catalog.findLatestBackupFromTimeline(targetTLI),
+		reason: "latest backup available for the target timeline",
+	}, nil
+}
+
+// locateTargetXID gets the position of the end of the target transaction.
+// An empty position is returned when there are no backups to start the
+// WAL archive inspection from.
+func (catalog *Catalog) locateTargetXID(
+	targetXIDString string,
+	targetTLI string,
+	locator RecoveryPointLocator,
+) (types.LSN, error) {
+	// PostgreSQL accepts a transaction ID including the epoch,
+	// but only uses its lower 32 bits
+	targetXID, err := strconv.ParseUint(targetXIDString, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("while parsing recovery target targetXID: %s", err.Error())
+	}
+
+	if locator == nil {
+		return "", ErrRecoveryPointLocatorRequired
+	}
+
+	return catalog.locateInWALArchive(targetTLI, func(startWAL, stopWAL string) (types.LSN, error) {
+		return locator.LocateTransaction(uint32(targetXID&0xFFFFFFFF), startWAL, stopWAL) // #nosec G115
+	})
+}
+
+// locateTargetName gets the position of the target restore point.
+// An empty position is returned when there are no backups to start the
+// WAL archive inspection from.
+func (catalog *Catalog) locateTargetName(
+	targetName string,
+	targetTLI string,
+	locator RecoveryPointLocator,
+) (types.LSN, error) {
+	if locator == nil {
+		return "", ErrRecoveryPointLocatorRequired
+	}
+
+	return catalog.locateInWALArchive(targetTLI, func(startWAL, stopWAL string) (types.LSN, error) {
+		return locator.LocateRestorePoint(targetName, startWAL, stopWAL)
+	})
+}
+
+// walInspectionRange is a part of the WAL archive to be inspected,
+// from the start segment to the one preceding the stop segment
+type walInspectionRange struct {
+	startWAL string
+	stopWAL  string
+}
+
+// locateInWALArchive inspects the parts of the WAL archive following the
+// completed backups of the timeline, from the newest one, until the locate
+// function finds the recovery target. This way only the WAL files written
+// after the newest backup preceding the target are read. An empty position
+// is returned when there are no backups to start the inspection from.
+func (catalog *Catalog) locateInWALArchive(
+	targetTLI string,
+	locate func(startWAL, stopWAL string) (types.LSN, error),
+) (types.LSN, error) {
+	ranges := catalog.walInspectionRanges(targetTLI)
+
+	var err error
+	for idx := len(ranges) - 1; idx >= 0; idx-- {
+		var lsn types.LSN
+		lsn, err = locate(ranges[idx].startWAL, ranges[idx].stopWAL)
+		if !errors.Is(err, ErrRecoveryPointNotFound) {
+			return lsn, err
+		}
+	}
+
+	return "", err
+}
+
+// walInspectionRanges splits the WAL archive in the parts beginning where a
+// completed backup of the timeline began, and stopping where the following
+// one began. The last part reaches the end of the archive.
+func (catalog *Catalog) walInspectionRanges(targetTLI string) []walInspectionRange {
+	var ranges []walInspectionRange
+	for idx := range catalog.List {
+		barmanBackup := &catalog.List[idx]
+		if !barmanBackup.IsDone() || barmanBackup.BeginWal == "" || !catalog.matchesTimeline(barmanBackup, targetTLI) {
+			continue
+		}
+
+		if len(ranges) > 0 {
+			if ranges[len(ranges)-1].startWAL == barmanBackup.BeginWal {
+				continue
+			}
+			ranges[len(ranges)-1].stopWAL = barmanBackup.BeginWal
+		}
+		ranges = append(ranges, walInspectionRange{startWAL: barmanBackup.BeginWal})
+	}
+
+	return ranges
 }
 
 func (catalog *Catalog) findClosestBackupFromTargetLSN(
@@ -180,12 +442,10 @@ func (catalog *Catalog) findClosestBackupFromTargetLSN(
 	}
 	for i := len(catalog.List) - 1; i >= 0; i-- {
 		barmanBackup := catalog.List[i]
-		if !barmanBackup.isBackupDone() {
+		if !barmanBackup.IsDone() {
 			continue
 		}
-		if (strconv.Itoa(barmanBackup.TimeLine) == targetTLI ||
-			// if targetTLI is not an integer, it will be ignored actually
-			currentTLIRegex.MatchString(targetTLI)) &&
+		if catalog.matchesTimeline(&barmanBackup, targetTLI) &&
 			types.LSN(barmanBackup.EndLSN).Less(targetLSN) {
 			return &catalog.List[i], nil
 		}
@@ -203,12 +463,10 @@ func (catalog *Catalog) findClosestBackupFromTargetTime(
 	}
 	for i := len(catalog.List) - 1; i >= 0; i-- {
 		barmanBackup := catalog.List[i]
-		if !barmanBackup.isBackupDone() {
+		if !barmanBackup.IsDone() {
 			continue
 		}
-		if (strconv.Itoa(barmanBackup.TimeLine) == targetTLI ||
-			// if targetTLI is not an integer, it will be ignored actually
-			currentTLIRegex.MatchString(targetTLI)) &&
+		if catalog.matchesTimeline(&barmanBackup, targetTLI) &&
 			!barmanBackup.EndTime.After(targetTime) {
 			return &catalog.List[i], nil
 		}
@@ -219,12 +477,10 @@ func (catalog *Catalog) findClosestBackupFromTargetTime(
 func (catalog *Catalog) findLatestBackupFromTimeline(targetTLI string) *BarmanBackup {
 	for i := len(catalog.List) - 1; i >= 0; i-- {
 		barmanBackup := catalog.List[i]
-		if !barmanBackup.isBackupDone() {
+		if !barmanBackup.IsDone() {
 			continue
 		}
-		if strconv.Itoa(barmanBackup.TimeLine) == targetTLI ||
-			// if targetTLI is not an integer, it will be ignored actually
-			currentTLIRegex.MatchString(targetTLI) {
+		if catalog.matchesTimeline(&barmanBackup, targetTLI) {
 			return &catalog.List[i]
 		}
 	}
@@ -232,17 +488,24 @@ func (catalog *Catalog) findLatestBackupFromTimeline(targetTLI string) *BarmanBa
 	return nil
 }
 
-func (catalog *Catalog) findBackupFromID(backupID string) (*BarmanBackup, error) {
+func (catalog *Catalog) findBackupFromID(backupID string, targetTLI string) (*BarmanBackup, error) {
 	if backupID == "" {
 		return nil, fmt.Errorf("no backupID provided")
 	}
 	for _, barmanBackup := range catalog.List {
-		if !barmanBackup.isBackupDone() {
+		if !barmanBackup.IsDone() {
+			continue
+		}
+		if barmanBackup.ID != backupID {
 			continue
 		}
-		if barmanBackup.ID == backupID {
-			return &barmanBackup, nil
+		if history, ok := catalog.targetTimelineHistory(targetTLI); ok && !history.reaches(&barmanBackup) {
+			return nil, fmt.Errorf(
+				"%w: backup %s is on timeline %d, which is not an ancestor of timeline %d "+
+					"or has been left before the end of the backup",
+				ErrTimelineDiverged, backupID, barmanBackup.TimeLine, history.TimeLine)
 		}
+		return &barmanBackup, nil
 	}
 	return nil, fmt.Errorf("no backup found with ID %s", backupID)
 }
@@ -298,6 +561,47 @@ type BarmanBackup struct {
 
 	// The TimeLine
 	TimeLine int `json:"timeline"`
+
+	// The status of the backup
+	Status BackupStatus `json:"status,omitempty"`
+
+	// The backup type, such as full or incremental. Populated
+	// only by the barman versions supporting incremental backups.
+	BackupType string `json:"backup_type,omitempty"`
+
+	// The ID of the backup this incremental backup is based on
+	ParentBackupID string `json:"parent_backup_id,omitempty"`
+
+	// The IDs of the incremental backups based on this one
+	ChildrenBackupIDs []string `json:"children_backup_ids,omitempty"`
+
+	// The backup mode, as reported by barman
+	Mode string `json:"mode,omitempty"`
+
+	// The compression algorithm used for the backup files
+	Compression string `json:"compression,omitempty"`
+
+	// The size of the backup in bytes
+	Size int64 `json:"size,omitempty"`
+
+	// The size of the backup in bytes, without the deduplicated files
+	DeduplicatedSize int64 `json:"deduplicated_size,omitempty"`
+
+	// The PostgreSQL version, in the server_version_num format
+	Version int `json:"version,omitempty"`
+
+	// The tablespaces included in the backup
+	Tablespaces []BarmanTablespace `json:"tablespaces,omitempty"`
+
+	// The name of the server in the object store
+	ServerName string `json:"server_name,omitempty"`
+
+	// The size of the WAL segments of the cluster
+	WALSegmentSize int64 `json:"xlog_segment_size,omitempty"`
+
+	// The keep annotation of the backup, preventing
+	// it from being removed by the retention policies
+	Keep KeepTarget `json:"keep,omitempty"`
 }
 
 type barmanBackupShow struct {
@@ -369,10 +673,6 @@ func parseTimeWithFallbackLayout(value string, primaryLayout string, fallbackLay
 	return result, err
 }
 
-func (b *BarmanBackup) isBackupDone() bool {
-	return !b.BeginTime.IsZero() && !b.EndTime.IsZero()
-}
-
 // NewCatalog creates a new sorted backup catalog, given a list of backup infos
 // belonging to the same server.
 func NewCatalog(list []BarmanBackup) *Catalog {
@@ -20,8 +20,11 @@ SPDX-License-Identifier: Apache-2.0
 package catalog
 
 import (
+	"strconv"
 	"time"
 
+	"github.com/cloudnative-pg/machinery/pkg/types"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -628,3 +631,170 @@ var _ = Describe("barman-cloud-backup-show parsing with RFC3339 times in the wro
 		)))
 	})
 })
+
+type testRecoveryTarget struct {
+	backupID   string
+	targetTime string
+	targetLSN  string
+	targetTLI  string
+	targetXID  string
+	targetName string
+}
+
+func (target *testRecoveryTarget) GetBackupID() string   { return target.backupID }
+func (target *testRecoveryTarget) GetTargetTime() string { return target.targetTime }
+func (target *testRecoveryTarget) GetTargetLSN() string  { return target.targetLSN }
+func (target *testRecoveryTarget) GetTargetTLI() string  { return target.targetTLI }
+func (target *testRecoveryTarget) GetTargetXID() string  { return target.targetXID }
+func (target *testRecoveryTarget) GetTargetName() string { return target.targetName }
+
+type testRecoveryPointLocator struct {
+	transactions  map[uint32]types.LSN
+	restorePoints map[string]types.LSN
+
+	// The start segments of the inspected parts of the WAL archive
+	startWALs []string
+}
+
+// locate finds a recovery target whose position is inside
+// the inspected part of the WAL archive
+func (locator *testRecoveryPointLocator) locate(
+	lsn types.LSN,
+	found bool,
+	startWAL string,
+	stopWAL string,
+) (types.LSN, error) {
+	locator.startWALs = append(locator.startWALs, startWAL)
+	if !found {
+		return "", ErrRecoveryPointNotFound
+	}
+
+	position, err := lsn.Parse()
+	Expect(err).ToNot(HaveOccurred())
+	start, err := types.LSNStartFromWALName(startWAL, 16*1024*1024)
+	Expect(err).ToNot(HaveOccurred())
+	startPosition, err := start.Parse()
+	Expect(err).ToNot(HaveOccurred())
+	if position < startPosition {
+		return "", ErrRecoveryPointNotFound
+	}
+	if stopWAL != "" {
+		stop, err := types.LSNStartFromWALName(stopWAL, 16*1024*1024)
+		Expect(err).ToNot(HaveOccurred())
+		stopPosition, err := stop.Parse()
+		Expect(err).ToNot(HaveOccurred())
+		if position >= stopPosition {
+			return "", ErrRecoveryPointNotFound
+		}
+	}
+
+	return lsn, nil
+}
+
+func (locator *testRecoveryPointLocator) LocateTransaction(
+	xid uint32,
+	startWAL string,
+	stopWAL string,
+) (types.LSN, error) {
+	lsn, ok := locator.transactions[xid]
+	return locator.locate(lsn, ok, startWAL, stopWAL)
+}
+
+func (locator *testRecoveryPointLocator) LocateRestorePoint(
+	name string,
+	startWAL string,
+	stopWAL string,
+) (types.LSN, error) {
+	lsn, ok := locator.restorePoints[name]
+	return locator.locate(lsn, ok, startWAL, stopWAL)
+}
+
+var _ = Describe("Recovery target resolution using the WAL archive", func() {
+	var (
+		catalog *Catalog
+		locator *testRecoveryPointLocator
+	)
+
+	BeforeEach(func() {
+		catalog = NewCatalog([]BarmanBackup{
+			{
+				ID:        "202101011200",
+				BeginTime: time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC),
+				EndTime:   time.Date(2021, 1, 1, 12, 30, 0, 0, time.UTC),
+				BeginWal:  "000000010000000000000002",
+				BeginLSN:  "0/2000028",
+				EndLSN:    "0/2000100",
+				TimeLine:  1,
+			},
+			{
+				ID:        "202101021200",
+				BeginTime: time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC),
+				EndTime:   time.Date(2021, 1, 2, 12, 30, 0, 0, time.UTC),
+				BeginWal:  "000000010000000000000005",
+				BeginLSN:  "0/5000028",
+				EndLSN:    "0/5000100",
+				TimeLine:  1,
+			},
+		})
+		locator = &testRecoveryPointLocator{
+			transactions:  map[uint32]types.LSN{700: "0/3000200", 900: "0/6000000"},
+			restorePoints: map[string]types.LSN{"before_upgrade": "0/5000050"},
+		}
+	})
+
+	It("finds the backup preceding the commit of a transaction", func() {
+		backup, err := catalog.FindBackupInfoWithLocator(&testRecoveryTarget{targetXID: "700"}, locator)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("202101011200"))
+		Expect(locator.startWALs).To(Equal([]string{"000000010000000000000005", "000000010000000000000002"}))
+
+		locator.startWALs = nil
+		backup, err = catalog.FindBackupInfoWithLocator(&testRecoveryTarget{targetXID: "900"}, locator)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("202101021200"))
+		Expect(locator.startWALs).To(Equal([]string{"000000010000000000000005"}))
+	})
+
+	It("ignores the epoch of the transaction ID", func() {
+		backup, err := catalog.FindBackupInfoWithLocator(
+			&testRecoveryTarget{targetXID: strconv.FormatUint(3<<32+700, 10)}, locator)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("202101011200"))
+	})
+
+	It("finds the backup preceding a restore point", func() {
+		backup, err := catalog.FindBackupInfoWithLocator(&testRecoveryTarget{targetName: "before_upgrade"}, locator)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("202101011200"))
+	})
+
+	It("reports the targets not found in the WAL archive", func() {
+		_, err := catalog.FindBackupInfoWithLocator(&testRecoveryTarget{targetName: "missing"}, locator)
+		Expect(err).To(MatchError(ErrRecoveryPointNotFound))
+		Expect(locator.startWALs).To(Equal([]string{"000000010000000000000005", "000000010000000000000002"}))
+	})
+
+	It("requires a locator", func() {
+		_, err := catalog.FindBackupInfo(&testRecoveryTarget{targetXID: "700"})
+		Expect(err).To(MatchError(ErrRecoveryPointLocatorRequired))
+	})
+
+	It("rejects invalid transaction IDs", func() {
+		_, err := catalog.FindBackupInfoWithLocator(&testRecoveryTarget{targetXID: "abc"}, locator)
+		Expect(err).To(MatchError(ContainSubstring("targetXID")))
+	})
+
+	It("returns no backup when the timeline has no backups", func() {
+		backup, err := catalog.FindBackupInfoWithLocator(
+			&testRecoveryTarget{targetXID: "700", targetTLI: "2"}, locator)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup).To(BeNil())
+	})
+
+	It("gives precedence to the time and LSN targets", func() {
+		backup, err := catalog.FindBackupInfoWithLocator(
+			&testRecoveryTarget{targetLSN: "0/6000000", targetXID: "700"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("202101021200"))
+	})
+})
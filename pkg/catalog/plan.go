@@ -0,0 +1,240 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/cloudnative-pg/machinery/pkg/types"
+)
+
+// RecoveryPlan describes what a point in time recovery will do, so
+// that it can be reviewed before starting it
+type RecoveryPlan struct {
+	// The backup to be restored, nil when no backup can
+	// be used to reach the recovery target
+	Backup *BarmanBackup `json:"backup,omitempty"`
+
+	// Why the backup has been chosen
+	Reason string `json:"reason"`
+
+	// The first WAL file required by the recovery
+	FirstWAL string `json:"firstWal,omitempty"`
+
+	// The last WAL file required by the recovery. When the position of
+	// the target is not known, as for time based targets, this is the
+	// last WAL file needed to reach a consistent state, and the recovery
+	// will read the following ones until the target is reached.
+	LastWAL string `json:"lastWal,omitempty"`
+
+	// True when LastWAL is the WAL file containing the recovery target
+	LastWALContainsTarget bool `json:"lastWalContainsTarget"`
+
+	// The timelines the required WAL files belong to, in order
+	Timelines []int `json:"timelines,omitempty"`
+
+	// The estimated amount of bytes to be downloaded, made of the
+	// size of the backup and of the WAL files up to LastWAL
+	EstimatedBytes int64 `json:"estimatedBytes"`
+
+	// True when EstimatedBytes is a lower bound, as LastWAL doesn't
+	// contain the recovery target and the recovery will download the
+	// following WAL files too, up to the target or to the last archived one
+	EstimateIsLowerBound bool `json:"estimateIsLowerBound"`
+
+	// The issues detected while planning the recovery
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PlanRecovery describes the recovery needed to reach the passed target,
+// choosing the backup like FindBackupInfoWithLocator does
+func (catalog *Catalog) PlanRecovery(
+	recoveryTarget recoveryTargetAdapter,
+	locator RecoveryPointLocator,
+) (*RecoveryPlan, error) {
+	selection, err := catalog.selectBackup(recoveryTarget, locator)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := selection.backup
+	if backup == nil {
+		plan := &RecoveryPlan{Reason: "no backup can be used to reach the recovery target"}
+		if warning := catalog.firstRecoverabilityPointWarning(recoveryTarget, selection.targetLSN); warning != "" {
+			plan.Warnings = append(plan.Warnings, warning)
+		} else if catalog.firstCompletedBackup() == nil {
+			plan.Warnings = append(plan.Warnings, "the catalog doesn't contain any completed backup")
+		}
+		return plan, nil
+	}
+
+	plan := &RecoveryPlan{
+		Backup:   backup,
+		Reason:   selection.reason,
+		FirstWAL: backup.BeginWal,
+		LastWAL:  backup.EndWal,
+	}
+
+	segmentSize := backup.GetWALSegmentSize()
+	targetTLI := recoveryTarget.GetTargetTLI()
+	plan.Timelines = catalog.timelinePath(backup, targetTLI, selection.targetLSN)
+
+	if selection.targetLSN != "" {
+		lastTimeline := plan.Timelines[len(plan.Timelines)-1]
+		lastWAL, err := selection.targetLSN.WALFileName(lastTimeline, segmentSize)
+		if err != nil {
+			return nil, fmt.Errorf("while computing the WAL file containing the recovery target: %w", err)
+		}
+		if !selection.targetLSN.Less(types.LSN(backup.EndLSN)) {
+			plan.LastWAL = lastWAL
+			plan.LastWALContainsTarget = true
+		}
+	}
+
+	plan.EstimatedBytes = backup.Size + estimateWALBytes(plan.FirstWAL, plan.LastWAL, segmentSize)
+	plan.EstimateIsLowerBound = !plan.LastWALContainsTarget
+	plan.Warnings = planWarnings(recoveryTarget, selection, plan)
+	if warning := catalog.firstRecoverabilityPointWarning(recoveryTarget, selection.targetLSN); warning != "" {
+		plan.Warnings = append(plan.Warnings, warning)
+	}
+
+	return plan, nil
+}
+
+// firstRecoverabilityPointWarning reports a recovery target preceding the
+// end of the first completed backup, which no backup can reach, returning
+// an empty string when the target doesn't precede it
+func (catalog *Catalog) firstRecoverabilityPointWarning(
+	recoveryTarget recoveryTargetAdapter,
+	targetLSN types.LSN,
+) string {
+	firstBackup := catalog.firstCompletedBackup()
+	if firstBackup == nil {
+		return ""
+	}
+
+	precedes := targetLSN != "" && firstBackup.EndLSN != "" && targetLSN.Less(types.LSN(firstBackup.EndLSN))
+	if t := recoveryTarget.GetTargetTime(); t != "" {
+		targetTime, err := types.ParseTargetTime(nil, t)
+		precedes = precedes || (err == nil && targetTime.Before(firstBackup.EndTime))
+	}
+	if !precedes {
+		return ""
+	}
+
+	return fmt.Sprintf("the recovery target precedes the first recoverability point %s, the end of backup %s",
+		firstBackup.EndTime.Format("2006-01-02 15:04:05Z07:00"), firstBackup.ID)
+}
+
+// timelinePath gets the timelines the recovery will go through, from the
+// one of the backup to the one containing the recovery target, if its
+// position is known, or to the target timeline
+func (catalog *Catalog) timelinePath(backup *BarmanBackup, targetTLI string, targetLSN types.LSN) []int {
+	history, ok := catalog.targetTimelineHistory(targetTLI)
+	if !ok || history.TimeLine == backup.TimeLine {
+		return []int{backup.TimeLine}
+	}
+
+	target, err := targetLSN.Parse()
+	hasTarget := targetLSN != "" && err == nil
+
+	var timelines []int
+	for _, entry := range history.Entries {
+		if entry.TimeLine < backup.TimeLine {
+			continue
+		}
+		timelines = append(timelines, entry.TimeLine)
+
+		// The target is before the switch to the following timeline
+		switchPoint, err := entry.SwitchPoint.Parse()
+		if hasTarget && err == nil && target < switchPoint {
+			return timelines
+		}
+	}
+
+	return append(timelines, history.TimeLine)
+}
+
+// planWarnings detects the issues of a recovery plan
+func planWarnings(
+	recoveryTarget recoveryTargetAdapter,
+	selection *backupSelection,
+	plan *RecoveryPlan,
+) []string {
+	backup := selection.backup
+	var warnings []string
+
+	if backup.Error != "" {
+		warnings = append(warnings, fmt.Sprintf("backup %s reported an error: %s", backup.ID, backup.Error))
+	}
+
+	if selection.targetLSN != "" && backup.EndLSN != "" && selection.targetLSN.Less(types.LSN(backup.EndLSN)) {
+		warnings = append(warnings, fmt.Sprintf(
+			"the recovery target LSN %s precedes the end of backup %s at %s, "+
+				"the recovery can't reach a consistent state",
+			selection.targetLSN, backup.ID, backup.EndLSN))
+	}
+
+	if t := recoveryTarget.GetTargetTime(); t != "" && recoveryTarget.GetBackupID() != "" {
+		targetTime, err := types.ParseTargetTime(nil, t)
+		if err == nil && targetTime.Before(backup.EndTime) {
+			warnings = append(warnings, fmt.Sprintf(
+				"the recovery target time %s precedes the end of backup %s, "+
+					"the recovery can't reach a consistent state",
+				t, backup.ID))
+		}
+	}
+
+	if len(plan.Timelines) > 1 {
+		warnings = append(warnings, fmt.Sprintf(
+			"backup %s is on timeline %d, the recovery will follow timelines %v",
+			backup.ID, backup.TimeLine, plan.Timelines))
+	}
+
+	return warnings
+}
+
+// estimateWALBytes estimates the size of the WAL files in the passed
+// range, which can span multiple timelines
+func estimateWALBytes(firstWAL, lastWAL string, segmentSize uint64) int64 {
+	if firstWAL == "" || lastWAL == "" {
+		return 0
+	}
+
+	first, err := types.LSNStartFromWALName(firstWAL, segmentSize)
+	if err != nil {
+		return 0
+	}
+	last, err := types.LSNStartFromWALName(lastWAL, segmentSize)
+	if err != nil {
+		return 0
+	}
+
+	firstPosition, err := first.Parse()
+	if err != nil {
+		return 0
+	}
+	lastPosition, err := last.Parse()
+	if err != nil || lastPosition < firstPosition {
+		return 0
+	}
+
+	return int64(lastPosition - firstPosition + segmentSize) // #nosec G115
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package catalog
+
+import (
+	"time"
+
+	"github.com/cloudnative-pg/machinery/pkg/types"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Recovery plan", func() {
+	var catalog *Catalog
+
+	BeforeEach(func() {
+		catalog = NewCatalog([]BarmanBackup{
+			{
+				ID:        "first",
+				BeginTime: time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC),
+				EndTime:   time.Date(2021, 1, 1, 12, 30, 0, 0, time.UTC),
+				BeginWal:  "000000010000000000000002",
+				EndWal:    "000000010000000000000003",
+				BeginLSN:  "0/2000028",
+				EndLSN:    "0/3000100",
+				Size:      1000,
+				TimeLine:  1,
+			},
+			{
+				ID:        "second",
+				BeginTime: time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC),
+				EndTime:   time.Date(2021, 1, 2, 12, 30, 0, 0, time.UTC),
+				BeginWal:  "000000020000000000000007",
+				EndWal:    "000000020000000000000007",
+				BeginLSN:  "0/7000028",
+				EndLSN:    "0/7000100",
+				Size:      2000,
+				TimeLine:  2,
+				Error:     "failure uploading the backup_label",
+			},
+		})
+		catalog.SetTimelineHistory(&TimelineHistory{
+			TimeLine: 2,
+			Entries:  []TimelineHistoryEntry{{TimeLine: 1, SwitchPoint: "0/5000000"}},
+		})
+	})
+
+	It("describes a recovery to a target LSN", func() {
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{targetTLI: "2", targetLSN: "0/6000010"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backup.ID).To(Equal("first"))
+		Expect(plan.Reason).To(ContainSubstring("target LSN 0/6000010"))
+		Expect(plan.FirstWAL).To(Equal("000000010000000000000002"))
+		Expect(plan.LastWAL).To(Equal("000000020000000000000006"))
+		Expect(plan.LastWALContainsTarget).To(BeTrue())
+		Expect(plan.Timelines).To(Equal([]int{1, 2}))
+		Expect(plan.EstimatedBytes).To(Equal(int64(1000 + 5*wal.DefaultSegmentSize)))
+		Expect(plan.EstimateIsLowerBound).To(BeFalse())
+		Expect(plan.Warnings).To(ContainElement(ContainSubstring("will follow timelines [1 2]")))
+	})
+
+	It("stops on the ancestor timeline when the target precedes the switch", func() {
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{targetTLI: "2", targetLSN: "0/4000000"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Timelines).To(Equal([]int{1}))
+		Expect(plan.LastWAL).To(Equal("000000010000000000000004"))
+	})
+
+	It("uses the WAL segment size of the backup", func() {
+		const segmentSize = 64 * 1024 * 1024
+		catalog := NewCatalog([]BarmanBackup{
+			{
+				ID:             "large-segments",
+				BeginTime:      time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC),
+				EndTime:        time.Date(2021, 1, 1, 12, 30, 0, 0, time.UTC),
+				BeginWal:       "000000010000000000000001",
+				EndWal:         "000000010000000000000001",
+				BeginLSN:       "0/4000028",
+				EndLSN:         "0/4000100",
+				Size:           1000,
+				TimeLine:       1,
+				WALSegmentSize: segmentSize,
+			},
+		})
+
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{targetTLI: "1", targetLSN: "0/C000010"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.LastWAL).To(Equal("000000010000000000000003"))
+		Expect(plan.EstimatedBytes).To(Equal(int64(1000 + 3*segmentSize)))
+	})
+
+	It("only includes the WALs needed for consistency when the target position is unknown", func() {
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{
+			targetTLI:  "1",
+			targetTime: "2021-01-03 00:00:00+00:00",
+		}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backup.ID).To(Equal("first"))
+		Expect(plan.LastWAL).To(Equal("000000010000000000000003"))
+		Expect(plan.LastWALContainsTarget).To(BeFalse())
+		Expect(plan.EstimatedBytes).To(Equal(int64(1000 + 2*wal.DefaultSegmentSize)))
+		Expect(plan.EstimateIsLowerBound).To(BeTrue())
+		Expect(plan.Warnings).To(BeEmpty())
+	})
+
+	It("reports the errors of the chosen backup", func() {
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{targetTLI: "2"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backup.ID).To(Equal("second"))
+		Expect(plan.Warnings).To(ConsistOf(ContainSubstring("failure uploading the backup_label")))
+	})
+
+	It("reports targets preceding the first recoverability point", func() {
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{targetTime: "2020-01-01 00:00:00+00:00"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backup).To(BeNil())
+		Expect(plan.Warnings).To(ConsistOf(ContainSubstring("precedes the first recoverability point")))
+	})
+
+	It("reports targets preceding the first recoverability point when a backup is requested", func() {
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{
+			backupID:   "second",
+			targetTLI:  "2",
+			targetTime: "2021-01-01 00:00:00+00:00",
+		}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backup.ID).To(Equal("second"))
+		Expect(plan.Warnings).To(ContainElement(
+			ContainSubstring("precedes the first recoverability point 2021-01-01 12:30:00Z, the end of backup first")))
+
+		plan, err = catalog.PlanRecovery(&testRecoveryTarget{backupID: "second", targetTLI: "2", targetLSN: "0/2000000"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Warnings).To(ContainElement(ContainSubstring("precedes the first recoverability point")))
+	})
+
+	It("reports targets preceding the end of an explicitly requested backup", func() {
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{
+			backupID:   "first",
+			targetTime: "2021-01-01 12:10:00+00:00",
+		}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Reason).To(ContainSubstring("explicitly requested"))
+		Expect(plan.Warnings).To(ContainElement(ContainSubstring("can't reach a consistent state")))
+
+		plan, err = catalog.PlanRecovery(&testRecoveryTarget{backupID: "first", targetLSN: "0/3000000"}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.LastWAL).To(Equal("000000010000000000000003"))
+		Expect(plan.Warnings).To(ContainElement(ContainSubstring("can't reach a consistent state")))
+	})
+
+	It("resolves the targets using the WAL archive", func() {
+		locator := &testRecoveryPointLocator{
+			restorePoints: map[string]types.LSN{"before_upgrade": "0/4000010"},
+		}
+		plan, err := catalog.PlanRecovery(&testRecoveryTarget{targetTLI: "2", targetName: "before_upgrade"}, locator)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backup.ID).To(Equal("first"))
+		Expect(plan.Reason).To(ContainSubstring(`restore point "before_upgrade"`))
+		Expect(plan.LastWAL).To(Equal("000000010000000000000004"))
+	})
+})
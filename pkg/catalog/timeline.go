@@ -0,0 +1,292 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package catalog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnative-pg/machinery/pkg/types"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+// ErrTimelineHistoryNotFound is returned by a TimelineHistoryFetcher
+// when the history file is not in the WAL archive
+var ErrTimelineHistoryNotFound = errors.New("timeline history not found")
+
+// ErrTimelineDiverged is returned when the backups are on timelines
+// which diverged from the history of the target timeline
+var ErrTimelineDiverged = errors.New("timeline diverged from the target timeline history")
+
+// TimelineHistoryEntry is a line of a timeline history file
+type TimelineHistoryEntry struct {
+	// The parent timeline
+	TimeLine int
+
+	// The position where the parent timeline has been left
+	SwitchPoint types.LSN
+
+	// The reason of the switch, as written by PostgreSQL
+	Reason string
+}
+
+// TimelineHistory is the content of the history file of a timeline,
+// listing the ancestor timelines in order
+type TimelineHistory struct {
+	// The timeline the history refers to
+	TimeLine int
+
+	// The ancestor timelines
+	Entries []TimelineHistoryEntry
+}
+
+// TimelineHistoryFetcher gets the content of the history file of a timeline,
+// returning ErrTimelineHistoryNotFound when it is not in the WAL archive
+type TimelineHistoryFetcher func(tli int) ([]byte, error)
+
+// ParseTimelineHistory parses the content of the history file of a timeline
+func ParseTimelineHistory(tli int, content []byte) (*TimelineHistory, error) {
+	history := &TimelineHistory{TimeLine: tli}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid line %d in the history of timeline %d", lineNumber, tli)
+		}
+
+		parentTLI, err := strconv.Atoi(fields[0])
+		if err != nil || parentTLI <= 0 || parentTLI >= tli {
+			return nil, fmt.Errorf("invalid parent timeline %q in the history of timeline %d", fields[0], tli)
+		}
+		if len(history.Entries) > 0 && parentTLI <= history.Entries[len(history.Entries)-1].TimeLine {
+			return nil, fmt.Errorf("timeline %d is not in order in the history of timeline %d", parentTLI, tli)
+		}
+
+		switchPoint := types.LSN(fields[1])
+		if _, err := switchPoint.Parse(); err != nil {
+			return nil, fmt.Errorf("invalid switch point in the history of timeline %d: %w", tli, err)
+		}
+
+		history.Entries = append(history.Entries, TimelineHistoryEntry{
+			TimeLine:    parentTLI,
+			SwitchPoint: switchPoint,
+			Reason:      strings.Join(fields[2:], " "),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// TimelineHistoryFileName gets the name of the history file of a timeline
+func TimelineHistoryFileName(tli int) string {
+	return wal.HistoryFileName(uint32(tli)) // #nosec G115
+}
+
+// reaches checks if a backup can be used to reach the timeline. This
+// happens when the backup is on the timeline itself or on one of its
+// ancestors and ended before the ancestor was left.
+func (history *TimelineHistory) reaches(backup *BarmanBackup) bool {
+	if backup.TimeLine == history.TimeLine {
+		return true
+	}
+
+	for _, entry := range history.Entries {
+		if entry.TimeLine != backup.TimeLine {
+			continue
+		}
+
+		endLSN, err := types.LSN(backup.EndLSN).Parse()
+		if err != nil {
+			return false
+		}
+		switchPoint, err := entry.SwitchPoint.Parse()
+		if err != nil {
+			return false
+		}
+		return endLSN <= switchPoint
+	}
+
+	return false
+}
+
+// SetTimelineHistory adds the history of a timeline to the catalog. Once
+// the history of the target timeline is known, the backups taken on its
+// ancestors are considered when looking for the backup to be recovered.
+func (catalog *Catalog) SetTimelineHistory(history *TimelineHistory) {
+	if catalog.timelineHistories == nil {
+		catalog.timelineHistories = make(map[int]*TimelineHistory)
+	}
+	catalog.timelineHistories[history.TimeLine] = history
+}
+
+// LoadTimelineHistory reads the history of the target timeline from the
+// WAL archive. When the target timeline is "latest" or empty, the newest
+// timeline is detected by probing the history files following the newest
+// timeline of the backups, as PostgreSQL does.
+func (catalog *Catalog) LoadTimelineHistory(targetTLI string, fetch TimelineHistoryFetcher) error {
+	if !currentTLIRegex.MatchString(targetTLI) {
+		tli, err := strconv.Atoi(targetTLI)
+		if err != nil {
+			return fmt.Errorf("while parsing recovery target targetTLI: %w", err)
+		}
+		return catalog.loadTimelineHistory(tli, fetch)
+	}
+
+	newestTLI := 0
+	for _, backup := range catalog.List {
+		newestTLI = max(newestTLI, backup.TimeLine)
+	}
+	if newestTLI == 0 {
+		return nil
+	}
+
+	for {
+		content, err := fetch(newestTLI + 1)
+		if errors.Is(err, ErrTimelineHistoryNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		history, err := ParseTimelineHistory(newestTLI+1, content)
+		if err != nil {
+			return err
+		}
+		catalog.SetTimelineHistory(history)
+		newestTLI++
+	}
+
+	// The history of the newest timeline may have been already loaded while probing
+	if _, ok := catalog.timelineHistories[newestTLI]; ok {
+		return nil
+	}
+
+	return catalog.loadTimelineHistory(newestTLI, fetch)
+}
+
+// loadTimelineHistory reads the history of a timeline from the WAL
+// archive. A missing history file is not an error, as the catalog
+// falls back to only considering the backups on the target timeline.
+func (catalog *Catalog) loadTimelineHistory(tli int, fetch TimelineHistoryFetcher) error {
+	if tli <= 1 {
+		// The first timeline has no history file
+		return nil
+	}
+
+	content, err := fetch(tli)
+	if errors.Is(err, ErrTimelineHistoryNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("while reading the history of timeline %d: %w", tli, err)
+	}
+
+	history, err := ParseTimelineHistory(tli, content)
+	if err != nil {
+		return err
+	}
+	catalog.SetTimelineHistory(history)
+	return nil
+}
+
+// targetTimelineHistory gets the history of the target timeline, if known.
+// The newest known timeline is used when the target is "latest".
+func (catalog *Catalog) targetTimelineHistory(targetTLI string) (*TimelineHistory, bool) {
+	if len(catalog.timelineHistories) == 0 {
+		return nil, false
+	}
+
+	if currentTLIRegex.MatchString(targetTLI) {
+		newestTLI := slices.Max(slices.Collect(maps.Keys(catalog.timelineHistories)))
+		return catalog.timelineHistories[newestTLI], true
+	}
+
+	tli, err := strconv.Atoi(targetTLI)
+	if err != nil {
+		return nil, false
+	}
+
+	history, ok := catalog.timelineHistories[tli]
+	return history, ok
+}
+
+// matchesTimeline checks if a backup can be used to reach the
+// target timeline. Without the history of the target timeline
+// only the backups on the timeline itself are considered.
+func (catalog *Catalog) matchesTimeline(backup *BarmanBackup, targetTLI string) bool {
+	if history, ok := catalog.targetTimelineHistory(targetTLI); ok {
+		return history.reaches(backup)
+	}
+
+	return strconv.Itoa(backup.TimeLine) == targetTLI ||
+		// if targetTLI is not an integer, it will be ignored actually
+		currentTLIRegex.MatchString(targetTLI)
+}
+
+// checkDivergedTimelines returns a descriptive error when no backup can
+// be used to reach the target timeline because every backup is on
+// a timeline which diverged from its history
+func (catalog *Catalog) checkDivergedTimelines(targetTLI string) error {
+	history, ok := catalog.targetTimelineHistory(targetTLI)
+	if !ok {
+		return nil
+	}
+
+	var divergedTLIs []int
+	for i := range catalog.List {
+		backup := &catalog.List[i]
+		if !backup.IsDone() {
+			continue
+		}
+		if history.reaches(backup) {
+			return nil
+		}
+		if !slices.Contains(divergedTLIs, backup.TimeLine) {
+			divergedTLIs = append(divergedTLIs, backup.TimeLine)
+		}
+	}
+
+	if len(divergedTLIs) == 0 {
+		return nil
+	}
+
+	slices.Sort(divergedTLIs)
+	return fmt.Errorf(
+		"%w: the backups are on timelines %v, which are not ancestors of timeline %d "+
+			"or have been taken after the switch to a different timeline",
+		ErrTimelineDiverged, divergedTLIs, history.TimeLine)
+}
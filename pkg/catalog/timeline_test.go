@@ -0,0 +1,182 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package catalog
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	timeline2History = "1\t0/5000000\tno recovery target specified\n"
+	timeline3History = "1\t0/5000000\tno recovery target specified\n\n" +
+		"2\t0/8000000\tat restore point \"before_upgrade\"\n"
+)
+
+func historyFetcher(histories map[int]string) TimelineHistoryFetcher {
+	return func(tli int) ([]byte, error) {
+		content, ok := histories[tli]
+		if !ok {
+			return nil, ErrTimelineHistoryNotFound
+		}
+		return []byte(content), nil
+	}
+}
+
+var _ = Describe("Timeline history parsing", func() {
+	It("parses the history of a timeline", func() {
+		history, err := ParseTimelineHistory(3, []byte("# comment\n"+timeline3History))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history.TimeLine).To(Equal(3))
+		Expect(history.Entries).To(Equal([]TimelineHistoryEntry{
+			{TimeLine: 1, SwitchPoint: "0/5000000", Reason: "no recovery target specified"},
+			{TimeLine: 2, SwitchPoint: "0/8000000", Reason: "at restore point \"before_upgrade\""},
+		}))
+	})
+
+	It("rejects invalid histories", func() {
+		_, err := ParseTimelineHistory(2, []byte("1\n"))
+		Expect(err).To(HaveOccurred())
+
+		_, err = ParseTimelineHistory(2, []byte("2\t0/5000000\n"))
+		Expect(err).To(HaveOccurred())
+
+		_, err = ParseTimelineHistory(3, []byte("2\t0/5000000\n1\t0/4000000\n"))
+		Expect(err).To(HaveOccurred())
+
+		_, err = ParseTimelineHistory(2, []byte("1\tinvalid\n"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("builds the name of the history files", func() {
+		Expect(TimelineHistoryFileName(10)).To(Equal("0000000A.history"))
+	})
+})
+
+var _ = Describe("Timeline history aware backup selection", func() {
+	var (
+		firstBackup  BarmanBackup
+		lateBackup   BarmanBackup
+		branchBackup BarmanBackup
+	)
+
+	BeforeEach(func() {
+		firstBackup = BarmanBackup{
+			ID:        "first",
+			BeginTime: time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC),
+			EndTime:   time.Date(2021, 1, 1, 12, 30, 0, 0, time.UTC),
+			BeginWal:  "000000010000000000000002",
+			EndLSN:    "0/2000100",
+			TimeLine:  1,
+		}
+		lateBackup = BarmanBackup{
+			ID:        "late",
+			BeginTime: time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC),
+			EndTime:   time.Date(2021, 1, 2, 12, 30, 0, 0, time.UTC),
+			BeginWal:  "000000010000000000000006",
+			EndLSN:    "0/6000100",
+			TimeLine:  1,
+		}
+		branchBackup = BarmanBackup{
+			ID:        "branch",
+			BeginTime: time.Date(2021, 1, 3, 12, 0, 0, 0, time.UTC),
+			EndTime:   time.Date(2021, 1, 3, 12, 30, 0, 0, time.UTC),
+			BeginWal:  "000000020000000000000007",
+			EndLSN:    "0/7000100",
+			TimeLine:  2,
+		}
+	})
+
+	It("only considers the target timeline without its history", func() {
+		catalog := NewCatalog([]BarmanBackup{firstBackup, lateBackup, branchBackup})
+		backup, err := catalog.FindBackupInfo(&testRecoveryTarget{targetTLI: "3"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup).To(BeNil())
+	})
+
+	It("selects the backups on the ancestor timelines", func() {
+		catalog := NewCatalog([]BarmanBackup{firstBackup, lateBackup, branchBackup})
+		Expect(catalog.LoadTimelineHistory("3", historyFetcher(map[int]string{3: timeline3History}))).To(Succeed())
+
+		backup, err := catalog.FindBackupInfo(&testRecoveryTarget{targetTLI: "3"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("branch"))
+
+		// The late backup ended after the first timeline was left
+		backup, err = catalog.FindBackupInfo(&testRecoveryTarget{targetTLI: "3", targetLSN: "0/6500000"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("first"))
+	})
+
+	It("detects the newest timeline when the target is the latest one", func() {
+		catalog := NewCatalog([]BarmanBackup{firstBackup, lateBackup, branchBackup})
+		Expect(catalog.LoadTimelineHistory("latest", historyFetcher(map[int]string{
+			2: timeline2History,
+			3: timeline3History,
+		}))).To(Succeed())
+
+		backup, err := catalog.FindBackupInfo(&testRecoveryTarget{targetTLI: "latest", targetLSN: "0/6500000"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("first"))
+	})
+
+	It("loads the history of the newest timeline of the backups", func() {
+		catalog := NewCatalog([]BarmanBackup{firstBackup, lateBackup, branchBackup})
+		Expect(catalog.LoadTimelineHistory("", historyFetcher(map[int]string{2: timeline2History}))).To(Succeed())
+
+		history, ok := catalog.targetTimelineHistory("")
+		Expect(ok).To(BeTrue())
+		Expect(history.TimeLine).To(Equal(2))
+	})
+
+	It("ignores missing history files", func() {
+		catalog := NewCatalog([]BarmanBackup{firstBackup})
+		Expect(catalog.LoadTimelineHistory("3", historyFetcher(nil))).To(Succeed())
+
+		_, ok := catalog.targetTimelineHistory("3")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("rejects the backups on diverged timelines", func() {
+		catalog := NewCatalog([]BarmanBackup{lateBackup, branchBackup})
+		catalog.SetTimelineHistory(&TimelineHistory{
+			TimeLine: 4,
+			Entries:  []TimelineHistoryEntry{{TimeLine: 1, SwitchPoint: "0/5000000"}},
+		})
+
+		_, err := catalog.FindBackupInfo(&testRecoveryTarget{targetTLI: "4"})
+		Expect(err).To(MatchError(ErrTimelineDiverged))
+		Expect(err).To(MatchError(ContainSubstring("timelines [1 2]")))
+
+		_, err = catalog.FindBackupInfo(&testRecoveryTarget{targetTLI: "4", backupID: "branch"})
+		Expect(err).To(MatchError(ErrTimelineDiverged))
+	})
+
+	It("doesn't report diverged timelines when the target precedes every backup", func() {
+		catalog := NewCatalog([]BarmanBackup{firstBackup, branchBackup})
+		Expect(catalog.LoadTimelineHistory("3", historyFetcher(map[int]string{3: timeline3History}))).To(Succeed())
+
+		backup, err := catalog.FindBackupInfo(&testRecoveryTarget{targetTLI: "3", targetLSN: "0/1000000"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup).To(BeNil())
+	})
+})
@@ -23,10 +23,12 @@ import (
 	"bytes"
 	"context"
 	"os/exec"
+	"time"
 
 	"github.com/cloudnative-pg/machinery/pkg/log"
 
 	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
 	barmanUtils "github.com/cloudnative-pg/barman-cloud/pkg/utils"
 )
 
@@ -41,28 +43,19 @@ func DeleteBackupsByPolicy(
 ) error {
 	contextLogger := log.FromContext(ctx).WithName("barman")
 
-	var options []string
-	if barmanConfiguration.EndpointURL != "" {
-		options = append(options, "--endpoint-url", barmanConfiguration.EndpointURL)
+	if barmanApi.IsFileDestinationPath(barmanConfiguration.DestinationPath) {
+		store, err := filestore.NewFromConfiguration(barmanConfiguration, serverName)
+		if err != nil {
+			return err
+		}
+		return store.DeleteBackupsByPolicy(retentionPolicy, time.Now())
 	}
 
-	options, err := AppendCloudProviderOptionsFromConfiguration(ctx, options, barmanConfiguration)
+	options, err := backupDeleteOptions(ctx, barmanConfiguration, serverName, retentionPolicy)
 	if err != nil {
 		return err
 	}
 
-	parsedPolicy, err := barmanUtils.ParsePolicy(retentionPolicy)
-	if err != nil {
-		return err
-	}
-
-	options = append(
-		options,
-		"--retention-policy",
-		parsedPolicy,
-		barmanConfiguration.DestinationPath,
-		serverName)
-
 	var stdoutBuffer bytes.Buffer
 	var stderrBuffer bytes.Buffer
 	cmd := exec.Command(barmanUtils.BarmanCloudBackupDelete, options...) // #nosec G204
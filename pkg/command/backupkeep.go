@@ -0,0 +1,213 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/cloudnative-pg/machinery/pkg/log"
+
+	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
+	barmanUtils "github.com/cloudnative-pg/barman-cloud/pkg/utils"
+)
+
+// keepStatusPrefix is the prefix of the line in which
+// barman-cloud-backup-keep reports the keep status of a backup
+const keepStatusPrefix = "Keep:"
+
+// KeepBackup marks a backup to be kept regardless of the retention policies,
+// given the Barman object store configuration, the server name, the environment
+// variables, the backup ID and the keep target
+func KeepBackup(
+	ctx context.Context,
+	barmanConfiguration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+	env []string,
+	backupID string,
+	target catalog.KeepTarget,
+) error {
+	if !target.IsValid() {
+		return fmt.Errorf("%w: %q", catalog.ErrInvalidKeepTarget, target)
+	}
+
+	if barmanApi.IsFileDestinationPath(barmanConfiguration.DestinationPath) {
+		store, err := filestore.NewFromConfiguration(barmanConfiguration, serverName)
+		if err != nil {
+			return err
+		}
+		return store.KeepBackup(backupID, target)
+	}
+
+	_, err := executeBackupKeepCommand(
+		ctx, barmanConfiguration, serverName, env, backupID, "--target", string(target))
+	return err
+}
+
+// ReleaseBackup removes the keep annotation of a backup, making it
+// subject to the retention policies again
+func ReleaseBackup(
+	ctx context.Context,
+	barmanConfiguration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+	env []string,
+	backupID string,
+) error {
+	if barmanApi.IsFileDestinationPath(barmanConfiguration.DestinationPath) {
+		store, err := filestore.NewFromConfiguration(barmanConfiguration, serverName)
+		if err != nil {
+			return err
+		}
+		return store.ReleaseBackup(backupID)
+	}
+
+	_, err := executeBackupKeepCommand(ctx, barmanConfiguration, serverName, env, backupID, "--release")
+	return err
+}
+
+// GetKeepStatus gets the keep target of a backup, which is empty
+// when the backup is not kept
+func GetKeepStatus(
+	ctx context.Context,
+	barmanConfiguration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+	env []string,
+	backupID string,
+) (catalog.KeepTarget, error) {
+	if barmanApi.IsFileDestinationPath(barmanConfiguration.DestinationPath) {
+		store, err := filestore.NewFromConfiguration(barmanConfiguration, serverName)
+		if err != nil {
+			return "", err
+		}
+		return store.GetKeepStatus(backupID)
+	}
+
+	output, err := executeBackupKeepCommand(ctx, barmanConfiguration, serverName, env, backupID, "--status")
+	if err != nil {
+		return "", err
+	}
+
+	return parseKeepStatus(output)
+}
+
+// contextKeyReadKeepStatus contains a bool indicating if the keep status
+// of the backups read from an object store should be read too
+const contextKeyReadKeepStatus contextKey = "readKeepStatus"
+
+func readKeepStatus(ctx context.Context) bool {
+	result, _ := ctx.Value(contextKeyReadKeepStatus).(bool)
+	return result
+}
+
+// ContextWithKeepStatus creates a context that contains the contextKeyReadKeepStatus flag.
+// When set to true, GetBackupList and GetBackupByName read the keep status of the completed
+// backups found in an object store, running barman-cloud-backup-keep once per backup.
+// The local filesystem object stores always report it.
+func ContextWithKeepStatus(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, contextKeyReadKeepStatus, enabled)
+}
+
+// setKeepStatus sets the keep target of a backup read from an object store,
+// as the output of barman-cloud-backup-list and barman-cloud-backup-show
+// doesn't contain it. This is only done when requested with ContextWithKeepStatus,
+// and only for the completed backups, as they are the only ones which can be kept.
+// The keep target is left empty when it can't be read.
+func setKeepStatus(
+	ctx context.Context,
+	barmanConfiguration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+	env []string,
+	backup *catalog.BarmanBackup,
+) {
+	if !readKeepStatus(ctx) || backup.Status != catalog.BackupStatusDone {
+		return
+	}
+
+	keep, err := GetKeepStatus(ctx, barmanConfiguration, serverName, env, backup.ID)
+	switch {
+	case errors.Is(err, barmanUtils.ErrBarmanCapabilityNotSupported):
+		return
+	case err != nil:
+		log.FromContext(ctx).Warning("Cannot read the keep status of the backup",
+			"backupID", backup.ID, "error", err)
+		return
+	}
+
+	backup.Keep = keep
+}
+
+// parseKeepStatus parses the output of barman-cloud-backup-keep --status,
+// such as "Keep: full" or "Keep: nokeep"
+func parseKeepStatus(output string) (catalog.KeepTarget, error) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(strings.TrimSpace(scanner.Text()), keepStatusPrefix); found {
+			return catalog.ParseKeepTarget(value)
+		}
+	}
+
+	return "", fmt.Errorf("cannot find the keep status in the output of %s: %q",
+		barmanUtils.BarmanCloudBackupKeep, output)
+}
+
+func executeBackupKeepCommand(
+	ctx context.Context,
+	barmanConfiguration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+	env []string,
+	backupID string,
+	action ...string,
+) (string, error) {
+	contextLogger := log.FromContext(ctx).WithName("barman")
+
+	if err := barmanUtils.CheckInstalledBarmanCapabilities(ctx, barmanUtils.BarmanCapabilityBackupKeep); err != nil {
+		return "", err
+	}
+
+	options, err := backupKeepOptions(ctx, barmanConfiguration, serverName, backupID, action...)
+	if err != nil {
+		return "", err
+	}
+
+	var stdoutBuffer bytes.Buffer
+	var stderrBuffer bytes.Buffer
+	cmd := exec.Command(barmanUtils.BarmanCloudBackupKeep, options...) // #nosec G204
+	cmd.Env = env
+	cmd.Stdout = &stdoutBuffer
+	cmd.Stderr = &stderrBuffer
+	err = cmd.Run()
+	if err != nil {
+		contextLogger.Error(err,
+			"Error invoking "+barmanUtils.BarmanCloudBackupKeep,
+			"options", options,
+			"stdout", stdoutBuffer.String(),
+			"stderr", stderrBuffer.String())
+		return "", err
+	}
+
+	return stdoutBuffer.String(), nil
+}
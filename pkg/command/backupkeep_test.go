@@ -0,0 +1,150 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("parseKeepStatus", func() {
+	DescribeTable("parses the output of barman-cloud-backup-keep",
+		func(output string, expected catalog.KeepTarget) {
+			Expect(parseKeepStatus(output)).To(Equal(expected))
+		},
+		Entry("full target", "Keep: full\n", catalog.KeepTargetFull),
+		Entry("standalone target", "Keep: standalone\n", catalog.KeepTargetStandalone),
+		Entry("not kept", "Keep: nokeep\n", catalog.KeepTarget("")),
+	)
+
+	It("complains about an unexpected output", func() {
+		_, err := parseKeepStatus("Keep: forever\n")
+		Expect(errors.Is(err, catalog.ErrInvalidKeepTarget)).To(BeTrue())
+
+		_, err = parseKeepStatus("")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("backup keep on a local directory", func() {
+	It("keeps and releases a backup", func(ctx SpecContext) {
+		destination := GinkgoT().TempDir()
+		backupDirectory := filepath.Join(destination, "cluster", "base", "20240101T000000")
+		Expect(os.MkdirAll(backupDirectory, 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(backupDirectory, "backup.info"), []byte(
+			"begin_time=2024-01-01 00:00:00+00:00\n"+
+				"begin_wal=000000010000000000000002\n"+
+				"end_time=2024-01-01 00:10:00+00:00\n"+
+				"status=DONE\n"+
+				"timeline=1\n"), 0o600)).To(Succeed())
+
+		configuration := &barmanApi.BarmanObjectStoreConfiguration{DestinationPath: "file://" + destination}
+		Expect(KeepBackup(ctx, configuration, "cluster", nil, "20240101T000000", catalog.KeepTargetFull)).
+			To(Succeed())
+		Expect(GetKeepStatus(ctx, configuration, "cluster", nil, "20240101T000000")).
+			To(Equal(catalog.KeepTargetFull))
+
+		Expect(ReleaseBackup(ctx, configuration, "cluster", nil, "20240101T000000")).To(Succeed())
+		Expect(GetKeepStatus(ctx, configuration, "cluster", nil, "20240101T000000")).To(BeEmpty())
+	})
+
+	It("refuses an invalid keep target", func(ctx SpecContext) {
+		configuration := &barmanApi.BarmanObjectStoreConfiguration{DestinationPath: "s3://bucket"}
+		err := KeepBackup(ctx, configuration, "cluster", nil, "20240101T000000", "forever")
+		Expect(errors.Is(err, catalog.ErrInvalidKeepTarget)).To(BeTrue())
+	})
+})
+
+var _ = Describe("backup keep on an object store", func() {
+	// installScript installs a barman-cloud tool in the PATH
+	installScript := func(binDirectory string, tool string, content string) {
+		// #nosec G306
+		Expect(os.WriteFile(filepath.Join(binDirectory, tool), []byte(content), 0o755)).To(Succeed())
+	}
+
+	var binDirectory string
+
+	BeforeEach(func() {
+		binDirectory = GinkgoT().TempDir()
+		installScript(binDirectory, "barman-cloud-backup-list", `#!/bin/sh
+cat <<EOF
+{"backups_list": [
+  {"backup_id": "20240101T000000", "status": "DONE",
+   "begin_time": "Mon Jan  1 00:00:00 2024", "end_time": "Mon Jan  1 00:10:00 2024"},
+  {"backup_id": "20240102T000000", "status": "DONE",
+   "begin_time": "Tue Jan  2 00:00:00 2024", "end_time": "Tue Jan  2 00:10:00 2024"},
+  {"backup_id": "20240103T000000", "status": "FAILED",
+   "begin_time": "Wed Jan  3 00:00:00 2024", "end_time": "Wed Jan  3 00:10:00 2024"},
+  {"backup_id": "20240104T000000", "status": "DONE",
+   "begin_time": "Thu Jan  4 00:00:00 2024", "end_time": "Thu Jan  4 00:10:00 2024"}
+]}
+EOF
+`)
+		installScript(binDirectory, "barman-cloud-backup-keep", `#!/bin/sh
+for backup_id; do :; done
+echo "$backup_id" >> "$(dirname "$0")/kept"
+case "$backup_id" in
+20240102T000000) echo "Keep: standalone" ;;
+20240104T000000) echo "cannot reach the object store" >&2; exit 1 ;;
+*) echo "Keep: nokeep" ;;
+esac
+`)
+		GinkgoT().Setenv("PATH", binDirectory+string(os.PathListSeparator)+os.Getenv("PATH"))
+	})
+
+	It("doesn't read the keep status unless requested", func(ctx SpecContext) {
+		configuration := &barmanApi.BarmanObjectStoreConfiguration{DestinationPath: "s3://bucket"}
+		backupList, err := GetBackupList(ctx, configuration, "cluster", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backupList.List).To(HaveLen(4))
+		for _, backup := range backupList.List {
+			Expect(backup.IsKept()).To(BeFalse())
+		}
+		Expect(filepath.Join(binDirectory, "kept")).ToNot(BeAnExistingFile())
+	})
+
+	It("reads the keep status of the completed backups in the catalog", func(ctx SpecContext) {
+		configuration := &barmanApi.BarmanObjectStoreConfiguration{DestinationPath: "s3://bucket"}
+		backupList, err := GetBackupList(ContextWithKeepStatus(ctx, true), configuration, "cluster", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backupList.List).To(HaveLen(4))
+		for _, backup := range backupList.List {
+			if backup.ID == "20240102T000000" {
+				Expect(backup.Keep).To(Equal(catalog.KeepTargetStandalone))
+				Expect(backup.IsKept()).To(BeTrue())
+			} else {
+				// the keep status of the last backup can't be read
+				Expect(backup.IsKept()).To(BeFalse())
+			}
+		}
+
+		kept, err := os.ReadFile(filepath.Join(binDirectory, "kept")) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Fields(string(kept))).To(ConsistOf("20240101T000000", "20240102T000000", "20240104T000000"))
+	})
+})
@@ -53,6 +53,7 @@ import (
 
 	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
 	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
 	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
 )
 
@@ -66,20 +67,11 @@ func executeQueryCommand(
 ) (string, error) {
 	contextLogger := log.FromContext(ctx).WithName("barman")
 
-	options := []string{"--format", "json"}
-
-	if barmanConfiguration.EndpointURL != "" {
-		options = append(options, "--endpoint-url", barmanConfiguration.EndpointURL)
-	}
-
-	options, err := AppendCloudProviderOptionsFromConfiguration(ctx, options, barmanConfiguration)
+	options, err := queryOptions(ctx, barmanCommand, barmanConfiguration, serverName, additionalOptions...)
 	if err != nil {
 		return "", err
 	}
 
-	options = append(options, barmanConfiguration.DestinationPath, serverName)
-	options = append(options, additionalOptions...)
-
 	var stdoutBuffer bytes.Buffer
 	var stderrBuffer bytes.Buffer
 	cmd := exec.Command(barmanCommand, options...) // #nosec G204
@@ -100,7 +92,9 @@ func executeQueryCommand(
 	return stdoutBuffer.String(), nil
 }
 
-// GetBackupList returns the catalog reading it from the object store
+// GetBackupList returns the catalog reading it from the object store.
+// The keep status of the backups is only read when requested with
+// ContextWithKeepStatus, as barman-cloud-backup-list doesn't report it.
 func GetBackupList(
 	ctx context.Context,
 	barmanConfiguration *barmanApi.BarmanObjectStoreConfiguration,
@@ -109,6 +103,14 @@ func GetBackupList(
 ) (*catalog.Catalog, error) {
 	contextLogger := log.FromContext(ctx).WithName("barman")
 
+	if barmanApi.IsFileDestinationPath(barmanConfiguration.DestinationPath) {
+		store, err := filestore.NewFromConfiguration(barmanConfiguration, serverName)
+		if err != nil {
+			return nil, err
+		}
+		return store.GetBackupList()
+	}
+
 	rawJSON, err := executeQueryCommand(
 		ctx,
 		utils.BarmanCloudBackupList,
@@ -128,6 +130,10 @@ func GetBackupList(
 		return nil, err
 	}
 
+	for idx := range backupList.List {
+		setKeepStatus(ctx, barmanConfiguration, serverName, env, &backupList.List[idx])
+	}
+
 	return backupList, nil
 }
 
@@ -141,6 +147,14 @@ func GetBackupByName(
 ) (*catalog.BarmanBackup, error) {
 	contextLogger := log.FromContext(ctx)
 
+	if barmanApi.IsFileDestinationPath(barmanConfiguration.DestinationPath) {
+		store, err := filestore.NewFromConfiguration(barmanConfiguration, serverName)
+		if err != nil {
+			return nil, err
+		}
+		return store.GetBackup(backupName)
+	}
+
 	rawJSON, err := executeQueryCommand(
 		ctx,
 		utils.BarmanCloudBackupShow,
@@ -155,7 +169,14 @@ func GetBackupByName(
 
 	contextLogger.Debug("raw backup barman object", "rawBarmanObject", rawJSON)
 
-	return catalog.NewBackupFromBarmanCloudBackupShow(rawJSON)
+	backup, err := catalog.NewBackupFromBarmanCloudBackupShow(rawJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	setKeepStatus(ctx, barmanConfiguration, serverName, env, backup)
+
+	return backup, nil
 }
 
 // GetLatestBackup returns the latest executed backup
@@ -23,6 +23,7 @@ import (
 	"context"
 
 	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
 )
 
 // CloudWalRestoreOptions returns the options needed to execute the barman command successfully
@@ -31,32 +32,42 @@ func CloudWalRestoreOptions(
 	configuration *barmanApi.BarmanObjectStoreConfiguration,
 	clusterName string,
 ) ([]string, error) {
-	var options []string
-	if len(configuration.EndpointURL) > 0 {
-		options = append(
-			options,
-			"--endpoint-url",
-			configuration.EndpointURL)
+	if err := checkObjectStoreCapabilities(ctx, configuration); err != nil {
+		return nil, err
 	}
 
-	options, err := AppendCloudProviderOptionsFromConfiguration(ctx, options, configuration)
+	options, err := NewWalRestoreOptions(ctx, configuration, clusterName)
 	if err != nil {
 		return nil, err
 	}
 
-	serverName := clusterName
-	if len(configuration.ServerName) != 0 {
-		serverName = configuration.ServerName
+	return options.Args(), nil
+}
+
+// NewWalRestoreOptions builds the command line of barman-cloud-wal-restore
+// like CloudWalRestoreOptions, without checking the installed barman version.
+// The additional command arguments are applied after the flags needed
+// to reach the object store, so that they can't override them.
+func NewWalRestoreOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	clusterName string,
+) (*Options, error) {
+	options, err := NewOptions(utils.BarmanCloudWalRestore).
+		ObjectStore(ctx, configuration, GetServerName(configuration, clusterName))
+	if err != nil {
+		return nil, err
 	}
 
-	options = append(options, configuration.DestinationPath, serverName)
-	options = configuration.Wal.AppendRestoreAdditionalCommandArgs(options)
+	if configuration.Wal != nil {
+		options.AdditionalArgs(configuration.Wal.RestoreAdditionalCommandArgs)
+	}
 
 	return options, nil
 }
 
 // AppendCloudProviderOptionsFromConfiguration takes an options array and adds the cloud provider specified
-// in the Barman configuration object
+// in the Barman configuration object. The installed barman version is not checked.
 func AppendCloudProviderOptionsFromConfiguration(
 	ctx context.Context,
 	options []string,
@@ -66,7 +77,7 @@ func AppendCloudProviderOptionsFromConfiguration(
 }
 
 // AppendCloudProviderOptionsFromBackup takes an options array and adds the cloud provider specified
-// in the Backup object
+// in the Backup object. The installed barman version is not checked.
 func AppendCloudProviderOptionsFromBackup(
 	ctx context.Context,
 	options []string,
@@ -81,50 +92,44 @@ func appendCloudProviderOptions(
 	options []string,
 	credentials barmanApi.BarmanCredentials,
 ) ([]string, error) {
-	switch {
-	case credentials.AWS != nil:
-		options = append(
-			options,
-			"--cloud-provider",
-			"aws-s3")
-	case credentials.Azure != nil:
-		options = append(
-			options,
-			"--cloud-provider",
-			"azure-blob-storage")
-
-		// deprecated, to be removed in future versions
-		if useDefaultAzureCredentials(ctx) {
-			options = append(
-				options,
-				"--credential",
-				"default")
-			break
-		}
-
-		if credentials.Azure.UseDefaultAzureCredentials {
-			options = append(
-				options,
-				"--credential",
-				"default")
-			break
-		}
-
-		if credentials.Azure.InheritFromAzureAD {
-			options = append(
-				options,
-				"--credential",
-				"managed-identity")
-			break
-		}
-	case credentials.Google != nil:
-		options = append(
-			options,
-			"--cloud-provider",
-			"google-cloud-storage")
+	provider := barmanApi.DetectCloudProvider(&barmanApi.BarmanObjectStoreConfiguration{
+		BarmanCredentials: credentials,
+	})
+	if provider == nil {
+		return options, nil
 	}
 
-	return options, nil
+	// the context flag is deprecated, to be removed in future versions
+	if credentials.Azure != nil && useDefaultAzureCredentials(ctx) {
+		azureCredentials := *credentials.Azure
+		azureCredentials.UseDefaultAzureCredentials = true
+		credentials.Azure = &azureCredentials
+	}
+
+	options = append(
+		options,
+		"--cloud-provider",
+		provider.Name())
+
+	return provider.AppendOptions(ctx, options, credentials)
+}
+
+// checkObjectStoreCapabilities checks if the installed barman version supports
+// the options needed to reach the object store. This runs barman, and is only
+// done by the functions building the command lines to be executed.
+func checkObjectStoreCapabilities(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+) error {
+	if configuration.Azure == nil {
+		return nil
+	}
+
+	if configuration.Azure.UseDefaultAzureCredentials || useDefaultAzureCredentials(ctx) {
+		return utils.CheckInstalledBarmanCapabilities(ctx, utils.BarmanCapabilityAzureDefaultCredential)
+	}
+
+	return nil
 }
 
 type contextKey string
@@ -21,6 +21,8 @@ package command
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"strings"
 
 	machineryapi "github.com/cloudnative-pg/machinery/pkg/api"
@@ -59,7 +61,7 @@ var _ = Describe("barmanCloudWalRestoreOptions", func() {
 		Expect(strings.Join(options, " ")).
 			To(
 				Equal(
-					"s3://bucket-name/ test-cluster --read-timeout=60 -vv",
+					"--read-timeout=60 -vv s3://bucket-name/ test-cluster",
 				))
 	})
 })
@@ -172,3 +174,128 @@ var _ = Describe("AppendCloudProviderOptions with Azure credentials", func() {
 		))
 	})
 })
+
+var _ = Describe("Barman capabilities check", func() {
+	var (
+		marker        string
+		configuration *barmanApi.BarmanObjectStoreConfiguration
+	)
+
+	BeforeEach(func() {
+		// This barman-cloud-backup leaves a marker when run, and fails so
+		// that the detected version is never cached
+		binDir := GinkgoT().TempDir()
+		marker = filepath.Join(binDir, "executed")
+		script := "#!/bin/sh\ntouch " + marker + "\nexit 1\n"
+		Expect(os.WriteFile(filepath.Join(binDir, "barman-cloud-backup"), []byte(script), 0o700)).To(Succeed())
+		GinkgoT().Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		configuration = &barmanApi.BarmanObjectStoreConfiguration{
+			DestinationPath: "https://account.blob.core.windows.net/container",
+			BarmanCredentials: barmanApi.BarmanCredentials{
+				Azure: &barmanApi.AzureCredentials{UseDefaultAzureCredentials: true},
+			},
+		}
+	})
+
+	It("is not done when building the options", func(ctx SpecContext) {
+		_, err := NewWalArchiveOptions(ctx, configuration, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = NewWalRestoreOptions(ctx, configuration, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = NewBackupOptions(ctx, configuration, "cluster", "backup")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(marker).ToNot(BeAnExistingFile())
+	})
+
+	It("is done when building the command line to be executed", func(ctx SpecContext) {
+		// The version can't be detected, so the check is skipped
+		_, err := WalArchiveOptions(ctx, configuration, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(marker).To(BeAnExistingFile())
+	})
+
+	It("is not needed without the default Azure credentials", func(ctx SpecContext) {
+		configuration.Azure = &barmanApi.AzureCredentials{InheritFromAzureAD: true}
+		_, err := WalArchiveOptions(ctx, configuration, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(marker).ToNot(BeAnExistingFile())
+	})
+})
+
+var _ = Describe("AppendCloudProviderOptions with S3 credentials", func() {
+	It("should not use any profile by default", func(ctx SpecContext) {
+		credentials := barmanApi.BarmanCredentials{
+			AWS: &barmanApi.S3Credentials{
+				InheritFromIAMRole: true,
+				RegionName:         "us-east-1",
+			},
+		}
+		result, err := appendCloudProviderOptions(ctx, nil, credentials)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal([]string{
+			"--cloud-provider", "aws-s3",
+		}))
+	})
+
+	It("should not use any profile with S3-compatible options", func(ctx SpecContext) {
+		credentials := barmanApi.BarmanCredentials{
+			AWS: &barmanApi.S3Credentials{
+				InheritFromIAMRole: true,
+				AddressingStyle:    barmanApi.S3AddressingStylePath,
+			},
+		}
+		result, err := appendCloudProviderOptions(ctx, nil, credentials)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal([]string{
+			"--cloud-provider", "aws-s3",
+		}))
+	})
+})
+
+// extraOptionsCloudProvider is a cloud provider adding
+// an option to the ones of the wrapped provider
+type extraOptionsCloudProvider struct {
+	barmanApi.CloudProvider
+}
+
+func (p extraOptionsCloudProvider) AppendOptions(
+	ctx context.Context,
+	options []string,
+	credentials barmanApi.BarmanCredentials,
+) ([]string, error) {
+	options, err := p.CloudProvider.AppendOptions(ctx, options, credentials)
+	return append(options, "--read-timeout", "120"), err
+}
+
+var _ = Describe("Cloud provider options", func() {
+	It("should use the options of the registered provider", func(ctx SpecContext) {
+		credentials := barmanApi.BarmanCredentials{
+			Google: &barmanApi.GoogleCredentials{GKEEnvironment: true},
+		}
+		result, err := appendCloudProviderOptions(ctx, nil, credentials)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal([]string{
+			"--cloud-provider", "google-cloud-storage",
+		}))
+
+		previousProvider := barmanApi.GetCloudProvider(barmanApi.CloudProviderGoogle)
+		DeferCleanup(func() {
+			barmanApi.RegisterCloudProvider(previousProvider)
+		})
+		barmanApi.RegisterCloudProvider(extraOptionsCloudProvider{CloudProvider: previousProvider})
+
+		result, err = appendCloudProviderOptions(ctx, nil, credentials)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal([]string{
+			"--cloud-provider", "google-cloud-storage",
+			"--read-timeout", "120",
+		}))
+	})
+
+	It("should not add any option without credentials", func(ctx SpecContext) {
+		result, err := appendCloudProviderOptions(ctx, []string{"--option"}, barmanApi.BarmanCredentials{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal([]string{"--option"}))
+	})
+})
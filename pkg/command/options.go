@@ -0,0 +1,406 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
+	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
+)
+
+// Options is the command line of a barman-cloud tool. Flags and their
+// values are kept apart from the positional arguments, which are always
+// placed at the end of the command line.
+type Options struct {
+	tool        string
+	flags       []string
+	positionals []string
+	dropped     []string
+}
+
+// NewOptions creates an empty command line for a barman-cloud tool,
+// such as utils.BarmanCloudWalArchive
+func NewOptions(tool string) *Options {
+	return &Options{tool: tool}
+}
+
+// Tool gets the barman-cloud tool the command line is built for
+func (o *Options) Tool() string {
+	return o.tool
+}
+
+// Flag adds a flag, followed by its values
+func (o *Options) Flag(name string, values ...string) *Options {
+	o.flags = append(o.flags, name)
+	o.flags = append(o.flags, values...)
+	return o
+}
+
+// Flags adds a list of already assembled flags
+func (o *Options) Flags(flags ...string) *Options {
+	o.flags = append(o.flags, flags...)
+	return o
+}
+
+// Positional adds positional arguments
+func (o *Options) Positional(values ...string) *Options {
+	o.positionals = append(o.positionals, values...)
+	return o
+}
+
+// AdditionalArgs adds the user-defined additional command arguments,
+// dropping the ones which would override a flag already set
+func (o *Options) AdditionalArgs(additionalCommandArgs []string) *Options {
+	accepted, dropped := barmanApi.SplitAdditionalCommandArgs(additionalCommandArgs, o.flags)
+	o.dropped = append(o.dropped, dropped...)
+	return o.Flags(accepted...)
+}
+
+// DroppedAdditionalArgs gets the user-defined additional command arguments
+// which were dropped because the same flags were already set
+func (o *Options) DroppedAdditionalArgs() []string {
+	return o.dropped
+}
+
+// Tags adds a tags flag, such as --tags, followed by the tags sorted by key
+func (o *Options) Tags(flag string, tags map[string]string) (*Options, error) {
+	if len(tags) == 0 {
+		return o, nil
+	}
+
+	flags, err := utils.MapToBarmanTagsFormat(flag, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.Flags(flags...), nil
+}
+
+// ObjectStore adds the flags needed to reach the object store
+// and the positional arguments selecting the server inside it
+func (o *Options) ObjectStore(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+) (*Options, error) {
+	if len(configuration.EndpointURL) > 0 {
+		o.Flag("--endpoint-url", configuration.EndpointURL)
+	}
+
+	flags, err := AppendCloudProviderOptionsFromConfiguration(ctx, o.flags, configuration)
+	if err != nil {
+		return nil, err
+	}
+	o.flags = flags
+
+	return o.Positional(configuration.DestinationPath, serverName), nil
+}
+
+// WalConfiguration adds the flags corresponding to the configuration of the
+// WAL archive, as accepted by barman-cloud-wal-archive
+func (o *Options) WalConfiguration(wal *barmanApi.WalBackupConfiguration) *Options {
+	if wal == nil {
+		return o
+	}
+
+	if len(wal.Compression) != 0 {
+		o.Flag(fmt.Sprintf("--%v", wal.Compression))
+	}
+
+	if len(wal.Encryption) != 0 {
+		o.Flag("-e", string(wal.Encryption))
+	}
+
+	return o.AdditionalArgs(wal.ArchiveAdditionalCommandArgs)
+}
+
+// DataConfiguration adds the flags corresponding to the configuration of the
+// base backups, as accepted by barman-cloud-backup
+func (o *Options) DataConfiguration(data *barmanApi.DataBackupConfiguration) *Options {
+	if data == nil {
+		return o
+	}
+
+	if len(data.Compression) != 0 {
+		o.Flag(fmt.Sprintf("--%v", data.Compression))
+	}
+
+	if len(data.Encryption) != 0 {
+		o.Flag("--encryption", string(data.Encryption))
+	}
+
+	if data.ImmediateCheckpoint {
+		o.Flag("--immediate-checkpoint")
+	}
+
+	if data.Jobs != nil {
+		o.Flag("--jobs", strconv.Itoa(int(*data.Jobs)))
+	}
+
+	if data.MaxBandwidth != nil {
+		o.Flag("--max-bandwidth", strconv.FormatInt(data.MaxBandwidth.Value(), 10))
+	}
+
+	return o.AdditionalArgs(data.AdditionalCommandArgs)
+}
+
+// Args gets the command line, with the flags followed by the positional arguments
+func (o *Options) Args() []string {
+	return slices.Concat(o.flags, o.positionals)
+}
+
+// GetServerName gets the name of the server inside the object store,
+// which defaults to the name of the cluster
+func GetServerName(configuration *barmanApi.BarmanObjectStoreConfiguration, clusterName string) string {
+	if len(configuration.ServerName) != 0 {
+		return configuration.ServerName
+	}
+
+	return clusterName
+}
+
+// WalArchiveOptions builds the command line of barman-cloud-wal-archive,
+// without the name of the WAL file to be archived
+func WalArchiveOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	clusterName string,
+) ([]string, error) {
+	if err := checkObjectStoreCapabilities(ctx, configuration); err != nil {
+		return nil, err
+	}
+	if configuration.Wal != nil {
+		if err := checkCompressionCapability(ctx, configuration.Wal.Compression); err != nil {
+			return nil, err
+		}
+	}
+
+	options, err := NewWalArchiveOptions(ctx, configuration, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.Args(), nil
+}
+
+// NewWalArchiveOptions builds the command line of barman-cloud-wal-archive
+// like WalArchiveOptions, without checking the installed barman version
+func NewWalArchiveOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	clusterName string,
+) (*Options, error) {
+	options := NewOptions(utils.BarmanCloudWalArchive).WalConfiguration(configuration.Wal)
+
+	options, err := options.Tags("--tags", configuration.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err = options.Tags("--history-tags", configuration.HistoryTags)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.ObjectStore(ctx, configuration, GetServerName(configuration, clusterName))
+}
+
+// CheckWalArchiveOptions builds the command line of barman-cloud-check-wal-archive,
+// requiring the WAL archive to be empty.
+// The tags are not included, as the tool doesn't upload any object.
+func CheckWalArchiveOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	clusterName string,
+) ([]string, error) {
+	return CheckWalArchiveTimelineOptions(ctx, configuration, clusterName, 0)
+}
+
+// CheckWalArchiveTimelineOptions builds the command line of barman-cloud-check-wal-archive,
+// accepting a WAL archive containing the WAL files of the timelines preceding the passed
+// one, as it happens when a server is promoted. A zero timeline requires the WAL archive
+// to be empty.
+func CheckWalArchiveTimelineOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	clusterName string,
+	timeline uint32,
+) ([]string, error) {
+	if err := checkObjectStoreCapabilities(ctx, configuration); err != nil {
+		return nil, err
+	}
+
+	options := NewOptions(utils.BarmanCloudCheckWalArchive)
+	if timeline > 0 {
+		options.Flag("--timeline", strconv.FormatUint(uint64(timeline), 10))
+	}
+
+	options, err := options.ObjectStore(ctx, configuration, GetServerName(configuration, clusterName))
+	if err != nil {
+		return nil, err
+	}
+
+	return options.Args(), nil
+}
+
+// BackupOptions builds the command line of barman-cloud-backup.
+// filestore.ErrBackupNotSupported is returned for the local filesystem object store.
+func BackupOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	backupName string,
+	serverName string,
+) ([]string, error) {
+	if barmanApi.IsFileDestinationPath(configuration.DestinationPath) {
+		return nil, filestore.ErrBackupNotSupported
+	}
+	if err := checkObjectStoreCapabilities(ctx, configuration); err != nil {
+		return nil, err
+	}
+	if err := utils.CheckInstalledBarmanCapabilities(ctx, utils.BarmanCapabilityBackupName); err != nil {
+		return nil, err
+	}
+	if configuration.Data != nil {
+		if err := checkCompressionCapability(ctx, configuration.Data.Compression); err != nil {
+			return nil, err
+		}
+		if configuration.Data.MaxBandwidth != nil {
+			if err := utils.CheckInstalledBarmanCapabilities(ctx, utils.BarmanCapabilityMaxBandwidth); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	options, err := NewBackupOptions(ctx, configuration, backupName, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.Args(), nil
+}
+
+// NewBackupOptions builds the command line of barman-cloud-backup
+// like BackupOptions, without checking the installed barman version
+func NewBackupOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	backupName string,
+	serverName string,
+) (*Options, error) {
+	options := NewOptions(utils.BarmanCloudBackup).
+		Flag("--user", "postgres").
+		Flag("--name", backupName).
+		DataConfiguration(configuration.Data)
+
+	options, err := options.Tags("--tags", configuration.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.ObjectStore(ctx, configuration, serverName)
+}
+
+// checkCompressionCapability checks if the installed barman
+// version supports the passed compression algorithm
+func checkCompressionCapability(ctx context.Context, compression barmanApi.CompressionType) error {
+	capability, ok := utils.CompressionCapability(string(compression))
+	if !ok {
+		return nil
+	}
+
+	return utils.CheckInstalledBarmanCapabilities(ctx, capability)
+}
+
+// queryOptions builds the command line of the barman-cloud tools
+// reading the backup catalog, such as barman-cloud-backup-list
+func queryOptions(
+	ctx context.Context,
+	tool string,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+	positionals ...string,
+) ([]string, error) {
+	if err := checkObjectStoreCapabilities(ctx, configuration); err != nil {
+		return nil, err
+	}
+
+	options, err := NewOptions(tool).
+		Flag("--format", "json").
+		ObjectStore(ctx, configuration, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.Positional(positionals...).Args(), nil
+}
+
+// backupDeleteOptions builds the command line of barman-cloud-backup-delete
+func backupDeleteOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+	retentionPolicy string,
+) ([]string, error) {
+	if err := checkObjectStoreCapabilities(ctx, configuration); err != nil {
+		return nil, err
+	}
+
+	parsedPolicy, err := utils.ParsePolicy(retentionPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := NewOptions(utils.BarmanCloudBackupDelete).
+		Flag("--retention-policy", parsedPolicy).
+		ObjectStore(ctx, configuration, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.Args(), nil
+}
+
+// backupKeepOptions builds the command line of barman-cloud-backup-keep,
+// where the action is one of --target, --release and --status
+func backupKeepOptions(
+	ctx context.Context,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	serverName string,
+	backupID string,
+	action ...string,
+) ([]string, error) {
+	if err := checkObjectStoreCapabilities(ctx, configuration); err != nil {
+		return nil, err
+	}
+
+	options, err := NewOptions(utils.BarmanCloudBackupKeep).
+		Flags(action...).
+		ObjectStore(ctx, configuration, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	return options.Positional(backupID).Args(), nil
+}
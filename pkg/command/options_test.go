@@ -0,0 +1,168 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+
+	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
+	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// updateSnapshotsEnvVar is the environment variable which, when set to
+// "true", regenerates the snapshots instead of comparing them
+const updateSnapshotsEnvVar = "UPDATE_SNAPSHOTS"
+
+// matchSnapshot compares a command line, one argument per line, with the
+// content of the snapshot having the passed name
+func matchSnapshot(name string, args []string) {
+	snapshotPath := filepath.Join("testdata", "options", name+".txt")
+	content := strings.Join(args, "\n") + "\n"
+
+	if os.Getenv(updateSnapshotsEnvVar) == "true" {
+		Expect(os.MkdirAll(filepath.Dir(snapshotPath), 0o750)).To(Succeed())
+		Expect(os.WriteFile(snapshotPath, []byte(content), 0o600)).To(Succeed())
+		return
+	}
+
+	expected, err := os.ReadFile(snapshotPath) // #nosec G304
+	Expect(err).ToNot(HaveOccurred(), "run with %s=true to create the snapshot", updateSnapshotsEnvVar)
+	Expect(content).To(Equal(string(expected)))
+}
+
+func fullConfiguration() *barmanApi.BarmanObjectStoreConfiguration {
+	return &barmanApi.BarmanObjectStoreConfiguration{
+		BarmanCredentials: barmanApi.BarmanCredentials{
+			AWS: &barmanApi.S3Credentials{
+				InheritFromIAMRole: true,
+				AddressingStyle:    barmanApi.S3AddressingStylePath,
+			},
+		},
+		EndpointURL:     "https://minio.example.com",
+		DestinationPath: "s3://bucket/path",
+		ServerName:      "custom-server",
+		Tags:            map[string]string{"team": "dba", "env": "prod", "app": "pg"},
+		HistoryTags:     map[string]string{"retention": "long", "kind": "history"},
+		Wal: &barmanApi.WalBackupConfiguration{
+			Compression:                  barmanApi.CompressionTypeGzip,
+			Encryption:                   barmanApi.EncryptionTypeAES256,
+			ArchiveAdditionalCommandArgs: []string{"--read-timeout=60", "--gzip"},
+			RestoreAdditionalCommandArgs: []string{"--no-partial"},
+		},
+		Data: &barmanApi.DataBackupConfiguration{
+			Compression:           barmanApi.CompressionTypeBzip2,
+			Encryption:            barmanApi.EncryptionTypeNoneAWSKMS,
+			ImmediateCheckpoint:   true,
+			Jobs:                  ptr.To(int32(4)),
+			MaxBandwidth:          ptr.To(resource.MustParse("50Mi")),
+			AdditionalCommandArgs: []string{"--min-chunk-size=5MB", "--jobs=8", "--max-bandwidth=1MB"},
+		},
+	}
+}
+
+var _ = Describe("Options", func() {
+	It("places the positional arguments after the flags", func() {
+		options := NewOptions(utils.BarmanCloudWalRestore).
+			Positional("s3://bucket", "server").
+			Flag("--read-timeout", "60").
+			AdditionalArgs([]string{"--read-timeout=30", "-vv"})
+		Expect(options.Tool()).To(Equal(utils.BarmanCloudWalRestore))
+		Expect(options.Args()).To(Equal([]string{"--read-timeout", "60", "-vv", "s3://bucket", "server"}))
+		Expect(options.DroppedAdditionalArgs()).To(Equal([]string{"--read-timeout=30"}))
+	})
+
+	It("sorts the tags by key", func() {
+		options, err := NewOptions(utils.BarmanCloudBackup).Tags("--tags", map[string]string{"b": "2", "a": "1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(options.Args()).To(Equal([]string{"--tags", "a,1", "b,2"}))
+	})
+
+	It("refuses to build the command line of a backup on a local filesystem", func(ctx SpecContext) {
+		_, err := BackupOptions(ctx, &barmanApi.BarmanObjectStoreConfiguration{
+			DestinationPath: "file:///backups",
+		}, "backup-name", "cluster")
+		Expect(err).To(MatchError(filestore.ErrBackupNotSupported))
+	})
+
+	DescribeTable("builds the command line of every tool",
+		func(ctx SpecContext, name string, build func(context.Context) ([]string, error)) {
+			// The output must be the same for every invocation
+			for range 5 {
+				args, err := build(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				matchSnapshot(name, args)
+			}
+		},
+		Entry("barman-cloud-wal-archive", "wal-archive", func(ctx context.Context) ([]string, error) {
+			return WalArchiveOptions(ctx, fullConfiguration(), "cluster")
+		}),
+		Entry("barman-cloud-check-wal-archive", "check-wal-archive", func(ctx context.Context) ([]string, error) {
+			return CheckWalArchiveOptions(ctx, fullConfiguration(), "cluster")
+		}),
+		Entry("barman-cloud-check-wal-archive, with a timeline", "check-wal-archive-timeline",
+			func(ctx context.Context) ([]string, error) {
+				return CheckWalArchiveTimelineOptions(ctx, fullConfiguration(), "cluster", 2)
+			}),
+		Entry("barman-cloud-wal-restore", "wal-restore", func(ctx context.Context) ([]string, error) {
+			return CloudWalRestoreOptions(ctx, fullConfiguration(), "cluster")
+		}),
+		Entry("barman-cloud-backup", "backup", func(ctx context.Context) ([]string, error) {
+			return BackupOptions(ctx, fullConfiguration(), "backup-name", "custom-server")
+		}),
+		Entry("barman-cloud-backup-list", "backup-list", func(ctx context.Context) ([]string, error) {
+			return queryOptions(ctx, utils.BarmanCloudBackupList, fullConfiguration(), "custom-server")
+		}),
+		Entry("barman-cloud-backup-show", "backup-show", func(ctx context.Context) ([]string, error) {
+			return queryOptions(ctx, utils.BarmanCloudBackupShow, fullConfiguration(), "custom-server", "backup-name")
+		}),
+		Entry("barman-cloud-backup-delete", "backup-delete", func(ctx context.Context) ([]string, error) {
+			return backupDeleteOptions(ctx, fullConfiguration(), "custom-server", "30d")
+		}),
+		Entry("barman-cloud-backup-keep", "backup-keep", func(ctx context.Context) ([]string, error) {
+			return backupKeepOptions(ctx, fullConfiguration(), "custom-server", "backup-id", "--target", "full")
+		}),
+		Entry("barman-cloud-wal-restore, duplicated flags", "wal-restore-duplicated-flags",
+			func(ctx context.Context) ([]string, error) {
+				configuration := fullConfiguration()
+				configuration.Wal.RestoreAdditionalCommandArgs = []string{
+					"--endpoint-url=https://other.example.com",
+					"--cloud-provider=google-cloud-storage",
+					"--no-partial",
+				}
+				return CloudWalRestoreOptions(ctx, configuration, "cluster")
+			}),
+		Entry("barman-cloud-wal-archive, minimal configuration", "wal-archive-minimal",
+			func(ctx context.Context) ([]string, error) {
+				return WalArchiveOptions(ctx, &barmanApi.BarmanObjectStoreConfiguration{
+					DestinationPath: "gs://bucket",
+				}, "cluster")
+			}),
+	)
+})
@@ -0,0 +1,331 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package copier
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudnative-pg/machinery/pkg/log"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/command"
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
+	"github.com/cloudnative-pg/barman-cloud/pkg/restorer"
+	"github.com/cloudnative-pg/barman-cloud/pkg/walarchive"
+)
+
+var (
+	// ErrBackupCopyNotSupported is returned when copying base backups
+	// between stores which are not located in a local directory, where
+	// only the WAL files can be copied, as planned by PlanWALs
+	ErrBackupCopyNotSupported = errors.New("base backups can only be copied between local directories")
+
+	// ErrVerificationFailed is returned when a WAL file in the destination
+	// store differs from the one in the source store
+	ErrVerificationFailed = errors.New("WAL file verification failed")
+)
+
+// Store is an object store taking part in a copy
+type Store struct {
+	// The configuration of the object store
+	Configuration *api.BarmanObjectStoreConfiguration
+
+	// The name of the server, used when the configuration doesn't specify it
+	ServerName string
+
+	// The environment needed by barman-cloud to reach the object
+	// store, as built by credentials.EnvSetCloudCredentialsAndCertificates
+	Env []string
+}
+
+// Result contains the objects copied to the destination store
+type Result struct {
+	// The IDs of the copied backups
+	CopiedBackups []string `json:"copiedBackups,omitempty"`
+
+	// The copied WAL files
+	CopiedWALs []string `json:"copiedWALs,omitempty"`
+
+	// The WAL files which were already in the destination store
+	ExistingWALs []string `json:"existingWALs,omitempty"`
+}
+
+// Copier copies base backups and WAL files from an object store to another one
+type Copier struct {
+	source      Store
+	destination Store
+
+	// The directory where the WAL files are temporarily stored
+	scratchDirectory string
+}
+
+// New creates a new copier. The scratch directory is used to
+// temporarily store the WAL files while they are copied.
+func New(source Store, destination Store, scratchDirectory string) *Copier {
+	return &Copier{
+		source:           source,
+		destination:      destination,
+		scratchDirectory: scratchDirectory,
+	}
+}
+
+// Plan reads the catalogs of both stores and selects the completed backups to
+// be copied, with the WAL files they need. The selector can be nil to select
+// every backup. The backups already in the destination store are skipped.
+// As barman-cloud can't upload an existing base backup, ErrBackupCopyNotSupported
+// is returned when backups are to be copied and a store is not a local directory.
+func (copier *Copier) Plan(ctx context.Context, selector BackupSelector) (*Plan, error) {
+	plan, err := copier.plan(ctx, selector, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(plan.Backups) > 0 && !copier.canCopyBackups() {
+		return nil, fmt.Errorf("while planning the copy of %d backups: %w", len(plan.Backups), ErrBackupCopyNotSupported)
+	}
+
+	return plan, nil
+}
+
+// PlanWALs reads the catalogs of both stores and enumerates the WAL files needed
+// by the completed backups selected in the source store, without copying the
+// backups themselves. This works between any object stores, and is meant to copy
+// the WAL archive of the base backups copied by other means, such as the tools of
+// the cloud provider, which can be done before or after the WAL files.
+func (copier *Copier) PlanWALs(ctx context.Context, selector BackupSelector) (*Plan, error) {
+	return copier.plan(ctx, selector, true)
+}
+
+func (copier *Copier) plan(ctx context.Context, selector BackupSelector, walsOnly bool) (*Plan, error) {
+	sourceCatalog, err := command.GetBackupList(
+		ctx, copier.source.Configuration, copier.source.serverName(), copier.source.Env)
+	if err != nil {
+		return nil, fmt.Errorf("while reading the catalog of the source store: %w", err)
+	}
+
+	destinationCatalog, err := command.GetBackupList(
+		ctx, copier.destination.Configuration, copier.destination.serverName(), copier.destination.Env)
+	if err != nil {
+		return nil, fmt.Errorf("while reading the catalog of the destination store: %w", err)
+	}
+
+	return newPlan(sourceCatalog, destinationCatalog, selector, walsOnly)
+}
+
+// Copy copies the objects of a plan into the destination store. WAL files are
+// copied before the base backups, so that every backup appearing in the
+// catalog of the destination store has the WAL files it needs.
+func (copier *Copier) Copy(ctx context.Context, plan *Plan) (*Result, error) {
+	contextLogger := log.FromContext(ctx).WithName("copier")
+
+	var sourceStore, destinationStore *filestore.Store
+	if len(plan.Backups) > 0 {
+		var err error
+		if sourceStore, destinationStore, err = copier.localStores(); err != nil {
+			return nil, err
+		}
+	}
+
+	walCopier, err := copier.newWALCopier(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for _, walName := range plan.WALs {
+		copied, err := walCopier.copy(ctx, walName)
+		if err != nil {
+			return result, fmt.Errorf("while copying WAL file %q: %w", walName, err)
+		}
+
+		if copied {
+			contextLogger.Info("Copied WAL file", "walName", walName)
+			result.CopiedWALs = append(result.CopiedWALs, walName)
+		} else {
+			result.ExistingWALs = append(result.ExistingWALs, walName)
+		}
+	}
+
+	for idx := range plan.Backups {
+		backupID := plan.Backups[idx].ID
+		if err := sourceStore.CopyBackup(backupID, destinationStore); err != nil {
+			return result, err
+		}
+
+		contextLogger.Info("Copied base backup", "backupID", backupID)
+		result.CopiedBackups = append(result.CopiedBackups, backupID)
+	}
+
+	return result, nil
+}
+
+// canCopyBackups checks if the base backups can be copied, which
+// requires both stores to be located in a local directory
+func (copier *Copier) canCopyBackups() bool {
+	return api.IsFileDestinationPath(copier.source.Configuration.DestinationPath) &&
+		api.IsFileDestinationPath(copier.destination.Configuration.DestinationPath)
+}
+
+// localStores gets the stores used to copy the base backups
+func (copier *Copier) localStores() (*filestore.Store, *filestore.Store, error) {
+	if !copier.canCopyBackups() {
+		return nil, nil, ErrBackupCopyNotSupported
+	}
+
+	sourceStore, err := filestore.NewFromConfiguration(copier.source.Configuration, copier.source.serverName())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	destinationStore, err := filestore.NewFromConfiguration(
+		copier.destination.Configuration, copier.destination.serverName())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sourceStore, destinationStore, nil
+}
+
+// serverName gets the name of the server inside the object store
+func (store *Store) serverName() string {
+	return command.GetServerName(store.Configuration, store.ServerName)
+}
+
+// walCopier copies WAL files restoring them from the source store
+// and archiving them into the destination one
+type walCopier struct {
+	scratchDirectory string
+
+	sourceRestorer      *restorer.WALRestorer
+	sourceOptions       []string
+	destinationRestorer *restorer.WALRestorer
+	destinationOptions  []string
+	archiver            *walarchive.BarmanArchiver
+	archiveOptions      []string
+}
+
+func (copier *Copier) newWALCopier(ctx context.Context) (*walCopier, error) {
+	result := &walCopier{
+		scratchDirectory: copier.scratchDirectory,
+		archiver:         &walarchive.BarmanArchiver{Env: copier.destination.Env},
+	}
+
+	var err error
+	if result.sourceRestorer, err = restorer.New(
+		ctx, copier.source.Env, filepath.Join(copier.scratchDirectory, "source")); err != nil {
+		return nil, err
+	}
+	if result.destinationRestorer, err = restorer.New(
+		ctx, copier.destination.Env, filepath.Join(copier.scratchDirectory, "destination")); err != nil {
+		return nil, err
+	}
+
+	if result.sourceOptions, err = command.CloudWalRestoreOptions(
+		ctx, copier.source.Configuration, copier.source.ServerName); err != nil {
+		return nil, err
+	}
+	if result.destinationOptions, err = command.CloudWalRestoreOptions(
+		ctx, copier.destination.Configuration, copier.destination.ServerName); err != nil {
+		return nil, err
+	}
+	if result.archiveOptions, err = command.WalArchiveOptions(
+		ctx, copier.destination.Configuration, copier.destination.ServerName); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// copy copies a WAL file unless the destination store already contains it,
+// verifying the copy by reading it back. It returns false when the WAL file
+// was already in the destination store.
+func (walCopier *walCopier) copy(ctx context.Context, walName string) (bool, error) {
+	sourcePath := filepath.Join(walCopier.scratchDirectory, walName)
+	verifyPath := filepath.Join(walCopier.scratchDirectory, walName+".verify")
+	defer func() {
+		_ = os.Remove(sourcePath)
+		_ = os.Remove(verifyPath)
+	}()
+
+	if err := walCopier.sourceRestorer.Restore(walName, sourcePath, walCopier.sourceOptions); err != nil {
+		return false, err
+	}
+	sourceChecksum, err := fileChecksum(sourcePath)
+	if err != nil {
+		return false, err
+	}
+
+	err = walCopier.verify(walName, verifyPath, sourceChecksum)
+	switch {
+	case err == nil:
+		return false, nil
+	case !errors.Is(err, restorer.ErrWALNotFound):
+		return false, err
+	}
+
+	if err := walCopier.archiver.Archive(ctx, sourcePath, walCopier.archiveOptions); err != nil {
+		return false, err
+	}
+
+	return true, walCopier.verify(walName, verifyPath, sourceChecksum)
+}
+
+// verify checks that the destination store contains a WAL
+// file having the passed checksum
+func (walCopier *walCopier) verify(walName string, verifyPath string, checksum []byte) error {
+	if err := walCopier.destinationRestorer.Restore(walName, verifyPath, walCopier.destinationOptions); err != nil {
+		return err
+	}
+
+	destinationChecksum, err := fileChecksum(verifyPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(checksum, destinationChecksum) {
+		return fmt.Errorf("%s: %w", walName, ErrVerificationFailed)
+	}
+
+	return nil
+}
+
+// fileChecksum gets the SHA-256 checksum of a file
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
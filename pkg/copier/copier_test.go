@@ -0,0 +1,162 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package copier
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+	"github.com/cloudnative-pg/barman-cloud/pkg/command"
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Copier", func() {
+	const backupID = "20240101T000000"
+
+	var (
+		tempDir     string
+		source      Store
+		destination Store
+	)
+
+	BeforeEach(func() {
+		tempDir = GinkgoT().TempDir()
+		source = Store{
+			Configuration: &api.BarmanObjectStoreConfiguration{
+				DestinationPath: "file://" + filepath.Join(tempDir, "source"),
+				Wal:             &api.WalBackupConfiguration{Compression: api.CompressionTypeGzip},
+			},
+			ServerName: "cluster",
+		}
+		destination = Store{
+			Configuration: &api.BarmanObjectStoreConfiguration{
+				DestinationPath: "file://" + filepath.Join(tempDir, "destination"),
+			},
+			ServerName: "cluster",
+		}
+
+		sourceStore, err := filestore.NewFromConfiguration(source.Configuration, source.ServerName)
+		Expect(err).ToNot(HaveOccurred())
+
+		backupDirectory := filepath.Join(sourceStore.ServerDirectory(), "base", backupID)
+		Expect(os.MkdirAll(filepath.Join(backupDirectory, "annotations"), 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(backupDirectory, "data.tar"), []byte("data"), 0o600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(backupDirectory, "annotations", "keep"), []byte("full"), 0o600)).
+			To(Succeed())
+		Expect(os.WriteFile(filepath.Join(backupDirectory, "backup.info"), []byte(
+			"begin_time=2024-01-01 00:00:00+00:00\n"+
+				"begin_wal=000000010000000000000002\n"+
+				"end_time=2024-01-01 00:10:00+00:00\n"+
+				"end_wal=000000010000000000000003\n"+
+				"status=DONE\n"+
+				"timeline=1\n"), 0o600)).To(Succeed())
+
+		walDirectory := filepath.Join(tempDir, "pg_wal")
+		Expect(os.MkdirAll(walDirectory, 0o750)).To(Succeed())
+		for _, walName := range []string{"000000010000000000000002", "000000010000000000000003"} {
+			walPath := filepath.Join(walDirectory, walName)
+			Expect(os.WriteFile(walPath, []byte(walName), 0o600)).To(Succeed())
+			Expect(sourceStore.ArchiveWAL(walPath)).To(Succeed())
+		}
+	})
+
+	It("copies the backups and their WAL files", func(ctx SpecContext) {
+		copier := New(source, destination, filepath.Join(tempDir, "scratch"))
+		plan, err := copier.Plan(ctx, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backups).To(HaveLen(1))
+		Expect(plan.WALs).To(Equal([]string{"000000010000000000000002", "000000010000000000000003"}))
+
+		result, err := copier.Copy(ctx, plan)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.CopiedBackups).To(Equal([]string{backupID}))
+		Expect(result.CopiedWALs).To(Equal(plan.WALs))
+
+		destinationCatalog, err := command.GetBackupList(ctx, destination.Configuration, "cluster", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(destinationCatalog.GetBackupIDs()).To(Equal([]string{backupID}))
+		Expect(destinationCatalog.List[0].Keep).To(BeEquivalentTo("full"))
+
+		destinationStore, err := filestore.NewFromConfiguration(destination.Configuration, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		restoredPath := filepath.Join(tempDir, "restored")
+		Expect(destinationStore.RestoreWAL("000000010000000000000003", restoredPath)).To(Succeed())
+		Expect(os.ReadFile(restoredPath)).To(Equal([]byte("000000010000000000000003")))
+
+		plan, err = copier.Plan(ctx, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backups).To(BeEmpty())
+		Expect(plan.SkippedBackups).To(Equal([]string{backupID}))
+
+		result, err = copier.Copy(ctx, &Plan{WALs: []string{"000000010000000000000002"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.CopiedWALs).To(BeEmpty())
+		Expect(result.ExistingWALs).To(Equal([]string{"000000010000000000000002"}))
+	})
+
+	It("refuses to overwrite a different WAL file", func(ctx SpecContext) {
+		destinationStore, err := filestore.NewFromConfiguration(destination.Configuration, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		walPath := filepath.Join(tempDir, "000000010000000000000002")
+		Expect(os.WriteFile(walPath, []byte("something else"), 0o600)).To(Succeed())
+		Expect(destinationStore.ArchiveWAL(walPath)).To(Succeed())
+
+		copier := New(source, destination, filepath.Join(tempDir, "scratch"))
+		_, err = copier.Copy(ctx, &Plan{WALs: []string{"000000010000000000000002"}})
+		Expect(errors.Is(err, ErrVerificationFailed)).To(BeTrue())
+	})
+
+	It("can only copy base backups between local directories", func(ctx SpecContext) {
+		copier := New(source, destination, filepath.Join(tempDir, "scratch"))
+		plan, err := copier.Plan(ctx, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		// An empty object store, as seen by barman-cloud-backup-list
+		binDirectory := filepath.Join(tempDir, "bin")
+		Expect(os.MkdirAll(binDirectory, 0o750)).To(Succeed())
+		// #nosec G306
+		Expect(os.WriteFile(filepath.Join(binDirectory, "barman-cloud-backup-list"),
+			[]byte("#!/bin/sh\necho '{\"backups_list\": []}'\n"), 0o755)).To(Succeed())
+		GinkgoT().Setenv("PATH", binDirectory+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		destination.Configuration = &api.BarmanObjectStoreConfiguration{DestinationPath: "s3://bucket"}
+		copier = New(source, destination, filepath.Join(tempDir, "scratch"))
+		_, err = copier.Plan(ctx, nil)
+		Expect(errors.Is(err, ErrBackupCopyNotSupported)).To(BeTrue())
+
+		_, err = copier.Copy(ctx, plan)
+		Expect(errors.Is(err, ErrBackupCopyNotSupported)).To(BeTrue())
+
+		plan, err = copier.Plan(ctx, func(*catalog.BarmanBackup) bool { return false })
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backups).To(BeEmpty())
+
+		plan, err = copier.PlanWALs(ctx, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backups).To(BeEmpty())
+		Expect(plan.WALs).To(Equal([]string{"000000010000000000000002", "000000010000000000000003"}))
+	})
+})
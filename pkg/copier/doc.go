@@ -0,0 +1,34 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package copier copies base backups and the WAL files they need
+// from an object store to another one, driven by the backup catalog.
+//
+// WAL files are copied with barman-cloud-wal-restore and
+// barman-cloud-wal-archive, and are verified reading them back from
+// the destination. Base backups can only be copied between stores
+// located in a local directory, as barman-cloud has no tool to upload
+// an existing base backup: the plan of a copy selecting base backups
+// between other stores is rejected before anything is copied.
+//
+// Between object stores in the cloud, only the WAL files needed by the
+// selected backups are copied, as planned by Copier.PlanWALs, while the
+// base backups, including their backup.info, are left to the tools of
+// the cloud provider.
+package copier
@@ -0,0 +1,108 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package copier
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+// BackupSelector decides which backups of the source catalog are copied
+type BackupSelector func(backup *catalog.BarmanBackup) bool
+
+// Plan contains the objects to be copied to the destination store
+type Plan struct {
+	// The backups to be copied
+	Backups []catalog.BarmanBackup `json:"backups"`
+
+	// The IDs of the selected backups which are already
+	// contained in the destination store
+	SkippedBackups []string `json:"skippedBackups,omitempty"`
+
+	// The WAL files needed by the backups to be copied,
+	// including the timeline history files
+	WALs []string `json:"wals"`
+}
+
+// newPlan selects the completed backups of the source catalog which are not
+// in the destination one, and enumerates the WAL files needed to make them
+// consistent. When only the WAL files are planned, the selected backups are
+// not copied and their WAL files are planned even if they are already in the
+// destination catalog.
+func newPlan(
+	source *catalog.Catalog,
+	destination *catalog.Catalog,
+	selector BackupSelector,
+	walsOnly bool,
+) (*Plan, error) {
+	plan := &Plan{}
+	walNames := make(map[string]struct{})
+	destinationBackupIDs := destination.GetBackupIDs()
+
+	for idx := range source.List {
+		backup := &source.List[idx]
+		if !backup.IsDone() || backup.BeginWal == "" {
+			continue
+		}
+		if selector != nil && !selector(backup) {
+			continue
+		}
+		if !walsOnly && slices.Contains(destinationBackupIDs, backup.ID) {
+			plan.SkippedBackups = append(plan.SkippedBackups, backup.ID)
+			continue
+		}
+
+		backupWALs, err := walRange(backup.BeginWal, backup.EndWal, backup.GetWALSegmentSize())
+		if err != nil {
+			return nil, fmt.Errorf("while enumerating the WAL files of backup %q: %w", backup.ID, err)
+		}
+		for _, walName := range backupWALs {
+			walNames[walName] = struct{}{}
+		}
+		if backup.TimeLine > 1 {
+			walNames[catalog.TimelineHistoryFileName(backup.TimeLine)] = struct{}{}
+		}
+
+		if !walsOnly {
+			plan.Backups = append(plan.Backups, *backup)
+		}
+	}
+
+	for walName := range walNames {
+		plan.WALs = append(plan.WALs, walName)
+	}
+	slices.Sort(plan.WALs)
+
+	return plan, nil
+}
+
+// walRange gets the names of the WAL files between the passed ones,
+// which must belong to the same timeline. When the last WAL file is
+// not known, only the first one is returned.
+func walRange(firstWAL string, lastWAL string, segmentSize uint64) ([]string, error) {
+	if lastWAL == "" || lastWAL == firstWAL {
+		return []string{firstWAL}, nil
+	}
+
+	return wal.SegmentRange(firstWAL, lastWAL, segmentSize)
+}
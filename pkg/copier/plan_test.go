@@ -0,0 +1,140 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package copier
+
+import (
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("walRange", func() {
+	It("enumerates the WAL files between two ones", func() {
+		Expect(walRange("0000000100000000000000FE", "000000010000000100000001", wal.DefaultSegmentSize)).
+			To(Equal([]string{
+				"0000000100000000000000FE",
+				"0000000100000000000000FF",
+				"000000010000000100000000",
+				"000000010000000100000001",
+			}))
+	})
+
+	It("supports custom WAL segment sizes", func() {
+		Expect(walRange("00000002000000000000003F", "000000020000000100000000", 64*1024*1024)).
+			To(Equal([]string{"00000002000000000000003F", "000000020000000100000000"}))
+	})
+
+	It("returns the first WAL file when the last one is not known", func() {
+		Expect(walRange("000000010000000000000002", "", wal.DefaultSegmentSize)).
+			To(Equal([]string{"000000010000000000000002"}))
+	})
+
+	It("refuses ranges crossing timelines or going backwards", func() {
+		_, err := walRange("000000010000000000000002", "000000020000000000000003", wal.DefaultSegmentSize)
+		Expect(err).To(HaveOccurred())
+
+		_, err = walRange("000000010000000000000003", "000000010000000000000002", wal.DefaultSegmentSize)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("newPlan", func() {
+	// backup creates a backup, starting at a time which
+	// depends on the position of its first WAL file
+	backup := func(id string, tli int, beginWal string, endWal string, done bool) catalog.BarmanBackup {
+		beginName, err := wal.Parse(beginWal)
+		Expect(err).ToNot(HaveOccurred())
+		segmentNumber, err := beginName.SegmentNumber(wal.DefaultSegmentSize)
+		Expect(err).ToNot(HaveOccurred())
+		beginTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).
+			Add(time.Duration(segmentNumber) * 24 * time.Hour) // #nosec G115
+
+		result := catalog.BarmanBackup{
+			ID:        id,
+			TimeLine:  tli,
+			BeginWal:  beginWal,
+			EndWal:    endWal,
+			BeginTime: beginTime,
+		}
+		if done {
+			result.EndTime = result.BeginTime.Add(time.Hour)
+		}
+		return result
+	}
+
+	It("selects the completed backups missing in the destination", func() {
+		source := catalog.NewCatalog([]catalog.BarmanBackup{
+			backup("first", 1, "000000010000000000000002", "000000010000000000000003", true),
+			backup("second", 2, "000000020000000000000005", "000000020000000000000005", true),
+			backup("running", 2, "000000020000000000000007", "", false),
+			backup("copied", 2, "000000020000000000000009", "000000020000000000000009", true),
+		})
+		destination := catalog.NewCatalog([]catalog.BarmanBackup{
+			backup("copied", 2, "000000020000000000000009", "000000020000000000000009", true),
+		})
+
+		plan, err := newPlan(source, destination, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backups).To(HaveLen(2))
+		Expect(plan.Backups[0].ID).To(Equal("first"))
+		Expect(plan.Backups[1].ID).To(Equal("second"))
+		Expect(plan.SkippedBackups).To(Equal([]string{"copied"}))
+		Expect(plan.WALs).To(Equal([]string{
+			"000000010000000000000002",
+			"000000010000000000000003",
+			"00000002.history",
+			"000000020000000000000005",
+		}))
+	})
+
+	It("plans the WAL files of the backups already in the destination when only WAL files are copied", func() {
+		source := catalog.NewCatalog([]catalog.BarmanBackup{
+			backup("first", 1, "000000010000000000000002", "000000010000000000000002", true),
+			backup("copied", 1, "000000010000000000000004", "000000010000000000000004", true),
+		})
+		destination := catalog.NewCatalog([]catalog.BarmanBackup{
+			backup("copied", 1, "000000010000000000000004", "000000010000000000000004", true),
+		})
+
+		plan, err := newPlan(source, destination, nil, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backups).To(BeEmpty())
+		Expect(plan.SkippedBackups).To(BeEmpty())
+		Expect(plan.WALs).To(Equal([]string{"000000010000000000000002", "000000010000000000000004"}))
+	})
+
+	It("only selects the backups accepted by the selector", func() {
+		source := catalog.NewCatalog([]catalog.BarmanBackup{
+			backup("first", 1, "000000010000000000000002", "000000010000000000000002", true),
+			backup("second", 1, "000000010000000000000004", "000000010000000000000004", true),
+		})
+
+		plan, err := newPlan(source, catalog.NewCatalog(nil), func(backup *catalog.BarmanBackup) bool {
+			return backup.ID == "second"
+		}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Backups).To(HaveLen(1))
+		Expect(plan.WALs).To(Equal([]string{"000000010000000000000004"}))
+	})
+})
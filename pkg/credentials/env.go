@@ -59,6 +59,15 @@ const (
 	BarmanEndpointCACertificateFileName = "barman-ca.crt"
 )
 
+const (
+	// awsCABundleEnvVar is the variable used by the AWS SDK to locate the CA bundle
+	awsCABundleEnvVar = "AWS_CA_BUNDLE"
+
+	// requestsCABundleEnvVar is the variable used by the Python requests library,
+	// which is used by both the Azure and the Google Cloud SDKs, to locate the CA bundle
+	requestsCABundleEnvVar = "REQUESTS_CA_BUNDLE"
+)
+
 // EnvSetBackupCloudCredentials sets the AWS environment variables needed for backups
 // given the configuration inside the cluster
 func EnvSetBackupCloudCredentials(
@@ -85,9 +94,12 @@ func EnvSetRestoreCloudCredentials(
 		ctx, c, namespace, configuration, env, BarmanRestoreEndpointCACertificateLocation)
 }
 
-// EnvSetCloudCredentialsAndCertificates sets the AWS and Azure
-// environment variables needed for restores given the configuration
-// inside the cluster
+// EnvSetCloudCredentialsAndCertificates sets the AWS, Azure and Google
+// environment variables needed to reach the object store, for both backups
+// and restores, given the configuration inside the cluster.
+// The CA bundle referenced by EndpointCA is expected to be already stored
+// in certificatesLocation, while the CA bundle of the TLS stanza is written
+// by this function in the same location.
 func EnvSetCloudCredentialsAndCertificates(
 	ctx context.Context,
 	c client.Client,
@@ -96,243 +108,109 @@ func EnvSetCloudCredentialsAndCertificates(
 	env []string,
 	certificatesLocation string,
 ) ([]string, error) {
-	if configuration.EndpointCA != nil && configuration.AWS != nil {
-		env = append(env, fmt.Sprintf("AWS_CA_BUNDLE=%s", certificatesLocation))
-	} else if configuration.EndpointCA != nil && configuration.Azure != nil {
-		env = append(env, fmt.Sprintf("REQUESTS_CA_BUNDLE=%s", certificatesLocation))
-	}
-	return envSetCloudCredentials(ctx, c, namespace, configuration, env)
+	return EnvSetCloudCredentialsInDirectory(
+		ctx, c, namespace, configuration, env, certificatesLocation, ScratchDataDirectory)
 }
 
-// envSetCloudCredentials sets the AWS environment variables given the configuration
-// inside the cluster
-func envSetCloudCredentials(
+// EnvSetCloudCredentialsInDirectory is like EnvSetCloudCredentialsAndCertificates,
+// but the files needed by the cloud provider SDKs, such as the AWS configuration
+// file and the Google application credentials, are written in the passed
+// scratch directory instead of ScratchDataDirectory
+func EnvSetCloudCredentialsInDirectory(
 	ctx context.Context,
 	c client.Client,
 	namespace string,
 	configuration *barmanApi.BarmanObjectStoreConfiguration,
 	env []string,
-) (envs []string, err error) {
-	if configuration.AWS != nil {
-		return envSetAWSCredentials(ctx, c, namespace, configuration.AWS, env)
-	}
-
-	if configuration.Google != nil {
-		return envSetGoogleCredentials(ctx, c, namespace, configuration.Google, env)
-	}
-
-	if configuration.Azure != nil {
-		return envSetAzureCredentials(ctx, c, namespace, configuration, env)
-	}
-
-	return nil, fmt.Errorf("ObjectStoreConfiguration invalid: no credentials defined")
-}
-
-// envSetAWSCredentials sets the AWS environment variables given the configuration
-// inside the cluster
-func envSetAWSCredentials(
-	ctx context.Context,
-	client client.Client,
-	namespace string,
-	s3credentials *barmanApi.S3Credentials,
-	env []string,
+	certificatesLocation string,
+	scratchDirectory string,
 ) ([]string, error) {
-	// check if AWS credentials are defined
-	if s3credentials == nil {
-		return nil, fmt.Errorf("missing S3 credentials")
-	}
-
-	if s3credentials.InheritFromIAMRole {
-		return env, nil
-	}
-
-	// Get access key ID
-	if s3credentials.AccessKeyIDReference == nil {
-		return nil, fmt.Errorf("missing access key ID")
-	}
-	accessKeyID, accessKeyErr := extractValueFromSecret(
-		ctx,
-		client,
-		s3credentials.AccessKeyIDReference,
-		namespace,
-	)
-	if accessKeyErr != nil {
-		return nil, accessKeyErr
-	}
-
-	// Get secret access key
-	if s3credentials.SecretAccessKeyReference == nil {
-		return nil, fmt.Errorf("missing secret access key")
-	}
-	secretAccessKey, secretAccessErr := extractValueFromSecret(
-		ctx,
-		client,
-		s3credentials.SecretAccessKeyReference,
-		namespace,
-	)
-	if secretAccessErr != nil {
-		return nil, secretAccessErr
-	}
-
-	if s3credentials.RegionReference != nil {
-		region, regionErr := extractValueFromSecret(
-			ctx,
-			client,
-			s3credentials.RegionReference,
-			namespace,
-		)
-		if regionErr != nil {
-			return nil, regionErr
-		}
-		env = append(env, fmt.Sprintf("AWS_DEFAULT_REGION=%s", region))
-	}
-
-	// Get session token secret
-	if s3credentials.SessionToken != nil {
-		sessionKey, sessErr := extractValueFromSecret(
-			ctx,
-			client,
-			s3credentials.SessionToken,
-			namespace,
-		)
-		if sessErr != nil {
-			return nil, sessErr
-		}
-		env = append(env, fmt.Sprintf("AWS_SESSION_TOKEN=%s", sessionKey))
+	env, err := envSetTLSConfiguration(ctx, c, namespace, configuration, env, certificatesLocation)
+	if err != nil {
+		return nil, err
 	}
-
-	env = append(env, fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", accessKeyID))
-	env = append(env, fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", secretAccessKey))
-
-	return env, nil
+	return envSetCloudCredentials(ctx, c, namespace, configuration, env, scratchDirectory)
 }
 
-// envSetAzureCredentials sets the Azure environment variables given the configuration
-// inside the cluster
-func envSetAzureCredentials(
+// envSetTLSConfiguration sets the environment variables needed to apply the
+// client-side TLS configuration, storing the CA bundle in the certificates
+// location
+func envSetTLSConfiguration(
 	ctx context.Context,
 	c client.Client,
 	namespace string,
 	configuration *barmanApi.BarmanObjectStoreConfiguration,
 	env []string,
+	certificatesLocation string,
 ) ([]string, error) {
-	// check if Azure credentials are defined
-	if configuration.Azure == nil {
-		return nil, fmt.Errorf("missing Azure credentials")
-	}
-
-	if configuration.Azure.InheritFromAzureAD {
-		return env, nil
-	}
-
-	if configuration.Azure.UseDefaultAzureCredentials {
-		return env, nil
+	tls := configuration.TLS
+	if tls == nil {
+		tls = &barmanApi.TLSConfiguration{}
 	}
 
-	// Get storage account name
-	if configuration.Azure.StorageAccount != nil {
-		storageAccount, err := extractValueFromSecret(
-			ctx,
-			c,
-			configuration.Azure.StorageAccount,
-			namespace,
-		)
-		if err != nil {
+	if tls.CA != nil {
+		if err := writeSecretValueToFile(ctx, c, namespace, tls.CA, certificatesLocation); err != nil {
 			return nil, err
 		}
-		env = append(env, fmt.Sprintf("AZURE_STORAGE_ACCOUNT=%s", storageAccount))
 	}
 
-	// Get the storage key
-	if configuration.Azure.StorageKey != nil {
-		storageKey, err := extractValueFromSecret(
-			ctx,
-			c,
-			configuration.Azure.StorageKey,
-			namespace,
-		)
-		if err != nil {
-			return nil, err
-		}
-		env = append(env, fmt.Sprintf("AZURE_STORAGE_KEY=%s", storageKey))
+	if configuration.EndpointCA != nil || tls.CA != nil {
+		env = append(env, fmt.Sprintf("%s=%s", caBundleEnvVar(configuration.BarmanCredentials), certificatesLocation))
 	}
 
-	// Get the SAS token
-	if configuration.Azure.StorageSasToken != nil {
-		storageSasToken, err := extractValueFromSecret(
-			ctx,
-			c,
-			configuration.Azure.StorageSasToken,
-			namespace,
-		)
-		if err != nil {
-			return nil, err
-		}
-		env = append(env, fmt.Sprintf("AZURE_STORAGE_SAS_TOKEN=%s", storageSasToken))
-	}
+	return env, nil
+}
 
-	if configuration.Azure.ConnectionString != nil {
-		connString, err := extractValueFromSecret(
-			ctx,
-			c,
-			configuration.Azure.ConnectionString,
-			namespace,
-		)
-		if err != nil {
-			return nil, err
-		}
-		env = append(env, fmt.Sprintf("AZURE_STORAGE_CONNECTION_STRING=%s", connString))
+// caBundleEnvVar gets the name of the variable used by the SDK
+// of the cloud provider to locate the CA bundle
+func caBundleEnvVar(credentials barmanApi.BarmanCredentials) string {
+	if credentials.AWS != nil {
+		return awsCABundleEnvVar
 	}
-
-	return env, nil
+	return requestsCABundleEnvVar
 }
 
-func envSetGoogleCredentials(
+// writeSecretValueToFile stores the content of a secret key in the passed file
+func writeSecretValueToFile(
 	ctx context.Context,
 	c client.Client,
 	namespace string,
-	googleCredentials *barmanApi.GoogleCredentials,
-	env []string,
-) ([]string, error) {
-	var applicationCredentialsContent []byte
-
-	if googleCredentials.GKEEnvironment &&
-		googleCredentials.ApplicationCredentials == nil {
-		return env, reconcileGoogleCredentials(googleCredentials, applicationCredentialsContent)
-	}
-
-	applicationCredentialsContent, err := extractValueFromSecret(
-		ctx,
-		c,
-		googleCredentials.ApplicationCredentials,
-		namespace,
-	)
+	secretReference *machineryapi.SecretKeySelector,
+	fileName string,
+) error {
+	content, err := extractValueFromSecret(ctx, c, secretReference, namespace)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if err := reconcileGoogleCredentials(googleCredentials, applicationCredentialsContent); err != nil {
-		return nil, err
+	if _, err := fileutils.WriteFileAtomic(fileName, content, 0o600); err != nil {
+		return fmt.Errorf("while writing %s: %w", fileName, err)
 	}
 
-	env = append(env, "GOOGLE_APPLICATION_CREDENTIALS=/controller/.application_credentials.json")
-
-	return env, nil
+	return nil
 }
 
-func reconcileGoogleCredentials(
-	googleCredentials *barmanApi.GoogleCredentials,
-	applicationCredentialsContent []byte,
-) error {
-	credentialsPath := "/controller/.application_credentials.json"
-
-	if googleCredentials == nil {
-		return fileutils.RemoveFile(credentialsPath)
+// envSetCloudCredentials sets the environment variables of the cloud provider
+// given the configuration inside the cluster
+func envSetCloudCredentials(
+	ctx context.Context,
+	c client.Client,
+	namespace string,
+	configuration *barmanApi.BarmanObjectStoreConfiguration,
+	env []string,
+	scratchDirectory string,
+) (envs []string, err error) {
+	provider := barmanApi.DetectCloudProvider(configuration)
+	if provider == nil {
+		return nil, fmt.Errorf("ObjectStoreConfiguration invalid: no credentials defined")
 	}
 
-	_, err := fileutils.WriteFileAtomic(credentialsPath, applicationCredentialsContent, 0o600)
-
-	return err
+	return provider.EnvSet(ctx, configuration, env, barmanApi.EnvOptions{
+		GetSecretValue: func(ctx context.Context, reference *machineryapi.SecretKeySelector) ([]byte, error) {
+			return extractValueFromSecret(ctx, c, reference, namespace)
+		},
+		ScratchDirectory: scratchDirectory,
+	})
 }
 
 func extractValueFromSecret(
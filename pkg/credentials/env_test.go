@@ -0,0 +1,207 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	machineryapi "github.com/cloudnative-pg/machinery/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSecretsClient is a Kubernetes client only
+// implementing the Get method for secrets
+type fakeSecretsClient struct {
+	client.Client
+
+	secrets map[string]map[string][]byte
+}
+
+func (c *fakeSecretsClient) Get(
+	_ context.Context,
+	key client.ObjectKey,
+	obj client.Object,
+	_ ...client.GetOption,
+) error {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return fmt.Errorf("unsupported object type %T", obj)
+	}
+
+	data, found := c.secrets[key.Name]
+	if !found {
+		return apierrs.NewNotFound(corev1.Resource("secrets"), key.Name)
+	}
+
+	secret.Name = key.Name
+	secret.Namespace = key.Namespace
+	secret.Data = data
+	return nil
+}
+
+func secretKey(name, key string) *machineryapi.SecretKeySelector {
+	return &machineryapi.SecretKeySelector{
+		LocalObjectReference: machineryapi.LocalObjectReference{Name: name},
+		Key:                  key,
+	}
+}
+
+var _ = Describe("S3 credentials", func() {
+	var (
+		c                *fakeSecretsClient
+		scratchDirectory string
+	)
+
+	BeforeEach(func() {
+		c = &fakeSecretsClient{
+			secrets: map[string]map[string][]byte{
+				"aws": {
+					"ACCESS_KEY_ID":     []byte("access-key"),
+					"ACCESS_SECRET_KEY": []byte("secret-key"),
+				},
+			},
+		}
+		scratchDirectory = GinkgoT().TempDir()
+	})
+
+	It("passes the static keys along with the S3 options to the process", func(ctx SpecContext) {
+		configuration := &barmanApi.BarmanObjectStoreConfiguration{
+			DestinationPath: "s3://bucket/path",
+			EndpointURL:     "https://minio.example.com",
+			BarmanCredentials: barmanApi.BarmanCredentials{
+				AWS: &barmanApi.S3Credentials{
+					AccessKeyIDReference:     secretKey("aws", "ACCESS_KEY_ID"),
+					SecretAccessKeyReference: secretKey("aws", "ACCESS_SECRET_KEY"),
+					AddressingStyle:          barmanApi.S3AddressingStylePath,
+					SignatureVersion:         barmanApi.S3SignatureVersionV4,
+				},
+			},
+		}
+
+		env, err := EnvSetCloudCredentialsInDirectory(
+			ctx, c, "default", configuration, nil, "", scratchDirectory)
+		Expect(err).ToNot(HaveOccurred())
+
+		awsConfigFile := filepath.Join(scratchDirectory, ".aws_config")
+		Expect(env).To(ContainElements(
+			"AWS_ACCESS_KEY_ID=access-key",
+			"AWS_SECRET_ACCESS_KEY=secret-key",
+			"AWS_CONFIG_FILE="+awsConfigFile,
+		))
+
+		// The options are in the default profile, as selecting a profile
+		// would make botocore ignore the keys set in the environment
+		content, err := os.ReadFile(awsConfigFile) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal(
+			"[default]\ns3 =\n    addressing_style = path\n    signature_version = s3v4\n"))
+
+		cmd := exec.CommandContext(ctx, "env")
+		cmd.Env = env
+		output, err := cmd.Output()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(output)).To(ContainSubstring("AWS_ACCESS_KEY_ID=access-key\n"))
+		Expect(string(output)).To(ContainSubstring("AWS_SECRET_ACCESS_KEY=secret-key\n"))
+	})
+
+	It("doesn't write the AWS configuration file when not needed", func(ctx SpecContext) {
+		configuration := &barmanApi.BarmanObjectStoreConfiguration{
+			DestinationPath: "s3://bucket/path",
+			BarmanCredentials: barmanApi.BarmanCredentials{
+				AWS: &barmanApi.S3Credentials{
+					InheritFromIAMRole: true,
+					RegionName:         "eu-central-1",
+				},
+			},
+		}
+
+		env, err := EnvSetCloudCredentialsInDirectory(
+			ctx, c, "default", configuration, nil, "", scratchDirectory)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env).To(Equal([]string{"AWS_DEFAULT_REGION=eu-central-1"}))
+		Expect(filepath.Join(scratchDirectory, ".aws_config")).ToNot(BeAnExistingFile())
+	})
+})
+
+var _ = Describe("Google credentials", func() {
+	It("writes the application credentials in the scratch directory", func(ctx SpecContext) {
+		c := &fakeSecretsClient{
+			secrets: map[string]map[string][]byte{
+				"gcs": {"credentials.json": []byte("{}")},
+			},
+		}
+		scratchDirectory := GinkgoT().TempDir()
+		configuration := &barmanApi.BarmanObjectStoreConfiguration{
+			DestinationPath: "gs://bucket/path",
+			BarmanCredentials: barmanApi.BarmanCredentials{
+				Google: &barmanApi.GoogleCredentials{
+					ApplicationCredentials: secretKey("gcs", "credentials.json"),
+				},
+			},
+		}
+
+		env, err := EnvSetCloudCredentialsInDirectory(
+			ctx, c, "default", configuration, nil, "", scratchDirectory)
+		Expect(err).ToNot(HaveOccurred())
+
+		credentialsFile := filepath.Join(scratchDirectory, ".application_credentials.json")
+		Expect(env).To(Equal([]string{"GOOGLE_APPLICATION_CREDENTIALS=" + credentialsFile}))
+		Expect(os.ReadFile(credentialsFile)).To(Equal([]byte("{}"))) // #nosec G304
+	})
+})
+
+var _ = Describe("TLS configuration", func() {
+	It("stores the CA bundle and points the SDK of the cloud provider to it", func(ctx SpecContext) {
+		c := &fakeSecretsClient{
+			secrets: map[string]map[string][]byte{
+				"gcs": {"credentials.json": []byte("{}")},
+				"tls": {"ca.crt": []byte("ca-bundle")},
+			},
+		}
+		scratchDirectory := GinkgoT().TempDir()
+		caLocation := filepath.Join(scratchDirectory, BarmanBackupEndpointCACertificateFileName)
+		configuration := &barmanApi.BarmanObjectStoreConfiguration{
+			DestinationPath: "gs://bucket/path",
+			TLS:             &barmanApi.TLSConfiguration{CA: secretKey("tls", "ca.crt")},
+			BarmanCredentials: barmanApi.BarmanCredentials{
+				Google: &barmanApi.GoogleCredentials{
+					ApplicationCredentials: secretKey("gcs", "credentials.json"),
+				},
+			},
+		}
+
+		env, err := EnvSetCloudCredentialsInDirectory(
+			ctx, c, "default", configuration, nil, caLocation, scratchDirectory)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env).To(ContainElement("REQUESTS_CA_BUNDLE=" + caLocation))
+		Expect(os.ReadFile(caLocation)).To(Equal([]byte("ca-bundle"))) // #nosec G304
+	})
+})
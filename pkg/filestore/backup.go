@@ -0,0 +1,380 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package filestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+// backupInfoTimeLayout is the format used by barman
+// to store timestamps inside the backup.info file
+const backupInfoTimeLayout = "2006-01-02 15:04:05.999999999-07:00"
+
+// backupInfoNone is the value used by barman for empty fields
+const backupInfoNone = "None"
+
+// GetBackupList reads the backup catalog from the store
+func (store *Store) GetBackupList() (*catalog.Catalog, error) {
+	entries, err := os.ReadDir(filepath.Join(store.serverDirectory, basePrefix))
+	if os.IsNotExist(err) {
+		return catalog.NewCatalog(nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while listing base backups: %w", err)
+	}
+
+	var list []catalog.BarmanBackup
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		backup, err := store.readBackupInfo(entry.Name())
+		if os.IsNotExist(err) {
+			// Backups without a backup.info file are still being uploaded
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, *backup)
+	}
+
+	return catalog.NewCatalog(list), nil
+}
+
+// GetBackup gets a backup from the store, given its ID or name.
+// ErrObjectNotFound is returned if there is no such backup.
+func (store *Store) GetBackup(backupIDOrName string) (*catalog.BarmanBackup, error) {
+	backupList, err := store.GetBackupList()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range backupList.List {
+		backup := &backupList.List[idx]
+		if backup.ID == backupIDOrName || backup.BackupName == backupIDOrName {
+			return backup, nil
+		}
+	}
+
+	return nil, fmt.Errorf("backup %q: %w", backupIDOrName, ErrObjectNotFound)
+}
+
+// DeleteBackup removes a base backup from the store
+func (store *Store) DeleteBackup(backupID string) error {
+	if backupID == "" || strings.ContainsAny(backupID, `/\`) || backupID == ".." {
+		return fmt.Errorf("invalid backup ID %q", backupID)
+	}
+
+	backupDirectory := filepath.Join(store.serverDirectory, basePrefix, backupID)
+	if _, err := os.Stat(backupDirectory); os.IsNotExist(err) {
+		return fmt.Errorf("backup %q: %w", backupID, ErrObjectNotFound)
+	}
+
+	if err := os.RemoveAll(backupDirectory); err != nil {
+		return fmt.Errorf("while deleting backup %q: %w", backupID, err)
+	}
+
+	return nil
+}
+
+// DeleteBackupsByPolicy enforces a recovery window retention policy, such as "30d",
+// as barman-cloud-backup-delete does. The backups ended inside the recovery
+// window are kept, together with the latest one ended before it, and the WAL
+// files which are not needed by the remaining backups are removed.
+// Kept backups are never removed: the ones kept with the full target also
+// retain every following WAL file, while the standalone ones only retain
+// the WAL files needed to make them consistent.
+func (store *Store) DeleteBackupsByPolicy(retentionPolicy string, now time.Time) error {
+	windowStart, err := utils.RecoveryWindowStart(retentionPolicy, now)
+	if err != nil {
+		return err
+	}
+
+	backupList, err := store.GetBackupList()
+	if err != nil {
+		return err
+	}
+
+	// The catalog is sorted by time, so we walk it from the latest backup
+	// and keep everything until the first one ended before the window
+	var oldestKept *catalog.BarmanBackup
+	var keptFull []*catalog.BarmanBackup
+	var standaloneRanges []walRange
+	for idx := len(backupList.List) - 1; idx >= 0; idx-- {
+		backup := &backupList.List[idx]
+		if backup.IsKept() {
+			switch {
+			case backup.BeginWal == "" || !backup.IsDone():
+			case backup.Keep == catalog.KeepTargetStandalone:
+				standaloneRanges = append(standaloneRanges, walRange{first: backup.BeginWal, last: backup.EndWal})
+			default:
+				keptFull = append(keptFull, backup)
+			}
+			continue
+		}
+
+		if oldestKept != nil && oldestKept.EndTime.Before(windowStart) {
+			if err := store.DeleteBackup(backup.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if backup.BeginWal != "" && backup.IsDone() {
+			oldestKept = backup
+		}
+	}
+
+	if oldestKept == nil {
+		return nil
+	}
+
+	firstNeededWAL := oldestKept.BeginWal
+	for _, backup := range keptFull {
+		firstNeededWAL = min(firstNeededWAL, backup.BeginWal)
+	}
+
+	return store.deleteWALsBefore(firstNeededWAL, standaloneRanges)
+}
+
+// walRange is an inclusive range of WAL files
+type walRange struct {
+	first string
+	last  string
+}
+
+// contains checks if a WAL file is inside the range
+func (r walRange) contains(walName string) bool {
+	return walName >= r.first && (r.last == "" || walName <= r.last)
+}
+
+// deleteWALsBefore removes the WAL files preceding the passed one,
+// except the ones inside the protected ranges.
+// History files are always kept.
+func (store *Store) deleteWALsBefore(walName string, protectedRanges []walRange) error {
+	walPaths, err := store.listWALs()
+	if err != nil {
+		return err
+	}
+
+	for _, walPath := range walPaths {
+		name := filepath.Base(walPath)
+		if len(name) < 24 || !wal.IsSegment(name[:24]) {
+			continue
+		}
+
+		if name[:24] >= walName {
+			continue
+		}
+
+		if slices.ContainsFunc(protectedRanges, func(r walRange) bool { return r.contains(name[:24]) }) {
+			continue
+		}
+
+		if err := os.Remove(walPath); err != nil {
+			return fmt.Errorf("while deleting WAL file %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// readBackupInfo parses the backup.info file of a base backup
+func (store *Store) readBackupInfo(backupID string) (*catalog.BarmanBackup, error) {
+	f, err := os.Open(filepath.Join(store.serverDirectory, basePrefix, backupID, backupInfoFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if value == backupInfoNone {
+			value = ""
+		}
+		if !strings.HasPrefix(value, "[") {
+			value = strings.Trim(value, `'"`)
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("while reading backup info of %q: %w", backupID, err)
+	}
+
+	backup := &catalog.BarmanBackup{
+		ID:             backupID,
+		BackupName:     values["backup_name"],
+		Label:          values["backup_label"],
+		BeginWal:       values["begin_wal"],
+		EndWal:         values["end_wal"],
+		BeginLSN:       values["begin_xlog"],
+		EndLSN:         values["end_xlog"],
+		SystemID:       values["systemid"],
+		Error:          values["error"],
+		Status:         catalog.BackupStatus(values["status"]),
+		BackupType:     values["backup_type"],
+		ParentBackupID: values["parent_backup_id"],
+		Mode:           values["mode"],
+		Compression:    values["compression"],
+		ServerName:     values["server_name"],
+	}
+
+	integerFields := []struct {
+		key   string
+		value *int64
+	}{
+		{key: "size", value: &backup.Size},
+		{key: "deduplicated_size", value: &backup.DeduplicatedSize},
+		{key: "xlog_segment_size", value: &backup.WALSegmentSize},
+	}
+	for _, field := range integerFields {
+		if values[field.key] == "" {
+			continue
+		}
+		if *field.value, err = strconv.ParseInt(values[field.key], 10, 64); err != nil {
+			return nil, fmt.Errorf("while parsing %s of backup %q: %w", field.key, backupID, err)
+		}
+	}
+
+	if timeline := values["timeline"]; timeline != "" {
+		if backup.TimeLine, err = strconv.Atoi(timeline); err != nil {
+			return nil, fmt.Errorf("while parsing timeline of backup %q: %w", backupID, err)
+		}
+	}
+
+	if version := values["version"]; version != "" {
+		if backup.Version, err = strconv.Atoi(version); err != nil {
+			return nil, fmt.Errorf("while parsing version of backup %q: %w", backupID, err)
+		}
+	}
+
+	if backup.ChildrenBackupIDs, err = parsePythonList[string](values["children_backup_ids"]); err != nil {
+		return nil, fmt.Errorf("while parsing children of backup %q: %w", backupID, err)
+	}
+
+	if backup.Tablespaces, err = parsePythonList[catalog.BarmanTablespace](values["tablespaces"]); err != nil {
+		return nil, fmt.Errorf("while parsing tablespaces of backup %q: %w", backupID, err)
+	}
+
+	if backup.Keep, err = store.readKeepAnnotation(backupID); err != nil {
+		return nil, err
+	}
+
+	if backup.BeginTime, err = parseBackupInfoTime(values["begin_time"]); err != nil {
+		return nil, fmt.Errorf("while parsing begin time of backup %q: %w", backupID, err)
+	}
+	if backup.EndTime, err = parseBackupInfoTime(values["end_time"]); err != nil {
+		return nil, fmt.Errorf("while parsing end time of backup %q: %w", backupID, err)
+	}
+
+	if !backup.BeginTime.IsZero() {
+		backup.BeginTimeISOString = backup.BeginTime.Format(time.RFC3339)
+	}
+	if !backup.EndTime.IsZero() {
+		backup.EndTimeISOString = backup.EndTime.Format(time.RFC3339)
+	}
+
+	return backup, nil
+}
+
+func parseBackupInfoTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(backupInfoTimeLayout, value)
+}
+
+// parsePythonList parses a list of strings, numbers or tuples written by
+// barman using the Python syntax, such as [('tbs1', 16387, '/data/tbs1')]
+func parsePythonList[T any](value string) ([]T, error) {
+	if value == "" || value == "[]" {
+		return nil, nil
+	}
+
+	var result []T
+	if err := json.Unmarshal([]byte(pythonLiteralToJSON(value)), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// pythonLiteralToJSON converts a Python literal made of lists, tuples,
+// strings and numbers to JSON
+func pythonLiteralToJSON(value string) string {
+	var result strings.Builder
+	var quote rune
+	escaped := false
+	for _, c := range value {
+		switch {
+		case quote != 0 && escaped:
+			if c == '\'' {
+				result.WriteRune(c)
+			} else {
+				result.WriteRune('\\')
+				result.WriteRune(c)
+			}
+			escaped = false
+		case quote != 0 && c == '\\':
+			escaped = true
+		case quote != 0 && c == quote:
+			result.WriteRune('"')
+			quote = 0
+		case quote != 0 && c == '"':
+			result.WriteString(`\"`)
+		case quote != 0:
+			result.WriteRune(c)
+		case c == '\'' || c == '"':
+			result.WriteRune('"')
+			quote = c
+		case c == '(':
+			result.WriteRune('[')
+		case c == ')':
+			result.WriteRune(']')
+		default:
+			result.WriteRune(c)
+		}
+	}
+	return result.String()
+}
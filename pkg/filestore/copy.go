@@ -0,0 +1,138 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package filestore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrObjectAlreadyExists is returned when the object
+	// to be written is already contained in the store
+	ErrObjectAlreadyExists = errors.New("object already exists")
+
+	// ErrChecksumMismatch is returned when a copied file
+	// differs from the original one
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// CopyBackup copies a base backup into another store, verifying the checksum
+// of every copied file. The backup.info file is copied last, so the backup
+// only appears in the catalog of the destination store once complete.
+func (store *Store) CopyBackup(backupID string, destination *Store) error {
+	backup, err := store.GetBackup(backupID)
+	if err != nil {
+		return err
+	}
+	if backup.ID != backupID {
+		return fmt.Errorf("backup %q: %w", backupID, ErrObjectNotFound)
+	}
+
+	sourceDirectory := filepath.Join(store.serverDirectory, basePrefix, backupID)
+	destinationDirectory := filepath.Join(destination.serverDirectory, basePrefix, backupID)
+	if _, err := os.Stat(filepath.Join(destinationDirectory, backupInfoFileName)); err == nil {
+		return fmt.Errorf("backup %q: %w", backupID, ErrObjectAlreadyExists)
+	}
+
+	err = filepath.WalkDir(sourceDirectory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(sourceDirectory, path)
+		if err != nil {
+			return err
+		}
+		if relativePath == backupInfoFileName {
+			return nil
+		}
+
+		return copyFileVerified(path, filepath.Join(destinationDirectory, relativePath))
+	})
+	if err != nil {
+		return fmt.Errorf("while copying backup %q: %w", backupID, err)
+	}
+
+	if err := copyFileVerified(
+		filepath.Join(sourceDirectory, backupInfoFileName),
+		filepath.Join(destinationDirectory, backupInfoFileName),
+	); err != nil {
+		return fmt.Errorf("while copying backup %q: %w", backupID, err)
+	}
+
+	return nil
+}
+
+// copyFileVerified copies a file, checking that the written
+// content has the same checksum as the original one
+func copyFileVerified(sourcePath string, destinationPath string) error {
+	source, err := os.Open(filepath.Clean(sourcePath))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+
+	sourceHash := sha256.New()
+	err = writeFileAtomic(destinationPath, func(f *os.File) error {
+		_, err := io.Copy(f, io.TeeReader(source, sourceHash))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	destinationHash, err := fileChecksum(destinationPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sourceHash.Sum(nil), destinationHash) {
+		_ = os.Remove(destinationPath)
+		return fmt.Errorf("%s: %w", destinationPath, ErrChecksumMismatch)
+	}
+
+	return nil
+}
+
+// fileChecksum gets the SHA-256 checksum of a file
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
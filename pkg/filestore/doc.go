@@ -0,0 +1,30 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package filestore implements an object store backed by a local directory,
+// using the same layout barman-cloud uses inside a bucket. It is selected by
+// destination paths using the file:// scheme and is meant for tests and
+// air-gapped deployments without access to a cloud provider.
+//
+// WAL files are archived and restored natively, and the backup catalog can be
+// read from the backup.info files stored in the directory. Base backups are
+// out of scope: they are not taken, ErrBackupNotSupported being returned when
+// building the barman-cloud-backup command line, and are expected to be copied
+// into the directory by other means.
+package filestore
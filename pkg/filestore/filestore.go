@@ -0,0 +1,192 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+)
+
+const (
+	// basePrefix is the directory, inside the server one, containing the base backups
+	basePrefix = "base"
+
+	// walsPrefix is the directory, inside the server one, containing the WAL archive
+	walsPrefix = "wals"
+
+	// backupInfoFileName is the name of the file describing a base backup
+	backupInfoFileName = "backup.info"
+
+	// tempSuffix is appended to files that are being written
+	tempSuffix = ".tmp"
+)
+
+// ErrObjectNotFound is returned when the requested object is not
+// contained in the store
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrBackupNotSupported is returned when a base backup is requested
+// on the local filesystem object store, which only archives WAL files
+var ErrBackupNotSupported = errors.New("base backups are not supported by the local filesystem object store")
+
+// compressionSuffixes maps every compression algorithm to the suffix
+// barman-cloud appends to the compressed WAL files
+var compressionSuffixes = map[api.CompressionType]string{
+	api.CompressionTypeNone:   "",
+	api.CompressionTypeGzip:   ".gz",
+	api.CompressionTypeBzip2:  ".bz2",
+	api.CompressionTypeLz4:    ".lz4",
+	api.CompressionTypeSnappy: ".snappy",
+	api.CompressionTypeXz:     ".xz",
+	api.CompressionTypeZstd:   ".zst",
+}
+
+// Store is an object store backed by a local directory
+type Store struct {
+	// The directory containing the data of the server
+	serverDirectory string
+
+	// The compression to be applied to the archived WAL files
+	compression api.CompressionType
+}
+
+// New creates a new store for a server, given a destination
+// path using the file:// scheme
+func New(destinationPath string, serverName string) (*Store, error) {
+	directory, err := api.ParseFileDestinationPath(destinationPath)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing destination path %q: %w", destinationPath, err)
+	}
+
+	if serverName == "" || strings.ContainsAny(serverName, `/\`) || serverName == ".." {
+		return nil, fmt.Errorf("invalid server name %q", serverName)
+	}
+
+	return &Store{
+		serverDirectory: filepath.Join(directory, serverName),
+	}, nil
+}
+
+// NewFromConfiguration creates a new store for a server, given
+// an object store configuration
+func NewFromConfiguration(
+	configuration *api.BarmanObjectStoreConfiguration,
+	serverName string,
+) (*Store, error) {
+	store, err := New(configuration.DestinationPath, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	if configuration.Wal != nil {
+		if err := store.setCompression(configuration.Wal.Compression); err != nil {
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// NewFromOptions creates a new store from the command-line options of a
+// barman-cloud command, where the destination path is followed by the server
+// name. A nil store is returned when the options don't target a local directory.
+func NewFromOptions(options []string) (*Store, error) {
+	for idx, option := range options {
+		if !api.IsFileDestinationPath(option) {
+			continue
+		}
+
+		if idx+1 >= len(options) {
+			return nil, fmt.Errorf("missing server name after destination path %q", option)
+		}
+
+		store, err := New(option, options[idx+1])
+		if err != nil {
+			return nil, err
+		}
+
+		for compression := range compressionSuffixes {
+			if compression != api.CompressionTypeNone && slices.Contains(options[:idx], "--"+string(compression)) {
+				if err := store.setCompression(compression); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return store, nil
+	}
+
+	return nil, nil
+}
+
+// ServerDirectory gets the directory containing the data of the server
+func (store *Store) ServerDirectory() string {
+	return store.serverDirectory
+}
+
+func (store *Store) setCompression(compression api.CompressionType) error {
+	if compression != api.CompressionTypeNone && compression != api.CompressionTypeGzip {
+		return fmt.Errorf("compression %q is not supported by the local filesystem object store", compression)
+	}
+
+	store.compression = compression
+	return nil
+}
+
+// writeFileAtomic writes a file using a temporary file which is
+// renamed to the final name only when the content is complete
+func writeFileAtomic(fileName string, write func(f *os.File) error) (err error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0o750); err != nil {
+		return err
+	}
+
+	tempFileName := fileName + tempSuffix
+	f, err := os.OpenFile(filepath.Clean(tempFileName), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tempFileName)
+		}
+	}()
+
+	if err = write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFileName, fileName)
+}
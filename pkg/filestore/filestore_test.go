@@ -0,0 +1,395 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package filestore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func writeBackupInfo(store *Store, backupID string, content string) {
+	directory := filepath.Join(store.ServerDirectory(), basePrefix, backupID)
+	Expect(os.MkdirAll(directory, 0o750)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(directory, backupInfoFileName), []byte(content), 0o600)).To(Succeed())
+}
+
+func backupInfo(name string, beginWal string, beginTime string, endTime string) string {
+	return "backup_label='START WAL LOCATION: 0/2000028'\n" +
+		"backup_name=" + name + "\n" +
+		"begin_time=" + beginTime + "\n" +
+		"begin_wal=" + beginWal + "\n" +
+		"begin_xlog=0/2000028\n" +
+		"end_time=" + endTime + "\n" +
+		"end_wal=" + beginWal + "\n" +
+		"end_xlog=0/2000100\n" +
+		"error=None\n" +
+		"status=DONE\n" +
+		"systemid=7143486741397123456\n" +
+		"timeline=1\n"
+}
+
+var _ = Describe("Store creation", func() {
+	It("creates a store from a destination path", func() {
+		store, err := New("file:///var/lib/backups/", "cluster-example")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.ServerDirectory()).To(Equal("/var/lib/backups/cluster-example"))
+	})
+
+	It("rejects invalid destination paths and server names", func() {
+		_, err := New("s3://bucket", "cluster-example")
+		Expect(err).To(HaveOccurred())
+
+		_, err = New("file:///var/lib/backups", "../other")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("creates a store from the options of a barman-cloud command", func() {
+		store, err := NewFromOptions([]string{"--gzip", "file:///backups", "cluster-example", "000000010000000000000001"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store).ToNot(BeNil())
+		Expect(store.ServerDirectory()).To(Equal("/backups/cluster-example"))
+		Expect(store.compression).To(Equal(api.CompressionTypeGzip))
+
+		store, err = NewFromOptions([]string{"--cloud-provider", "aws-s3", "s3://bucket", "cluster-example"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store).To(BeNil())
+	})
+
+	It("refuses unsupported compression algorithms", func() {
+		_, err := NewFromOptions([]string{"--bzip2", "file:///backups", "cluster-example"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("WAL archive", func() {
+	const walName = "000000010000000000000002"
+
+	var (
+		tmpDir string
+		pgWal  string
+	)
+
+	BeforeEach(func() {
+		tmpDir = GinkgoT().TempDir()
+		pgWal = filepath.Join(tmpDir, "pg_wal")
+		Expect(os.MkdirAll(pgWal, 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(pgWal, walName), []byte("wal content"), 0o600)).To(Succeed())
+	})
+
+	DescribeTable("archives and restores WAL files",
+		func(compression api.CompressionType, suffix string) {
+			store, err := NewFromConfiguration(&api.BarmanObjectStoreConfiguration{
+				DestinationPath: "file://" + filepath.Join(tmpDir, "backups"),
+				Wal:             &api.WalBackupConfiguration{Compression: compression},
+			}, "cluster-example")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(store.CheckWalArchive(0)).To(Succeed())
+			Expect(store.ArchiveWAL(filepath.Join(pgWal, walName))).To(Succeed())
+			Expect(filepath.Join(store.ServerDirectory(), walsPrefix, walName[:16], walName+suffix)).To(BeAnExistingFile())
+			Expect(store.CheckWalArchive(0)).ToNot(Succeed())
+
+			destination := filepath.Join(tmpDir, "RECOVERYXLOG")
+			Expect(store.RestoreWAL(walName, destination)).To(Succeed())
+			Expect(os.ReadFile(destination)).To(BeEquivalentTo("wal content"))
+		},
+		Entry("without compression", api.CompressionTypeNone, ""),
+		Entry("with gzip", api.CompressionTypeGzip, ".gz"),
+	)
+
+	It("stores history files in the root of the WAL archive", func() {
+		store, err := New("file://"+filepath.Join(tmpDir, "backups"), "cluster-example")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.WriteFile(filepath.Join(pgWal, "00000002.history"), []byte("1\t0/3000000\tno recovery target"), 0o600)).
+			To(Succeed())
+		Expect(store.ArchiveWAL(filepath.Join(pgWal, "00000002.history"))).To(Succeed())
+		Expect(filepath.Join(store.ServerDirectory(), walsPrefix, "00000002.history")).To(BeAnExistingFile())
+	})
+
+	It("accepts the WAL files of the preceding timelines", func() {
+		store, err := New("file://"+filepath.Join(tmpDir, "backups"), "cluster-example")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.setCompression(api.CompressionTypeGzip)).To(Succeed())
+
+		Expect(store.ArchiveWAL(filepath.Join(pgWal, walName))).To(Succeed())
+		Expect(store.CheckWalArchive(0)).ToNot(Succeed())
+		Expect(store.CheckWalArchive(1)).ToNot(Succeed())
+		Expect(store.CheckWalArchive(2)).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(pgWal, "00000002.history"), []byte("1\t0/3000000\tno recovery target"), 0o600)).
+			To(Succeed())
+		Expect(store.ArchiveWAL(filepath.Join(pgWal, "00000002.history"))).To(Succeed())
+		Expect(store.CheckWalArchive(2)).ToNot(Succeed())
+		Expect(store.CheckWalArchive(3)).To(Succeed())
+	})
+
+	It("reads the timeline from the options of barman-cloud-check-wal-archive", func() {
+		Expect(TimelineFromOptions([]string{"file:///backups", "cluster-example"})).To(BeZero())
+		Expect(TimelineFromOptions([]string{"--timeline", "2", "file:///backups", "cluster-example"})).
+			To(BeEquivalentTo(2))
+		Expect(TimelineFromOptions([]string{"--timeline=3", "file:///backups", "cluster-example"})).
+			To(BeEquivalentTo(3))
+		_, err := TimelineFromOptions([]string{"--timeline", "latest"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports missing WAL files", func() {
+		store, err := New("file://"+filepath.Join(tmpDir, "backups"), "cluster-example")
+		Expect(err).ToNot(HaveOccurred())
+
+		err = store.RestoreWAL(walName, filepath.Join(tmpDir, "RECOVERYXLOG"))
+		Expect(errors.Is(err, ErrObjectNotFound)).To(BeTrue())
+
+		Expect(store.ArchiveWAL(filepath.Join(pgWal, "not-a-wal"))).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Backup catalog", func() {
+	var store *Store
+
+	BeforeEach(func() {
+		var err error
+		store, err = New("file://"+GinkgoT().TempDir(), "cluster-example")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("returns an empty catalog when there are no backups", func() {
+		backupList, err := store.GetBackupList()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backupList.Len()).To(BeZero())
+	})
+
+	It("reads the backups from the backup.info files", func() {
+		writeBackupInfo(store, "20240102T000000", backupInfo(
+			"None", "000000010000000000000004",
+			"2024-01-02 00:00:00.123456+00:00", "2024-01-02 00:10:00+00:00"))
+		writeBackupInfo(store, "20240101T000000", backupInfo(
+			"'weekly'", "000000010000000000000002",
+			"2024-01-01 00:00:00+00:00", "2024-01-01 00:10:00+00:00"))
+		Expect(os.MkdirAll(filepath.Join(store.ServerDirectory(), basePrefix, "20240103T000000"), 0o750)).
+			To(Succeed())
+
+		backupList, err := store.GetBackupList()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backupList.GetBackupIDs()).To(Equal([]string{"20240101T000000", "20240102T000000"}))
+		Expect(backupList.List[0].BackupName).To(Equal("weekly"))
+		Expect(backupList.List[0].TimeLine).To(Equal(1))
+		Expect(backupList.List[1].BackupName).To(BeEmpty())
+		Expect(backupList.List[1].EndTimeISOString).To(Equal("2024-01-02T00:10:00Z"))
+		Expect(backupList.LatestBackupInfo().ID).To(Equal("20240102T000000"))
+
+		backup, err := store.GetBackup("weekly")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.ID).To(Equal("20240101T000000"))
+
+		_, err = store.GetBackup("missing")
+		Expect(errors.Is(err, ErrObjectNotFound)).To(BeTrue())
+	})
+
+	It("reads the whole backup metadata and the keep annotation", func() {
+		writeBackupInfo(store, "20240101T000000", backupInfo(
+			"None", "000000010000000000000002",
+			"2024-01-01 00:00:00+00:00", "2024-01-01 00:10:00+00:00")+
+			"backup_type=full\n"+
+			"children_backup_ids=['20240102T000000']\n"+
+			"compression=gzip\n"+
+			"deduplicated_size=1024\n"+
+			"size=4096\n"+
+			"tablespaces=[('tbs1', 16387, '/tbs1')]\n"+
+			"version=160002\n"+
+			"xlog_segment_size=16777216\n")
+		keepPath := store.keepAnnotationPath("20240101T000000")
+		Expect(os.MkdirAll(filepath.Dir(keepPath), 0o750)).To(Succeed())
+		Expect(os.WriteFile(keepPath, []byte("full\n"), 0o600)).To(Succeed())
+
+		backup, err := store.GetBackup("20240101T000000")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backup.Status).To(Equal(catalog.BackupStatusDone))
+		Expect(backup.BackupType).To(Equal(catalog.BackupTypeFull))
+		Expect(backup.ChildrenBackupIDs).To(Equal([]string{"20240102T000000"}))
+		Expect(backup.Compression).To(Equal("gzip"))
+		Expect(backup.Size).To(BeEquivalentTo(4096))
+		Expect(backup.DeduplicatedSize).To(BeEquivalentTo(1024))
+		Expect(backup.Version).To(Equal(160002))
+		Expect(backup.WALSegmentSize).To(BeEquivalentTo(16777216))
+		Expect(backup.Tablespaces).To(Equal([]catalog.BarmanTablespace{
+			{Name: "tbs1", OID: 16387, Location: "/tbs1"},
+		}))
+		Expect(backup.Keep).To(Equal(catalog.KeepTargetFull))
+	})
+
+	It("copies a backup into another store", func() {
+		writeBackupInfo(store, "20240101T000000", backupInfo(
+			"None", "000000010000000000000002",
+			"2024-01-01 00:00:00+00:00", "2024-01-01 00:10:00+00:00"))
+		dataPath := filepath.Join(store.ServerDirectory(), basePrefix, "20240101T000000", "data", "data.tar")
+		Expect(os.MkdirAll(filepath.Dir(dataPath), 0o750)).To(Succeed())
+		Expect(os.WriteFile(dataPath, []byte("data"), 0o600)).To(Succeed())
+
+		destination, err := New("file://"+GinkgoT().TempDir(), "cluster-example")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.CopyBackup("20240101T000000", destination)).To(Succeed())
+
+		backupList, err := destination.GetBackupList()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backupList.GetBackupIDs()).To(Equal([]string{"20240101T000000"}))
+		Expect(os.ReadFile(filepath.Join(destination.ServerDirectory(), basePrefix, "20240101T000000",
+			"data", "data.tar"))).To(Equal([]byte("data")))
+
+		err = store.CopyBackup("20240101T000000", destination)
+		Expect(errors.Is(err, ErrObjectAlreadyExists)).To(BeTrue())
+
+		err = store.CopyBackup("20240102T000000", destination)
+		Expect(errors.Is(err, ErrObjectNotFound)).To(BeTrue())
+	})
+
+	It("keeps and releases backups", func() {
+		writeBackupInfo(store, "20240101T000000", backupInfo(
+			"'monthly'", "000000010000000000000002",
+			"2024-01-01 00:00:00+00:00", "2024-01-01 00:10:00+00:00"))
+
+		Expect(store.KeepBackup("monthly", catalog.KeepTargetFull)).To(Succeed())
+		Expect(store.GetKeepStatus("20240101T000000")).To(Equal(catalog.KeepTargetFull))
+
+		Expect(store.KeepBackup("monthly", catalog.KeepTargetStandalone)).To(Succeed())
+		Expect(store.GetKeepStatus("monthly")).To(Equal(catalog.KeepTargetStandalone))
+
+		Expect(store.ReleaseBackup("monthly")).To(Succeed())
+		Expect(store.GetKeepStatus("monthly")).To(BeEmpty())
+		Expect(store.ReleaseBackup("monthly")).To(Succeed())
+
+		err := store.KeepBackup("monthly", "forever")
+		Expect(errors.Is(err, catalog.ErrInvalidKeepTarget)).To(BeTrue())
+
+		err = store.KeepBackup("missing", catalog.KeepTargetFull)
+		Expect(errors.Is(err, ErrObjectNotFound)).To(BeTrue())
+	})
+
+	It("refuses to keep a backup which is not completed", func() {
+		writeBackupInfo(store, "20240101T000000", backupInfo(
+			"None", "000000010000000000000002",
+			"2024-01-01 00:00:00+00:00", "None"))
+
+		err := store.KeepBackup("20240101T000000", catalog.KeepTargetFull)
+		Expect(errors.Is(err, ErrBackupNotDone)).To(BeTrue())
+	})
+
+	It("enforces the retention policy on backups and WAL files", func() {
+		writeBackupInfo(store, "20240101T000000", backupInfo(
+			"None", "000000010000000000000002",
+			"2024-01-01 00:00:00+00:00", "2024-01-01 00:10:00+00:00"))
+		writeBackupInfo(store, "20240105T000000", backupInfo(
+			"None", "000000010000000000000004",
+			"2024-01-05 00:00:00+00:00", "2024-01-05 00:10:00+00:00"))
+		writeBackupInfo(store, "20240110T000000", backupInfo(
+			"None", "000000010000000000000006",
+			"2024-01-10 00:00:00+00:00", "2024-01-10 00:10:00+00:00"))
+
+		walsDirectory := filepath.Join(store.ServerDirectory(), walsPrefix, "0000000100000000")
+		Expect(os.MkdirAll(walsDirectory, 0o750)).To(Succeed())
+		for _, walName := range []string{
+			"000000010000000000000002.gz",
+			"000000010000000000000003.gz",
+			"000000010000000000000004.gz",
+			"000000010000000000000005.gz",
+		} {
+			Expect(os.WriteFile(filepath.Join(walsDirectory, walName), nil, 0o600)).To(Succeed())
+		}
+		historyFile := filepath.Join(store.ServerDirectory(), walsPrefix, "00000001.history")
+		Expect(os.WriteFile(historyFile, nil, 0o600)).To(Succeed())
+
+		now := time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)
+		Expect(store.DeleteBackupsByPolicy("5d", now)).To(Succeed())
+
+		backupList, err := store.GetBackupList()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(backupList.GetBackupIDs()).To(Equal([]string{"20240105T000000", "20240110T000000"}))
+
+		Expect(filepath.Join(walsDirectory, "000000010000000000000003.gz")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(walsDirectory, "000000010000000000000004.gz")).To(BeAnExistingFile())
+		Expect(historyFile).To(BeAnExistingFile())
+	})
+
+	It("deletes a single backup", func() {
+		writeBackupInfo(store, "20240101T000000", backupInfo(
+			"None", "000000010000000000000002",
+			"2024-01-01 00:00:00+00:00", "2024-01-01 00:10:00+00:00"))
+
+		Expect(store.DeleteBackup("20240101T000000")).To(Succeed())
+		Expect(errors.Is(store.DeleteBackup("20240101T000000"), ErrObjectNotFound)).To(BeTrue())
+	})
+
+	DescribeTable("retains the kept backups and their WAL files",
+		func(keep catalog.KeepTarget, retainedWALs []string, removedWALs []string) {
+			writeBackupInfo(store, "20240101T000000", backupInfo(
+				"None", "000000010000000000000002",
+				"2024-01-01 00:00:00+00:00", "2024-01-01 00:10:00+00:00"))
+			writeBackupInfo(store, "20240103T000000", backupInfo(
+				"None", "000000010000000000000004",
+				"2024-01-03 00:00:00+00:00", "2024-01-03 00:10:00+00:00"))
+			writeBackupInfo(store, "20240105T000000", backupInfo(
+				"None", "000000010000000000000006",
+				"2024-01-05 00:00:00+00:00", "2024-01-05 00:10:00+00:00"))
+			writeBackupInfo(store, "20240110T000000", backupInfo(
+				"None", "000000010000000000000008",
+				"2024-01-10 00:00:00+00:00", "2024-01-10 00:10:00+00:00"))
+			Expect(store.KeepBackup("20240101T000000", keep)).To(Succeed())
+
+			walsDirectory := filepath.Join(store.ServerDirectory(), walsPrefix, "0000000100000000")
+			Expect(os.MkdirAll(walsDirectory, 0o750)).To(Succeed())
+			for _, walName := range slices.Concat(retainedWALs, removedWALs) {
+				Expect(os.WriteFile(filepath.Join(walsDirectory, walName), nil, 0o600)).To(Succeed())
+			}
+
+			now := time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)
+			Expect(store.DeleteBackupsByPolicy("5d", now)).To(Succeed())
+
+			backupList, err := store.GetBackupList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backupList.GetBackupIDs()).To(Equal([]string{
+				"20240101T000000", "20240105T000000", "20240110T000000",
+			}))
+			for _, walName := range retainedWALs {
+				Expect(filepath.Join(walsDirectory, walName)).To(BeAnExistingFile())
+			}
+			for _, walName := range removedWALs {
+				Expect(filepath.Join(walsDirectory, walName)).ToNot(BeAnExistingFile())
+			}
+		},
+		Entry("standalone target",
+			catalog.KeepTargetStandalone,
+			[]string{"000000010000000000000002", "000000010000000000000006"},
+			[]string{"000000010000000000000003", "000000010000000000000004"}),
+		Entry("full target",
+			catalog.KeepTargetFull,
+			[]string{"000000010000000000000002", "000000010000000000000003", "000000010000000000000004"},
+			nil),
+	)
+})
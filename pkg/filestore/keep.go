@@ -0,0 +1,121 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+)
+
+const (
+	// annotationsDirectory is the directory, inside a base backup,
+	// where barman stores the annotations of the backup
+	annotationsDirectory = "annotations"
+
+	// keepAnnotation is the annotation marking a backup to be kept
+	keepAnnotation = "keep"
+)
+
+// ErrBackupNotDone is returned when keeping a backup
+// which has not been completed successfully
+var ErrBackupNotDone = errors.New("only completed backups can be kept")
+
+// KeepBackup marks a backup to be kept regardless of the retention
+// policies, as barman-cloud-backup-keep does. Only completed backups
+// can be kept.
+func (store *Store) KeepBackup(backupIDOrName string, target catalog.KeepTarget) error {
+	if !target.IsValid() {
+		return fmt.Errorf("%w: %q", catalog.ErrInvalidKeepTarget, target)
+	}
+
+	backup, err := store.GetBackup(backupIDOrName)
+	if err != nil {
+		return err
+	}
+
+	if !backup.IsDone() {
+		return fmt.Errorf("backup %q: %w", backup.ID, ErrBackupNotDone)
+	}
+
+	err = writeFileAtomic(store.keepAnnotationPath(backup.ID), func(f *os.File) error {
+		_, err := f.WriteString(string(target))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("while writing the keep annotation of backup %q: %w", backup.ID, err)
+	}
+
+	return nil
+}
+
+// ReleaseBackup removes the keep annotation of a backup, making it
+// subject to the retention policies again
+func (store *Store) ReleaseBackup(backupIDOrName string) error {
+	backup, err := store.GetBackup(backupIDOrName)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(store.keepAnnotationPath(backup.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("while removing the keep annotation of backup %q: %w", backup.ID, err)
+	}
+
+	return nil
+}
+
+// GetKeepStatus gets the keep target of a backup, which is
+// empty when the backup is not kept
+func (store *Store) GetKeepStatus(backupIDOrName string) (catalog.KeepTarget, error) {
+	backup, err := store.GetBackup(backupIDOrName)
+	if err != nil {
+		return "", err
+	}
+
+	return backup.Keep, nil
+}
+
+// readKeepAnnotation reads the keep annotation of a backup, which
+// barman stores in the annotations directory of the backup
+func (store *Store) readKeepAnnotation(backupID string) (catalog.KeepTarget, error) {
+	content, err := os.ReadFile(store.keepAnnotationPath(backupID))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("while reading the keep annotation of backup %q: %w", backupID, err)
+	}
+
+	target, err := catalog.ParseKeepTarget(string(content))
+	if err != nil {
+		return "", fmt.Errorf("while reading the keep annotation of backup %q: %w", backupID, err)
+	}
+
+	return target, nil
+}
+
+// keepAnnotationPath gets the path of the keep annotation of a backup
+func (store *Store) keepAnnotationPath(backupID string) string {
+	return filepath.Join(store.serverDirectory, basePrefix, backupID, annotationsDirectory, keepAnnotation)
+}
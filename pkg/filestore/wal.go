@@ -0,0 +1,234 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package filestore
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+// walDirectory gets the directory, relative to the WAL archive, where a WAL
+// file is stored. Following the barman layout, WAL files are grouped by
+// timeline and log id while history files are stored in the root.
+func walDirectory(walName string) (string, error) {
+	name, err := wal.Parse(walName)
+	if err != nil {
+		return "", err
+	}
+	if name.Kind == wal.KindHistory {
+		return "", nil
+	}
+
+	return walName[:16], nil
+}
+
+// walPath gets the path of an archived WAL file, without the compression suffix
+func (store *Store) walPath(walName string) (string, error) {
+	directory, err := walDirectory(walName)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(store.serverDirectory, walsPrefix, directory, walName), nil
+}
+
+// ArchiveWAL copies a WAL file into the WAL archive, compressing it
+// with the configured algorithm
+func (store *Store) ArchiveWAL(walFilePath string) error {
+	walName := filepath.Base(walFilePath)
+	destination, err := store.walPath(walName)
+	if err != nil {
+		return err
+	}
+	destination += compressionSuffixes[store.compression]
+
+	source, err := os.Open(filepath.Clean(walFilePath))
+	if err != nil {
+		return fmt.Errorf("while opening WAL file %q: %w", walFilePath, err)
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+
+	err = writeFileAtomic(destination, func(f *os.File) error {
+		if store.compression != api.CompressionTypeGzip {
+			_, err := io.Copy(f, source)
+			return err
+		}
+
+		writer := gzip.NewWriter(f)
+		if _, err := io.Copy(writer, source); err != nil {
+			_ = writer.Close()
+			return err
+		}
+		return writer.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("while archiving WAL file %q: %w", walName, err)
+	}
+
+	return nil
+}
+
+// RestoreWAL copies a WAL file from the WAL archive to the
+// destination path, decompressing it when needed.
+// ErrObjectNotFound is returned if the WAL file is not archived.
+func (store *Store) RestoreWAL(walName string, destinationPath string) error {
+	source, err := store.walPath(walName)
+	if err != nil {
+		return err
+	}
+
+	for _, compression := range []api.CompressionType{api.CompressionTypeNone, api.CompressionTypeGzip} {
+		f, err := os.Open(filepath.Clean(source + compressionSuffixes[compression]))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("while opening archived WAL file %q: %w", walName, err)
+		}
+
+		err = restoreFile(f, compression, destinationPath)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("while restoring WAL file %q: %w", walName, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("WAL file %q: %w", walName, ErrObjectNotFound)
+}
+
+func restoreFile(source io.Reader, compression api.CompressionType, destinationPath string) error {
+	if compression == api.CompressionTypeGzip {
+		reader, err := gzip.NewReader(source)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		source = reader
+	}
+
+	return writeFileAtomic(destinationPath, func(f *os.File) error {
+		_, err := io.Copy(f, source)
+		return err
+	})
+}
+
+// CheckWalArchive checks that the WAL archive can be used by a new server, as
+// barman-cloud-check-wal-archive does before the first WAL file is archived.
+// Without a timeline the archive must be empty, otherwise it can only contain
+// the files of the timelines preceding the passed one, as after a promotion.
+func (store *Store) CheckWalArchive(timeline uint32) error {
+	walPaths, err := store.listWALs()
+	if err != nil {
+		return err
+	}
+
+	for _, walPath := range walPaths {
+		if timeline == 0 {
+			return fmt.Errorf(
+				"expected empty archive in %q, found WAL file %q",
+				store.serverDirectory, walPath)
+		}
+
+		name, err := wal.Parse(trimCompressionSuffix(filepath.Base(walPath)))
+		if err != nil {
+			// Files not following the WAL naming can't belong
+			// to a timeline, as barman ignores them too
+			continue
+		}
+		if name.Timeline >= timeline {
+			return fmt.Errorf(
+				"found WAL file %q of timeline %d in %q, expected only timelines preceding %d",
+				walPath, name.Timeline, store.serverDirectory, timeline)
+		}
+	}
+
+	return nil
+}
+
+// trimCompressionSuffix removes from the name of an archived
+// WAL file the suffix of the compression algorithm
+func trimCompressionSuffix(fileName string) string {
+	for _, suffix := range compressionSuffixes {
+		if suffix != "" && strings.HasSuffix(fileName, suffix) {
+			return strings.TrimSuffix(fileName, suffix)
+		}
+	}
+
+	return fileName
+}
+
+// TimelineFromOptions gets the timeline passed to barman-cloud-check-wal-archive
+// with the --timeline flag, or zero when it is not set
+func TimelineFromOptions(options []string) (uint32, error) {
+	for idx, option := range options {
+		value, found := strings.CutPrefix(option, "--timeline=")
+		if !found && option == "--timeline" && idx+1 < len(options) {
+			value, found = options[idx+1], true
+		}
+		if !found {
+			continue
+		}
+
+		timeline, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeline %q: %w", value, err)
+		}
+		return uint32(timeline), nil
+	}
+
+	return 0, nil
+}
+
+// listWALs gets the paths of every file contained in the WAL archive
+func (store *Store) listWALs() ([]string, error) {
+	var result []string
+	walsDirectory := filepath.Join(store.serverDirectory, walsPrefix)
+	err := filepath.WalkDir(walsDirectory, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == walsDirectory {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if !d.IsDir() && filepath.Ext(path) != tempSuffix {
+			result = append(result, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while listing WAL archive: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package health
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoCertificate is returned when a file doesn't contain a certificate
+var ErrNoCertificate = errors.New("no certificate found")
+
+// sasExpiryLayouts are the formats accepted by Azure for the
+// expiry of a shared access signature, in the se parameter
+var sasExpiryLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"}
+
+// CertificateExpiry is the expiry of a certificate
+type CertificateExpiry struct {
+	// The subject of the certificate
+	Subject string `json:"subject"`
+
+	// When the certificate expires
+	NotAfter time.Time `json:"notAfter"`
+}
+
+// CertificatesExpiry gets the expiry of the certificates used to verify the
+// object store endpoint. As not every certificate of a CA bundle is needed
+// to verify the endpoint, their expiry is reported only as a warning.
+type CertificatesExpiry func() ([]CertificateExpiry, error)
+
+// CABundleCertificates gets the expiry of the certificates of the CA bundle
+// used to verify the certificate of the object store endpoint, which is the
+// file referenced by the AWS_CA_BUNDLE or REQUESTS_CA_BUNDLE variable set by
+// the credentials package, such as credentials.BarmanBackupEndpointCACertificateLocation.
+// The bundle is read again on every check, as it may be renewed.
+func CABundleCertificates(bundlePath string) CertificatesExpiry {
+	return func() ([]CertificateExpiry, error) {
+		content, err := os.ReadFile(bundlePath) // #nosec G304
+		if err != nil {
+			return nil, fmt.Errorf("while reading the CA bundle: %w", err)
+		}
+
+		var result []CertificateExpiry
+		for {
+			var block *pem.Block
+			block, content = pem.Decode(content)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("while parsing the CA bundle: %w", err)
+			}
+
+			result = append(result, CertificateExpiry{
+				Subject:  certificate.Subject.String(),
+				NotAfter: certificate.NotAfter,
+			})
+		}
+
+		if len(result) == 0 {
+			return nil, fmt.Errorf("%w in %s", ErrNoCertificate, bundlePath)
+		}
+
+		return result, nil
+	}
+}
+
+// AWSSessionExpiry gets the expiry of the temporary credentials issued by
+// AWS STS, as found in the passed environment. The session token is opaque,
+// so the expiry is read from the AWS_CREDENTIAL_EXPIRATION variable, set
+// with the session token by the AWS CLI and SDKs when exporting them.
+// A zero time is returned without a session token or its expiry.
+func AWSSessionExpiry(env []string) CredentialsExpiry {
+	return func() (time.Time, error) {
+		if _, found := lookupEnv(env, "AWS_SESSION_TOKEN"); !found {
+			return time.Time{}, nil
+		}
+
+		expiration, found := lookupEnv(env, "AWS_CREDENTIAL_EXPIRATION")
+		if !found {
+			return time.Time{}, nil
+		}
+
+		expiry, err := time.Parse(time.RFC3339, expiration)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("while parsing AWS_CREDENTIAL_EXPIRATION: %w", err)
+		}
+
+		return expiry, nil
+	}
+}
+
+// AzureSASExpiry gets the expiry of the Azure shared access signature found
+// in the passed environment, either in the AZURE_STORAGE_SAS_TOKEN variable or
+// in the connection string, reading its se parameter.
+// A zero time is returned without a shared access signature.
+func AzureSASExpiry(env []string) CredentialsExpiry {
+	return func() (time.Time, error) {
+		token, found := lookupEnv(env, "AZURE_STORAGE_SAS_TOKEN")
+		if !found {
+			connectionString, _ := lookupEnv(env, "AZURE_STORAGE_CONNECTION_STRING")
+			for _, field := range strings.Split(connectionString, ";") {
+				if value, isSAS := strings.CutPrefix(field, "SharedAccessSignature="); isSAS {
+					token, found = value, true
+				}
+			}
+		}
+		if !found {
+			return time.Time{}, nil
+		}
+
+		parameters, err := url.ParseQuery(strings.TrimPrefix(token, "?"))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("while parsing the shared access signature: %w", err)
+		}
+
+		signedExpiry := parameters.Get("se")
+		if signedExpiry == "" {
+			return time.Time{}, nil
+		}
+		for _, layout := range sasExpiryLayouts {
+			if expiry, err := time.Parse(layout, signedExpiry); err == nil {
+				return expiry, nil
+			}
+		}
+
+		return time.Time{}, fmt.Errorf("invalid expiry %q in the shared access signature", signedExpiry)
+	}
+}
+
+// EarliestExpiry gets the earliest expiry among the passed sources,
+// ignoring the ones whose credentials don't expire
+func EarliestExpiry(sources ...CredentialsExpiry) CredentialsExpiry {
+	return func() (time.Time, error) {
+		var result time.Time
+		for _, source := range sources {
+			expiry, err := source()
+			if err != nil {
+				return time.Time{}, err
+			}
+			if !expiry.IsZero() && (result.IsZero() || expiry.Before(result)) {
+				result = expiry
+			}
+		}
+
+		return result, nil
+	}
+}
+
+// lookupEnv gets the value of a variable in an environment
+// formatted as KEY=VALUE, where the last definition wins
+func lookupEnv(env []string, name string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if value, found := strings.CutPrefix(env[i], name+"="); found {
+			return value, true
+		}
+	}
+
+	return "", false
+}
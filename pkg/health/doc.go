@@ -0,0 +1,25 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package health checks the health of the WAL archiver, aggregating the
+// archival statistics, the backlog of the spool, the reachability of the
+// object store and the expiry of the credentials into a liveness and
+// readiness result, which can be served over HTTP. The expiry of the
+// certificates used to verify the object store is reported as a warning.
+package health
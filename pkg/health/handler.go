@@ -0,0 +1,70 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	// LivenessPath is the path where the liveness is served
+	LivenessPath = "/healthz"
+
+	// ReadinessPath is the path where the readiness is served
+	ReadinessPath = "/readyz"
+)
+
+// Handler serves the liveness and the readiness of the archiver
+func (checker *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(LivenessPath, checker.LivenessHandler())
+	mux.Handle(ReadinessPath, checker.ReadinessHandler())
+	return mux
+}
+
+// LivenessHandler serves the health of the archiver, answering
+// with 503 Service Unavailable when it is not live
+func (checker *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := checker.Check(r.Context())
+		writeResult(w, result, result.Live)
+	})
+}
+
+// ReadinessHandler serves the health of the archiver, answering
+// with 503 Service Unavailable when it is not ready
+func (checker *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := checker.Check(r.Context())
+		writeResult(w, result, result.Ready)
+	})
+}
+
+// writeResult writes the health of the archiver as JSON
+func writeResult(w http.ResponseWriter, result *Result, healthy bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
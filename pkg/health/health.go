@@ -0,0 +1,357 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/stats"
+)
+
+const (
+	// defaultMaxConsecutiveFailures is the default number of consecutive
+	// archival failures after which the archiver is not ready
+	defaultMaxConsecutiveFailures = 3
+
+	// defaultReachabilityCacheDuration is the default time for which
+	// the result of the reachability check is reused
+	defaultReachabilityCacheDuration = time.Minute
+
+	// defaultCredentialsExpiryMargin is the default time before the expiry
+	// of the credentials when the archiver stops being ready
+	defaultCredentialsExpiryMargin = 10 * time.Minute
+)
+
+// Check is the name of a check contributing to the health of the archiver
+type Check string
+
+const (
+	// CheckArchival checks the outcome of the recent archivals
+	CheckArchival Check = "archival"
+
+	// CheckSpool checks the backlog of the spool
+	CheckSpool Check = "spool"
+
+	// CheckObjectStore checks the reachability of the object store
+	CheckObjectStore Check = "objectStore"
+
+	// CheckCredentials checks the expiry of the credentials
+	CheckCredentials Check = "credentials"
+
+	// CheckCertificates checks the expiry of the certificates used to
+	// verify the object store endpoint, only producing warnings
+	CheckCertificates Check = "certificates"
+)
+
+// StatisticsSource provides the statistics of the archival,
+// such as the ones kept by archiver.WALArchiver
+type StatisticsSource interface {
+	Statistics() (*stats.Statistics, error)
+}
+
+// Backlog provides the WAL files waiting in a spool, such as spool.WALSpool
+type Backlog interface {
+	List() ([]string, error)
+}
+
+// ReachabilityCheck checks if the object store can be reached, such as
+// archiver.WALArchiver.CheckWalArchiveDestination before the first archival
+type ReachabilityCheck func(ctx context.Context) error
+
+// CredentialsExpiry gets when the credentials used to reach the
+// object store expire. A zero time is returned when they don't.
+type CredentialsExpiry func() (time.Time, error)
+
+// Probes are the sources of information used to check the health of the
+// archiver. Every probe is optional, and the related check is skipped
+// when it is not set.
+type Probes struct {
+	Statistics         StatisticsSource
+	Backlog            Backlog
+	Reachability       ReachabilityCheck
+	CredentialsExpiry  CredentialsExpiry
+	CertificatesExpiry CertificatesExpiry
+}
+
+// Options are the thresholds used to check the health of the archiver
+type Options struct {
+	// The number of consecutive archival failures after which
+	// the archiver is not ready. Defaults to 3.
+	MaxConsecutiveFailures int64
+
+	// The time since the last successful archival after which, if the
+	// archival is failing, the archiver is not live anymore and should
+	// be restarted. Zero disables the check.
+	MaxTimeSinceLastSuccess time.Duration
+
+	// The number of WAL files in the spool above which
+	// the archiver is not ready. Zero disables the check.
+	MaxSpoolBacklog int
+
+	// The time for which the result of the reachability
+	// check is reused. Defaults to one minute.
+	ReachabilityCacheDuration time.Duration
+
+	// The time before the expiry of the credentials when the
+	// archiver stops being ready, and the certificates start
+	// being reported as expiring. Defaults to ten minutes.
+	CredentialsExpiryMargin time.Duration
+}
+
+// Reason explains why the archiver is not healthy
+type Reason struct {
+	// The check which failed
+	Check Check `json:"check"`
+
+	// The description of the problem
+	Message string `json:"message"`
+
+	// True when the problem makes the archiver not live,
+	// and not only not ready
+	Liveness bool `json:"liveness,omitempty"`
+}
+
+// Result is the health of the archiver
+type Result struct {
+	// False when the archiver is stuck and should be restarted
+	Live bool `json:"live"`
+
+	// False when the archiver is not able to archive WAL files
+	Ready bool `json:"ready"`
+
+	// The reasons why the archiver is not live or not ready
+	Reasons []Reason `json:"reasons,omitempty"`
+
+	// The problems which don't affect the liveness and the readiness
+	Warnings []Reason `json:"warnings,omitempty"`
+
+	// The statistics of the archival, if available
+	Statistics *stats.Statistics `json:"statistics,omitempty"`
+
+	// The number of WAL files in the spool, if available
+	SpoolBacklog *int `json:"spoolBacklog,omitempty"`
+
+	// When the credentials expire, if known
+	CredentialsExpiry *time.Time `json:"credentialsExpiry,omitempty"`
+
+	// When the health was checked
+	CheckTime time.Time `json:"checkTime"`
+}
+
+// Checker checks the health of the archiver
+type Checker struct {
+	probes  Probes
+	options Options
+
+	// now gets the current time
+	now func() time.Time
+
+	mutex                  sync.Mutex
+	reachabilityCheckTime  time.Time
+	reachabilityCheckError error
+}
+
+// NewChecker creates a new health checker
+func NewChecker(probes Probes, options Options) *Checker {
+	if options.MaxConsecutiveFailures <= 0 {
+		options.MaxConsecutiveFailures = defaultMaxConsecutiveFailures
+	}
+	if options.ReachabilityCacheDuration <= 0 {
+		options.ReachabilityCacheDuration = defaultReachabilityCacheDuration
+	}
+	if options.CredentialsExpiryMargin <= 0 {
+		options.CredentialsExpiryMargin = defaultCredentialsExpiryMargin
+	}
+
+	return &Checker{
+		probes:  probes,
+		options: options,
+		now:     time.Now,
+	}
+}
+
+// Check checks the health of the archiver
+func (checker *Checker) Check(ctx context.Context) *Result {
+	result := &Result{
+		Live:      true,
+		Ready:     true,
+		CheckTime: checker.now(),
+	}
+
+	checker.checkArchival(result)
+	checker.checkSpool(result)
+	checker.checkObjectStore(ctx, result)
+	checker.checkCredentials(result)
+	checker.checkCertificates(result)
+
+	return result
+}
+
+// addReason records a problem in the result
+func (result *Result) addReason(check Check, liveness bool, format string, args ...any) {
+	result.Ready = false
+	if liveness {
+		result.Live = false
+	}
+	result.Reasons = append(result.Reasons, Reason{
+		Check:    check,
+		Message:  fmt.Sprintf(format, args...),
+		Liveness: liveness,
+	})
+}
+
+// addWarning records a problem in the result without
+// affecting the liveness and the readiness
+func (result *Result) addWarning(check Check, format string, args ...any) {
+	result.Warnings = append(result.Warnings, Reason{
+		Check:   check,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkArchival checks the outcome of the recent archivals
+func (checker *Checker) checkArchival(result *Result) {
+	if checker.probes.Statistics == nil {
+		return
+	}
+
+	statistics, err := checker.probes.Statistics.Statistics()
+	if err != nil {
+		result.addReason(CheckArchival, false, "cannot read the archival statistics: %v", err)
+		return
+	}
+	result.Statistics = statistics
+
+	if statistics.ConsecutiveFailedCount >= checker.options.MaxConsecutiveFailures {
+		result.addReason(CheckArchival, false, "%d consecutive archival failures, the last one for %s: %s",
+			statistics.ConsecutiveFailedCount, statistics.LastFailedWAL, statistics.LastFailedError)
+	}
+
+	if checker.options.MaxTimeSinceLastSuccess <= 0 || statistics.ConsecutiveFailedCount == 0 {
+		return
+	}
+
+	// Without any success, the archival is measured
+	// from when the statistics were reset
+	lastSuccess := statistics.StatsReset
+	if statistics.LastTime != nil {
+		lastSuccess = *statistics.LastTime
+	}
+	if sinceLastSuccess := result.CheckTime.Sub(lastSuccess); sinceLastSuccess > checker.options.MaxTimeSinceLastSuccess {
+		result.addReason(CheckArchival, true, "no WAL file archived for %s while the archival is failing",
+			sinceLastSuccess.Round(time.Second))
+	}
+}
+
+// checkSpool checks the backlog of the spool
+func (checker *Checker) checkSpool(result *Result) {
+	if checker.probes.Backlog == nil {
+		return
+	}
+
+	walNames, err := checker.probes.Backlog.List()
+	if err != nil {
+		result.addReason(CheckSpool, false, "cannot read the spool: %v", err)
+		return
+	}
+
+	backlog := len(walNames)
+	result.SpoolBacklog = &backlog
+	if checker.options.MaxSpoolBacklog > 0 && backlog > checker.options.MaxSpoolBacklog {
+		result.addReason(CheckSpool, false, "%d WAL files in the spool, more than %d",
+			backlog, checker.options.MaxSpoolBacklog)
+	}
+}
+
+// checkObjectStore checks the reachability of the object store,
+// reusing the result of the last check when it is recent enough
+func (checker *Checker) checkObjectStore(ctx context.Context, result *Result) {
+	if checker.probes.Reachability == nil {
+		return
+	}
+
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	if checker.reachabilityCheckTime.IsZero() ||
+		result.CheckTime.Sub(checker.reachabilityCheckTime) >= checker.options.ReachabilityCacheDuration {
+		checker.reachabilityCheckError = checker.probes.Reachability(ctx)
+		checker.reachabilityCheckTime = result.CheckTime
+	}
+
+	if checker.reachabilityCheckError != nil {
+		result.addReason(CheckObjectStore, false, "the object store cannot be reached: %v",
+			checker.reachabilityCheckError)
+	}
+}
+
+// checkCredentials checks the expiry of the credentials
+func (checker *Checker) checkCredentials(result *Result) {
+	if checker.probes.CredentialsExpiry == nil {
+		return
+	}
+
+	expiry, err := checker.probes.CredentialsExpiry()
+	if err != nil {
+		result.addReason(CheckCredentials, false, "cannot get the expiry of the credentials: %v", err)
+		return
+	}
+	if expiry.IsZero() {
+		return
+	}
+
+	result.CredentialsExpiry = &expiry
+	switch {
+	case !expiry.After(result.CheckTime):
+		result.addReason(CheckCredentials, false, "the credentials expired at %s", expiry.Format(time.RFC3339))
+	case expiry.Sub(result.CheckTime) < checker.options.CredentialsExpiryMargin:
+		result.addReason(CheckCredentials, false, "the credentials expire at %s", expiry.Format(time.RFC3339))
+	}
+}
+
+// checkCertificates checks the expiry of the certificates used to verify the
+// object store endpoint. As the endpoint may be verified by any of them, their
+// expiry is reported as a warning, the reachability check telling if the
+// endpoint can still be verified.
+func (checker *Checker) checkCertificates(result *Result) {
+	if checker.probes.CertificatesExpiry == nil {
+		return
+	}
+
+	certificates, err := checker.probes.CertificatesExpiry()
+	if err != nil {
+		result.addWarning(CheckCertificates, "cannot get the expiry of the certificates: %v", err)
+		return
+	}
+
+	for _, certificate := range certificates {
+		switch {
+		case !certificate.NotAfter.After(result.CheckTime):
+			result.addWarning(CheckCertificates, "the certificate %q expired at %s",
+				certificate.Subject, certificate.NotAfter.Format(time.RFC3339))
+		case certificate.NotAfter.Sub(result.CheckTime) < checker.options.CredentialsExpiryMargin:
+			result.addWarning(CheckCertificates, "the certificate %q expires at %s",
+				certificate.Subject, certificate.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
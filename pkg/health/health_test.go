@@ -0,0 +1,285 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package health
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/stats"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeStatistics is a StatisticsSource returning fixed statistics
+type fakeStatistics struct {
+	statistics *stats.Statistics
+}
+
+func (source *fakeStatistics) Statistics() (*stats.Statistics, error) {
+	return source.statistics, nil
+}
+
+// fakeBacklog is a Backlog returning a fixed list of WAL files
+type fakeBacklog []string
+
+func (backlog fakeBacklog) List() ([]string, error) {
+	return backlog, nil
+}
+
+var _ = Describe("Checker", func() {
+	var now time.Time
+
+	BeforeEach(func() {
+		now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	newChecker := func(probes Probes, options Options) *Checker {
+		checker := NewChecker(probes, options)
+		checker.now = func() time.Time { return now }
+		return checker
+	}
+
+	reasonChecks := func(result *Result) []Check {
+		var checks []Check
+		for _, reason := range result.Reasons {
+			checks = append(checks, reason.Check)
+		}
+		return checks
+	}
+
+	It("is healthy without probes", func(ctx SpecContext) {
+		result := newChecker(Probes{}, Options{}).Check(ctx)
+		Expect(result.Live).To(BeTrue())
+		Expect(result.Ready).To(BeTrue())
+		Expect(result.Reasons).To(BeEmpty())
+	})
+
+	It("is not ready after too many consecutive failures", func(ctx SpecContext) {
+		lastSuccess := now.Add(-time.Minute)
+		source := &fakeStatistics{statistics: &stats.Statistics{
+			Count:                  10,
+			LastTime:               &lastSuccess,
+			ConsecutiveFailedCount: 2,
+			LastFailedWAL:          "000000010000000000000005",
+			LastFailedError:        "connectivity failure",
+		}}
+		checker := newChecker(Probes{Statistics: source}, Options{MaxTimeSinceLastSuccess: time.Hour})
+		Expect(checker.Check(ctx).Ready).To(BeTrue())
+
+		source.statistics.ConsecutiveFailedCount = 3
+		result := checker.Check(ctx)
+		Expect(result.Live).To(BeTrue())
+		Expect(result.Ready).To(BeFalse())
+		Expect(reasonChecks(result)).To(Equal([]Check{CheckArchival}))
+		Expect(result.Reasons[0].Message).To(ContainSubstring("connectivity failure"))
+	})
+
+	It("is not live when the archival has been failing for too long", func(ctx SpecContext) {
+		lastSuccess := now.Add(-2 * time.Hour)
+		source := &fakeStatistics{statistics: &stats.Statistics{
+			Count:                  10,
+			LastTime:               &lastSuccess,
+			ConsecutiveFailedCount: 1,
+		}}
+		checker := newChecker(Probes{Statistics: source}, Options{MaxTimeSinceLastSuccess: time.Hour})
+		result := checker.Check(ctx)
+		Expect(result.Live).To(BeFalse())
+		Expect(result.Ready).To(BeFalse())
+		Expect(result.Reasons[0].Liveness).To(BeTrue())
+
+		// An idle archiver is not stuck
+		source.statistics.ConsecutiveFailedCount = 0
+		Expect(checker.Check(ctx).Live).To(BeTrue())
+	})
+
+	It("is not ready when the spool backlog is too large", func(ctx SpecContext) {
+		backlog := fakeBacklog{"000000010000000000000001", "000000010000000000000002"}
+		result := newChecker(Probes{Backlog: backlog}, Options{MaxSpoolBacklog: 1}).Check(ctx)
+		Expect(result.Ready).To(BeFalse())
+		Expect(*result.SpoolBacklog).To(Equal(2))
+		Expect(reasonChecks(result)).To(Equal([]Check{CheckSpool}))
+	})
+
+	It("caches the reachability of the object store", func(ctx SpecContext) {
+		calls := 0
+		reachability := func(context.Context) error {
+			calls++
+			return errors.New("connection refused")
+		}
+		checker := newChecker(Probes{Reachability: reachability}, Options{ReachabilityCacheDuration: time.Minute})
+
+		Expect(reasonChecks(checker.Check(ctx))).To(Equal([]Check{CheckObjectStore}))
+		Expect(reasonChecks(checker.Check(ctx))).To(Equal([]Check{CheckObjectStore}))
+		Expect(calls).To(Equal(1))
+
+		now = now.Add(time.Minute)
+		checker.Check(ctx)
+		Expect(calls).To(Equal(2))
+	})
+
+	It("is not ready when the credentials are about to expire", func(ctx SpecContext) {
+		expiry := now.Add(time.Hour)
+		credentialsExpiry := func() (time.Time, error) { return expiry, nil }
+		checker := newChecker(Probes{CredentialsExpiry: credentialsExpiry}, Options{})
+		Expect(checker.Check(ctx).Ready).To(BeTrue())
+
+		expiry = now.Add(5 * time.Minute)
+		result := checker.Check(ctx)
+		Expect(result.Ready).To(BeFalse())
+		Expect(reasonChecks(result)).To(Equal([]Check{CheckCredentials}))
+		Expect(*result.CredentialsExpiry).To(Equal(expiry))
+	})
+
+	It("warns about the expiring certificates, staying ready", func(ctx SpecContext) {
+		certificatesExpiry := func() ([]CertificateExpiry, error) {
+			return []CertificateExpiry{
+				{Subject: "CN=expired", NotAfter: now.Add(-time.Hour)},
+				{Subject: "CN=expiring", NotAfter: now.Add(5 * time.Minute)},
+				{Subject: "CN=valid", NotAfter: now.Add(24 * time.Hour)},
+			}, nil
+		}
+		result := newChecker(Probes{CertificatesExpiry: certificatesExpiry}, Options{}).Check(ctx)
+		Expect(result.Ready).To(BeTrue())
+		Expect(result.Reasons).To(BeEmpty())
+		Expect(result.Warnings).To(HaveLen(2))
+		Expect(result.Warnings[0].Check).To(Equal(CheckCertificates))
+		Expect(result.Warnings[0].Message).To(ContainSubstring("CN=expired"))
+		Expect(result.Warnings[1].Message).To(ContainSubstring("CN=expiring"))
+	})
+})
+
+// newCertificate creates a self-signed certificate expiring at the passed time
+func newCertificate(notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "barman"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})
+}
+
+var _ = Describe("CABundleCertificates", func() {
+	It("reads the expiry of every certificate in the bundle", func() {
+		notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		bundle := append(newCertificate(notAfter.Add(24*time.Hour)), newCertificate(notAfter)...)
+
+		bundlePath := filepath.Join(GinkgoT().TempDir(), "backup-barman-ca.crt")
+		Expect(os.WriteFile(bundlePath, bundle, 0o600)).To(Succeed())
+
+		certificates, err := CABundleCertificates(bundlePath)()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(certificates).To(HaveLen(2))
+		Expect(certificates[0].Subject).To(Equal("CN=barman"))
+		Expect(certificates[0].NotAfter).To(BeTemporally("==", notAfter.Add(24*time.Hour)))
+		Expect(certificates[1].NotAfter).To(BeTemporally("==", notAfter))
+	})
+
+	It("refuses files without certificates", func() {
+		bundlePath := filepath.Join(GinkgoT().TempDir(), "backup-barman-ca.crt")
+		Expect(os.WriteFile(bundlePath, []byte("not a certificate"), 0o600)).To(Succeed())
+
+		_, err := CABundleCertificates(bundlePath)()
+		Expect(err).To(MatchError(ErrNoCertificate))
+	})
+})
+
+var _ = Describe("Token expiry", func() {
+	expiry := time.Date(2030, 1, 1, 12, 30, 0, 0, time.UTC)
+
+	It("reads the expiry of the AWS session credentials", func() {
+		Expect(AWSSessionExpiry([]string{"AWS_ACCESS_KEY_ID=id"})()).To(BeZero())
+		Expect(AWSSessionExpiry([]string{"AWS_SESSION_TOKEN=token"})()).To(BeZero())
+		Expect(AWSSessionExpiry([]string{
+			"AWS_SESSION_TOKEN=token",
+			"AWS_CREDENTIAL_EXPIRATION=2030-01-01T12:30:00Z",
+		})()).To(BeTemporally("==", expiry))
+
+		_, err := AWSSessionExpiry([]string{"AWS_SESSION_TOKEN=token", "AWS_CREDENTIAL_EXPIRATION=never"})()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reads the expiry of the Azure shared access signatures", func() {
+		Expect(AzureSASExpiry([]string{"AZURE_STORAGE_KEY=key"})()).To(BeZero())
+		Expect(AzureSASExpiry([]string{
+			"AZURE_STORAGE_SAS_TOKEN=?sv=2022-11-02&se=2030-01-01T12:30:00Z&sp=rwdl&sig=abc",
+		})()).To(BeTemporally("==", expiry))
+		Expect(AzureSASExpiry([]string{
+			"AZURE_STORAGE_CONNECTION_STRING=BlobEndpoint=https://account.blob.core.windows.net/;" +
+				"SharedAccessSignature=sv=2022-11-02&se=2030-01-01T12:30Z&sig=abc",
+		})()).To(BeTemporally("==", expiry))
+		Expect(AzureSASExpiry([]string{"AZURE_STORAGE_SAS_TOKEN=se=2030-01-01&sig=abc"})()).
+			To(BeTemporally("==", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+		_, err := AzureSASExpiry([]string{"AZURE_STORAGE_SAS_TOKEN=se=tomorrow&sig=abc"})()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("takes the earliest expiry", func() {
+		never := func() (time.Time, error) { return time.Time{}, nil }
+		at := func(expiry time.Time) CredentialsExpiry {
+			return func() (time.Time, error) { return expiry, nil }
+		}
+		Expect(EarliestExpiry(never, at(expiry.Add(time.Hour)), at(expiry))()).To(BeTemporally("==", expiry))
+		Expect(EarliestExpiry(never)()).To(BeZero())
+	})
+})
+
+var _ = Describe("Handler", func() {
+	It("serves the liveness and the readiness", func() {
+		source := &fakeStatistics{statistics: &stats.Statistics{ConsecutiveFailedCount: 5}}
+		handler := NewChecker(Probes{Statistics: source}, Options{}).Handler()
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, LivenessPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var result Result
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &result)).To(Succeed())
+		Expect(result.Ready).To(BeFalse())
+		Expect(result.Reasons).To(HaveLen(1))
+		Expect(result.Statistics.ConsecutiveFailedCount).To(Equal(int64(5)))
+	})
+})
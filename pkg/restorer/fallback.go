@@ -0,0 +1,216 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package restorer
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/api"
+	"github.com/cloudnative-pg/barman-cloud/pkg/command"
+)
+
+const (
+	// unreachableSourcePenalty is the latency recorded for a source which
+	// couldn't be reached, so that it is tried after the reachable ones
+	unreachableSourcePenalty = time.Minute
+
+	// latencySmoothingFactor is the weight of the latest observation
+	// in the moving average of the latency of a source
+	latencySmoothingFactor = 0.2
+)
+
+// ErrNoSource is returned when restoring from an empty list of sources
+var ErrNoSource = errors.New("no source to restore from")
+
+// Source is an object store from which WAL files can be restored
+type Source struct {
+	// The name of the source, used to report which source served a WAL file
+	Name string
+
+	// The options of barman-cloud-wal-restore, as
+	// built by command.CloudWalRestoreOptions
+	Options []string
+
+	// The environment needed by barman-cloud to reach the object store,
+	// as built by credentials.EnvSetCloudCredentialsAndCertificates.
+	// When nil, the environment of the restorer is used.
+	Env []string
+}
+
+// NewSource creates a source from the configuration of an object store
+// and the environment needed to reach it
+func NewSource(
+	ctx context.Context,
+	name string,
+	configuration *api.BarmanObjectStoreConfiguration,
+	clusterName string,
+	env []string,
+) (*Source, error) {
+	options, err := command.CloudWalRestoreOptions(ctx, configuration, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Source{
+		Name:    name,
+		Options: options,
+		Env:     env,
+	}, nil
+}
+
+// envOrDefault gets the environment of the source, or the passed one
+// when the source doesn't specify it
+func (source *Source) envOrDefault(env []string) []string {
+	if source.Env == nil {
+		return env
+	}
+
+	return source.Env
+}
+
+// SetPreferFastestSource makes the restorer try the source with the lowest
+// observed latency first, instead of following the order in which the sources
+// are passed. The sources which have not been used yet are tried first.
+func (restorer *WALRestorer) SetPreferFastestSource(enabled bool) {
+	restorer.preferFastestSource = enabled
+}
+
+// SourceLatency gets the moving average of the latency observed
+// when restoring WAL files from a source
+func (restorer *WALRestorer) SourceLatency(name string) (time.Duration, bool) {
+	return restorer.latencies.get(name)
+}
+
+// RestoreWithFallback restores a WAL file from the first source having it,
+// returning the name of the source which served it. The next source is tried
+// when a source is unreachable or doesn't contain the WAL file.
+// ErrWALNotFound is returned only when no source contains the WAL file.
+func (restorer *WALRestorer) RestoreWithFallback(
+	walName, destinationPath string,
+	sources []Source,
+) (string, error) {
+	if len(sources) == 0 {
+		return "", ErrNoSource
+	}
+
+	var errs []error
+	for _, source := range restorer.orderSources(sources) {
+		startTime := time.Now()
+		err := restorer.restoreWithEnv(walName, destinationPath, source.Options, source.envOrDefault(restorer.env))
+		elapsed := time.Since(startTime)
+
+		switch {
+		case err == nil:
+			restorer.latencies.observe(source.Name, elapsed)
+			return source.Name, nil
+
+		case errors.Is(err, ErrWALNotFound):
+			restorer.latencies.observe(source.Name, elapsed)
+
+		case errors.Is(err, ErrConnectivity):
+			restorer.latencies.observe(source.Name, max(elapsed, unreachableSourcePenalty))
+			errs = append(errs, fmt.Errorf("%s: %w", source.Name, err))
+
+		default:
+			return "", fmt.Errorf("%s: %w", source.Name, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return "", fmt.Errorf("WAL file %s not found in any source: %w", walName, ErrWALNotFound)
+	}
+
+	return "", errors.Join(errs...)
+}
+
+// RestoreListWithFallback restores a list of WALs as RestoreList does,
+// trying multiple sources as RestoreWithFallback does
+func (restorer *WALRestorer) RestoreListWithFallback(
+	ctx context.Context,
+	fetchList []string,
+	destinationPath string,
+	sources []Source,
+) (resultList []Result) {
+	sourceNames := make([]string, len(sources))
+	for idx := range sources {
+		sourceNames[idx] = sources[idx].Name
+	}
+
+	restore := func(walName, downloadPath string) (string, error) {
+		return restorer.RestoreWithFallback(walName, downloadPath, sources)
+	}
+
+	return restorer.restoreList(ctx, fetchList, destinationPath, restore, "sources", sourceNames)
+}
+
+// orderSources gets the sources in the order they should be tried
+func (restorer *WALRestorer) orderSources(sources []Source) []Source {
+	if !restorer.preferFastestSource {
+		return sources
+	}
+
+	result := slices.Clone(sources)
+	slices.SortStableFunc(result, func(a, b Source) int {
+		// The sources never used have no latency, and are tried first
+		aLatency, _ := restorer.latencies.get(a.Name)
+		bLatency, _ := restorer.latencies.get(b.Name)
+		return cmp.Compare(aLatency, bLatency)
+	})
+	return result
+}
+
+// sourceLatencies tracks the latency observed for every source
+type sourceLatencies struct {
+	mutex  sync.Mutex
+	values map[string]time.Duration
+}
+
+// observe adds an observation to the moving average of the latency of a source
+func (latencies *sourceLatencies) observe(name string, latency time.Duration) {
+	latencies.mutex.Lock()
+	defer latencies.mutex.Unlock()
+
+	if latencies.values == nil {
+		latencies.values = make(map[string]time.Duration)
+	}
+
+	previous, found := latencies.values[name]
+	if !found {
+		latencies.values[name] = latency
+		return
+	}
+
+	latencies.values[name] = previous + time.Duration(latencySmoothingFactor*float64(latency-previous))
+}
+
+// get gets the average latency of a source
+func (latencies *sourceLatencies) get(name string) (time.Duration, bool) {
+	latencies.mutex.Lock()
+	defer latencies.mutex.Unlock()
+
+	latency, found := latencies.values[name]
+	return latency, found
+}
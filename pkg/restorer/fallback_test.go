@@ -0,0 +1,177 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package restorer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Restore with fallback", func() {
+	const walName = "000000010000000000000001"
+
+	var (
+		tempDir     string
+		walRestorer *WALRestorer
+	)
+
+	// localSource creates a source restoring from a local directory,
+	// optionally containing the WAL file
+	localSource := func(name string, withWAL bool) Source {
+		directory := filepath.Join(tempDir, "stores", name)
+		walsDirectory := filepath.Join(directory, "cluster", "wals", walName[:16])
+		Expect(os.MkdirAll(walsDirectory, 0o750)).To(Succeed())
+		if withWAL {
+			Expect(os.WriteFile(filepath.Join(walsDirectory, walName), []byte(name), 0o600)).To(Succeed())
+		}
+
+		return Source{Name: name, Options: []string{"file://" + directory, "cluster"}}
+	}
+
+	// unreachableSource creates a source whose barman-cloud-wal-restore
+	// invocations fail with a connectivity error
+	unreachableSource := func(name string) Source {
+		binDirectory := filepath.Join(tempDir, "bin")
+		Expect(os.MkdirAll(binDirectory, 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(binDirectory, utils.BarmanCloudWalRestore),
+			[]byte("#!/bin/sh\nexit 2\n"), 0o700)).To(Succeed()) // #nosec G306
+		GinkgoT().Setenv("PATH", binDirectory+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		return Source{Name: name, Options: []string{"s3://unreachable", "cluster"}}
+	}
+
+	BeforeEach(func() {
+		tempDir = GinkgoT().TempDir()
+
+		var err error
+		walRestorer, err = New(context.Background(), nil, filepath.Join(tempDir, "spool"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("falls back to the next source when the WAL file is not found", func() {
+		destination := filepath.Join(tempDir, "RECOVERYXLOG")
+		source, err := walRestorer.RestoreWithFallback(walName, destination, []Source{
+			localSource("primary", false),
+			localSource("replica", true),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal("replica"))
+		Expect(os.ReadFile(destination)).To(Equal([]byte("replica")))
+	})
+
+	It("falls back to the next source when a source is unreachable", func() {
+		destination := filepath.Join(tempDir, "RECOVERYXLOG")
+		sources := []Source{unreachableSource("primary"), localSource("replica", true)}
+		source, err := walRestorer.RestoreWithFallback(walName, destination, sources)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal("replica"))
+
+		latency, found := walRestorer.SourceLatency("primary")
+		Expect(found).To(BeTrue())
+		Expect(latency).To(BeNumerically(">=", unreachableSourcePenalty))
+	})
+
+	It("restores from every source with its own environment", func() {
+		binDirectory := filepath.Join(tempDir, "bin")
+		Expect(os.MkdirAll(binDirectory, 0o750)).To(Succeed())
+		callsPath := filepath.Join(tempDir, "calls")
+		Expect(os.WriteFile(filepath.Join(binDirectory, utils.BarmanCloudWalRestore), []byte(
+			"#!/bin/sh\n"+
+				"echo \"$1 $CREDENTIALS\" >> "+callsPath+"\n"+
+				"[ \"$CREDENTIALS\" = valid ] || exit 2\n"+
+				"echo \"$1\" > \"$4\"\n"), 0o700)).To(Succeed()) // #nosec G306
+		GinkgoT().Setenv("PATH", binDirectory+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		destination := filepath.Join(tempDir, "RECOVERYXLOG")
+		source, err := walRestorer.RestoreWithFallback(walName, destination, []Source{
+			{Name: "primary", Options: []string{"s3://primary", "cluster"}, Env: []string{"CREDENTIALS=expired"}},
+			{Name: "replica", Options: []string{"s3://replica", "cluster"}, Env: []string{"CREDENTIALS=valid"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal("replica"))
+		Expect(os.ReadFile(destination)).To(Equal([]byte("s3://replica\n")))
+
+		calls, err := os.ReadFile(callsPath) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(calls)).To(Equal("s3://primary expired\ns3://replica valid\n"))
+	})
+
+	It("reports the WAL file as not found only when every source was reached", func() {
+		destination := filepath.Join(tempDir, "RECOVERYXLOG")
+		_, err := walRestorer.RestoreWithFallback(walName, destination, []Source{
+			localSource("primary", false),
+			localSource("replica", false),
+		})
+		Expect(err).To(MatchError(ErrWALNotFound))
+
+		_, err = walRestorer.RestoreWithFallback(walName, destination, []Source{
+			unreachableSource("primary"),
+			localSource("replica", false),
+		})
+		Expect(errors.Is(err, ErrConnectivity)).To(BeTrue())
+		Expect(errors.Is(err, ErrWALNotFound)).To(BeFalse())
+
+		_, err = walRestorer.RestoreWithFallback(walName, destination, nil)
+		Expect(err).To(MatchError(ErrNoSource))
+	})
+
+	It("records the source of every restored WAL file", func(ctx SpecContext) {
+		const nextWALName = "000000010000000000000002"
+		primary := localSource("primary", true)
+		replica := localSource("replica", false)
+		Expect(os.WriteFile(filepath.Join(tempDir, "stores", "replica", "cluster", "wals", walName[:16], nextWALName),
+			[]byte("replica"), 0o600)).To(Succeed())
+
+		destination := filepath.Join(tempDir, "RECOVERYXLOG")
+		results := walRestorer.RestoreListWithFallback(ctx, []string{walName, nextWALName}, destination,
+			[]Source{primary, replica})
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].Err).ToNot(HaveOccurred())
+		Expect(results[0].Source).To(Equal("primary"))
+		Expect(results[1].Err).ToNot(HaveOccurred())
+		Expect(results[1].Source).To(Equal("replica"))
+
+		Expect(walRestorer.RestoreFromSpool(nextWALName, filepath.Join(tempDir, "next"))).To(BeTrue())
+	})
+
+	It("prefers the fastest source when requested", func() {
+		sources := []Source{{Name: "far"}, {Name: "close"}, {Name: "unknown"}}
+		walRestorer.latencies.observe("far", 2*time.Second)
+		walRestorer.latencies.observe("close", 100*time.Millisecond)
+
+		Expect(walRestorer.orderSources(sources)).To(Equal(sources))
+
+		walRestorer.SetPreferFastestSource(true)
+		Expect(walRestorer.orderSources(sources)).To(Equal([]Source{{Name: "unknown"}, {Name: "close"}, {Name: "far"}}))
+
+		walRestorer.latencies.observe("close", 10*time.Second)
+		latency, _ := walRestorer.SourceLatency("close")
+		Expect(latency).To(Equal(2080 * time.Millisecond))
+		Expect(walRestorer.orderSources(sources)).To(Equal([]Source{{Name: "unknown"}, {Name: "far"}, {Name: "close"}}))
+	})
+})
@@ -0,0 +1,381 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package restorer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudnative-pg/machinery/pkg/fileutils"
+	"github.com/cloudnative-pg/machinery/pkg/log"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+const (
+	// prefetchStateFileName is the name of the file, inside the spool,
+	// holding the state of the adaptive prefetch, as every WAL file
+	// requested by PostgreSQL is restored by a different process
+	prefetchStateFileName = ".prefetch.json"
+
+	// defaultMaxPrefetchedWALs is the default maximum number of
+	// WAL files prefetched with every requested one
+	defaultMaxPrefetchedWALs = 16
+
+	// defaultSlowDownloadThreshold is the default download latency
+	// above which the prefetch window grows faster
+	defaultSlowDownloadThreshold = time.Second
+
+	// highHitRate is the fraction of prefetched WAL files used
+	// by PostgreSQL above which the prefetch window grows
+	highHitRate = 0.9
+
+	// lowHitRate is the fraction of prefetched WAL files used
+	// by PostgreSQL below which the prefetch window shrinks
+	lowHitRate = 0.5
+)
+
+// PrefetchOptions configures the adaptive prefetch of the WAL files
+type PrefetchOptions struct {
+	// The minimum number of WAL files prefetched with every requested one.
+	// Defaults to 1.
+	MinWALs int
+
+	// The maximum number of WAL files prefetched with every requested one.
+	// Defaults to 16.
+	MaxWALs int
+
+	// The size of the WAL segments. Defaults to 16MiB.
+	WALSegmentSize uint64
+
+	// The download latency above which the prefetch window
+	// grows faster, as every round trip is expensive. Defaults to 1s.
+	SlowDownloadThreshold time.Duration
+}
+
+// prefetchState is the state of the adaptive prefetch
+type prefetchState struct {
+	mutex   sync.Mutex
+	options PrefetchOptions
+
+	// The file where the state is persisted between the restores.
+	// When empty, the state is only kept in memory.
+	path string
+
+	// The number of WAL files to be prefetched with the next request
+	window int
+
+	// The number of WAL files prefetched by the last download
+	prefetched int
+
+	// The number of prefetched WAL files used since the last download
+	hits int
+
+	// The timeline of the last requested WAL file
+	timeline string
+}
+
+// persistedPrefetchState is the content of the file holding the state
+// of the adaptive prefetch
+type persistedPrefetchState struct {
+	Window     int    `json:"window"`
+	Prefetched int    `json:"prefetched"`
+	Hits       int    `json:"hits"`
+	Timeline   string `json:"timeline,omitempty"`
+}
+
+// SetPrefetchOptions configures the adaptive prefetch used by RestoreWithPrefetch
+func (restorer *WALRestorer) SetPrefetchOptions(options PrefetchOptions) {
+	if options.MinWALs <= 0 {
+		options.MinWALs = 1
+	}
+	if options.MaxWALs <= 0 {
+		options.MaxWALs = defaultMaxPrefetchedWALs
+	}
+	options.MaxWALs = max(options.MaxWALs, options.MinWALs)
+	if options.WALSegmentSize == 0 {
+		options.WALSegmentSize = wal.DefaultSegmentSize
+	}
+	if options.SlowDownloadThreshold <= 0 {
+		options.SlowDownloadThreshold = defaultSlowDownloadThreshold
+	}
+
+	restorer.prefetch.mutex.Lock()
+	defer restorer.prefetch.mutex.Unlock()
+
+	restorer.prefetch.options = options
+	restorer.prefetch.reset()
+}
+
+// PrefetchWindow gets the number of WAL files which will be
+// prefetched with the next requested one
+func (restorer *WALRestorer) PrefetchWindow() int {
+	// When the state can't be read, the next restore starts from the minimum window
+	_ = restorer.prefetch.load()
+
+	restorer.prefetch.mutex.Lock()
+	defer restorer.prefetch.mutex.Unlock()
+
+	return restorer.prefetch.window
+}
+
+// RestoreWithPrefetch restores a WAL file requested by PostgreSQL, serving it
+// from the spool when it has already been prefetched. Otherwise, the WAL file
+// is downloaded together with the following ones, whose number adapts to the
+// fraction of prefetched WAL files used by PostgreSQL and to the download latency.
+//
+// The prefetch stops at the end of the WAL stream, when a prefetched WAL file
+// is not found, and on timeline boundaries: history files are never prefetched,
+// and the prefetch window is reset when the timeline changes.
+//
+// The state of the adaptation is persisted in the spool, so that it carries over
+// the restores done by different processes, as PostgreSQL runs restore_command
+// once for every WAL file.
+func (restorer *WALRestorer) RestoreWithPrefetch(
+	ctx context.Context,
+	walName, destinationPath string,
+	options []string,
+) error {
+	contextLog := log.FromContext(ctx)
+
+	if err := restorer.prefetch.load(); err != nil {
+		contextLog.Warning("Cannot read the state of the WAL prefetch, starting from the minimum window",
+			"error", err)
+	}
+	defer func() {
+		if err := restorer.prefetch.save(); err != nil {
+			contextLog.Warning("Cannot save the state of the WAL prefetch", "error", err)
+		}
+	}()
+
+	wasInSpool, err := restorer.RestoreFromSpool(walName, destinationPath)
+	if err != nil {
+		return err
+	}
+	if wasInSpool {
+		restorer.prefetch.hit()
+		return nil
+	}
+
+	// A previous download proved this WAL file is not archived yet, so
+	// we avoid querying the object store again and let PostgreSQL retry
+	isEndOfWALStream, err := restorer.IsEndOfWALStream()
+	if err != nil {
+		return err
+	}
+	if isEndOfWALStream {
+		if err := restorer.ResetEndOfWalStream(); err != nil {
+			return err
+		}
+		return fmt.Errorf("end of WAL stream reached before %s: %w", walName, ErrWALNotFound)
+	}
+
+	fetchList, err := restorer.prefetch.fetchList(walName)
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	results := restorer.RestoreList(ctx, fetchList, destinationPath, options)
+	elapsed := time.Since(startTime)
+
+	prefetched := 0
+	endOfWALStream := false
+	for _, result := range results[1:] {
+		switch {
+		case result.Err == nil:
+			prefetched++
+		case errors.Is(result.Err, ErrWALNotFound):
+			endOfWALStream = true
+		}
+	}
+
+	if endOfWALStream {
+		if err := restorer.SetEndOfWALStream(); err != nil {
+			return err
+		}
+	}
+
+	window := restorer.prefetch.downloaded(prefetched, elapsed, endOfWALStream)
+	contextLog.Debug("Adapted the WAL prefetch window",
+		"walName", walName,
+		"prefetched", prefetched,
+		"endOfWALStream", endOfWALStream,
+		"elapsed", elapsed,
+		"window", window)
+
+	return results[0].Err
+}
+
+// ensureOptions applies the default options when
+// SetPrefetchOptions has never been called
+func (state *prefetchState) ensureOptions() {
+	if state.options.MaxWALs > 0 {
+		return
+	}
+
+	state.options = PrefetchOptions{
+		MinWALs:               1,
+		MaxWALs:               defaultMaxPrefetchedWALs,
+		WALSegmentSize:        wal.DefaultSegmentSize,
+		SlowDownloadThreshold: defaultSlowDownloadThreshold,
+	}
+	state.reset()
+}
+
+// load reads the state of the adaptation from its file, if any,
+// adapting the window to the current options. The state is reset
+// when the file can't be read.
+func (state *prefetchState) load() error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.ensureOptions()
+	if state.path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(state.path) // #nosec G304
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		state.reset()
+		return fmt.Errorf("while reading the prefetch state: %w", err)
+	}
+
+	var persisted persistedPrefetchState
+	if err := json.Unmarshal(content, &persisted); err != nil {
+		state.reset()
+		return fmt.Errorf("while parsing the prefetch state %q: %w", state.path, err)
+	}
+
+	state.window = min(max(persisted.Window, state.options.MinWALs), state.options.MaxWALs)
+	state.prefetched = persisted.Prefetched
+	state.hits = persisted.Hits
+	state.timeline = persisted.Timeline
+	return nil
+}
+
+// save writes the state of the adaptation into its file
+func (state *prefetchState) save() error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.path == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(persistedPrefetchState{
+		Window:     state.window,
+		Prefetched: state.prefetched,
+		Hits:       state.hits,
+		Timeline:   state.timeline,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := fileutils.WriteFileAtomic(state.path, content, 0o600); err != nil {
+		return fmt.Errorf("while writing the prefetch state: %w", err)
+	}
+	return nil
+}
+
+// reset restarts the adaptation from the minimum window
+func (state *prefetchState) reset() {
+	state.window = state.options.MinWALs
+	state.prefetched = 0
+	state.hits = 0
+}
+
+// hit records the usage of a prefetched WAL file
+func (state *prefetchState) hit() {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.hits++
+}
+
+// fetchList gets the list of WAL files to be downloaded when
+// PostgreSQL requests a WAL file which has not been prefetched
+func (state *prefetchState) fetchList(walName string) ([]string, error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.ensureOptions()
+
+	if !wal.IsSegment(walName) {
+		// History files are requested when a new timeline is being
+		// followed, and the adaptation starts again
+		state.reset()
+		state.timeline = ""
+		return []string{walName}, nil
+	}
+
+	if timeline := walName[:8]; timeline != state.timeline {
+		state.reset()
+		state.timeline = timeline
+	}
+
+	nextWALs, err := wal.NextSegments(walName, state.window, state.options.WALSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{walName}, nextWALs...), nil
+}
+
+// downloaded adapts the prefetch window after a download, and returns it.
+// The window grows when most of the previously prefetched WAL files have been
+// used, faster when the download is slow, and shrinks when most of them
+// were wasted or the end of the WAL stream has been reached.
+func (state *prefetchState) downloaded(prefetched int, elapsed time.Duration, endOfWALStream bool) int {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	switch {
+	case endOfWALStream:
+		state.window = state.options.MinWALs
+
+	case state.prefetched > 0:
+		// The WAL files are downloaded in parallel, so the elapsed
+		// time is close to the latency of a single download
+		hitRate := float64(state.hits) / float64(state.prefetched)
+		switch {
+		case hitRate >= highHitRate && elapsed >= state.options.SlowDownloadThreshold:
+			state.window *= 2
+		case hitRate >= highHitRate:
+			state.window++
+		case hitRate < lowHitRate:
+			state.window /= 2
+		}
+	}
+
+	state.window = min(max(state.window, state.options.MinWALs), state.options.MaxWALs)
+	state.prefetched = prefetched
+	state.hits = 0
+	return state.window
+}
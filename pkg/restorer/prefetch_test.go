@@ -0,0 +1,161 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+var _ = Describe("Adaptive prefetch", func() {
+	It("grows the window when the prefetched WAL files are used", func() {
+		state := &prefetchState{}
+		state.ensureOptions()
+
+		Expect(state.fetchList("000000010000000000000001")).To(HaveLen(2))
+		Expect(state.downloaded(1, time.Millisecond, false)).To(Equal(1))
+
+		state.hit()
+		Expect(state.downloaded(2, time.Millisecond, false)).To(Equal(2))
+
+		state.hit()
+		state.hit()
+		Expect(state.downloaded(4, 2*time.Second, false)).To(Equal(4))
+	})
+
+	It("shrinks the window when the prefetched WAL files are wasted", func() {
+		state := &prefetchState{options: PrefetchOptions{MinWALs: 1, MaxWALs: 8}, window: 8, prefetched: 8, hits: 2}
+		Expect(state.downloaded(8, time.Millisecond, false)).To(Equal(4))
+
+		Expect(state.downloaded(3, time.Millisecond, true)).To(Equal(1))
+	})
+
+	It("resets the window on timeline boundaries", func() {
+		state := &prefetchState{options: PrefetchOptions{MinWALs: 1, MaxWALs: 8, WALSegmentSize: wal.DefaultSegmentSize}}
+		state.reset()
+
+		Expect(state.fetchList("000000010000000000000001")).To(HaveLen(2))
+		state.window = 4
+		Expect(state.fetchList("000000010000000000000002")).To(HaveLen(5))
+
+		Expect(state.fetchList("00000002.history")).To(Equal([]string{"00000002.history"}))
+		Expect(state.window).To(Equal(1))
+
+		state.window = 4
+		Expect(state.fetchList("000000020000000000000003")).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("RestoreWithPrefetch", func() {
+	var (
+		tempDir       string
+		walsDirectory string
+		options       []string
+		walRestorer   *WALRestorer
+	)
+
+	archive := func(walNames ...string) {
+		for _, walName := range walNames {
+			Expect(os.WriteFile(filepath.Join(walsDirectory, walName), []byte(walName), 0o600)).To(Succeed())
+		}
+	}
+
+	restore := func(ctx context.Context, walName string) error {
+		return walRestorer.RestoreWithPrefetch(ctx, walName, filepath.Join(tempDir, "RECOVERYXLOG"), options)
+	}
+
+	BeforeEach(func() {
+		tempDir = GinkgoT().TempDir()
+		walsDirectory = filepath.Join(tempDir, "archive", "cluster", "wals", "0000000100000000")
+		Expect(os.MkdirAll(walsDirectory, 0o750)).To(Succeed())
+		options = []string{"file://" + filepath.Join(tempDir, "archive"), "cluster"}
+
+		var err error
+		walRestorer, err = New(context.Background(), nil, filepath.Join(tempDir, "spool"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("prefetches more WAL files while PostgreSQL uses them", func(ctx SpecContext) {
+		archive("000000010000000000000001", "000000010000000000000002", "000000010000000000000003",
+			"000000010000000000000004", "000000010000000000000005", "000000010000000000000006")
+
+		Expect(restore(ctx, "000000010000000000000001")).To(Succeed())
+		Expect(walRestorer.PrefetchWindow()).To(Equal(1))
+
+		Expect(restore(ctx, "000000010000000000000002")).To(Succeed())
+		Expect(os.ReadFile(filepath.Join(tempDir, "RECOVERYXLOG"))).To(Equal([]byte("000000010000000000000002")))
+
+		Expect(restore(ctx, "000000010000000000000003")).To(Succeed())
+		Expect(walRestorer.PrefetchWindow()).To(Equal(2))
+
+		// The next download prefetches two WAL files
+		Expect(restore(ctx, "000000010000000000000004")).To(Succeed())
+		Expect(restore(ctx, "000000010000000000000005")).To(Succeed())
+		Expect(walRestorer.RestoreFromSpool("000000010000000000000006", filepath.Join(tempDir, "next"))).
+			To(BeTrue())
+	})
+
+	It("carries the adaptation over the restorers sharing the spool", func(ctx SpecContext) {
+		archive("000000010000000000000001", "000000010000000000000002", "000000010000000000000003",
+			"000000010000000000000004", "000000010000000000000005", "000000010000000000000006")
+
+		// Every WAL file is restored by a different process, as PostgreSQL does
+		restoreWithNewRestorer := func(walName string) {
+			var err error
+			walRestorer, err = New(ctx, nil, filepath.Join(tempDir, "spool"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(restore(ctx, walName)).To(Succeed())
+		}
+
+		restoreWithNewRestorer("000000010000000000000001")
+		restoreWithNewRestorer("000000010000000000000002")
+		restoreWithNewRestorer("000000010000000000000003")
+
+		otherRestorer, err := New(ctx, nil, filepath.Join(tempDir, "spool"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(otherRestorer.PrefetchWindow()).To(Equal(2))
+
+		// The next download prefetches two WAL files
+		restoreWithNewRestorer("000000010000000000000004")
+		restoreWithNewRestorer("000000010000000000000005")
+		Expect(walRestorer.RestoreFromSpool("000000010000000000000006", filepath.Join(tempDir, "next"))).
+			To(BeTrue())
+	})
+
+	It("stops at the end of the WAL stream", func(ctx SpecContext) {
+		walRestorer.SetPrefetchOptions(PrefetchOptions{MinWALs: 2})
+		archive("000000010000000000000001")
+
+		Expect(restore(ctx, "000000010000000000000001")).To(Succeed())
+		Expect(walRestorer.IsEndOfWALStream()).To(BeTrue())
+
+		// The WAL file is not looked up again until PostgreSQL retries
+		archive("000000010000000000000002")
+		Expect(restore(ctx, "000000010000000000000002")).To(MatchError(ErrWALNotFound))
+		Expect(restore(ctx, "000000010000000000000002")).To(Succeed())
+	})
+})
@@ -24,15 +24,21 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/cloudnative-pg/machinery/pkg/execlog"
 	"github.com/cloudnative-pg/machinery/pkg/log"
 
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
 	"github.com/cloudnative-pg/barman-cloud/pkg/spool"
+	"github.com/cloudnative-pg/barman-cloud/pkg/stats"
+	"github.com/cloudnative-pg/barman-cloud/pkg/throttle"
 	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
 )
 
 const (
@@ -47,7 +53,7 @@ var ErrWALNotFound = errors.New("WAL not found")
 var ErrConnectivity = errors.New("connectivity failure")
 
 // ErrInvalidWALName is returned when the WAL name is not valid
-var ErrInvalidWALName = errors.New("invalid WAL name")
+var ErrInvalidWALName = wal.ErrInvalidName
 
 // ErrGeneric is returned when barman-cloud-wal-restore fails with a generic
 // error
@@ -63,8 +69,30 @@ type WALRestorer struct {
 	// The spool of WAL files to be archived in parallel
 	spool *spool.WALSpool
 
+	// The directory of the spool, also holding the state
+	// of the prefetch and of the limiter
+	spoolDirectory string
+
 	// The environment that should be used to invoke barman-cloud-wal-archive
 	env []string
+
+	// The latency observed for every source, used to prefer
+	// the fastest one when restoring from multiple sources
+	latencies sourceLatencies
+
+	// True to try the fastest source first, instead of
+	// following the order in which they are passed
+	preferFastestSource bool
+
+	// The state of the adaptive prefetch of the WAL files
+	prefetch prefetchState
+
+	// The statistics of the restore of the WAL files
+	statistics *stats.File
+
+	// Limits the bandwidth and the request rate shared
+	// by the concurrent restores. Nil means no limit.
+	limiter *throttle.Limiter
 }
 
 // Result is the structure filled by the restore process on completion
@@ -83,6 +111,10 @@ type Result struct {
 
 	// The time when end barman-cloud-wal-archive ended
 	EndTime time.Time
+
+	// The name of the source which served the WAL file, only
+	// set when restoring from multiple sources
+	Source string
 }
 
 // New creates a new WAL restorer
@@ -100,9 +132,12 @@ func New(
 	}
 
 	restorer = &WALRestorer{
-		spool: walRecoverSpool,
-		env:   env,
+		spool:          walRecoverSpool,
+		spoolDirectory: spoolDirectory,
+		env:            env,
+		statistics:     stats.NewFile(filepath.Join(spoolDirectory, statisticsFileName)),
 	}
+	restorer.prefetch.path = filepath.Join(spoolDirectory, prefetchStateFileName)
 	return restorer, nil
 }
 
@@ -169,9 +204,27 @@ func (restorer *WALRestorer) RestoreList(
 	fetchList []string,
 	destinationPath string,
 	options []string,
+) (resultList []Result) {
+	restore := func(walName, downloadPath string) (string, error) {
+		return "", restorer.Restore(walName, downloadPath, options)
+	}
+
+	return restorer.restoreList(ctx, fetchList, destinationPath, restore, "options", options)
+}
+
+// restoreList restores a list of WALs in parallel with the passed function,
+// which returns the name of the source of the WAL file, if known.
+// The key-value pairs are added to the log entries of the failures.
+func (restorer *WALRestorer) restoreList(
+	ctx context.Context,
+	fetchList []string,
+	destinationPath string,
+	restore func(walName, downloadPath string) (string, error),
+	logKeysAndValues ...any,
 ) (resultList []Result) {
 	resultList = make([]Result, len(fetchList))
 	contextLog := log.FromContext(ctx)
+	failureLog := contextLog.WithValues(logKeysAndValues...)
 	var waitGroup sync.WaitGroup
 
 	for idx := range fetchList {
@@ -195,7 +248,7 @@ func (restorer *WALRestorer) RestoreList(
 			}
 
 			result.StartTime = time.Now()
-			result.Err = restorer.Restore(fetchList[walIndex], downloadPath, options)
+			result.Source, result.Err = restorer.throttledRestore(ctx, fetchList[walIndex], downloadPath, restore)
 			result.EndTime = time.Now()
 
 			// For prefetched WALs, commit the temp file to make it visible,
@@ -216,6 +269,7 @@ func (restorer *WALRestorer) RestoreList(
 				contextLog.Info(
 					"Restored WAL file",
 					"walName", result.WalName,
+					"source", result.Source,
 					"startTime", result.StartTime,
 					"endTime", result.EndTime,
 					"elapsedWalTime", elapsedWalTime)
@@ -226,18 +280,16 @@ func (restorer *WALRestorer) RestoreList(
 				// The implemented prefetch is speculative and this WAL may just
 				// not exist, this means that this may not be a real error.
 				if errors.Is(result.Err, ErrWALNotFound) {
-					contextLog.Info(
+					failureLog.Info(
 						"WAL file not found in the recovery object store",
 						"walName", result.WalName,
-						"options", options,
 						"startTime", result.StartTime,
 						"endTime", result.EndTime,
 						"elapsedWalTime", elapsedWalTime)
 				} else {
-					contextLog.Warning(
+					failureLog.Warning(
 						"Failed restoring WAL file (Postgres might retry)",
 						"walName", result.WalName,
-						"options", options,
 						"startTime", result.StartTime,
 						"endTime", result.EndTime,
 						"elapsedWalTime", elapsedWalTime,
@@ -249,9 +301,47 @@ func (restorer *WALRestorer) RestoreList(
 	}
 
 	waitGroup.Wait()
+	restorer.recordStatistics(ctx, resultList)
 	return resultList
 }
 
+// throttledRestore restores a WAL file with the passed function, respecting
+// the limits of the restorer. As the size of the WAL file is known only
+// once it has been downloaded, its bytes delay the following downloads.
+func (restorer *WALRestorer) throttledRestore(
+	ctx context.Context,
+	walName, downloadPath string,
+	restore func(walName, downloadPath string) (string, error),
+) (string, error) {
+	if err := restorer.limiter.WaitRequest(ctx); err != nil {
+		return "", fmt.Errorf("while waiting to restore WAL file %s: %w", walName, err)
+	}
+
+	source, err := restore(walName, downloadPath)
+	if err != nil {
+		return source, err
+	}
+
+	if info, statErr := os.Stat(downloadPath); statErr == nil {
+		if err := restorer.limiter.WaitBytes(ctx, info.Size()); err != nil {
+			return source, fmt.Errorf("while waiting after restoring WAL file %s: %w", walName, err)
+		}
+	}
+
+	return source, nil
+}
+
+// limiterStateFileName is the name of the file, inside the spool,
+// holding the state of the limiter shared by the restores
+const limiterStateFileName = ".limiter.json"
+
+// SetLimiter limits the bandwidth and the request rate used
+// by the restores, such as with throttle.NewFromConfiguration.
+// The limits are shared through the spool by the restorers using it.
+func (restorer *WALRestorer) SetLimiter(limiter *throttle.Limiter) {
+	restorer.limiter = limiter.WithSharedState(filepath.Join(restorer.spoolDirectory, limiterStateFileName))
+}
+
 // errorForExitCode maps a barman-cloud-wal-restore exit code to the
 // corresponding wrapped sentinel error so callers can identify the
 // failure class via errors.Is.
@@ -290,6 +380,20 @@ func (restorer *WALRestorer) Restore(
 	walName, destinationPath string,
 	baseOptions []string,
 ) error {
+	return restorer.restoreWithEnv(walName, destinationPath, baseOptions, restorer.env)
+}
+
+// restoreWithEnv restores a WAL file from the object store, running
+// barman-cloud-wal-restore with the passed environment
+func (restorer *WALRestorer) restoreWithEnv(
+	walName, destinationPath string,
+	baseOptions []string,
+	env []string,
+) error {
+	if !wal.IsValid(walName) {
+		return fmt.Errorf("invalid name for a WAL file %q: %w", walName, ErrInvalidWALName)
+	}
+
 	optionsLength := len(baseOptions)
 	if optionsLength >= math.MaxInt-2 {
 		return fmt.Errorf("can't restore wal file %v, options too long", walName)
@@ -298,12 +402,24 @@ func (restorer *WALRestorer) Restore(
 	copy(options, baseOptions)
 	options = append(options, walName, destinationPath)
 
+	store, err := filestore.NewFromOptions(baseOptions)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		err := store.RestoreWAL(walName, destinationPath)
+		if errors.Is(err, filestore.ErrObjectNotFound) {
+			return fmt.Errorf("object storage or file not found %s: %w", walName, ErrWALNotFound)
+		}
+		return err
+	}
+
 	barmanCloudWalRestoreCmd := exec.Command(
 		utils.BarmanCloudWalRestore,
 		options...) // #nosec G204
-	barmanCloudWalRestoreCmd.Env = restorer.env
+	barmanCloudWalRestoreCmd.Env = env
 
-	err := execlog.RunStreaming(barmanCloudWalRestoreCmd, utils.BarmanCloudWalRestore)
+	err = execlog.RunStreaming(barmanCloudWalRestoreCmd, utils.BarmanCloudWalRestore)
 	if err == nil {
 		return nil
 	}
@@ -316,3 +432,21 @@ func (restorer *WALRestorer) Restore(
 
 	return errorForExitCode(exitError.ExitCode(), walName)
 }
+
+// Fetch restores a file from the object store, such as a WAL segment or
+// a timeline history file, and returns its content. The file is
+// temporarily stored in the passed directory.
+func (restorer *WALRestorer) Fetch(
+	walName, directory string,
+	baseOptions []string,
+) ([]byte, error) {
+	destinationPath := filepath.Join(directory, walName)
+	if err := restorer.Restore(walName, destinationPath, baseOptions); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.Remove(destinationPath)
+	}()
+
+	return os.ReadFile(destinationPath) // #nosec G304
+}
@@ -20,8 +20,11 @@ SPDX-License-Identifier: Apache-2.0
 package restorer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -63,3 +66,40 @@ var _ = Describe("errorForExitCode", func() {
 		Expect(errors.Is(outer, ErrConnectivity)).To(BeTrue())
 	})
 })
+
+var _ = Describe("Fetch", func() {
+	It("reads the content of the archived files", func() {
+		tempDir := GinkgoT().TempDir()
+		archive := filepath.Join(tempDir, "archive", "cluster-example", "wals")
+		Expect(os.MkdirAll(archive, 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(archive, "00000002.history"),
+			[]byte("1\t0/5000000\tno recovery target specified\n"), 0o600)).To(Succeed())
+
+		walRestorer, err := New(context.Background(), nil, filepath.Join(tempDir, "spool"))
+		Expect(err).ToNot(HaveOccurred())
+
+		options := []string{"file://" + filepath.Join(tempDir, "archive"), "cluster-example"}
+		content, err := walRestorer.Fetch("00000002.history", tempDir, options)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(HavePrefix("1\t0/5000000"))
+		Expect(filepath.Join(tempDir, "00000002.history")).ToNot(BeAnExistingFile())
+
+		_, err = walRestorer.Fetch("00000003.history", tempDir, options)
+		Expect(err).To(MatchError(ErrWALNotFound))
+	})
+})
+
+var _ = Describe("Restore", func() {
+	It("refuses the names which are not in the WAL archive", func() {
+		tempDir := GinkgoT().TempDir()
+		walRestorer, err := New(context.Background(), nil, filepath.Join(tempDir, "spool"))
+		Expect(err).ToNot(HaveOccurred())
+
+		// barman-cloud-wal-restore would fail for a missing executable,
+		// so the name must be refused before running it
+		GinkgoT().Setenv("PATH", tempDir)
+		err = walRestorer.Restore("../000000010000000000000001", filepath.Join(tempDir, "RECOVERYXLOG"),
+			[]string{"s3://bucket", "cluster-example"})
+		Expect(err).To(MatchError(ErrInvalidWALName))
+	})
+})
@@ -0,0 +1,77 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package restorer
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/cloudnative-pg/machinery/pkg/log"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/stats"
+)
+
+// statisticsFileName is the name of the file, inside
+// the spool, holding the statistics of the restore
+const statisticsFileName = ".statistics.json"
+
+// Statistics gets the statistics of the restore of the WAL files
+func (restorer *WALRestorer) Statistics() (*stats.Statistics, error) {
+	return restorer.statistics.Read()
+}
+
+// StatisticsPath gets the path of the file holding the
+// statistics of the restore of the WAL files
+func (restorer *WALRestorer) StatisticsPath() string {
+	return restorer.statistics.Path()
+}
+
+// recordStatistics updates the statistics with the results of the restore.
+// The WAL files not found are not failures, as they are expected at the
+// end of the WAL stream and when prefetching. Failing to update the
+// statistics doesn't affect the restore.
+func (restorer *WALRestorer) recordStatistics(ctx context.Context, resultList []Result) {
+	events := make([]stats.Event, 0, len(resultList))
+	for _, result := range resultList {
+		if errors.Is(result.Err, ErrWALNotFound) {
+			continue
+		}
+
+		event := stats.Event{
+			WalName:   result.WalName,
+			Err:       result.Err,
+			StartTime: result.StartTime,
+			EndTime:   result.EndTime,
+		}
+		if result.Err == nil {
+			if info, err := os.Stat(result.DestinationPath); err == nil {
+				event.Bytes = info.Size()
+			}
+		}
+		events = append(events, event)
+	}
+
+	if err := restorer.statistics.Record(events...); err != nil {
+		log.FromContext(ctx).Warning("Cannot update the restore statistics",
+			"statisticsPath", restorer.statistics.Path(),
+			"error", err)
+	}
+}
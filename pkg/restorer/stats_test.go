@@ -0,0 +1,59 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package restorer
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Restore statistics", func() {
+	It("records the restored WAL files, ignoring the ones not found", func(ctx SpecContext) {
+		const (
+			walName     = "000000010000000000000001"
+			nextWALName = "000000010000000000000002"
+		)
+
+		tempDir := GinkgoT().TempDir()
+		walDirectory := filepath.Join(tempDir, "archive", "cluster", "wals", walName[:16])
+		Expect(os.MkdirAll(walDirectory, 0o750)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(walDirectory, walName), []byte("content"), 0o600)).To(Succeed())
+
+		walRestorer, err := New(ctx, nil, filepath.Join(tempDir, "spool"))
+		Expect(err).ToNot(HaveOccurred())
+
+		options := []string{"file://" + filepath.Join(tempDir, "archive"), "cluster"}
+		results := walRestorer.RestoreList(ctx, []string{walName, nextWALName},
+			filepath.Join(tempDir, "RECOVERYXLOG"), options)
+		Expect(results[0].Err).ToNot(HaveOccurred())
+		Expect(results[1].Err).To(MatchError(ErrWALNotFound))
+
+		statistics, err := walRestorer.Statistics()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statistics.Count).To(Equal(int64(1)))
+		Expect(statistics.Bytes).To(Equal(int64(len("content"))))
+		Expect(statistics.LastWAL).To(Equal(walName))
+		Expect(statistics.FailedCount).To(BeZero())
+		Expect(walRestorer.StatisticsPath()).To(BeAnExistingFile())
+	})
+})
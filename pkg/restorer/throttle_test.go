@@ -0,0 +1,89 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/throttle"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Restore throttling", func() {
+	const walName = "000000010000000000000001"
+
+	var (
+		walRestorer  *WALRestorer
+		downloadPath string
+		restored     []string
+	)
+
+	restore := func(walName, downloadPath string) (string, error) {
+		restored = append(restored, walName)
+		return "archive", os.WriteFile(downloadPath, []byte("content"), 0o600)
+	}
+
+	BeforeEach(func(ctx SpecContext) {
+		tempDir := GinkgoT().TempDir()
+		downloadPath = filepath.Join(tempDir, "RECOVERYXLOG")
+		restored = nil
+
+		var err error
+		walRestorer, err = New(ctx, nil, filepath.Join(tempDir, "spool"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("restores without waiting when there are no limits", func(ctx SpecContext) {
+		source, err := walRestorer.throttledRestore(ctx, walName, downloadPath, restore)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(source).To(Equal("archive"))
+		Expect(restored).To(ConsistOf(walName))
+	})
+
+	It("doesn't restore when the limiter can't grant a request", func(ctx SpecContext) {
+		walRestorer.SetLimiter(throttle.New(0, 1))
+
+		cancelCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := walRestorer.throttledRestore(cancelCtx, walName, downloadPath, restore)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(restored).To(BeEmpty())
+	})
+
+	It("takes the bytes of the restored file from the limiter", func(ctx SpecContext) {
+		walRestorer.SetLimiter(throttle.New(int64(len("content")), 0))
+
+		_, err := walRestorer.throttledRestore(ctx, walName, downloadPath, restore)
+		Expect(err).ToNot(HaveOccurred())
+
+		// The bucket has been emptied by the first restore, and
+		// refilling it takes longer than the deadline
+		timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err = walRestorer.throttledRestore(timeoutCtx, walName, downloadPath, restore)
+		Expect(err).To(HaveOccurred())
+		Expect(restored).To(HaveLen(2))
+	})
+})
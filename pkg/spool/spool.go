@@ -25,6 +25,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/cloudnative-pg/machinery/pkg/fileutils"
 	"github.com/cloudnative-pg/machinery/pkg/log"
@@ -93,6 +94,27 @@ func (spool *WALSpool) Touch(walFile string) (err error) {
 	return nil
 }
 
+// List gets the names of the WAL files in the spool, sorted by name.
+// Files which are still being downloaded are not included, as well as
+// hidden files, which hold the state of the archiver and the restorer.
+func (spool *WALSpool) List() ([]string, error) {
+	entries, err := os.ReadDir(spool.spoolDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasSuffix(entry.Name(), tempSuffix) ||
+			strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		result = append(result, entry.Name())
+	}
+
+	return result, nil
+}
+
 // MoveOut moves out a file from the spool to the destination file
 func (spool *WALSpool) MoveOut(walName, destination string) (err error) {
 	// We cannot use os.Rename here, as it will not work between different
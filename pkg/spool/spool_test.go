@@ -77,6 +77,16 @@ var _ = Describe("Spool", func() {
 		Expect(spool.Contains(walFile)).To(BeFalse())
 	})
 
+	It("lists the WAL files in the spool", func() {
+		Expect(spool.Touch("000000010000000000000002")).To(Succeed())
+		Expect(spool.Touch("000000010000000000000001")).To(Succeed())
+		Expect(os.WriteFile(spool.TempFileName("000000010000000000000003"), nil, 0o600)).To(Succeed())
+		Expect(os.Mkdir(path.Join(tmpDir, "subdirectory"), 0o750)).To(Succeed())
+		Expect(os.WriteFile(path.Join(tmpDir, ".statistics.json"), nil, 0o600)).To(Succeed())
+
+		Expect(spool.List()).To(Equal([]string{"000000010000000000000001", "000000010000000000000002"}))
+	})
+
 	It("can move out files from the spool", func() {
 		var err error
 		const walFile = "000000020000068A00000003"
@@ -0,0 +1,225 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Statistics is the state of the archival or restore of WAL files
+type Statistics struct {
+	// The number of WAL files processed successfully
+	Count int64 `json:"count"`
+
+	// The total size, in bytes, of the WAL files processed successfully
+	Bytes int64 `json:"bytes"`
+
+	// The total time spent processing the WAL files successfully
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+
+	// The name of the last WAL file processed successfully
+	LastWAL string `json:"lastWal,omitempty"`
+
+	// The time when the last WAL file was processed successfully
+	LastTime *time.Time `json:"lastTime,omitempty"`
+
+	// The time spent processing the last WAL file
+	LastElapsedSeconds float64 `json:"lastElapsedSeconds,omitempty"`
+
+	// The number of failed attempts to process a WAL file
+	FailedCount int64 `json:"failedCount"`
+
+	// The number of failed attempts since the last success
+	ConsecutiveFailedCount int64 `json:"consecutiveFailedCount"`
+
+	// The name of the WAL file of the last failed attempt
+	LastFailedWAL string `json:"lastFailedWal,omitempty"`
+
+	// The time of the last failed attempt
+	LastFailedTime *time.Time `json:"lastFailedTime,omitempty"`
+
+	// The error of the last failed attempt
+	LastFailedError string `json:"lastFailedError,omitempty"`
+
+	// The time when the statistics were reset
+	StatsReset time.Time `json:"statsReset"`
+}
+
+// Event is the outcome of the processing of a WAL file
+type Event struct {
+	// The name of the WAL file
+	WalName string
+
+	// The size of the WAL file, when processed successfully
+	Bytes int64
+
+	// If not nil, the processing failed with this error
+	Err error
+
+	// The time when the processing started
+	StartTime time.Time
+
+	// The time when the processing ended
+	EndTime time.Time
+}
+
+// File is a JSON file holding the statistics. The updates are serialized
+// inside a process, while different processes are expected not to update
+// the same file concurrently, as PostgreSQL runs archive_command and
+// restore_command one at a time.
+type File struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// NewFile creates a statistics file at the passed path. The
+// file is written when the first event is recorded.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Path gets the path of the statistics file
+func (file *File) Path() string {
+	return file.path
+}
+
+// Read reads the statistics
+func (file *File) Read() (*Statistics, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	return ReadFile(file.path)
+}
+
+// Record updates the statistics with the outcome of
+// the processing of some WAL files
+func (file *File) Record(events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	statistics, err := ReadFile(file.path)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		statistics.add(event)
+	}
+
+	return file.write(statistics)
+}
+
+// Reset clears the statistics
+func (file *File) Reset() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	return file.write(&Statistics{StatsReset: time.Now()})
+}
+
+// ReadFile reads the statistics from a file. Empty statistics
+// are returned when the file doesn't exist yet.
+func ReadFile(path string) (*Statistics, error) {
+	content, err := os.ReadFile(path) // #nosec G304
+	if errors.Is(err, os.ErrNotExist) {
+		return &Statistics{StatsReset: time.Now()}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading the statistics file: %w", err)
+	}
+
+	var statistics Statistics
+	if err := json.Unmarshal(content, &statistics); err != nil {
+		return nil, fmt.Errorf("while parsing the statistics file %q: %w", path, err)
+	}
+
+	return &statistics, nil
+}
+
+// add updates the statistics with an event
+func (statistics *Statistics) add(event Event) {
+	endTime := event.EndTime
+	elapsedSeconds := event.EndTime.Sub(event.StartTime).Seconds()
+
+	if event.Err != nil {
+		statistics.FailedCount++
+		statistics.ConsecutiveFailedCount++
+		statistics.LastFailedWAL = event.WalName
+		statistics.LastFailedTime = &endTime
+		statistics.LastFailedError = event.Err.Error()
+		return
+	}
+
+	statistics.Count++
+	statistics.ConsecutiveFailedCount = 0
+	statistics.Bytes += event.Bytes
+	statistics.ElapsedSeconds += elapsedSeconds
+	if statistics.LastTime == nil || !endTime.Before(*statistics.LastTime) {
+		statistics.LastWAL = event.WalName
+		statistics.LastTime = &endTime
+		statistics.LastElapsedSeconds = elapsedSeconds
+	}
+}
+
+// write atomically replaces the statistics file, so that
+// readers never see a partially written one
+func (file *File) write(statistics *Statistics) error {
+	content, err := json.MarshalIndent(statistics, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file.path), 0o750); err != nil {
+		return fmt.Errorf("while creating the statistics directory: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(file.path), filepath.Base(file.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("while writing the statistics file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		_ = os.Remove(tempPath)
+	}()
+
+	if _, err := tempFile.Write(content); err != nil {
+		_ = tempFile.Close()
+		return fmt.Errorf("while writing the statistics file: %w", err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		_ = tempFile.Close()
+		return fmt.Errorf("while writing the statistics file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("while writing the statistics file: %w", err)
+	}
+
+	return os.Rename(tempPath, file.path)
+}
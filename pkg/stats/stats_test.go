@@ -0,0 +1,108 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package stats
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Statistics file", func() {
+	var file *File
+
+	BeforeEach(func() {
+		file = NewFile(filepath.Join(GinkgoT().TempDir(), "spool", ".statistics.json"))
+	})
+
+	It("starts with empty statistics", func() {
+		statistics, err := file.Read()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statistics.Count).To(BeZero())
+		Expect(statistics.LastTime).To(BeNil())
+		Expect(file.Path()).ToNot(BeAnExistingFile())
+	})
+
+	It("records the successes and the failures", func() {
+		startTime := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+		Expect(file.Record(
+			Event{
+				WalName:   "000000010000000000000001",
+				Bytes:     100,
+				StartTime: startTime,
+				EndTime:   startTime.Add(2 * time.Second),
+			},
+			Event{
+				WalName:   "000000010000000000000002",
+				Bytes:     50,
+				StartTime: startTime,
+				EndTime:   startTime.Add(time.Second),
+			},
+		)).To(Succeed())
+		Expect(file.Record(Event{
+			WalName:   "000000010000000000000003",
+			Err:       errors.New("connectivity failure"),
+			StartTime: startTime.Add(time.Minute),
+			EndTime:   startTime.Add(time.Minute + time.Second),
+		})).To(Succeed())
+
+		statistics, err := ReadFile(file.Path())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statistics.Count).To(Equal(int64(2)))
+		Expect(statistics.Bytes).To(Equal(int64(150)))
+		Expect(statistics.ElapsedSeconds).To(BeNumerically("~", 3))
+		Expect(statistics.LastWAL).To(Equal("000000010000000000000001"))
+		Expect(*statistics.LastTime).To(BeTemporally("==", startTime.Add(2*time.Second)))
+		Expect(statistics.LastElapsedSeconds).To(BeNumerically("~", 2))
+		Expect(statistics.FailedCount).To(Equal(int64(1)))
+		Expect(statistics.ConsecutiveFailedCount).To(Equal(int64(1)))
+		Expect(statistics.LastFailedWAL).To(Equal("000000010000000000000003"))
+		Expect(statistics.LastFailedError).To(Equal("connectivity failure"))
+	})
+
+	It("resets the statistics", func() {
+		Expect(file.Record(Event{WalName: "000000010000000000000001", EndTime: time.Now()})).To(Succeed())
+		Expect(file.Reset()).To(Succeed())
+
+		statistics, err := file.Read()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(statistics.Count).To(BeZero())
+		Expect(statistics.StatsReset).ToNot(BeZero())
+	})
+
+	It("leaves no temporary files behind", func() {
+		Expect(file.Record(Event{WalName: "000000010000000000000001", EndTime: time.Now()})).To(Succeed())
+
+		entries, err := os.ReadDir(filepath.Dir(file.Path()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("refuses corrupted files", func() {
+		Expect(os.MkdirAll(filepath.Dir(file.Path()), 0o750)).To(Succeed())
+		Expect(os.WriteFile(file.Path(), []byte("{"), 0o600)).To(Succeed())
+		_, err := file.Read()
+		Expect(err).To(HaveOccurred())
+	})
+})
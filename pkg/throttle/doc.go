@@ -0,0 +1,26 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package throttle limits the bandwidth and the request rate used to
+// transfer WAL files to and from the object store, sharing the limits
+// across the archivals and restores running concurrently. The limits
+// can also be shared between processes through a state file, as
+// PostgreSQL runs a new process for every WAL file to be archived or
+// restored.
+package throttle
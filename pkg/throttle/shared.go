@@ -0,0 +1,146 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package throttle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sharedBuckets is the content of the file holding the state of the token
+// buckets of a shared limiter. Following the generic cell rate algorithm,
+// every bucket is tracked by the time when it will be full again.
+type sharedBuckets struct {
+	Bandwidth time.Time `json:"bandwidth"`
+	Requests  time.Time `json:"requests"`
+}
+
+// WithSharedState gets a limiter enforcing the same limits, whose token
+// buckets are stored in the passed file instead of in memory. The limits
+// are then shared by every process using the same file, such as the ones
+// run by PostgreSQL to archive or restore every WAL file.
+func (limiter *Limiter) WithSharedState(path string) *Limiter {
+	if limiter == nil {
+		return nil
+	}
+
+	return &Limiter{
+		bandwidth: limiter.bandwidth,
+		requests:  limiter.requests,
+		statePath: path,
+	}
+}
+
+// reserveShared takes the passed number of tokens from a bucket stored in the
+// state file, and waits until they are available. The tokens are not given
+// back when the context is canceled while waiting.
+func (limiter *Limiter) reserveShared(
+	ctx context.Context,
+	bucketLimiter *rate.Limiter,
+	bucket func(*sharedBuckets) *time.Time,
+	tokens int64,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delay, err := limiter.reserveSharedTokens(bucketLimiter, bucket, tokens)
+	if err != nil {
+		return err
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserveSharedTokens updates the state file taking the passed number of
+// tokens from a bucket, and returns the time to wait for them
+func (limiter *Limiter) reserveSharedTokens(
+	bucketLimiter *rate.Limiter,
+	bucket func(*sharedBuckets) *time.Time,
+	tokens int64,
+) (time.Duration, error) {
+	file, err := os.OpenFile(limiter.statePath, os.O_RDWR|os.O_CREATE, 0o600) // #nosec G304
+	if err != nil {
+		return 0, fmt.Errorf("while opening the limiter state: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if err := lockFile(file); err != nil {
+		return 0, fmt.Errorf("while locking the limiter state: %w", err)
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return 0, fmt.Errorf("while reading the limiter state: %w", err)
+	}
+
+	// An unreadable state, as left by a crash while writing it,
+	// is replaced by full buckets
+	var buckets sharedBuckets
+	if len(content) > 0 && json.Unmarshal(content, &buckets) != nil {
+		buckets = sharedBuckets{}
+	}
+
+	limit := float64(bucketLimiter.Limit())
+	tolerance := time.Duration(float64(bucketLimiter.Burst()) / limit * float64(time.Second))
+	cost := time.Duration(float64(tokens) / limit * float64(time.Second))
+
+	now := time.Now()
+	fullAt := bucket(&buckets)
+	*fullAt = now.Add(max(fullAt.Sub(now), 0) + cost)
+
+	if content, err = json.Marshal(buckets); err != nil {
+		return 0, err
+	}
+	if err := file.Truncate(0); err != nil {
+		return 0, fmt.Errorf("while writing the limiter state: %w", err)
+	}
+	if _, err := file.WriteAt(content, 0); err != nil {
+		return 0, fmt.Errorf("while writing the limiter state: %w", err)
+	}
+
+	return fullAt.Sub(now) - tolerance, nil
+}
+
+func bandwidthBucket(buckets *sharedBuckets) *time.Time {
+	return &buckets.Bandwidth
+}
+
+func requestsBucket(buckets *sharedBuckets) *time.Time {
+	return &buckets.Requests
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package throttle
+
+import (
+	"context"
+	"math"
+
+	"golang.org/x/time/rate"
+
+	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+)
+
+// Limiter limits the bandwidth and the request rate with two token buckets,
+// one holding bytes and the other requests. Every transfer takes a request,
+// and as many bytes as the size of the transferred file. A nil Limiter
+// doesn't limit anything.
+type Limiter struct {
+	bandwidth *rate.Limiter
+	requests  *rate.Limiter
+
+	// The file holding the token buckets when they are
+	// shared between processes, see WithSharedState
+	statePath string
+}
+
+// New creates a limiter allowing at most the passed number of bytes
+// and requests per second. Zero disables the related limit, and nil
+// is returned when nothing is limited.
+func New(bytesPerSecond int64, requestsPerSecond int) *Limiter {
+	if bytesPerSecond <= 0 && requestsPerSecond <= 0 {
+		return nil
+	}
+
+	limiter := &Limiter{}
+	if bytesPerSecond > 0 {
+		// The bucket holds the bytes of one second, and larger
+		// transfers take the tokens of multiple seconds
+		burst := int(min(bytesPerSecond, math.MaxInt32))
+		limiter.bandwidth = rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+	}
+	if requestsPerSecond > 0 {
+		limiter.requests = rate.NewLimiter(rate.Limit(requestsPerSecond), requestsPerSecond)
+	}
+
+	return limiter
+}
+
+// NewFromConfiguration creates a limiter enforcing the limits of
+// the configuration of the WAL archive
+func NewFromConfiguration(configuration *barmanApi.WalBackupConfiguration) *Limiter {
+	if configuration == nil {
+		return nil
+	}
+
+	var bytesPerSecond int64
+	if configuration.MaxBandwidth != nil {
+		bytesPerSecond = configuration.MaxBandwidth.Value()
+	}
+
+	var requestsPerSecond int
+	if configuration.MaxRequestsPerSecond != nil {
+		requestsPerSecond = int(*configuration.MaxRequestsPerSecond)
+	}
+
+	return New(bytesPerSecond, requestsPerSecond)
+}
+
+// Wait blocks until a file of the passed size can be transferred
+func (limiter *Limiter) Wait(ctx context.Context, bytes int64) error {
+	if err := limiter.WaitRequest(ctx); err != nil {
+		return err
+	}
+
+	return limiter.WaitBytes(ctx, bytes)
+}
+
+// WaitRequest blocks until a request can be made
+func (limiter *Limiter) WaitRequest(ctx context.Context) error {
+	if limiter == nil || limiter.requests == nil {
+		return nil
+	}
+
+	if limiter.statePath != "" {
+		return limiter.reserveShared(ctx, limiter.requests, requestsBucket, 1)
+	}
+
+	return limiter.requests.Wait(ctx)
+}
+
+// WaitBytes blocks until the passed number of bytes can be transferred.
+// When the size of a file is known only after the transfer, such as
+// when restoring, waiting afterwards delays the following transfers.
+func (limiter *Limiter) WaitBytes(ctx context.Context, bytes int64) error {
+	if limiter == nil || limiter.bandwidth == nil || bytes <= 0 {
+		return nil
+	}
+
+	if limiter.statePath != "" {
+		return limiter.reserveShared(ctx, limiter.bandwidth, bandwidthBucket, bytes)
+	}
+
+	burst := int64(limiter.bandwidth.Burst())
+	for bytes > 0 {
+		chunk := min(bytes, burst)
+		if err := limiter.bandwidth.WaitN(ctx, int(chunk)); err != nil {
+			return err
+		}
+		bytes -= chunk
+	}
+
+	return nil
+}
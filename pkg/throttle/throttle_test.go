@@ -0,0 +1,138 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package throttle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+
+	barmanApi "github.com/cloudnative-pg/barman-cloud/pkg/api"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("New", func() {
+	It("doesn't limit anything without limits", func() {
+		Expect(New(0, 0)).To(BeNil())
+		Expect(NewFromConfiguration(nil)).To(BeNil())
+		Expect(NewFromConfiguration(&barmanApi.WalBackupConfiguration{})).To(BeNil())
+	})
+
+	It("reads the limits from the configuration", func() {
+		bandwidth := resource.MustParse("1Mi")
+		limiter := NewFromConfiguration(&barmanApi.WalBackupConfiguration{
+			MaxBandwidth:         &bandwidth,
+			MaxRequestsPerSecond: ptr.To(int32(5)),
+		})
+		Expect(limiter).ToNot(BeNil())
+		Expect(limiter.bandwidth.Limit()).To(BeNumerically("==", 1024*1024))
+		Expect(limiter.bandwidth.Burst()).To(Equal(1024 * 1024))
+		Expect(limiter.requests.Limit()).To(BeNumerically("==", 5))
+	})
+
+	It("limits only what has been requested", func() {
+		limiter := New(0, 10)
+		Expect(limiter.bandwidth).To(BeNil())
+		Expect(limiter.requests).ToNot(BeNil())
+	})
+})
+
+var _ = Describe("Limiter", func() {
+	It("doesn't block when nil", func(ctx SpecContext) {
+		var limiter *Limiter
+		Expect(limiter.Wait(ctx, 1<<40)).To(Succeed())
+		Expect(limiter.WaitRequest(ctx)).To(Succeed())
+		Expect(limiter.WaitBytes(ctx, 1<<40)).To(Succeed())
+	})
+
+	It("paces the requests", func(ctx SpecContext) {
+		limiter := New(0, 20)
+		start := time.Now()
+		for range 30 {
+			Expect(limiter.WaitRequest(ctx)).To(Succeed())
+		}
+		// The first 20 requests are in the burst, the others take 50ms each
+		Expect(time.Since(start)).To(BeNumerically(">=", 400*time.Millisecond))
+	})
+
+	It("splits transfers larger than the burst", func(ctx SpecContext) {
+		limiter := New(1000, 0)
+		start := time.Now()
+		Expect(limiter.WaitBytes(ctx, 1500)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 400*time.Millisecond))
+	})
+
+	It("stops waiting when the context is canceled", func(ctx SpecContext) {
+		limiter := New(100, 0)
+		Expect(limiter.WaitBytes(ctx, 100)).To(Succeed())
+
+		cancelCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		Expect(limiter.Wait(cancelCtx, 100)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Shared limiter", func() {
+	var statePath string
+
+	BeforeEach(func() {
+		statePath = filepath.Join(GinkgoT().TempDir(), "limiter.json")
+	})
+
+	It("doesn't limit anything without limits", func() {
+		var limiter *Limiter
+		Expect(limiter.WithSharedState(statePath)).To(BeNil())
+	})
+
+	It("shares the limits between the limiters using the same state", func(ctx SpecContext) {
+		first := New(0, 20).WithSharedState(statePath)
+		second := New(0, 20).WithSharedState(statePath)
+		start := time.Now()
+		for range 15 {
+			Expect(first.WaitRequest(ctx)).To(Succeed())
+			Expect(second.WaitRequest(ctx)).To(Succeed())
+		}
+		// The first 20 requests are in the burst, the others take 50ms each
+		Expect(time.Since(start)).To(BeNumerically(">=", 400*time.Millisecond))
+		Expect(statePath).To(BeAnExistingFile())
+	})
+
+	It("paces the transfers", func(ctx SpecContext) {
+		limiter := New(1000, 0).WithSharedState(statePath)
+		start := time.Now()
+		Expect(limiter.WaitBytes(ctx, 1000)).To(Succeed())
+		Expect(limiter.WaitBytes(ctx, 500)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 400*time.Millisecond))
+	})
+
+	It("replaces an unreadable state", func(ctx SpecContext) {
+		Expect(os.WriteFile(statePath, []byte("{"), 0o600)).To(Succeed())
+		limiter := New(0, 20).WithSharedState(statePath)
+		start := time.Now()
+		Expect(limiter.WaitRequest(ctx)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+	})
+})
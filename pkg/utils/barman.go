@@ -22,8 +22,13 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"math"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var regexPolicy = regexp.MustCompile(`([1-9][0-9]*)([dwm])$`)
@@ -44,8 +49,32 @@ func ParsePolicy(policy string) (string, error) {
 	return fmt.Sprintf("RECOVERY WINDOW OF %v %v", matches[1], unitName[matches[2]]), nil
 }
 
+// RecoveryWindowStart returns the beginning of the recovery window
+// described by the passed policy, given the current time
+func RecoveryWindowStart(policy string, now time.Time) (time.Time, error) {
+	matches := regexPolicy.FindStringSubmatch(policy)
+	if len(matches) < 3 {
+		return time.Time{}, fmt.Errorf("not a valid policy")
+	}
+
+	value, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("not a valid policy: %w", err)
+	}
+
+	switch matches[2] {
+	case "d":
+		return now.AddDate(0, 0, -value), nil
+	case "w":
+		return now.AddDate(0, 0, -7*value), nil
+	default:
+		return now.AddDate(0, -value, 0), nil
+	}
+}
+
 // MapToBarmanTagsFormat will transform a map[string]string into the
-// Barman tags format needed
+// Barman tags format needed. Tags are sorted by key, to keep
+// the command line stable across invocations.
 func MapToBarmanTagsFormat(option string, mapTags map[string]string) ([]string, error) {
 	tagsLength := len(mapTags)
 	if tagsLength == 0 {
@@ -57,9 +86,46 @@ func MapToBarmanTagsFormat(option string, mapTags map[string]string) ([]string,
 
 	tags := make([]string, 0, tagsLength+1)
 	tags = append(tags, option)
-	for k, v := range mapTags {
-		tags = append(tags, fmt.Sprintf("%v,%v", k, v))
+	for _, k := range slices.Sorted(maps.Keys(mapTags)) {
+		tag, err := FormatBarmanTag(k, mapTags[k])
+		if err != nil {
+			return []string{}, err
+		}
+		tags = append(tags, tag)
 	}
 
 	return tags, nil
 }
+
+// FormatBarmanTag formats a tag as a "key,value" pair. Barman parses tags
+// as CSV records, so keys and values containing commas or quotes are
+// quoted, doubling the quotes they contain.
+func FormatBarmanTag(key string, value string) (string, error) {
+	if err := ValidateBarmanTag(key, value); err != nil {
+		return "", err
+	}
+
+	return formatCSVField(key) + "," + formatCSVField(value), nil
+}
+
+// ValidateBarmanTag checks that a tag can be passed to barman
+func ValidateBarmanTag(key string, value string) error {
+	if key == "" {
+		return errors.New("tag keys cannot be empty")
+	}
+
+	if strings.ContainsAny(key, "\r\n") || strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("tag %q cannot contain line breaks", key)
+	}
+
+	return nil
+}
+
+// formatCSVField quotes a CSV field when needed, as the Python csv module does
+func formatCSVField(field string) string {
+	if !strings.ContainsAny(field, `,"`) {
+		return field
+	}
+
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
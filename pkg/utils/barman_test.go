@@ -20,6 +20,8 @@ SPDX-License-Identifier: Apache-2.0
 package utils //nolint:revive
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -53,4 +55,41 @@ var _ = Describe("converting map to barman tags format", func() {
 		tags := map[string]string{"retentionDays": "90days"}
 		Expect(MapToBarmanTagsFormat("test", tags)).To(BeEquivalentTo([]string{"test", "retentionDays,90days"}))
 	})
+
+	It("sorts the tags by key", func() {
+		tags := map[string]string{"team": "dba", "app": "pg", "env": "prod"}
+		for range 5 {
+			Expect(MapToBarmanTagsFormat("--tags", tags)).
+				To(Equal([]string{"--tags", "app,pg", "env,prod", "team,dba"}))
+		}
+	})
+
+	It("quotes the keys and values containing commas or quotes", func() {
+		tags := map[string]string{"owners": "alice,bob", `say "hi"`: "hello"}
+		Expect(MapToBarmanTagsFormat("--tags", tags)).
+			To(Equal([]string{"--tags", `owners,"alice,bob"`, `"say ""hi""",hello`}))
+	})
+
+	It("refuses invalid tags", func() {
+		_, err := MapToBarmanTagsFormat("--tags", map[string]string{"": "value"})
+		Expect(err).To(HaveOccurred())
+
+		_, err = MapToBarmanTagsFormat("--tags", map[string]string{"key": "multi\nline"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("recovery window", func() {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	It("computes the beginning of the recovery window", func() {
+		Expect(RecoveryWindowStart("7d", now)).To(Equal(time.Date(2024, 3, 24, 12, 0, 0, 0, time.UTC)))
+		Expect(RecoveryWindowStart("2w", now)).To(Equal(time.Date(2024, 3, 17, 12, 0, 0, 0, time.UTC)))
+		Expect(RecoveryWindowStart("1m", now)).To(Equal(time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)))
+	})
+
+	It("complains with a wrong policy", func() {
+		_, err := RecoveryWindowStart("30", now)
+		Expect(err).To(HaveOccurred())
+	})
 })
@@ -0,0 +1,152 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package utils
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// barmanCloudFlagsJSON is the catalog of the command-line flags
+// accepted by the barman-cloud tools
+//
+//go:embed barman_cloud_flags.json
+var barmanCloudFlagsJSON []byte
+
+// BarmanCloudFlag is a command-line flag accepted by a barman-cloud tool
+type BarmanCloudFlag struct {
+	// The names of the flag, including the short ones
+	Names []string `json:"names"`
+
+	// True if the flag is followed by a value
+	HasValue bool `json:"hasValue,omitempty"`
+
+	// The first barman version supporting the flag, if not
+	// supported since the introduction of the tool
+	Since string `json:"since,omitempty"`
+
+	// The first barman version supporting some values of the flag,
+	// when they have been introduced after the flag itself
+	ValuesSince map[string]string `json:"valuesSince,omitempty"`
+
+	// True if the flag is set by this library and cannot be
+	// passed as an additional command argument
+	Forbidden bool `json:"forbidden,omitempty"`
+
+	// The reason why the flag is forbidden
+	Reason string `json:"reason,omitempty"`
+}
+
+// IsSupportedBy checks if the flag is supported by the passed barman
+// version. An empty version is considered to be the latest one.
+func (flag *BarmanCloudFlag) IsSupportedBy(version string) bool {
+	if flag.Since == "" || version == "" {
+		return true
+	}
+
+	return compareVersions(version, flag.Since) >= 0
+}
+
+type barmanCloudFlagCatalog struct {
+	ToolsSince map[string]string            `json:"toolsSince"`
+	Common     []BarmanCloudFlag            `json:"common"`
+	Tools      map[string][]BarmanCloudFlag `json:"tools"`
+}
+
+var getBarmanCloudFlagCatalog = sync.OnceValues(func() (*barmanCloudFlagCatalog, error) {
+	result := &barmanCloudFlagCatalog{}
+	if err := json.Unmarshal(barmanCloudFlagsJSON, result); err != nil {
+		return nil, fmt.Errorf("while parsing the barman-cloud flag catalog: %w", err)
+	}
+	return result, nil
+})
+
+// GetBarmanCloudFlags gets the flags accepted by a barman-cloud tool,
+// such as BarmanCloudWalArchive, which can be customized by the user
+func GetBarmanCloudFlags(tool string) ([]BarmanCloudFlag, error) {
+	flagCatalog, err := getBarmanCloudFlagCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	toolFlags, ok := flagCatalog.Tools[tool]
+	if !ok {
+		return nil, fmt.Errorf("no flag catalog for %s", tool)
+	}
+
+	return slices.Concat(flagCatalog.Common, toolFlags), nil
+}
+
+// LookupBarmanCloudFlag finds the flag of a barman-cloud tool corresponding to
+// a command-line argument, such as "--read-timeout=60". A nil flag is returned
+// when the argument is not a flag known to be accepted by the tool.
+func LookupBarmanCloudFlag(tool string, argument string) (*BarmanCloudFlag, error) {
+	flags, err := GetBarmanCloudFlags(tool)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _, _ := strings.Cut(argument, "=")
+	for idx := range flags {
+		if slices.Contains(flags[idx].Names, name) {
+			return &flags[idx], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// getBarmanCloudToolSince gets the first barman version shipping a
+// barman-cloud tool, if not shipped since the introduction of barman-cloud
+func getBarmanCloudToolSince(tool string) (string, error) {
+	flagCatalog, err := getBarmanCloudFlagCatalog()
+	if err != nil {
+		return "", err
+	}
+
+	return flagCatalog.ToolsSince[tool], nil
+}
+
+// compareVersions compares two dotted version numbers, such as 3.12.1,
+// returning a negative number, zero or a positive number if the first
+// one is respectively lower, equal or greater than the second one
+func compareVersions(first string, second string) int {
+	firstParts := strings.Split(first, ".")
+	secondParts := strings.Split(second, ".")
+	for idx := 0; idx < max(len(firstParts), len(secondParts)); idx++ {
+		var firstValue, secondValue int
+		if idx < len(firstParts) {
+			firstValue, _ = strconv.Atoi(firstParts[idx])
+		}
+		if idx < len(secondParts) {
+			secondValue, _ = strconv.Atoi(secondParts[idx])
+		}
+		if firstValue != secondValue {
+			return firstValue - secondValue
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package utils
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("barman-cloud flag catalog", func() {
+	It("finds the flags of a tool, including the common ones", func() {
+		flag, err := LookupBarmanCloudFlag(BarmanCloudWalArchive, "--read-timeout=60")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(flag).ToNot(BeNil())
+		Expect(flag.HasValue).To(BeTrue())
+
+		flag, err = LookupBarmanCloudFlag(BarmanCloudBackup, "-J")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(flag.Names).To(ContainElement("--jobs"))
+
+		flag, err = LookupBarmanCloudFlag(BarmanCloudWalRestore, "--jobs")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(flag).To(BeNil())
+	})
+
+	It("complains about unknown tools", func() {
+		_, err := LookupBarmanCloudFlag(BarmanCloudBackupList, "--format")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("checks the barman version supporting a flag", func() {
+		flag, err := LookupBarmanCloudFlag(BarmanCloudWalArchive, "--zstd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(flag.IsSupportedBy("3.11.1")).To(BeFalse())
+		Expect(flag.IsSupportedBy("3.12.0")).To(BeTrue())
+		Expect(flag.IsSupportedBy("3.14")).To(BeTrue())
+		Expect(flag.IsSupportedBy("")).To(BeTrue())
+	})
+})
@@ -0,0 +1,216 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sync"
+
+	"github.com/cloudnative-pg/machinery/pkg/log"
+)
+
+// BarmanCapability is a feature of barman-cloud which is not
+// available in every barman version
+type BarmanCapability string
+
+const (
+	// BarmanCapabilityCompressionSnappy is the snappy compression
+	BarmanCapabilityCompressionSnappy BarmanCapability = "compression snappy"
+
+	// BarmanCapabilityCompressionLz4 is the lz4 compression
+	BarmanCapabilityCompressionLz4 BarmanCapability = "compression lz4"
+
+	// BarmanCapabilityCompressionXz is the xz compression
+	BarmanCapabilityCompressionXz BarmanCapability = "compression xz"
+
+	// BarmanCapabilityCompressionZstd is the zstd compression
+	BarmanCapabilityCompressionZstd BarmanCapability = "compression zstd"
+
+	// BarmanCapabilityBackupName is the ability to name backups
+	// and to refer to them by name
+	BarmanCapabilityBackupName BarmanCapability = "backup names"
+
+	// BarmanCapabilityBackupKeep is the ability to keep backups
+	// regardless of the retention policies
+	BarmanCapabilityBackupKeep BarmanCapability = "backup keep"
+
+	// BarmanCapabilityMaxBandwidth is the ability to limit the
+	// bandwidth used to upload a backup
+	BarmanCapabilityMaxBandwidth BarmanCapability = "bandwidth limit"
+
+	// BarmanCapabilityAzureDefaultCredential is the "--credential default"
+	// option, using the default Azure credential chain
+	BarmanCapabilityAzureDefaultCredential BarmanCapability = "the default Azure credential"
+)
+
+// barmanCapabilityFlag is the barman-cloud tool, and optionally the flag
+// and the flag value, whose introduction brought a capability
+type barmanCapabilityFlag struct {
+	tool  string
+	flag  string
+	value string
+}
+
+// barmanCapabilities maps every capability to where it was introduced. The
+// minimum barman versions are taken from the barman-cloud flag catalog.
+var barmanCapabilities = map[BarmanCapability]barmanCapabilityFlag{
+	BarmanCapabilityCompressionSnappy:      {tool: BarmanCloudWalArchive, flag: "--snappy"},
+	BarmanCapabilityCompressionLz4:         {tool: BarmanCloudWalArchive, flag: "--lz4"},
+	BarmanCapabilityCompressionXz:          {tool: BarmanCloudWalArchive, flag: "--xz"},
+	BarmanCapabilityCompressionZstd:        {tool: BarmanCloudWalArchive, flag: "--zstd"},
+	BarmanCapabilityBackupName:             {tool: BarmanCloudBackup, flag: "--name"},
+	BarmanCapabilityBackupKeep:             {tool: BarmanCloudBackupKeep},
+	BarmanCapabilityMaxBandwidth:           {tool: BarmanCloudBackup, flag: "--max-bandwidth"},
+	BarmanCapabilityAzureDefaultCredential: {tool: BarmanCloudWalArchive, flag: "--credential", value: "default"},
+}
+
+// getBarmanCapabilitySince gets the first barman version supporting a
+// capability, or an empty string if every barman version supports it
+func getBarmanCapabilitySince(capability BarmanCapability) (string, error) {
+	capabilityFlag, ok := barmanCapabilities[capability]
+	if !ok {
+		return "", nil
+	}
+
+	if capabilityFlag.flag == "" {
+		return getBarmanCloudToolSince(capabilityFlag.tool)
+	}
+
+	flag, err := LookupBarmanCloudFlag(capabilityFlag.tool, capabilityFlag.flag)
+	if err != nil {
+		return "", err
+	}
+	if flag == nil {
+		return "", fmt.Errorf("%s is not in the flag catalog of %s", capabilityFlag.flag, capabilityFlag.tool)
+	}
+
+	if since, ok := flag.ValuesSince[capabilityFlag.value]; ok && capabilityFlag.value != "" {
+		return since, nil
+	}
+
+	return flag.Since, nil
+}
+
+// ErrBarmanCapabilityNotSupported is returned when a barman-cloud
+// feature is not supported by the installed barman version
+var ErrBarmanCapabilityNotSupported = errors.New("not supported by the installed barman version")
+
+// CompressionCapability gets the capability required to use a
+// compression algorithm, returning false if every barman version supports it
+func CompressionCapability(compression string) (BarmanCapability, bool) {
+	capability := BarmanCapability("compression " + compression)
+	_, ok := barmanCapabilities[capability]
+	return capability, ok
+}
+
+// CheckBarmanCapability checks if a barman version supports a capability.
+// An empty version, i.e. an undetected one, is considered to support every capability.
+func CheckBarmanCapability(version string, capability BarmanCapability) error {
+	minimumVersion, err := getBarmanCapabilitySince(capability)
+	if err != nil {
+		return err
+	}
+
+	if minimumVersion == "" || version == "" || compareVersions(version, minimumVersion) >= 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%s requires barman >= %s, found %s: %w",
+		capability, minimumVersion, version, ErrBarmanCapabilityNotSupported)
+}
+
+// CheckInstalledBarmanCapabilities checks if the installed barman version supports
+// the passed capabilities. When the version can't be detected, the check is
+// skipped and the barman-cloud tools will report any error.
+func CheckInstalledBarmanCapabilities(ctx context.Context, capabilities ...BarmanCapability) error {
+	if len(capabilities) == 0 {
+		return nil
+	}
+
+	version, err := DetectBarmanVersion(ctx)
+	if err != nil {
+		log.FromContext(ctx).Debug("Skipping the barman capabilities check", "error", err)
+		return nil
+	}
+
+	for _, capability := range capabilities {
+		if err := CheckBarmanCapability(version, capability); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var barmanVersionRegex = regexp.MustCompile(`\b(\d+\.\d+(\.\d+)?)\b`)
+
+var barmanVersionCache struct {
+	sync.Mutex
+	version string
+}
+
+// DetectBarmanVersion gets the version of the installed barman-cloud tools,
+// such as "3.12.1", by running barman-cloud-backup --version. The detected
+// version is cached for the lifetime of the process, while failures, such as
+// a cancelled context, are not and the detection is retried at the next call.
+func DetectBarmanVersion(ctx context.Context) (string, error) {
+	barmanVersionCache.Lock()
+	defer barmanVersionCache.Unlock()
+
+	if barmanVersionCache.version != "" {
+		return barmanVersionCache.version, nil
+	}
+
+	version, err := runBarmanVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	barmanVersionCache.version = version
+	return version, nil
+}
+
+func runBarmanVersion(ctx context.Context) (string, error) {
+	var outputBuffer bytes.Buffer
+	cmd := exec.CommandContext(ctx, BarmanCloudBackup, "--version") // #nosec G204
+	cmd.Stdout = &outputBuffer
+	cmd.Stderr = &outputBuffer
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("while running %s --version: %w", BarmanCloudBackup, err)
+	}
+
+	return parseBarmanVersion(outputBuffer.String())
+}
+
+// parseBarmanVersion extracts the version from the output
+// of barman-cloud-backup --version
+func parseBarmanVersion(output string) (string, error) {
+	match := barmanVersionRegex.FindStringSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("cannot find the barman version in %q", output)
+	}
+
+	return match[1], nil
+}
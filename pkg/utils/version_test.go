@@ -0,0 +1,86 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("barman version", func() {
+	It("parses the output of barman-cloud-backup --version", func() {
+		Expect(parseBarmanVersion("barman-cloud-backup 3.12.1\n")).To(Equal("3.12.1"))
+		Expect(parseBarmanVersion("3.10\n")).To(Equal("3.10"))
+
+		_, err := parseBarmanVersion("barman-cloud-backup: command not found")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("checks the capabilities of a barman version", func() {
+		Expect(CheckBarmanCapability("3.12.0", BarmanCapabilityCompressionZstd)).To(Succeed())
+		Expect(CheckBarmanCapability("", BarmanCapabilityCompressionZstd)).To(Succeed())
+
+		err := CheckBarmanCapability("3.11.1", BarmanCapabilityCompressionZstd)
+		Expect(errors.Is(err, ErrBarmanCapabilityNotSupported)).To(BeTrue())
+		Expect(err.Error()).To(HavePrefix("compression zstd requires barman >= 3.12.0"))
+	})
+
+	It("knows which compression algorithms depend on the barman version", func() {
+		capability, ok := CompressionCapability("lz4")
+		Expect(ok).To(BeTrue())
+		Expect(capability).To(Equal(BarmanCapabilityCompressionLz4))
+
+		_, ok = CompressionCapability("gzip")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("takes the capability minimum versions from the flag catalog", func() {
+		Expect(getBarmanCapabilitySince(BarmanCapabilityCompressionLz4)).To(Equal("3.12.0"))
+		Expect(getBarmanCapabilitySince(BarmanCapabilityBackupName)).To(Equal("3.3.0"))
+		Expect(getBarmanCapabilitySince(BarmanCapabilityBackupKeep)).To(Equal("2.18.0"))
+		Expect(getBarmanCapabilitySince(BarmanCapabilityMaxBandwidth)).To(Equal("2.18.0"))
+		Expect(getBarmanCapabilitySince(BarmanCapabilityAzureDefaultCredential)).To(Equal("3.13.0"))
+
+		for capability := range barmanCapabilities {
+			Expect(getBarmanCapabilitySince(capability)).ToNot(BeEmpty(), string(capability))
+		}
+	})
+
+	It("retries the version detection after a failure", func() {
+		DeferCleanup(func() { barmanVersionCache.version = "" })
+
+		binDir := GinkgoT().TempDir()
+		script := "#!/bin/sh\necho barman-cloud-backup 3.12.1\n"
+		Expect(os.WriteFile(filepath.Join(binDir, BarmanCloudBackup), []byte(script), 0o700)).To(Succeed())
+		GinkgoT().Setenv("PATH", binDir)
+
+		cancelledCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := DetectBarmanVersion(cancelledCtx)
+		Expect(err).To(HaveOccurred())
+
+		Expect(DetectBarmanVersion(context.Background())).To(Equal("3.12.1"))
+	})
+})
@@ -0,0 +1,68 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wal
+
+import (
+	"slices"
+)
+
+// MissingSegments gets the names of the WAL segments which are missing
+// between the first and the last WAL segment of every timeline in the
+// passed list. The names of the other files contained in the WAL archive
+// are ignored, except for .partial segments, which fill their position.
+func MissingSegments(walNames []string, segmentSize uint64) ([]string, error) {
+	if err := CheckSegmentSize(segmentSize); err != nil {
+		return nil, err
+	}
+
+	segmentsByTimeline := make(map[uint32][]uint64)
+	for _, walName := range walNames {
+		name, err := Parse(walName)
+		if err != nil {
+			return nil, err
+		}
+		if name.Kind != KindSegment && name.Kind != KindPartial {
+			continue
+		}
+
+		segmentNumber, err := name.SegmentNumber(segmentSize)
+		if err != nil {
+			return nil, err
+		}
+		segmentsByTimeline[name.Timeline] = append(segmentsByTimeline[name.Timeline], segmentNumber)
+	}
+
+	var result []string
+	for timeline, segmentNumbers := range segmentsByTimeline {
+		slices.Sort(segmentNumbers)
+		for idx := 1; idx < len(segmentNumbers); idx++ {
+			for missing := segmentNumbers[idx-1] + 1; missing < segmentNumbers[idx]; missing++ {
+				name, err := FromSegmentNumber(timeline, missing, segmentSize)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, name.String())
+			}
+		}
+	}
+	slices.Sort(result)
+
+	return result, nil
+}
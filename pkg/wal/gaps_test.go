@@ -0,0 +1,55 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wal
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MissingSegments", func() {
+	It("finds the WAL segments missing on every timeline", func() {
+		Expect(MissingSegments([]string{
+			"0000000100000000000000FE",
+			"000000010000000100000001",
+			"000000010000000100000000.00000028.backup",
+			"00000002.history",
+			"000000020000000100000003.partial",
+			"000000020000000100000005",
+		}, DefaultSegmentSize)).To(Equal([]string{
+			"0000000100000000000000FF",
+			"000000010000000100000000",
+			"000000020000000100000004",
+		}))
+	})
+
+	It("finds nothing in a continuous WAL stream", func() {
+		Expect(MissingSegments([]string{
+			"000000010000000000000002",
+			"000000010000000000000001",
+			"000000010000000000000003",
+		}, DefaultSegmentSize)).To(BeEmpty())
+	})
+
+	It("refuses the names which are not in the WAL archive", func() {
+		_, err := MissingSegments([]string{"backup_label"}, DefaultSegmentSize)
+		Expect(err).To(MatchError(ErrInvalidName))
+	})
+})
@@ -0,0 +1,362 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/cloudnative-pg/machinery/pkg/types"
+)
+
+const (
+	// DefaultSegmentSize is the default size of the WAL segments
+	DefaultSegmentSize = 16 * 1024 * 1024
+
+	// minSegmentSize is the minimum size of the WAL segments
+	minSegmentSize = 1024 * 1024
+
+	// maxSegmentSize is the maximum size of the WAL segments
+	maxSegmentSize = 1024 * 1024 * 1024
+
+	// logSize is the number of bytes addressed by a log file, which
+	// is the middle part of the name of a WAL segment
+	logSize = 0x100000000
+
+	// segmentNameLength is the length of the name of a WAL segment
+	segmentNameLength = 24
+)
+
+var (
+	// ErrInvalidName is returned when a name is not
+	// the one of a file contained in the WAL archive
+	ErrInvalidName = errors.New("invalid WAL file name")
+
+	// ErrInvalidSegmentSize is returned when a WAL segment size is not
+	// a power of two between 1MiB and 1GiB
+	ErrInvalidSegmentSize = errors.New("invalid WAL segment size")
+)
+
+var (
+	segmentRegex     = regexp.MustCompile(`^([0-9A-F]{8})([0-9A-F]{8})([0-9A-F]{8})$`)
+	partialRegex     = regexp.MustCompile(`^([0-9A-F]{8})([0-9A-F]{8})([0-9A-F]{8})\.partial$`)
+	backupLabelRegex = regexp.MustCompile(`^([0-9A-F]{8})([0-9A-F]{8})([0-9A-F]{8})\.([0-9A-F]{8})\.backup$`)
+	historyRegex     = regexp.MustCompile(`^([0-9A-F]{8})\.history$`)
+)
+
+// Kind is the kind of a file contained in the WAL archive
+type Kind string
+
+const (
+	// KindSegment is a WAL segment
+	KindSegment Kind = "segment"
+
+	// KindPartial is a WAL segment which was not completed,
+	// such as the last one of a timeline on promotion
+	KindPartial Kind = "partial"
+
+	// KindBackupLabel is the backup history file written at the end of a backup
+	KindBackupLabel Kind = "backup"
+
+	// KindHistory is a timeline history file
+	KindHistory Kind = "history"
+)
+
+// Name is the parsed name of a file contained in the WAL archive
+type Name struct {
+	// The kind of file
+	Kind Kind
+
+	// The timeline of the file
+	Timeline uint32
+
+	// The log file, which is the middle part of a WAL segment
+	// name. Not set for timeline history files.
+	Log uint32
+
+	// The segment inside the log file, which is the last part of
+	// a WAL segment name. Not set for timeline history files.
+	Segment uint32
+
+	// The offset, inside the WAL segment, where the backup
+	// started. Only set for backup labels.
+	Offset uint32
+}
+
+// Parse parses the name of a file contained in the WAL archive
+func Parse(name string) (Name, error) {
+	if matches := segmentRegex.FindStringSubmatch(name); matches != nil {
+		return newName(KindSegment, matches[1:]...)
+	}
+	if matches := partialRegex.FindStringSubmatch(name); matches != nil {
+		return newName(KindPartial, matches[1:]...)
+	}
+	if matches := backupLabelRegex.FindStringSubmatch(name); matches != nil {
+		return newName(KindBackupLabel, matches[1:]...)
+	}
+	if matches := historyRegex.FindStringSubmatch(name); matches != nil {
+		return newName(KindHistory, matches[1:]...)
+	}
+
+	return Name{}, fmt.Errorf("%w: %q", ErrInvalidName, name)
+}
+
+// Classify gets the kind of a file contained in the WAL archive.
+// An empty kind is returned for the names which are not valid.
+func Classify(name string) Kind {
+	parsed, err := Parse(name)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Kind
+}
+
+// IsSegment checks if a name is the one of a WAL segment
+func IsSegment(name string) bool {
+	return segmentRegex.MatchString(name)
+}
+
+// IsValid checks if a name is the one of a file contained in the WAL archive
+func IsValid(name string) bool {
+	return Classify(name) != ""
+}
+
+// newName builds a name from the hexadecimal fields matched by the regexes
+func newName(kind Kind, fields ...string) (Name, error) {
+	values := make([]uint32, len(fields))
+	for idx, field := range fields {
+		value, err := strconv.ParseUint(field, 16, 32)
+		if err != nil {
+			return Name{}, fmt.Errorf("%w: %w", ErrInvalidName, err)
+		}
+		values[idx] = uint32(value)
+	}
+
+	result := Name{Kind: kind, Timeline: values[0]}
+	if kind == KindHistory {
+		return result, nil
+	}
+
+	result.Log = values[1]
+	result.Segment = values[2]
+	if kind == KindBackupLabel {
+		result.Offset = values[3]
+	}
+	return result, nil
+}
+
+// SegmentName formats the name of a WAL segment
+func SegmentName(timeline, log, segment uint32) string {
+	return fmt.Sprintf("%08X%08X%08X", timeline, log, segment)
+}
+
+// HistoryFileName formats the name of the history file of a timeline
+func HistoryFileName(timeline uint32) string {
+	return fmt.Sprintf("%08X.history", timeline)
+}
+
+// String formats the name
+func (name Name) String() string {
+	segmentName := SegmentName(name.Timeline, name.Log, name.Segment)
+	switch name.Kind {
+	case KindHistory:
+		return HistoryFileName(name.Timeline)
+	case KindPartial:
+		return segmentName + ".partial"
+	case KindBackupLabel:
+		return fmt.Sprintf("%s.%08X.backup", segmentName, name.Offset)
+	default:
+		return segmentName
+	}
+}
+
+// SegmentName gets the name of the WAL segment a file refers to, such as
+// the segment where a backup started for a backup label. It is empty for
+// timeline history files.
+func (name Name) SegmentName() string {
+	if name.Kind == KindHistory {
+		return ""
+	}
+
+	return SegmentName(name.Timeline, name.Log, name.Segment)
+}
+
+// CheckSegmentSize checks if a WAL segment size is a
+// power of two between 1MiB and 1GiB
+func CheckSegmentSize(segmentSize uint64) error {
+	if segmentSize < minSegmentSize || segmentSize > maxSegmentSize || segmentSize&(segmentSize-1) != 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidSegmentSize, segmentSize)
+	}
+
+	return nil
+}
+
+// SegmentNumber gets the position of the WAL segment inside the
+// timeline, counting the segments from the start of the WAL stream
+func (name Name) SegmentNumber(segmentSize uint64) (uint64, error) {
+	if err := name.checkSegment(segmentSize); err != nil {
+		return 0, err
+	}
+
+	return uint64(name.Log)*(logSize/segmentSize) + uint64(name.Segment), nil
+}
+
+// FromSegmentNumber builds the name of a WAL segment from its
+// position inside the timeline
+func FromSegmentNumber(timeline uint32, segmentNumber uint64, segmentSize uint64) (Name, error) {
+	if err := CheckSegmentSize(segmentSize); err != nil {
+		return Name{}, err
+	}
+
+	segmentsPerLog := logSize / segmentSize
+	if segmentNumber/segmentsPerLog > 0xFFFFFFFF {
+		return Name{}, fmt.Errorf("%w: segment number %d out of range", ErrInvalidName, segmentNumber)
+	}
+
+	return Name{
+		Kind:     KindSegment,
+		Timeline: timeline,
+		Log:      uint32(segmentNumber / segmentsPerLog), // #nosec G115
+		Segment:  uint32(segmentNumber % segmentsPerLog), // #nosec G115
+	}, nil
+}
+
+// FromLSN gets the name of the WAL segment containing an LSN
+func FromLSN(timeline uint32, lsn types.LSN, segmentSize uint64) (Name, error) {
+	position, err := lsn.Parse()
+	if err != nil {
+		return Name{}, err
+	}
+	if err := CheckSegmentSize(segmentSize); err != nil {
+		return Name{}, err
+	}
+
+	return FromSegmentNumber(timeline, position/segmentSize, segmentSize)
+}
+
+// StartLSN gets the LSN of the first byte of the WAL segment
+func (name Name) StartLSN(segmentSize uint64) (types.LSN, error) {
+	segmentNumber, err := name.SegmentNumber(segmentSize)
+	if err != nil {
+		return "", err
+	}
+
+	return types.Int64ToLSN(segmentNumber * segmentSize), nil
+}
+
+// Next gets the WAL segment following this one on the same timeline
+func (name Name) Next(segmentSize uint64) (Name, error) {
+	segmentNumber, err := name.SegmentNumber(segmentSize)
+	if err != nil {
+		return Name{}, err
+	}
+
+	return FromSegmentNumber(name.Timeline, segmentNumber+1, segmentSize)
+}
+
+// Previous gets the WAL segment preceding this one on the same timeline
+func (name Name) Previous(segmentSize uint64) (Name, error) {
+	segmentNumber, err := name.SegmentNumber(segmentSize)
+	if err != nil {
+		return Name{}, err
+	}
+	if segmentNumber == 0 {
+		return Name{}, fmt.Errorf("%w: %s is the first WAL segment", ErrInvalidName, name)
+	}
+
+	return FromSegmentNumber(name.Timeline, segmentNumber-1, segmentSize)
+}
+
+// NextSegments gets the names of the count WAL segments following
+// the passed one on the same timeline
+func NextSegments(walName string, count int, segmentSize uint64) ([]string, error) {
+	name, err := Parse(walName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, count)
+	for range count {
+		if name, err = name.Next(segmentSize); err != nil {
+			return nil, err
+		}
+		result = append(result, name.String())
+	}
+
+	return result, nil
+}
+
+// SegmentRange gets the names of the WAL segments between the passed ones,
+// both included, which must belong to the same timeline
+func SegmentRange(firstWAL, lastWAL string, segmentSize uint64) ([]string, error) {
+	first, err := Parse(firstWAL)
+	if err != nil {
+		return nil, err
+	}
+	last, err := Parse(lastWAL)
+	if err != nil {
+		return nil, err
+	}
+	if first.Timeline != last.Timeline {
+		return nil, fmt.Errorf("%w: %s and %s belong to different timelines", ErrInvalidName, firstWAL, lastWAL)
+	}
+
+	firstNumber, err := first.SegmentNumber(segmentSize)
+	if err != nil {
+		return nil, err
+	}
+	lastNumber, err := last.SegmentNumber(segmentSize)
+	if err != nil {
+		return nil, err
+	}
+	if lastNumber < firstNumber {
+		return nil, fmt.Errorf("%w: %s precedes %s", ErrInvalidName, lastWAL, firstWAL)
+	}
+
+	result := make([]string, 0, lastNumber-firstNumber+1)
+	for segmentNumber := firstNumber; segmentNumber <= lastNumber; segmentNumber++ {
+		name, err := FromSegmentNumber(first.Timeline, segmentNumber, segmentSize)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, name.String())
+	}
+
+	return result, nil
+}
+
+// checkSegment checks that the name refers to a WAL segment
+// which is valid for the passed segment size
+func (name Name) checkSegment(segmentSize uint64) error {
+	if err := CheckSegmentSize(segmentSize); err != nil {
+		return err
+	}
+	if name.Kind == KindHistory {
+		return fmt.Errorf("%w: %s is not a WAL segment", ErrInvalidName, name)
+	}
+	if uint64(name.Segment) >= logSize/segmentSize {
+		return fmt.Errorf("%w: %s is not valid with WAL segments of %d bytes", ErrInvalidName, name, segmentSize)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package wal
+
+import (
+	"github.com/cloudnative-pg/machinery/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parse", func() {
+	DescribeTable("parses the names of the files in the WAL archive",
+		func(walName string, expected Name) {
+			name, err := Parse(walName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(name).To(Equal(expected))
+			Expect(name.String()).To(Equal(walName))
+			Expect(Classify(walName)).To(Equal(expected.Kind))
+		},
+		Entry("WAL segment", "0000000200000001000000FE",
+			Name{Kind: KindSegment, Timeline: 2, Log: 1, Segment: 0xFE}),
+		Entry("partial WAL segment", "000000010000000000000003.partial",
+			Name{Kind: KindPartial, Timeline: 1, Segment: 3}),
+		Entry("backup label", "000000010000000000000004.00000028.backup",
+			Name{Kind: KindBackupLabel, Timeline: 1, Segment: 4, Offset: 0x28}),
+		Entry("timeline history file", "0000000A.history",
+			Name{Kind: KindHistory, Timeline: 10}),
+	)
+
+	DescribeTable("refuses the names which are not in the WAL archive",
+		func(walName string) {
+			_, err := Parse(walName)
+			Expect(err).To(MatchError(ErrInvalidName))
+			Expect(Classify(walName)).To(BeEmpty())
+			Expect(IsValid(walName)).To(BeFalse())
+		},
+		Entry("empty name", ""),
+		Entry("lowercase name", "0000000100000000000000fe"),
+		Entry("short name", "00000001000000000000001"),
+		Entry("path", "pg_wal/000000010000000000000001"),
+		Entry("compressed name", "000000010000000000000001.gz"),
+		Entry("RECOVERYHISTORY", "RECOVERYHISTORY"),
+	)
+
+	It("gets the WAL segment of a backup label", func() {
+		name, err := Parse("000000010000000000000004.00000028.backup")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name.SegmentName()).To(Equal("000000010000000000000004"))
+	})
+})
+
+var _ = Describe("WAL segment arithmetic", func() {
+	It("moves to the next and previous log file when needed", func() {
+		name, err := Parse("0000000100000000000000FF")
+		Expect(err).ToNot(HaveOccurred())
+
+		next, err := name.Next(DefaultSegmentSize)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(next.String()).To(Equal("000000010000000100000000"))
+
+		previous, err := next.Previous(DefaultSegmentSize)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(previous).To(Equal(name))
+	})
+
+	It("supports custom WAL segment sizes", func() {
+		Expect(NextSegments("00000002000000000000003F", 2, 64*1024*1024)).To(Equal([]string{
+			"000000020000000100000000",
+			"000000020000000100000001",
+		}))
+	})
+
+	It("refuses the segments which are not valid for the WAL segment size", func() {
+		name, err := Parse("000000010000000000000040")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = name.Next(64 * 1024 * 1024)
+		Expect(err).To(MatchError(ErrInvalidName))
+	})
+
+	It("refuses the WAL segment sizes which are not valid", func() {
+		Expect(CheckSegmentSize(DefaultSegmentSize)).To(Succeed())
+		Expect(CheckSegmentSize(1024 * 1024 * 1024)).To(Succeed())
+		Expect(CheckSegmentSize(512 * 1024)).To(MatchError(ErrInvalidSegmentSize))
+		Expect(CheckSegmentSize(2 * 1024 * 1024 * 1024)).To(MatchError(ErrInvalidSegmentSize))
+		Expect(CheckSegmentSize(24 * 1024 * 1024)).To(MatchError(ErrInvalidSegmentSize))
+	})
+
+	It("has no WAL segment before the first one", func() {
+		name, err := Parse("000000010000000000000000")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = name.Previous(DefaultSegmentSize)
+		Expect(err).To(MatchError(ErrInvalidName))
+	})
+
+	It("refuses to compute the next segment of a history file", func() {
+		_, err := NextSegments("00000002.history", 1, DefaultSegmentSize)
+		Expect(err).To(MatchError(ErrInvalidName))
+	})
+
+	It("converts LSNs to WAL segment names and back", func() {
+		name, err := FromLSN(3, types.LSN("1/FE000028"), DefaultSegmentSize)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name.String()).To(Equal("0000000300000001000000FE"))
+
+		start, err := name.StartLSN(DefaultSegmentSize)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(start).To(Equal(types.LSN("1/FE000000")))
+	})
+
+	It("enumerates the WAL segments between two ones", func() {
+		Expect(SegmentRange("0000000100000000000000FE", "000000010000000100000001", DefaultSegmentSize)).
+			To(Equal([]string{
+				"0000000100000000000000FE",
+				"0000000100000000000000FF",
+				"000000010000000100000000",
+				"000000010000000100000001",
+			}))
+
+		_, err := SegmentRange("000000010000000000000002", "000000020000000000000003", DefaultSegmentSize)
+		Expect(err).To(MatchError(ErrInvalidName))
+		_, err = SegmentRange("000000010000000000000003", "000000010000000000000002", DefaultSegmentSize)
+		Expect(err).To(MatchError(ErrInvalidName))
+	})
+})
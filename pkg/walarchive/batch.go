@@ -0,0 +1,313 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package walarchive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnative-pg/machinery/pkg/execlog"
+	"github.com/cloudnative-pg/machinery/pkg/log"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
+	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
+)
+
+// exitCodeBatchNotSupported is the exit code of the batch helper when the
+// barman installation doesn't expose the entry point of barman-cloud-wal-archive
+const exitCodeBatchNotSupported = 64
+
+// batchHelper is a Python program archiving a batch of WAL files inside a
+// single interpreter, by invoking the entry point of barman-cloud-wal-archive
+// once per WAL file. This way the cost of starting Python and loading barman
+// is paid once per batch instead of once per WAL file.
+//
+// The entry point is not known to be thread safe, so the WAL files are
+// archived one at a time in the main thread, every invocation building its
+// own connection to the object store. When more workers are requested, the
+// WAL files are archived by a pool of threads of that size, where the
+// compression of the WAL files is serialized by the Python global
+// interpreter lock.
+//
+// The arguments are the path of the results file, the number of workers,
+// the number of options, the options and the WAL files. For every WAL file,
+// a JSON line with its exit code and timing is appended to the results file.
+const batchHelper = `
+import json, sys, time
+from concurrent.futures import ThreadPoolExecutor
+try:
+    from barman.clients.cloud_walarchive import main
+except ImportError:
+    sys.exit(64)
+
+def archive(wal_path):
+    start_time = time.time()
+    try:
+        main(options + [wal_path])
+        exit_code = 0
+    except SystemExit as exc:
+        exit_code = 0 if exc.code is None else (exc.code if isinstance(exc.code, int) else 1)
+    except Exception:
+        exit_code = 4
+    return {
+        "walPath": wal_path,
+        "exitCode": exit_code,
+        "startTime": start_time,
+        "endTime": time.time(),
+    }
+
+def write_results(results, archived):
+    for result in archived:
+        results.write(json.dumps(result) + "\n")
+        results.flush()
+
+results_path, workers, options_count = sys.argv[1], int(sys.argv[2]), int(sys.argv[3])
+options = sys.argv[4:4 + options_count]
+wal_paths = sys.argv[4 + options_count:]
+with open(results_path, "w") as results:
+    if workers <= 1:
+        write_results(results, map(archive, wal_paths))
+    else:
+        with ThreadPoolExecutor(max_workers=workers) as pool:
+            write_results(results, pool.map(archive, wal_paths))
+`
+
+// ErrBatchNotSupported is returned when the WAL files
+// can't be archived with a single barman process
+var ErrBatchNotSupported = errors.New("batch archiving is not supported")
+
+// batchResult is the result of the archival of a WAL file
+// reported by the batch helper
+type batchResult struct {
+	WalPath   string  `json:"walPath"`
+	ExitCode  int     `json:"exitCode"`
+	StartTime float64 `json:"startTime"`
+	EndTime   float64 `json:"endTime"`
+}
+
+// ArchiveBatch archives a list of WAL files with a single barman process,
+// avoiding the cost of starting barman-cloud-wal-archive for every WAL
+// file. As in ArchiveList, the WAL files following the first one are added
+// to the spool once archived, and the result of every WAL file is reported.
+// When the barman installation doesn't allow it, or the destination is a
+// local directory, the WAL files are archived with ArchiveList.
+func (archiver *BarmanArchiver) ArchiveBatch(
+	ctx context.Context,
+	walNames []string,
+	options []string,
+) []WALArchiverResult {
+	contextLog := log.FromContext(ctx)
+
+	store, err := filestore.NewFromOptions(options)
+	if err != nil || store != nil || len(walNames) < 2 {
+		return archiver.ArchiveList(ctx, walNames, options)
+	}
+
+	result, err := archiver.archiveBatch(ctx, walNames, options)
+	if errors.Is(err, ErrBatchNotSupported) {
+		contextLog.Debug("Archiving WAL files in parallel", "reason", err)
+		return archiver.ArchiveList(ctx, walNames, options)
+	}
+	if err != nil {
+		// The WAL files without a result have not been archived
+		for idx := range result {
+			if result[idx].EndTime.IsZero() {
+				result[idx].Err = err
+				result[idx].EndTime = time.Now()
+			}
+		}
+	}
+
+	for idx := range result {
+		if result[idx].Err == nil {
+			result[idx].Err = archiver.archived(ctx, result[idx].WalName)
+		}
+		archiver.spoolResult(contextLog, idx, &result[idx], "Pre-archived WAL file (batch)")
+	}
+
+	return result
+}
+
+// archiveBatch runs the batch helper, and collects the result of every WAL
+// file. The WAL files not reached by the helper have a zero end time.
+func (archiver *BarmanArchiver) archiveBatch(
+	ctx context.Context,
+	walNames []string,
+	options []string,
+) ([]WALArchiverResult, error) {
+	contextLog := log.FromContext(ctx)
+
+	interpreter, err := barmanInterpreter()
+	if err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	result := make([]WALArchiverResult, len(walNames))
+	walIndexes := make(map[string]int, len(walNames))
+	var batchWALNames []string
+	for idx, walName := range walNames {
+		result[idx] = WALArchiverResult{WalName: walName, StartTime: startTime}
+		if !wal.IsValid(filepath.Base(walName)) {
+			result[idx].Err = fmt.Errorf("can't archive wal file %v: %w", walName, wal.ErrInvalidName)
+			result[idx].EndTime = startTime
+			continue
+		}
+		if _, found := walIndexes[walName]; found {
+			result[idx].Err = fmt.Errorf("can't archive wal file %v twice in the same batch", walName)
+			result[idx].EndTime = startTime
+			continue
+		}
+		walIndexes[walName] = idx
+		batchWALNames = append(batchWALNames, walName)
+	}
+
+	for _, walName := range batchWALNames {
+		if err := archiver.Limiter.Wait(ctx, walFileSize(walName)); err != nil {
+			return result, fmt.Errorf("while waiting to archive wal files: %w", err)
+		}
+	}
+
+	resultsFile, err := os.CreateTemp("", "barman-cloud-wal-archive-*.json")
+	if err != nil {
+		return result, fmt.Errorf("while creating the batch results file: %w", err)
+	}
+	resultsPath := resultsFile.Name()
+	_ = resultsFile.Close()
+	defer func() {
+		_ = os.Remove(resultsPath)
+	}()
+
+	workers := min(max(archiver.BatchWorkers, 1), len(batchWALNames))
+	args := make([]string, 0, len(interpreter)+5+len(options)+len(batchWALNames))
+	args = append(args, interpreter[1:]...)
+	args = append(args, "-c", batchHelper, resultsPath, strconv.Itoa(workers), strconv.Itoa(len(options)))
+	args = append(args, options...)
+	args = append(args, batchWALNames...)
+
+	contextLog.Info("Executing "+utils.BarmanCloudWalArchive+" in batch",
+		"walNames", batchWALNames,
+		"options", options,
+	)
+
+	batchCmd := exec.CommandContext(ctx, interpreter[0], args...) // #nosec G204
+	batchCmd.Env = archiver.Env
+	runErr := execlog.RunStreaming(batchCmd, utils.BarmanCloudWalArchive)
+
+	var exitError *exec.ExitError
+	switch {
+	case errors.Is(runErr, exec.ErrNotFound):
+		return nil, fmt.Errorf("%w: %w", ErrBatchNotSupported, runErr)
+	case errors.As(runErr, &exitError) && exitError.ExitCode() == exitCodeBatchNotSupported:
+		return nil, fmt.Errorf("%w: barman doesn't expose the entry point of %s",
+			ErrBatchNotSupported, utils.BarmanCloudWalArchive)
+	}
+
+	if err := readBatchResults(resultsPath, walIndexes, result); err != nil {
+		return result, err
+	}
+	if runErr != nil {
+		return result, fmt.Errorf("unexpected failure invoking %s in batch: %w", utils.BarmanCloudWalArchive, runErr)
+	}
+
+	return result, nil
+}
+
+// readBatchResults reads the results file written by the batch helper,
+// and reports the result of every WAL file
+func readBatchResults(resultsPath string, walIndexes map[string]int, result []WALArchiverResult) error {
+	resultsFile, err := os.Open(resultsPath) // #nosec G304
+	if err != nil {
+		return fmt.Errorf("while reading the batch results file: %w", err)
+	}
+	defer func() {
+		_ = resultsFile.Close()
+	}()
+
+	scanner := bufio.NewScanner(resultsFile)
+	for scanner.Scan() {
+		var entry batchResult
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("while parsing the batch results file: %w", err)
+		}
+
+		idx, ok := walIndexes[entry.WalPath]
+		if !ok {
+			continue
+		}
+
+		walStatus := &result[idx]
+		walStatus.StartTime = floatToTime(entry.StartTime)
+		walStatus.EndTime = floatToTime(entry.EndTime)
+		if entry.ExitCode != 0 {
+			walStatus.Err = fmt.Errorf("%s failed with exit code %d", utils.BarmanCloudWalArchive, entry.ExitCode)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// barmanInterpreter gets the command line of the Python interpreter
+// running barman-cloud-wal-archive, reading its shebang line
+func barmanInterpreter() ([]string, error) {
+	scriptPath, err := exec.LookPath(utils.BarmanCloudWalArchive)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBatchNotSupported, err)
+	}
+
+	script, err := os.Open(scriptPath) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBatchNotSupported, err)
+	}
+	defer func() {
+		_ = script.Close()
+	}()
+
+	firstLine, _ := bufio.NewReader(script).ReadString('\n')
+	shebang, found := strings.CutPrefix(strings.TrimSpace(firstLine), "#!")
+	interpreter := strings.Fields(shebang)
+	if !found || len(interpreter) == 0 {
+		return nil, fmt.Errorf("%w: %s is not a Python script", ErrBatchNotSupported, scriptPath)
+	}
+	if filepath.Base(interpreter[0]) == "env" {
+		interpreter = interpreter[1:]
+	}
+	if len(interpreter) == 0 || !strings.HasPrefix(filepath.Base(interpreter[0]), "python") {
+		return nil, fmt.Errorf("%w: %s is not a Python script", ErrBatchNotSupported, scriptPath)
+	}
+
+	return interpreter, nil
+}
+
+// floatToTime converts a Unix timestamp with fractional seconds to a time
+func floatToTime(timestamp float64) time.Time {
+	seconds := int64(timestamp)
+	return time.Unix(seconds, int64((timestamp-float64(seconds))*float64(time.Second)))
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package walarchive
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// stubWalArchiveModule is a barman entry point copying the WAL files into the
+// destination directory, failing for the WAL files ending in 3, raising an
+// exception for the WAL files ending in 4, exiting with no exit code after
+// the WAL files ending in 2, and recording the process archiving every WAL
+// file and when the archival starts and ends
+const stubWalArchiveModule = `
+import os, shutil, time
+
+def main(args=None):
+    destination, wal_path = args[0], args[-1]
+    with open(os.path.join(destination, "processes"), "a") as processes:
+        processes.write("%d\n" % os.getpid())
+    with open(os.path.join(destination, "events"), "a") as events:
+        events.write("start\n")
+    try:
+        time.sleep(0.05)
+        if wal_path.endswith("3"):
+            raise SystemExit(4)
+        if wal_path.endswith("4"):
+            raise RuntimeError("upload failed")
+        shutil.copy(wal_path, destination)
+        if wal_path.endswith("2"):
+            raise SystemExit()
+    finally:
+        with open(os.path.join(destination, "events"), "a") as events:
+            events.write("end\n")
+`
+
+var _ = Describe("ArchiveBatch", func() {
+	var (
+		tempDir     string
+		destination string
+		walNames    []string
+		touched     []string
+		mutex       sync.Mutex
+		archiver    *BarmanArchiver
+	)
+
+	// installScript installs a barman-cloud-wal-archive script in the PATH
+	installScript := func(content string) {
+		binDirectory := filepath.Join(tempDir, "bin")
+		Expect(os.MkdirAll(binDirectory, 0o750)).To(Succeed())
+		// #nosec G306
+		Expect(os.WriteFile(filepath.Join(binDirectory, "barman-cloud-wal-archive"), []byte(content), 0o755)).
+			To(Succeed())
+		GinkgoT().Setenv("PATH", binDirectory+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+
+	BeforeEach(func() {
+		tempDir = GinkgoT().TempDir()
+		destination = filepath.Join(tempDir, "destination")
+		Expect(os.MkdirAll(destination, 0o750)).To(Succeed())
+
+		walDirectory := filepath.Join(tempDir, "pg_wal")
+		Expect(os.MkdirAll(walDirectory, 0o750)).To(Succeed())
+		walNames = nil
+		for _, walName := range []string{
+			"000000010000000000000001",
+			"000000010000000000000002",
+			"000000010000000000000003",
+		} {
+			walPath := filepath.Join(walDirectory, walName)
+			Expect(os.WriteFile(walPath, []byte(walName), 0o600)).To(Succeed())
+			walNames = append(walNames, walPath)
+		}
+
+		touched = nil
+		archiver = &BarmanArchiver{
+			Env: os.Environ(),
+			Touch: func(walFile string) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				touched = append(touched, filepath.Base(walFile))
+				return nil
+			},
+			EmptyWalArchivePath: filepath.Join(tempDir, ".check-empty-wal-archive"),
+		}
+	})
+
+	// installStubModule installs a Python barman-cloud-wal-archive
+	// script whose entry point is stubWalArchiveModule
+	installStubModule := func() {
+		if _, err := exec.LookPath("python3"); err != nil {
+			Skip("python3 is not available")
+		}
+
+		moduleDirectory := filepath.Join(tempDir, "lib", "barman", "clients")
+		Expect(os.MkdirAll(moduleDirectory, 0o750)).To(Succeed())
+		for _, fileName := range []string{"__init__.py", filepath.Join("..", "__init__.py")} {
+			Expect(os.WriteFile(filepath.Join(moduleDirectory, fileName), nil, 0o600)).To(Succeed())
+		}
+		Expect(os.WriteFile(filepath.Join(moduleDirectory, "cloud_walarchive.py"),
+			[]byte(stubWalArchiveModule), 0o600)).To(Succeed())
+		installScript("#!/usr/bin/env python3\n")
+		archiver.Env = append(archiver.Env, "PYTHONPATH="+filepath.Join(tempDir, "lib"))
+	}
+
+	It("archives the WAL files with a single barman process", func() {
+		installStubModule()
+
+		result := archiver.ArchiveBatch(context.Background(), walNames, []string{destination, "cluster-example"})
+		Expect(result).To(HaveLen(3))
+		Expect(result[0].Err).ToNot(HaveOccurred())
+		Expect(result[1].Err).ToNot(HaveOccurred())
+		Expect(result[2].Err).To(HaveOccurred())
+		for _, walStatus := range result {
+			Expect(walStatus.EndTime).ToNot(BeTemporally("<", walStatus.StartTime))
+		}
+
+		Expect(filepath.Join(destination, "000000010000000000000001")).To(BeAnExistingFile())
+		Expect(filepath.Join(destination, "000000010000000000000002")).To(BeAnExistingFile())
+		Expect(touched).To(ConsistOf("000000010000000000000002"))
+
+		processes, err := os.ReadFile(filepath.Join(destination, "processes")) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		lines := strings.Fields(string(processes))
+		Expect(lines).To(HaveLen(3))
+		Expect(lines).To(HaveEach(lines[0]))
+	})
+
+	It("archives the WAL files one at a time, reporting the exceptions as failures", func() {
+		installStubModule()
+		failingWAL := filepath.Join(tempDir, "pg_wal", "000000010000000000000004")
+		Expect(os.WriteFile(failingWAL, []byte("wal"), 0o600)).To(Succeed())
+
+		result := archiver.ArchiveBatch(context.Background(), append(walNames, failingWAL),
+			[]string{destination, "cluster-example"})
+		Expect(result).To(HaveLen(4))
+		Expect(result[3].Err).To(MatchError(ContainSubstring("failed with exit code 4")))
+		Expect(filepath.Join(destination, "000000010000000000000004")).ToNot(BeAnExistingFile())
+		Expect(touched).To(ConsistOf("000000010000000000000002"))
+
+		events, err := os.ReadFile(filepath.Join(destination, "events")) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Fields(string(events))).To(Equal([]string{
+			"start", "end", "start", "end", "start", "end", "start", "end",
+		}))
+	})
+
+	It("archives the WAL files concurrently when requested", func() {
+		installStubModule()
+		archiver.BatchWorkers = 3
+
+		result := archiver.ArchiveBatch(context.Background(), walNames, []string{destination, "cluster-example"})
+		Expect(result).To(HaveLen(3))
+		Expect(result[0].Err).ToNot(HaveOccurred())
+		Expect(result[1].Err).ToNot(HaveOccurred())
+		Expect(result[2].Err).To(HaveOccurred())
+
+		events, err := os.ReadFile(filepath.Join(destination, "events")) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Fields(string(events))[:3]).To(Equal([]string{"start", "start", "start"}))
+	})
+
+	It("rejects the WAL files passed twice", func() {
+		installStubModule()
+
+		result := archiver.ArchiveBatch(context.Background(), append(walNames, walNames[0]),
+			[]string{destination, "cluster-example"})
+		Expect(result).To(HaveLen(4))
+		Expect(result[0].Err).ToNot(HaveOccurred())
+		Expect(result[3].Err).To(MatchError(ContainSubstring("twice in the same batch")))
+
+		processes, err := os.ReadFile(filepath.Join(destination, "processes")) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Fields(string(processes))).To(HaveLen(3))
+	})
+
+	It("archives the WAL files one by one when barman is not a Python script", func() {
+		installScript("#!/bin/sh\necho $$ >> \"$1/processes\"\ncase \"$3\" in *3) exit 4;; esac\ncp \"$3\" \"$1\"\n")
+
+		result := archiver.ArchiveBatch(context.Background(), walNames, []string{destination, "cluster-example"})
+		Expect(result).To(HaveLen(3))
+		Expect(result[0].Err).ToNot(HaveOccurred())
+		Expect(result[1].Err).ToNot(HaveOccurred())
+		Expect(result[2].Err).To(HaveOccurred())
+		Expect(touched).To(ConsistOf("000000010000000000000002"))
+
+		processes, err := os.ReadFile(filepath.Join(destination, "processes")) // #nosec G304
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Fields(string(processes))).To(HaveLen(3))
+	})
+})
@@ -23,7 +23,9 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -31,7 +33,10 @@ import (
 	"github.com/cloudnative-pg/machinery/pkg/fileutils"
 	"github.com/cloudnative-pg/machinery/pkg/log"
 
+	"github.com/cloudnative-pg/barman-cloud/pkg/filestore"
+	"github.com/cloudnative-pg/barman-cloud/pkg/throttle"
 	"github.com/cloudnative-pg/barman-cloud/pkg/utils"
+	"github.com/cloudnative-pg/barman-cloud/pkg/wal"
 )
 
 // BarmanArchiver implements a WAL archiver based
@@ -40,6 +45,16 @@ type BarmanArchiver struct {
 	Env                 []string
 	Touch               func(walFile string) error
 	EmptyWalArchivePath string
+
+	// Limits the bandwidth and the request rate shared
+	// by the concurrent archivals. Nil means no limit.
+	Limiter *throttle.Limiter
+
+	// The maximum number of WAL files archived concurrently by ArchiveBatch
+	// inside the barman process, capped by the size of the batch. The entry
+	// point of barman-cloud-wal-archive is not known to be thread safe, so
+	// the WAL files are archived one at a time unless this is set.
+	BatchWorkers int
 }
 
 // WALArchiverResult contains the result of the archival of one WAL
@@ -64,7 +79,10 @@ func (archiver *BarmanArchiver) Archive(
 	walName string,
 	baseOptions []string,
 ) error {
-	contextLogger := log.FromContext(ctx)
+	if !wal.IsValid(filepath.Base(walName)) {
+		return fmt.Errorf("can't archive wal file %v: %w", walName, wal.ErrInvalidName)
+	}
+
 	optionsLength := len(baseOptions)
 	if optionsLength >= math.MaxInt-1 {
 		return fmt.Errorf("can't archive wal file %v, options too long", walName)
@@ -73,6 +91,63 @@ func (archiver *BarmanArchiver) Archive(
 	copy(options, baseOptions)
 	options = append(options, walName)
 
+	if err := archiver.Limiter.Wait(ctx, walFileSize(walName)); err != nil {
+		return fmt.Errorf("while waiting to archive wal file %v: %w", walName, err)
+	}
+
+	if err := archiver.archive(ctx, walName, options); err != nil {
+		return err
+	}
+
+	return archiver.archived(ctx, walName)
+}
+
+// walFileSize gets the size of a WAL file, which is zero when it can't
+// be read, leaving the archival to report the error
+func walFileSize(walName string) int64 {
+	info, err := os.Stat(walName)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// archived completes the archival of a WAL file, releasing it from
+// the page cache and removing the flag used to check the WAL archive
+func (archiver *BarmanArchiver) archived(ctx context.Context, walName string) error {
+	contextLogger := log.FromContext(ctx)
+	if err := archiver.fadviseNotUsed(walName); err != nil {
+		contextLogger.Error(err, "Error issuing fadvise after archiving WAL",
+			"walName", walName,
+		)
+	}
+
+	// Removes the `.check-empty-wal-archive` file inside PGDATA after the
+	// first successful archival of a WAL file.
+	if err := fileutils.RemoveFile(archiver.EmptyWalArchivePath); err != nil {
+		return fmt.Errorf("error while deleting the check WAL file flag: %w", err)
+	}
+	return nil
+}
+
+// archive archives a WAL file, either natively when the destination is
+// a local directory or by invoking barman-cloud-wal-archive
+func (archiver *BarmanArchiver) archive(ctx context.Context, walName string, options []string) error {
+	contextLogger := log.FromContext(ctx)
+
+	store, err := filestore.NewFromOptions(options)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		contextLogger.Info("Archiving WAL file into local directory",
+			"walName", walName,
+			"serverDirectory", store.ServerDirectory(),
+		)
+		return store.ArchiveWAL(walName)
+	}
+
 	contextLogger.Info("Executing "+utils.BarmanCloudWalArchive,
 		"walName", walName,
 		"options", options,
@@ -81,7 +156,7 @@ func (archiver *BarmanArchiver) Archive(
 	barmanCloudWalArchiveCmd := exec.Command(utils.BarmanCloudWalArchive, options...) // #nosec G204
 	barmanCloudWalArchiveCmd.Env = archiver.Env
 
-	err := execlog.RunStreaming(barmanCloudWalArchiveCmd, utils.BarmanCloudWalArchive)
+	err = execlog.RunStreaming(barmanCloudWalArchiveCmd, utils.BarmanCloudWalArchive)
 	if err != nil {
 		contextLogger.Error(err, "Error invoking "+utils.BarmanCloudWalArchive,
 			"walName", walName,
@@ -91,17 +166,6 @@ func (archiver *BarmanArchiver) Archive(
 		return fmt.Errorf("unexpected failure invoking %s: %w", utils.BarmanCloudWalArchive, err)
 	}
 
-	if err := archiver.fadviseNotUsed(walName); err != nil {
-		contextLogger.Error(err, "Error issuing fadvise after archiving WAL",
-			"walName", walName,
-		)
-	}
-
-	// Removes the `.check-empty-wal-archive` file inside PGDATA after the
-	// first successful archival of a WAL file.
-	if err := fileutils.RemoveFile(archiver.EmptyWalArchivePath); err != nil {
-		return fmt.Errorf("error while deleting the check WAL file flag: %w", err)
-	}
 	return nil
 }
 
@@ -126,35 +190,7 @@ func (archiver *BarmanArchiver) ArchiveList(
 				Err:       archiver.Archive(ctx, walNames[walIndex], options),
 				EndTime:   time.Now(),
 			}
-			walStatus := &result[walIndex]
-
-			walContextLog := contextLog.WithValues(
-				"walName", walStatus.WalName,
-				"startTime", walStatus.StartTime,
-				"endTime", walStatus.EndTime,
-				"elapsedWalTime", walStatus.EndTime.Sub(walStatus.StartTime),
-			)
-
-			if walStatus.Err != nil {
-				walContextLog.Warning(
-					"Failed archiving WAL: PostgreSQL will retry",
-					"error", walStatus.Err)
-				return
-			}
-
-			if walIndex == 0 {
-				walContextLog.Info("Archived WAL file")
-				return
-			}
-
-			if err := archiver.Touch(walNames[walIndex]); err != nil {
-				walContextLog.Warning(
-					"WAL file pre-archived, but it could not be added to the spool. PostgreSQL will retry",
-					"error", err)
-				return
-			}
-
-			walContextLog.Info("Pre-archived WAL file (parallel)")
+			archiver.spoolResult(contextLog, walIndex, &result[walIndex], "Pre-archived WAL file (parallel)")
 		}(idx)
 	}
 
@@ -162,16 +198,67 @@ func (archiver *BarmanArchiver) ArchiveList(
 	return result
 }
 
+// spoolResult logs the result of the archival of a WAL file and, when the
+// WAL file is not the one requested by PostgreSQL, adds it to the spool so
+// that PostgreSQL will find it already archived
+func (archiver *BarmanArchiver) spoolResult(
+	contextLog log.Logger,
+	walIndex int,
+	walStatus *WALArchiverResult,
+	preArchivedMessage string,
+) {
+	walContextLog := contextLog.WithValues(
+		"walName", walStatus.WalName,
+		"startTime", walStatus.StartTime,
+		"endTime", walStatus.EndTime,
+		"elapsedWalTime", walStatus.EndTime.Sub(walStatus.StartTime),
+	)
+
+	if walStatus.Err != nil {
+		walContextLog.Warning(
+			"Failed archiving WAL: PostgreSQL will retry",
+			"error", walStatus.Err)
+		return
+	}
+
+	if walIndex == 0 {
+		walContextLog.Info("Archived WAL file")
+		return
+	}
+
+	if err := archiver.Touch(walStatus.WalName); err != nil {
+		walContextLog.Warning(
+			"WAL file pre-archived, but it could not be added to the spool. PostgreSQL will retry",
+			"error", err)
+		return
+	}
+
+	walContextLog.Info(preArchivedMessage)
+}
+
 // CheckWalArchiveDestination checks if the destinationObjectStore is ready perform archiving.
 // Based on this ticket in Barman https://github.com/EnterpriseDB/barman/issues/432
 // and its implementation https://github.com/EnterpriseDB/barman/pull/443
 // The idea here is to check ONLY if we're archiving the wal files for the first time in the bucket
 // since in this case the command barman-cloud-check-wal-archive will fail if the bucket exist and
-// contain wal files inside
+// contain wal files inside, unless they belong to the timelines preceding the one passed
+// with --timeline (see command.CheckWalArchiveTimelineOptions)
 func (archiver *BarmanArchiver) CheckWalArchiveDestination(ctx context.Context, options []string) error {
 	contextLogger := log.FromContext(ctx)
 	contextLogger.Info("barman-cloud-check-wal-archive checking the first wal")
 
+	store, err := filestore.NewFromOptions(options)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		timeline, err := filestore.TimelineFromOptions(options)
+		if err != nil {
+			return err
+		}
+		return store.CheckWalArchive(timeline)
+	}
+
 	contextLogger.Trace("Executing "+utils.BarmanCloudCheckWalArchive,
 		"options", options,
 	)
@@ -179,7 +266,7 @@ func (archiver *BarmanArchiver) CheckWalArchiveDestination(ctx context.Context,
 	barmanCloudWalArchiveCmd := exec.Command(utils.BarmanCloudCheckWalArchive, options...) // #nosec G204
 	barmanCloudWalArchiveCmd.Env = archiver.Env
 
-	err := execlog.RunStreaming(barmanCloudWalArchiveCmd, utils.BarmanCloudCheckWalArchive)
+	err = execlog.RunStreaming(barmanCloudWalArchiveCmd, utils.BarmanCloudCheckWalArchive)
 	if err != nil {
 		contextLogger.Error(err, "Error invoking "+utils.BarmanCloudCheckWalArchive,
 			"options", options,
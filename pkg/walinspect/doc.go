@@ -0,0 +1,29 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package walinspect reads the records stored in archived WAL segments,
+// to locate the recovery targets that cannot be resolved using the
+// backup metadata, such as transaction IDs and named restore points.
+//
+// Only the record headers and the main data of the records without
+// block references are decoded, which is enough to detect transaction
+// commits and aborts, including the ones of prepared transactions, and
+// restore points. The segments following the one being read are fetched
+// in the background.
+package walinspect
@@ -0,0 +1,281 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package walinspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cloudnative-pg/machinery/pkg/types"
+
+	"github.com/cloudnative-pg/barman-cloud/pkg/catalog"
+)
+
+// ErrTargetNotFound is returned when the recovery target is not found
+// in the inspected part of the WAL archive. It is the error the catalog
+// expects to go on with the inspection of the previous part.
+var ErrTargetNotFound = catalog.ErrRecoveryPointNotFound
+
+const (
+	// The resource manager of the WAL control records (RM_XLOG_ID)
+	resourceManagerXLOG = 0
+
+	// The resource manager of the transaction records (RM_XACT_ID)
+	resourceManagerXact = 1
+
+	// The bits of the information flags reserved
+	// to the WAL machinery (XLR_INFO_MASK)
+	recordInfoMask = 0x0F
+
+	// The XLOG records we are interested in
+	xlogSwitch       = 0x40
+	xlogRestorePoint = 0x70
+
+	// The transaction records we are interested in
+	xactOpMask         = 0x70
+	xactCommit         = 0x00
+	xactAbort          = 0x20
+	xactCommitPrepared = 0x30
+	xactAbortPrepared  = 0x40
+
+	// The transaction record is followed by the xinfo flags (XLOG_XACT_HAS_INFO)
+	xactHasInfo = 0x80
+
+	// The optional parts of the commit and abort records (XACT_XINFO_HAS_*)
+	xactXinfoHasDBInfo       = 1 << 0
+	xactXinfoHasSubxacts     = 1 << 1
+	xactXinfoHasRelfilenodes = 1 << 2
+	xactXinfoHasInvals       = 1 << 3
+	xactXinfoHasTwoPhase     = 1 << 4
+	xactXinfoHasDroppedStats = 1 << 8
+
+	// The sizes of the parts of the commit and abort records: the commit
+	// time (xact_time), the database info (xl_xact_dbinfo), a subtransaction
+	// ID, a relation (RelFileLocator) and an invalidation message
+	xactTimeSize         = 8
+	xactDBInfoSize       = 8
+	xactSubxactSize      = 4
+	xactRelfilenodeSize  = 12
+	xactInvalidationSize = 16
+
+	// The size of a dropped statistics entry (xl_xact_stats_item), whose
+	// object ID has been widened to 64 bits after PostgreSQL 17
+	xactStatsItemSize     = 12
+	xactStatsItemSizeWide = 16
+	pageMagicPostgreSQL17 = 0xD116
+
+	// The offset and size of the name inside
+	// a restore point record (xl_restore_point)
+	restorePointNameOffset = 8
+	restorePointNameLength = 64
+)
+
+// isSegmentSwitch checks if the record is an XLOG_SWITCH one
+func (r *record) isSegmentSwitch() bool {
+	return r.rmid == resourceManagerXLOG && r.info&^recordInfoMask == xlogSwitch
+}
+
+// isRestorePoint checks if the record is an XLOG_RESTORE_POINT one
+func (r *record) isRestorePoint() bool {
+	return r.rmid == resourceManagerXLOG && r.info&^recordInfoMask == xlogRestorePoint
+}
+
+// isTransactionEnd checks if the record is the commit
+// or the abort of a top-level transaction, as these are
+// the records recovery_target_xid is matched against
+func (r *record) isTransactionEnd() bool {
+	if r.rmid != resourceManagerXact {
+		return false
+	}
+	operation := r.info & xactOpMask
+	return operation == xactCommit || operation == xactAbort
+}
+
+// isPreparedTransactionEnd checks if the record is the commit or the
+// abort of a prepared transaction, whose ID is not the one in the
+// record header but the one in the record data
+func (r *record) isPreparedTransactionEnd() bool {
+	if r.rmid != resourceManagerXact {
+		return false
+	}
+	operation := r.info & xactOpMask
+	return operation == xactCommitPrepared || operation == xactAbortPrepared
+}
+
+// preparedTransactionXID gets the ID of the prepared transaction committed
+// or aborted by the record, skipping the optional parts of the record which
+// precede it (xl_xact_twophase) as ParseCommitRecord and ParseAbortRecord do
+func (r *record) preparedTransactionXID() (uint32, bool) {
+	data, ok := r.mainData()
+	if !ok || r.info&xactHasInfo == 0 || len(data) < xactTimeSize+4 {
+		return 0, false
+	}
+
+	xinfo := binary.LittleEndian.Uint32(data[xactTimeSize:])
+	position := xactTimeSize + 4
+	if xinfo&xactXinfoHasDBInfo != 0 {
+		position += xactDBInfoSize
+	}
+
+	statsItemSize := xactStatsItemSize
+	if r.pageMagic > pageMagicPostgreSQL17 {
+		statsItemSize = xactStatsItemSizeWide
+	}
+	for _, array := range []struct {
+		flag     uint32
+		itemSize int
+	}{
+		{flag: xactXinfoHasSubxacts, itemSize: xactSubxactSize},
+		{flag: xactXinfoHasRelfilenodes, itemSize: xactRelfilenodeSize},
+		{flag: xactXinfoHasDroppedStats, itemSize: statsItemSize},
+		{flag: xactXinfoHasInvals, itemSize: xactInvalidationSize},
+	} {
+		if xinfo&array.flag == 0 {
+			continue
+		}
+		if position, ok = skipCountedArray(data, position, array.itemSize); !ok {
+			return 0, false
+		}
+	}
+
+	if xinfo&xactXinfoHasTwoPhase == 0 || position+4 > len(data) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(data[position:]), true
+}
+
+// skipCountedArray skips an array made of its number of items followed by
+// the items, returning the position following it
+func skipCountedArray(data []byte, position int, itemSize int) (int, bool) {
+	if position+4 > len(data) {
+		return 0, false
+	}
+
+	count := int(int32(binary.LittleEndian.Uint32(data[position:]))) // #nosec G115
+	position += 4
+	if count < 0 || position+count*itemSize > len(data) {
+		return 0, false
+	}
+	return position + count*itemSize, true
+}
+
+// restorePointName gets the name of a restore point
+func (r *record) restorePointName() (string, bool) {
+	data, ok := r.mainData()
+	if !ok || len(data) < restorePointNameOffset+restorePointNameLength {
+		return "", false
+	}
+
+	name := data[restorePointNameOffset : restorePointNameOffset+restorePointNameLength]
+	if idx := bytes.IndexByte(name, 0); idx >= 0 {
+		name = name[:idx]
+	}
+	return string(name), true
+}
+
+// Locator locates the recovery targets inside the WAL archive,
+// reading the segments of a timeline in order
+type Locator struct {
+	fetch SegmentFetcher
+}
+
+// NewLocator creates a new locator reading the
+// WAL segments with the passed fetcher
+func NewLocator(fetch SegmentFetcher) *Locator {
+	return &Locator{fetch: fetch}
+}
+
+// LocateTransaction gets the position of the commit or abort record of the
+// passed top-level transaction, including the prepared ones, reading the WAL
+// archive from the start segment and stopping before the stop one, which can
+// be empty to read up to the end of the WAL archive. As PostgreSQL does, only
+// the lower 32 bits of the transaction ID are considered.
+func (locator *Locator) LocateTransaction(xid uint32, startWAL string, stopWAL string) (types.LSN, error) {
+	candidate := func(r *record) bool {
+		return (r.xid == xid && r.isTransactionEnd()) || r.isPreparedTransactionEnd()
+	}
+	lsn, err := locator.locate(startWAL, stopWAL, candidate, func(r *record) bool {
+		if !r.isPreparedTransactionEnd() {
+			return true
+		}
+		preparedXID, ok := r.preparedTransactionXID()
+		return ok && preparedXID == xid
+	})
+	if err != nil {
+		return "", fmt.Errorf("while looking for transaction %d: %w", xid, err)
+	}
+
+	return lsn, nil
+}
+
+// LocateRestorePoint gets the position of the first restore point with the
+// passed name, reading the WAL archive from the start segment and stopping
+// before the stop one, which can be empty to read up to the end of the WAL archive
+func (locator *Locator) LocateRestorePoint(name string, startWAL string, stopWAL string) (types.LSN, error) {
+	lsn, err := locator.locate(startWAL, stopWAL, (*record).isRestorePoint, func(r *record) bool {
+		recordName, ok := r.restorePointName()
+		return ok && recordName == name
+	})
+	if err != nil {
+		return "", fmt.Errorf("while looking for restore point %q: %w", name, err)
+	}
+
+	return lsn, nil
+}
+
+// locate gets the position of the first record satisfying the candidate
+// function, which only has access to the record header, and the matches
+// one, which can access the whole record. A nil matches function
+// accepts every candidate.
+func (locator *Locator) locate(
+	startWAL string,
+	stopWAL string,
+	candidate func(*record) bool,
+	matches func(*record) bool,
+) (types.LSN, error) {
+	reader, err := newWALReader(locator.fetch, startWAL, stopWAL)
+	if errors.Is(err, ErrSegmentNotFound) {
+		return "", ErrTargetNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	wanted := candidate
+	if matches == nil {
+		wanted = func(*record) bool { return false }
+	}
+
+	for {
+		r, err := reader.next(wanted)
+		if errors.Is(err, errEndOfWAL) {
+			return "", ErrTargetNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if candidate(r) && (matches == nil || matches(r)) {
+			return r.LSN(), nil
+		}
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package walinspect
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/cloudnative-pg/machinery/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	testPageSize    = 256
+	testSegmentSize = 2048
+)
+
+// walBuilder writes a WAL stream using the PostgreSQL page layout
+type walBuilder struct {
+	start    uint64
+	position uint64
+	data     []byte
+}
+
+func newWALBuilder(startSegment uint64) *walBuilder {
+	start := startSegment * testSegmentSize
+	return &walBuilder{start: start, position: start}
+}
+
+// put writes the content of a record, adding the page
+// headers of the pages containing its continuation
+func (b *walBuilder) put(content []byte) {
+	for len(content) > 0 {
+		if b.position%testPageSize == 0 {
+			b.pageHeader(uint32(len(content)))
+		}
+		chunk := min(len(content), int(testPageSize-b.position%testPageSize))
+		b.data = append(b.data, content[:chunk]...)
+		b.position += uint64(chunk)
+		content = content[chunk:]
+	}
+}
+
+func (b *walBuilder) pageHeader(remainingLength uint32) {
+	header := make([]byte, shortPageHeaderSize)
+	info := uint16(0)
+	if remainingLength > 0 {
+		info |= pageContRecordFlag
+	}
+	if b.position%testSegmentSize == 0 {
+		info |= pageLongHeaderFlag
+		header = make([]byte, longPageHeaderSize)
+		binary.LittleEndian.PutUint32(header[32:], testSegmentSize)
+		binary.LittleEndian.PutUint32(header[36:], testPageSize)
+	}
+	binary.LittleEndian.PutUint16(header[0:], 0xD116)
+	binary.LittleEndian.PutUint16(header[2:], info)
+	binary.LittleEndian.PutUint32(header[4:], 1)
+	binary.LittleEndian.PutUint64(header[8:], b.position)
+	if info&pageContRecordFlag != 0 {
+		binary.LittleEndian.PutUint32(header[16:], remainingLength)
+	}
+	b.data = append(b.data, header...)
+	b.position += uint64(len(header))
+}
+
+// skipTo fills the stream with zeroes up to the passed position
+func (b *walBuilder) skipTo(position uint64) {
+	b.data = append(b.data, make([]byte, position-b.position)...)
+	b.position = position
+}
+
+// record writes a record, returning its position
+func (b *walBuilder) record(rmid uint8, info uint8, xid uint32, mainData []byte) uint64 {
+	b.skipTo(alignRecord(b.position))
+	if b.position%testPageSize == 0 {
+		// The record begins on a new page, which
+		// doesn't start with a continuation
+		b.pageHeader(0)
+	}
+	lsn := b.position
+
+	body := []byte{blockIDDataLong, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(body[1:], uint32(len(mainData)))
+	body = append(body, mainData...)
+
+	header := make([]byte, recordHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], uint32(recordHeaderSize+len(body)))
+	binary.LittleEndian.PutUint32(header[4:], xid)
+	header[16] = info
+	header[17] = rmid
+
+	b.put(append(header, body...))
+	return lsn
+}
+
+func (b *walBuilder) restorePoint(name string) uint64 {
+	data := make([]byte, restorePointNameOffset+restorePointNameLength)
+	copy(data[restorePointNameOffset:], name)
+	return b.record(resourceManagerXLOG, xlogRestorePoint, 0, data)
+}
+
+func (b *walBuilder) commit(xid uint32) uint64 {
+	return b.record(resourceManagerXact, xactCommit, xid, make([]byte, 8))
+}
+
+// preparedTransactionEnd writes a COMMIT_PREPARED or ABORT_PREPARED record,
+// with the optional parts preceding the ID of the prepared transaction
+func (b *walBuilder) preparedTransactionEnd(operation uint8, xid uint32) uint64 {
+	xinfo := uint32(xactXinfoHasDBInfo | xactXinfoHasSubxacts | xactXinfoHasRelfilenodes |
+		xactXinfoHasDroppedStats | xactXinfoHasTwoPhase)
+	if operation == xactCommitPrepared {
+		xinfo |= xactXinfoHasInvals
+	}
+
+	data := binary.LittleEndian.AppendUint32(make([]byte, xactTimeSize), xinfo)
+	data = append(data, make([]byte, xactDBInfoSize)...)
+	for _, array := range []struct {
+		flag     uint32
+		itemSize int
+	}{
+		{flag: xactXinfoHasSubxacts, itemSize: xactSubxactSize},
+		{flag: xactXinfoHasRelfilenodes, itemSize: xactRelfilenodeSize},
+		{flag: xactXinfoHasDroppedStats, itemSize: xactStatsItemSize},
+		{flag: xactXinfoHasInvals, itemSize: xactInvalidationSize},
+	} {
+		if xinfo&array.flag != 0 {
+			data = binary.LittleEndian.AppendUint32(data, 2)
+			data = append(data, make([]byte, 2*array.itemSize)...)
+		}
+	}
+	data = binary.LittleEndian.AppendUint32(data, xid)
+
+	return b.record(resourceManagerXact, operation|xactHasInfo, 0, data)
+}
+
+func (b *walBuilder) heap(xid uint32, size int) uint64 {
+	return b.record(10, 0, xid, make([]byte, size))
+}
+
+func (b *walBuilder) segmentSwitch() uint64 {
+	lsn := b.record(resourceManagerXLOG, xlogSwitch, 0, nil)
+	b.skipTo((b.position + testSegmentSize - 1) / testSegmentSize * testSegmentSize)
+	return lsn
+}
+
+// segments splits the stream in segments, padding the last one
+func (b *walBuilder) segments() map[string][]byte {
+	b.skipTo((b.position + testSegmentSize - 1) / testSegmentSize * testSegmentSize)
+	result := make(map[string][]byte)
+	for offset := 0; offset < len(b.data); offset += testSegmentSize {
+		segmentNumber := (b.start + uint64(offset)) / testSegmentSize
+		segmentsPerLogID := uint64(0x100000000 / testSegmentSize)
+		walName := fmt.Sprintf("%08X%08X%08X", 1, segmentNumber/segmentsPerLogID, segmentNumber%segmentsPerLogID)
+		result[walName] = b.data[offset : offset+testSegmentSize]
+	}
+	return result
+}
+
+func fetcherFor(segments map[string][]byte) SegmentFetcher {
+	return func(walName string) ([]byte, error) {
+		content, ok := segments[walName]
+		if !ok {
+			return nil, ErrSegmentNotFound
+		}
+		return content, nil
+	}
+}
+
+var _ = Describe("Locator", func() {
+	It("locates a restore point", func() {
+		builder := newWALBuilder(0)
+		builder.heap(700, 100)
+		builder.restorePoint("before_migration")
+		expected := builder.restorePoint("after_migration")
+		builder.restorePoint("after_migration")
+
+		locator := NewLocator(fetcherFor(builder.segments()))
+		lsn, err := locator.LocateRestorePoint("after_migration", "000000010000000000000000", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(expected)))
+	})
+
+	It("locates a commit after records spanning pages and segments", func() {
+		builder := newWALBuilder(0)
+		builder.heap(700, 3*testSegmentSize)
+		builder.commit(700)
+		builder.heap(701, 300)
+		expected := builder.commit(701)
+
+		segments := builder.segments()
+		Expect(len(segments)).To(BeNumerically(">", 3))
+
+		locator := NewLocator(fetcherFor(segments))
+		lsn, err := locator.LocateTransaction(701, "000000010000000000000000", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(expected)))
+	})
+
+	It("skips the record continuing from the previous segment", func() {
+		builder := newWALBuilder(0)
+		builder.heap(700, 2*testSegmentSize)
+		expected := builder.commit(700)
+
+		segments := builder.segments()
+		delete(segments, "000000010000000000000000")
+
+		locator := NewLocator(fetcherFor(segments))
+		lsn, err := locator.LocateTransaction(700, "000000010000000000000001", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(expected)))
+	})
+
+	It("continues reading from the next segment after a segment switch", func() {
+		builder := newWALBuilder(4)
+		builder.commit(700)
+		builder.segmentSwitch()
+		expected := builder.restorePoint("target")
+
+		locator := NewLocator(fetcherFor(builder.segments()))
+		lsn, err := locator.LocateRestorePoint("target", "000000010000000000000004", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(expected)))
+	})
+
+	It("stops at recycled segments", func() {
+		builder := newWALBuilder(0)
+		builder.commit(700)
+		segments := builder.segments()
+
+		stale := newWALBuilder(0)
+		stale.commit(701)
+		segments["000000010000000000000001"] = stale.segments()["000000010000000000000000"]
+
+		locator := NewLocator(fetcherFor(segments))
+		_, err := locator.LocateTransaction(701, "000000010000000000000000", "")
+		Expect(err).To(MatchError(ErrTargetNotFound))
+	})
+
+	It("reports targets which are not in the archive", func() {
+		builder := newWALBuilder(0)
+		builder.commit(700)
+		locator := NewLocator(fetcherFor(builder.segments()))
+
+		_, err := locator.LocateTransaction(800, "000000010000000000000000", "")
+		Expect(err).To(MatchError(ErrTargetNotFound))
+
+		_, err = locator.LocateRestorePoint("missing", "000000010000000000000000", "")
+		Expect(err).To(MatchError(ErrTargetNotFound))
+
+		_, err = locator.LocateTransaction(700, "000000010000000000000005", "")
+		Expect(err).To(MatchError(ErrTargetNotFound))
+	})
+
+	It("locates the commit and the abort of prepared transactions", func() {
+		builder := newWALBuilder(0)
+		builder.preparedTransactionEnd(xactCommitPrepared, 699)
+		builder.commit(0)
+		committed := builder.preparedTransactionEnd(xactCommitPrepared, 700)
+		aborted := builder.preparedTransactionEnd(xactAbortPrepared, 701)
+		locator := NewLocator(fetcherFor(builder.segments()))
+
+		lsn, err := locator.LocateTransaction(700, "000000010000000000000000", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(committed)))
+
+		lsn, err = locator.LocateTransaction(701, "000000010000000000000000", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(aborted)))
+	})
+
+	It("stops before the records beginning in the stop segment", func() {
+		builder := newWALBuilder(0)
+		first := builder.commit(700)
+		builder.heap(701, testSegmentSize)
+		second := builder.commit(701)
+		locator := NewLocator(fetcherFor(builder.segments()))
+
+		_, err := locator.LocateTransaction(701, "000000010000000000000000", "000000010000000000000001")
+		Expect(err).To(MatchError(ErrTargetNotFound))
+
+		lsn, err := locator.LocateTransaction(700, "000000010000000000000000", "000000010000000000000001")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(first)))
+
+		lsn, err = locator.LocateTransaction(701, "000000010000000000000001", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(second)))
+	})
+
+	It("prefetches the following segments up to the stop one", func() {
+		builder := newWALBuilder(0)
+		expected := builder.commit(700)
+		builder.heap(701, 6*testSegmentSize)
+		segments := builder.segments()
+
+		var (
+			mutex   sync.Mutex
+			fetched []string
+		)
+		fetch := fetcherFor(segments)
+		locator := NewLocator(func(walName string) ([]byte, error) {
+			mutex.Lock()
+			fetched = append(fetched, walName)
+			mutex.Unlock()
+			return fetch(walName)
+		})
+		fetchedSegments := func() []string {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return slices.Clone(fetched)
+		}
+
+		lsn, err := locator.LocateTransaction(700, "000000010000000000000000", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lsn).To(Equal(types.Int64ToLSN(expected)))
+		Eventually(fetchedSegments).Should(ConsistOf(
+			"000000010000000000000000",
+			"000000010000000000000001",
+			"000000010000000000000002",
+			"000000010000000000000003",
+			"000000010000000000000004",
+		))
+
+		mutex.Lock()
+		fetched = nil
+		mutex.Unlock()
+		_, err = locator.LocateTransaction(700, "000000010000000000000000", "000000010000000000000003")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(fetchedSegments).Should(ConsistOf(
+			"000000010000000000000000",
+			"000000010000000000000001",
+			"000000010000000000000002",
+		))
+		Consistently(fetchedSegments).Should(HaveLen(3))
+	})
+
+	It("rejects invalid segment names", func() {
+		_, err := NewLocator(fetcherFor(nil)).LocateTransaction(700, "invalid", "")
+		Expect(err).To(HaveOccurred())
+		Expect(err).ToNot(MatchError(ErrTargetNotFound))
+	})
+})
@@ -0,0 +1,469 @@
+/*
+Copyright © contributors to CloudNativePG, established as
+CloudNativePG a Series of LF Projects, LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package walinspect
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cloudnative-pg/machinery/pkg/types"
+)
+
+// ErrSegmentNotFound is returned by a SegmentFetcher when
+// the requested WAL segment is not in the archive
+var ErrSegmentNotFound = errors.New("WAL segment not found")
+
+// errEndOfWAL is returned when there are no more records to be read
+var errEndOfWAL = errors.New("end of WAL")
+
+const (
+	// The size of the short page header (SizeOfXLogShortPHD)
+	shortPageHeaderSize = 24
+
+	// The size of the long page header (SizeOfXLogLongPHD)
+	longPageHeaderSize = 40
+
+	// The page header contains the segment and page sizes (XLP_LONG_HEADER)
+	pageLongHeaderFlag = 0x0002
+
+	// The page begins with the continuation of a record (XLP_FIRST_IS_CONTRECORD)
+	pageContRecordFlag = 0x0001
+
+	// The size of the record header (SizeOfXLogRecord)
+	recordHeaderSize = 24
+
+	// The alignment of the records (MAXIMUM_ALIGNOF)
+	recordAlignment = 8
+
+	// The largest record PostgreSQL can write (XLogRecordMaxSize)
+	maxRecordSize = 1020 * 1024 * 1024
+
+	// The identifiers of the block headers (XLR_BLOCK_ID_*)
+	blockIDDataShort   = 255
+	blockIDDataLong    = 254
+	blockIDOrigin      = 253
+	blockIDTopLevelXID = 252
+
+	// The number of segments fetched in the background
+	// while the reader is reading the current one
+	prefetchSegments = 4
+)
+
+// SegmentFetcher fetches the content of a WAL segment from the
+// archive, returning ErrSegmentNotFound if it is not archived
+type SegmentFetcher func(walName string) ([]byte, error)
+
+// record is a WAL record
+type record struct {
+	// The position of the record
+	lsn uint64
+
+	// The transaction ID which wrote the record
+	xid uint32
+
+	// The information flags of the record
+	info uint8
+
+	// The resource manager which wrote the record
+	rmid uint8
+
+	// The magic number of the WAL pages, identifying
+	// the PostgreSQL version which wrote the record
+	pageMagic uint16
+
+	// The whole record, including the header. Filled
+	// only when requested by the caller
+	content []byte
+}
+
+// LSN returns the position of the record
+func (r *record) LSN() types.LSN {
+	return types.Int64ToLSN(r.lsn)
+}
+
+// mainData returns the main data of records without block references
+func (r *record) mainData() ([]byte, bool) {
+	content := r.content
+	position := recordHeaderSize
+	for position < len(content) {
+		blockID := content[position]
+		position++
+
+		switch blockID {
+		case blockIDDataShort:
+			if position+1 > len(content) {
+				return nil, false
+			}
+			length := int(content[position])
+			position++
+			if position+length > len(content) {
+				return nil, false
+			}
+			return content[position : position+length], true
+
+		case blockIDDataLong:
+			if position+4 > len(content) {
+				return nil, false
+			}
+			length := int(binary.LittleEndian.Uint32(content[position:]))
+			position += 4
+			if length < 0 || position+length > len(content) {
+				return nil, false
+			}
+			return content[position : position+length], true
+
+		case blockIDOrigin:
+			position += 2
+
+		case blockIDTopLevelXID:
+			position += 4
+
+		default:
+			// The record contains block references, whose
+			// layout depends on the PostgreSQL version
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// segmentFetchResult is the result of the fetch of a WAL segment
+type segmentFetchResult struct {
+	content []byte
+	err     error
+}
+
+// walReader reads the records stored in a sequence of
+// archived WAL segments belonging to the same timeline
+type walReader struct {
+	fetch       SegmentFetcher
+	timeline    uint32
+	segmentSize uint64
+	pageSize    uint64
+	pageMagic   uint16
+
+	// The records beginning in this segment, or in the following
+	// ones, are not read. Zero to read up to the end of the WAL.
+	stopSegmentNumber uint64
+
+	// The currently loaded segment
+	segmentNumber uint64
+	segment       []byte
+
+	// The segments being fetched in the background
+	prefetched map[uint64]chan segmentFetchResult
+
+	// The position of the next record
+	position uint64
+}
+
+// parseWALName parses the name of a WAL segment
+func parseWALName(walName string) (timeline uint32, logID uint32, segmentID uint32, err error) {
+	if len(walName) != 24 {
+		return 0, 0, 0, fmt.Errorf("invalid WAL name %q", walName)
+	}
+	if _, err := fmt.Sscanf(walName, "%08X%08X%08X", &timeline, &logID, &segmentID); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid WAL name %q: %w", walName, err)
+	}
+
+	return timeline, logID, segmentID, nil
+}
+
+// newWALReader creates a reader starting from the first record beginning
+// inside the start segment, and stopping before the records beginning
+// inside the stop one, which can be empty to read up to the end of the WAL.
+// The stop segment is compared by position, regardless of its timeline.
+func newWALReader(fetch SegmentFetcher, startWAL string, stopWAL string) (*walReader, error) {
+	timeline, logID, segmentID, err := parseWALName(startWAL)
+	if err != nil {
+		return nil, err
+	}
+
+	var stopLogID, stopSegmentID uint32
+	if stopWAL != "" {
+		if _, stopLogID, stopSegmentID, err = parseWALName(stopWAL); err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := fetch(startWAL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) < longPageHeaderSize {
+		return nil, fmt.Errorf("WAL segment %s is too short", startWAL)
+	}
+	info := binary.LittleEndian.Uint16(content[2:])
+	if info&pageLongHeaderFlag == 0 {
+		return nil, fmt.Errorf("WAL segment %s doesn't begin with a long page header", startWAL)
+	}
+
+	segmentSize := uint64(binary.LittleEndian.Uint32(content[32:]))
+	pageSize := uint64(binary.LittleEndian.Uint32(content[36:]))
+	if !isPowerOfTwo(segmentSize) || !isPowerOfTwo(pageSize) ||
+		pageSize < longPageHeaderSize || pageSize > segmentSize || segmentSize > 1<<32 {
+		return nil, fmt.Errorf("WAL segment %s has invalid segment size %d or page size %d",
+			startWAL, segmentSize, pageSize)
+	}
+
+	segmentNumber := uint64(logID)*(0x100000000/segmentSize) + uint64(segmentID)
+	reader := &walReader{
+		fetch:         fetch,
+		timeline:      timeline,
+		segmentSize:   segmentSize,
+		pageSize:      pageSize,
+		pageMagic:     binary.LittleEndian.Uint16(content[0:]),
+		segmentNumber: segmentNumber,
+		segment:       content,
+		prefetched:    make(map[uint64]chan segmentFetchResult),
+	}
+	if stopWAL != "" {
+		reader.stopSegmentNumber = uint64(stopLogID)*(0x100000000/segmentSize) + uint64(stopSegmentID)
+	}
+	if err := reader.checkSegment(startWAL); err != nil {
+		return nil, err
+	}
+	reader.prefetch()
+
+	reader.position, err = reader.firstRecordPosition(segmentNumber * segmentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// walName gets the name of a segment of the timeline being read
+func (reader *walReader) walName(segmentNumber uint64) string {
+	segmentsPerLogID := 0x100000000 / reader.segmentSize
+	return fmt.Sprintf("%08X%08X%08X",
+		reader.timeline, segmentNumber/segmentsPerLogID, segmentNumber%segmentsPerLogID)
+}
+
+// prefetch starts fetching in the background the segments following the
+// loaded one, as the records are read in order. The segments from the stop
+// one on are not prefetched, as they are only read to complete a record.
+func (reader *walReader) prefetch() {
+	for segmentNumber := range reader.prefetched {
+		if segmentNumber <= reader.segmentNumber {
+			delete(reader.prefetched, segmentNumber)
+		}
+	}
+
+	lastSegmentNumber := reader.segmentNumber + prefetchSegments
+	if reader.stopSegmentNumber != 0 {
+		lastSegmentNumber = min(lastSegmentNumber, reader.stopSegmentNumber-1)
+	}
+	for segmentNumber := reader.segmentNumber + 1; segmentNumber <= lastSegmentNumber; segmentNumber++ {
+		if _, ok := reader.prefetched[segmentNumber]; ok {
+			continue
+		}
+
+		result := make(chan segmentFetchResult, 1)
+		reader.prefetched[segmentNumber] = result
+		go func(walName string) {
+			content, err := reader.fetch(walName)
+			result <- segmentFetchResult{content: content, err: err}
+		}(reader.walName(segmentNumber))
+	}
+}
+
+// fetchSegment gets the content of a segment, waiting
+// for its fetch when it is being prefetched
+func (reader *walReader) fetchSegment(segmentNumber uint64) ([]byte, error) {
+	if result, ok := reader.prefetched[segmentNumber]; ok {
+		delete(reader.prefetched, segmentNumber)
+		fetched := <-result
+		return fetched.content, fetched.err
+	}
+
+	return reader.fetch(reader.walName(segmentNumber))
+}
+
+// checkSegment checks the size of the loaded segment
+func (reader *walReader) checkSegment(walName string) error {
+	if uint64(len(reader.segment)) != reader.segmentSize {
+		return fmt.Errorf("WAL segment %s has size %d, expected %d",
+			walName, len(reader.segment), reader.segmentSize)
+	}
+
+	return nil
+}
+
+// page gets the page containing the passed position, loading the
+// segment if needed. errEndOfWAL is returned when the segment is not
+// archived or the page doesn't belong to the expected position, as it
+// happens with recycled segments.
+func (reader *walReader) page(position uint64) ([]byte, error) {
+	segmentNumber := position / reader.segmentSize
+	if segmentNumber != reader.segmentNumber {
+		content, err := reader.fetchSegment(segmentNumber)
+		if errors.Is(err, ErrSegmentNotFound) {
+			return nil, errEndOfWAL
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		reader.segment = content
+		reader.segmentNumber = segmentNumber
+		if err := reader.checkSegment(reader.walName(segmentNumber)); err != nil {
+			return nil, err
+		}
+		reader.prefetch()
+	}
+
+	pageStart := position - position%reader.pageSize
+	offset := pageStart - segmentNumber*reader.segmentSize
+	page := reader.segment[offset : offset+reader.pageSize]
+
+	magic := binary.LittleEndian.Uint16(page[0:])
+	pageAddress := binary.LittleEndian.Uint64(page[8:])
+	if magic == 0 || pageAddress != pageStart {
+		return nil, errEndOfWAL
+	}
+
+	return page, nil
+}
+
+// pageHeaderSize gets the size of the header of a page
+func pageHeaderSize(page []byte) uint64 {
+	if binary.LittleEndian.Uint16(page[2:])&pageLongHeaderFlag != 0 {
+		return longPageHeaderSize
+	}
+	return shortPageHeaderSize
+}
+
+// firstRecordPosition gets the position of the first record
+// beginning after the passed page boundary, skipping the
+// continuation of the record started in the previous segment
+func (reader *walReader) firstRecordPosition(position uint64) (uint64, error) {
+	for {
+		page, err := reader.page(position)
+		if err != nil {
+			return 0, err
+		}
+
+		headerSize := pageHeaderSize(page)
+		if binary.LittleEndian.Uint16(page[2:])&pageContRecordFlag == 0 {
+			return position + headerSize, nil
+		}
+
+		remainingLength := uint64(binary.LittleEndian.Uint32(page[16:]))
+		if remainingLength <= reader.pageSize-headerSize {
+			return alignRecord(position + headerSize + remainingLength), nil
+		}
+
+		position += reader.pageSize
+	}
+}
+
+// read reads length bytes of the logical WAL stream starting from
+// the passed position, skipping the page headers. When dest is nil
+// the bytes are skipped. The position following the read bytes is
+// returned.
+func (reader *walReader) read(position uint64, length uint64, dest []byte) ([]byte, uint64, error) {
+	for length > 0 {
+		page, err := reader.page(position)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		offset := position % reader.pageSize
+		if offset == 0 {
+			offset = pageHeaderSize(page)
+			position += offset
+		}
+
+		chunk := min(length, reader.pageSize-offset)
+		if dest != nil {
+			dest = append(dest, page[offset:offset+chunk]...)
+		}
+		position += chunk
+		length -= chunk
+	}
+
+	return dest, position, nil
+}
+
+// next reads the next record. The whole content of the record is
+// read only when the wanted function returns true for its header.
+func (reader *walReader) next(wanted func(*record) bool) (*record, error) {
+	position := reader.position
+	if reader.stopSegmentNumber != 0 && position/reader.segmentSize >= reader.stopSegmentNumber {
+		return nil, errEndOfWAL
+	}
+	if position%reader.pageSize == 0 {
+		page, err := reader.page(position)
+		if err != nil {
+			return nil, err
+		}
+		position += pageHeaderSize(page)
+	}
+
+	header, afterHeader, err := reader.read(position, recordHeaderSize, make([]byte, 0, recordHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+
+	totalLength := uint64(binary.LittleEndian.Uint32(header[0:]))
+	if totalLength < recordHeaderSize || totalLength > maxRecordSize {
+		// Zeroed space, we reached the end of the written WAL
+		return nil, errEndOfWAL
+	}
+
+	result := &record{
+		lsn:       position,
+		xid:       binary.LittleEndian.Uint32(header[4:]),
+		info:      header[16],
+		rmid:      header[17],
+		pageMagic: reader.pageMagic,
+	}
+
+	var end uint64
+	if wanted(result) {
+		result.content, end, err = reader.read(afterHeader, totalLength-recordHeaderSize, header)
+	} else {
+		_, end, err = reader.read(afterHeader, totalLength-recordHeaderSize, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.isSegmentSwitch() {
+		// The remaining part of the segment is unused
+		reader.position = (end + reader.segmentSize - 1) / reader.segmentSize * reader.segmentSize
+	} else {
+		reader.position = alignRecord(end)
+	}
+
+	return result, nil
+}
+
+func alignRecord(position uint64) uint64 {
+	return (position + recordAlignment - 1) &^ (recordAlignment - 1)
+}
+
+func isPowerOfTwo(value uint64) bool {
+	return value != 0 && value&(value-1) == 0
+}